@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/quangkeu95/binancebot/core"
+	"github.com/quangkeu95/binancebot/pkg/controller"
 	"github.com/quangkeu95/binancebot/pkg/exchange"
 	"github.com/quangkeu95/binancebot/pkg/model"
 	"github.com/quangkeu95/binancebot/pkg/notification"
+	"github.com/quangkeu95/binancebot/pkg/storage"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 var backtestCmd = &cobra.Command{
@@ -15,29 +23,45 @@ var backtestCmd = &cobra.Command{
 	RunE:  backtestMain,
 }
 
+var (
+	backtestCmdStore                 bool
+	backtestCmdSymbols               []string
+	backtestCmdStart, backtestCmdEnd int64
+)
+
 func backtestMain(cmd *cobra.Command, args []string) error {
-	csvFeed, err := exchange.NewCSVFeed(
-		exchange.SymbolFeed{
-			SymbolInfo: model.SymbolInfo{
-				Symbol:     "SXPUSDT",
-				BaseAsset:  "SXP",
-				QuoteAsset: "USDT",
-				Status:     model.SymbolStatusTrading.String(),
+	listTimeframes := []model.Timeframe{model.Timeframe4h}
+
+	var (
+		csvFeed *exchange.CSVFeed
+		err     error
+	)
+	if backtestCmdStore {
+		csvFeed, err = storeFeed(listTimeframes)
+	} else {
+		csvFeed, err = exchange.NewCSVFeed(
+			exchange.SymbolFeed{
+				SymbolInfo: model.SymbolInfo{
+					Symbol:     "SXPUSDT",
+					BaseAsset:  "SXP",
+					QuoteAsset: "USDT",
+					Status:     model.SymbolStatusTrading.String(),
+				},
+				Timeframe: model.Timeframe4h,
+				File:      "testdata/sxpusdt-4h-test1.csv",
 			},
-			Timeframe: "4h",
-			File:      "testdata/sxpusdt-4h-test1.csv",
-		},
-		exchange.SymbolFeed{
-			SymbolInfo: model.SymbolInfo{
-				Symbol:     "KNCUSDT",
-				BaseAsset:  "KNC",
-				QuoteAsset: "USDT",
-				Status:     model.SymbolStatusTrading.String(),
+			exchange.SymbolFeed{
+				SymbolInfo: model.SymbolInfo{
+					Symbol:     "KNCUSDT",
+					BaseAsset:  "KNC",
+					QuoteAsset: "USDT",
+					Status:     model.SymbolStatusTrading.String(),
+				},
+				Timeframe: model.Timeframe4h,
+				File:      "testdata/kncusdt-4h-test1.csv",
 			},
-			Timeframe: "4h",
-			File:      "testdata/kncusdt-4h-test1.csv",
-		},
-	)
+		)
+	}
 
 	if err != nil {
 		return err
@@ -45,19 +69,91 @@ func backtestMain(cmd *cobra.Command, args []string) error {
 
 	notifier := notification.NewMocNotifier()
 
-	strategy, err := core.NewAlertOnMAStrategy(notifier)
+	// the strategy consumes each candle before the paper exchange reads the next one
+	viper.Set(controller.DispatchSynchronousFlag, true)
+	// the candles of the csv files are not streamed in real time
+	viper.Set(controller.WatchdogEnabledFlag, false)
+
+	alerts, err := controller.NewAlertController(notifier)
 	if err != nil {
 		return err
 	}
-	listTimeframes := []string{"4h"}
+	strategy, err := core.NewAlertOnMAStrategy(alerts)
+	if err != nil {
+		return err
+	}
+
+	// orders are simulated against the csv candles
+	paper, err := exchange.NewPaperExchange(csvFeed, nil)
+	if err != nil {
+		return err
+	}
+	strategy.SetBroker(paper)
 
-	coreIns, err := core.New(csvFeed, strategy)
+	coreIns, err := core.New(paper, strategy, nil)
 	if err != nil {
 		return err
 	}
-	return coreIns.Run(cmd.Context(), listTimeframes)
+	if err := coreIns.Run(cmd.Context(), listTimeframes); err != nil {
+		return err
+	}
+
+	balances, err := paper.Balances(cmd.Context())
+	if err != nil {
+		return err
+	}
+	for _, balance := range balances {
+		zap.S().Infow("backtest balance", "asset", balance.Asset, "free", balance.Free, "locked", balance.Locked)
+	}
+	return nil
+}
+
+// storeFeed loads the candles saved by `download --store` in the candle store of `storage_path`
+func storeFeed(timeframes []model.Timeframe) (*exchange.CSVFeed, error) {
+	db, err := storage.NewBadgerDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	csvFeed, err := exchange.NewCSVFeed()
+	if err != nil {
+		return nil, err
+	}
+	var start, end = time.Unix(0, 0), time.Now()
+	if backtestCmdStart > 0 {
+		start = time.Unix(0, backtestCmdStart*int64(time.Millisecond))
+	}
+	if backtestCmdEnd > 0 {
+		end = time.Unix(0, backtestCmdEnd*int64(time.Millisecond))
+	}
+	for _, symbol := range backtestCmdSymbols {
+		var info model.SymbolInfo
+		if err := db.Get(storage.SymbolInfoKey(symbol), &info); err != nil {
+			if errors.Is(err, storage.ErrKeyNotFound) {
+				return nil, fmt.Errorf("symbol %s not in the candle store, download it with --store", symbol)
+			}
+			return nil, err
+		}
+		for _, timeframe := range timeframes {
+			candles, err := db.Candles(symbol, timeframe, start, end)
+			if err != nil {
+				return nil, err
+			}
+			if len(candles) == 0 {
+				return nil, fmt.Errorf("no %s %s candles in the candle store", symbol, timeframe)
+			}
+			csvFeed.LoadCandles(info, timeframe, candles)
+		}
+	}
+	return csvFeed, nil
 }
 
 func init() {
 	rootCmd.AddCommand(backtestCmd)
+
+	backtestCmd.Flags().BoolVar(&backtestCmdStore, "store", false, "Read the candles from the candle store of storage_path instead of the csv files")
+	backtestCmd.Flags().StringSliceVar(&backtestCmdSymbols, "symbols", []string{"SXPUSDT", "KNCUSDT"}, "Symbols read from the candle store")
+	backtestCmd.Flags().Int64Var(&backtestCmdStart, "start", 0, "Start time in milliseconds of the candles read from the candle store")
+	backtestCmd.Flags().Int64Var(&backtestCmdEnd, "end", 0, "End time in milliseconds of the candles read from the candle store")
 }
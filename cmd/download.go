@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/quangkeu95/binancebot/pkg/download"
 	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -12,6 +15,7 @@ var (
 	downloadCmdSymbol, downloadCmdTimeframe, downloadCmdOutput string
 	downdloadCmdDays                                           int
 	downloadCmdStart, downloadCmdEnd                           int64
+	downloadCmdStore                                           bool
 )
 
 var downloadCmd = &cobra.Command{
@@ -22,7 +26,12 @@ var downloadCmd = &cobra.Command{
 }
 
 func downloadMain(cmd *cobra.Command, args []string) error {
-	exc, err := exchange.NewBinance()
+	timeframe, err := model.ParseTimeframe(downloadCmdTimeframe)
+	if err != nil {
+		return err
+	}
+
+	exc, err := exchange.NewBinanceExchange()
 	if err != nil {
 		return err
 	}
@@ -39,10 +48,46 @@ func downloadMain(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if downloadCmdStore {
+		return downloadToStore(cmd.Context(), exc, timeframe, options)
+	}
+
 	// return data.NewDownloader(exc).Download(c.Context, c.String("pair"),
 	// 	c.String("timeframe"), c.String("output"), options...)
 	return download.NewDownloader(exc).Download(cmd.Context(),
-		downloadCmdSymbol, downloadCmdTimeframe, downloadCmdOutput, options...)
+		downloadCmdSymbol, timeframe, downloadCmdOutput, options...)
+}
+
+// downloadToStore saves the candles to the candle store of `storage_path`, with the exchange info of the symbol read
+// by the backtests
+func downloadToStore(ctx context.Context, exc exchange.Feeder, timeframe model.Timeframe, options []download.Option) error {
+	exchangeInfo, err := exc.GetExchangeInfo(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		info  model.SymbolInfo
+		found bool
+	)
+	for _, item := range exchangeInfo.Symbols {
+		if item.Symbol == downloadCmdSymbol {
+			info, found = item, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("symbol %s not found in the exchange info", downloadCmdSymbol)
+	}
+
+	db, err := storage.NewBadgerDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Set(storage.SymbolInfoKey(downloadCmdSymbol), info); err != nil {
+		return err
+	}
+	return download.NewDownloader(exc).Store(ctx, db, downloadCmdSymbol, timeframe, options...)
 }
 
 func init() {
@@ -52,6 +97,7 @@ func init() {
 	downloadCmd.Flags().IntVarP(&downdloadCmdDays, "days", "d", 0, "Number of days")
 	downloadCmd.Flags().Int64VarP(&downloadCmdStart, "start", "s", 0, "Start time in milliseconds")
 	downloadCmd.Flags().Int64VarP(&downloadCmdEnd, "end", "e", 0, "End time in milliseconds")
+	downloadCmd.Flags().BoolVar(&downloadCmdStore, "store", false, "Save to the candle store of storage_path instead of the output file")
 
 	rootCmd.AddCommand(downloadCmd)
-}
\ No newline at end of file
+}
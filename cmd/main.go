@@ -2,12 +2,18 @@ package cmd
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/quangkeu95/binancebot/config"
 	"github.com/quangkeu95/binancebot/core"
 	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/controller"
 	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/metrics"
+	"github.com/quangkeu95/binancebot/pkg/model"
 	"github.com/quangkeu95/binancebot/pkg/notification"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+	"github.com/quangkeu95/binancebot/pkg/strategy"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -22,30 +28,106 @@ var rootCmd = &cobra.Command{
 }
 
 func rootMain(cmd *cobra.Command, args []string) error {
-	ex, err := exchange.NewBinance()
+	listTimeframes, err := model.ParseTimeframes(viper.GetStringSlice(core.ListTimeframesFlag))
 	if err != nil {
 		return err
 	}
 
-	teleBot, err := notification.NewTelegram()
+	ex, err := exchange.NewBinanceExchange()
 	if err != nil {
 		return err
 	}
 
-	alertOnMAStrategy, err := core.NewAlertOnMAStrategy(teleBot)
+	teleBot, err := notification.NewTelegram()
+	if err != nil {
+		return err
+	}
+	// the strategies send their alerts through the alert controller
+	alerts, err := controller.NewAlertController(teleBot)
 	if err != nil {
 		return err
 	}
 
-	coreIns, err := core.New(ex, alertOnMAStrategy)
+	var (
+		alertOnMAStrategy *core.AlertOnMAStrategy
+		str               strategy.Strategy
+	)
+	// the crosses of futures symbols are reported with their funding rate and open interest
+	if viper.GetString(exchange.BinanceMarketFlag) == exchange.MarketFutures {
+		fundingStrategy, err := core.NewAlertOnFundingStrategy(alerts, ex)
+		if err != nil {
+			return err
+		}
+		alertOnMAStrategy, str = fundingStrategy.AlertOnMAStrategy, fundingStrategy
+	} else {
+		if alertOnMAStrategy, err = core.NewAlertOnMAStrategy(alerts); err != nil {
+			return err
+		}
+		str = alertOnMAStrategy
+	}
+	// the states of the strategy and the paper account, and the candles when enabled, are saved in `storage_path`
+	var db *storage.BadgerDB
+	if viper.GetString(storage.StoragePathFlag) != "" || viper.GetBool(exchange.PaperEnabledFlag) ||
+		viper.GetBool(storage.CandleStoreEnabledFlag) {
+		if db, err = storage.NewBadgerDB(); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	// orders are only placed when `order_quote_quantity` is set
+	var feeder exchange.Feeder = ex
+	if viper.GetBool(exchange.PaperEnabledFlag) {
+		paper, err := exchange.NewPaperExchange(ex, db)
+		if err != nil {
+			return err
+		}
+		feeder = paper
+		alertOnMAStrategy.SetBroker(paper)
+	} else {
+		alertOnMAStrategy.SetBroker(ex)
+	}
+
+	// a nil *BadgerDB is not a nil storage
+	var store storage.KeyValueStorage
+	if db != nil {
+		store = db
+	}
+	coreIns, err := core.New(feeder, str, store)
 	if err != nil {
 		return err
 	}
 
-	listTimeframes := viper.GetStringSlice(core.ListTimeframesFlag)
+	coreIns.SetAlerts(alerts)
+	if db != nil && viper.GetBool(storage.CandleStoreEnabledFlag) {
+		coreIns.SetCandleStore(db)
+	}
+
+	// whale trades and liquidations are only reported when enabled
+	if viper.GetBool(core.FlowAlertsWhaleTradesFlag) || viper.GetBool(core.FlowAlertsLiquidationsFlag) {
+		flowAlert, err := core.NewAlertOnFlow(alerts, ex)
+		if err != nil {
+			return err
+		}
+		coreIns.SetFlowAlert(flowAlert)
+	}
 
 	ctx, cancel := context.WithCancel(cmd.Context())
 	defer cancel()
+	go alerts.Start(ctx)
+
+	if address := viper.GetString(app.HttpAddressFlag); address != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", controller.LiveHandler())
+		mux.Handle("/readyz", coreIns.Health().ReadyHandler())
+		mux.Handle("/alerts", alerts.HistoryHandler())
+		go func() {
+			if err := app.ServeHTTP(ctx, address, mux); err != nil {
+				zap.S().Errorw("http server error", "error", err, "address", address)
+			}
+		}()
+	}
 	return coreIns.Run(ctx, listTimeframes)
 }
 
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/controller"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/notification"
+	"github.com/quangkeu95/binancebot/pkg/strategy"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// FlowAlertsWhaleTradesFlag enables the alerts on aggregate trades above the whale notional
+	FlowAlertsWhaleTradesFlag = "flow_alerts.whale_trades"
+	// FlowAlertsLiquidationsFlag enables the alerts on futures liquidations above the liquidation notional
+	FlowAlertsLiquidationsFlag = "flow_alerts.liquidations"
+	// FlowAlertsWhaleNotionalFlag is the quote amount of a single trade reported as a whale trade
+	FlowAlertsWhaleNotionalFlag = "flow_alerts.whale_notional"
+	// FlowAlertsLiquidationNotionalFlag is the quote amount of a single liquidation reported
+	FlowAlertsLiquidationNotionalFlag = "flow_alerts.liquidation_notional"
+	// FlowAlertsSymbolsFlag maps symbols to their own thresholds, e.g. `{"BTCUSDT": {"whale_notional": 5000000}}`
+	FlowAlertsSymbolsFlag = "flow_alerts.symbols"
+	// FlowAlertsTiersFlag lists the thresholds of the symbols whose 24h quote volume reaches min_quote_volume, e.g.
+	// `[{"min_quote_volume": 1000000000, "whale_notional": 2000000, "liquidation_notional": 500000}]`
+	FlowAlertsTiersFlag = "flow_alerts.tiers"
+
+	DefaultWhaleNotional       = 1000000.0
+	DefaultLiquidationNotional = 100000.0
+
+	// FlowAlertInterval is the period the whale trades and liquidations of a symbol are aggregated over
+	FlowAlertInterval = time.Minute
+	// FlowAlertDelay is the time waited after the end of a period for the events still in flight
+	FlowAlertDelay = 5 * time.Second
+	// QuoteVolumeRefreshInterval is the delay between two fetches of the 24h quote volumes of the tiers
+	QuoteVolumeRefreshInterval = time.Hour
+)
+
+const (
+	flowKindWhaleTrades  = "whale_trades"
+	flowKindLiquidations = "liquidations"
+)
+
+// FlowThresholds are the quote amounts from which a single trade or liquidation is reported, zero values use the
+// thresholds of the next level: symbol, then quote volume tier, then default
+type FlowThresholds struct {
+	WhaleNotional       float64 `mapstructure:"whale_notional"`
+	LiquidationNotional float64 `mapstructure:"liquidation_notional"`
+}
+
+// FlowTier are the thresholds of the symbols trading at least MinQuoteVolume in 24h
+type FlowTier struct {
+	MinQuoteVolume      float64 `mapstructure:"min_quote_volume"`
+	WhaleNotional       float64 `mapstructure:"whale_notional"`
+	LiquidationNotional float64 `mapstructure:"liquidation_notional"`
+}
+
+// flowBucket aggregates the events of one kind of a symbol during a FlowAlertInterval period
+type flowBucket struct {
+	kind         string
+	symbol       string
+	start        time.Time
+	buys         int
+	sells        int
+	buyNotional  float64
+	sellNotional float64
+	largest      float64
+	largestPrice float64
+}
+
+func (b *flowBucket) add(isBuy bool, price, notional float64) {
+	if isBuy {
+		b.buys++
+		b.buyNotional += notional
+	} else {
+		b.sells++
+		b.sellNotional += notional
+	}
+	if notional > b.largest {
+		b.largest, b.largestPrice = notional, price
+	}
+}
+
+// AlertOnFlow reports the aggregate trades and liquidations above a notional threshold. The events of a symbol are
+// aggregated per minute, so a liquidation cascade sends a single message per minute and not one per order.
+type AlertOnFlow struct {
+	sync.Mutex
+	l            *zap.SugaredLogger
+	alerts       *controller.AlertController
+	feeder       exchange.Feeder
+	whaleTrades  bool
+	liquidations bool
+	defaults     FlowThresholds
+	symbols      map[string]FlowThresholds
+	tiers        []FlowTier // ordered by decreasing min quote volume
+	quoteVolumes map[string]float64
+	buckets      map[string]*flowBucket // each kind--symbol is a key
+	symbolInfos  strategy.SymbolInfoGetter
+}
+
+func NewAlertOnFlow(alerts *controller.AlertController, feeder exchange.Feeder) (*AlertOnFlow, error) {
+	l := zap.S()
+
+	defaults := FlowThresholds{
+		WhaleNotional:       viper.GetFloat64(FlowAlertsWhaleNotionalFlag),
+		LiquidationNotional: viper.GetFloat64(FlowAlertsLiquidationNotionalFlag),
+	}
+	if defaults.WhaleNotional <= 0 {
+		defaults.WhaleNotional = DefaultWhaleNotional
+	}
+	if defaults.LiquidationNotional <= 0 {
+		defaults.LiquidationNotional = DefaultLiquidationNotional
+	}
+
+	var configSymbols = make(map[string]FlowThresholds)
+	if err := viper.UnmarshalKey(FlowAlertsSymbolsFlag, &configSymbols); err != nil {
+		l.Errorw("parse `flow_alerts.symbols` configuration error", "error", err)
+		return nil, err
+	}
+	// viper lower cases the keys of the configuration
+	var symbols = make(map[string]FlowThresholds, len(configSymbols))
+	for symbol, thresholds := range configSymbols {
+		symbols[strings.ToUpper(symbol)] = thresholds
+	}
+	var tiers = make([]FlowTier, 0)
+	if err := viper.UnmarshalKey(FlowAlertsTiersFlag, &tiers); err != nil {
+		l.Errorw("parse `flow_alerts.tiers` configuration error", "error", err)
+		return nil, err
+	}
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MinQuoteVolume > tiers[j].MinQuoteVolume
+	})
+
+	return &AlertOnFlow{
+		l:            l,
+		alerts:       alerts,
+		feeder:       feeder,
+		whaleTrades:  viper.GetBool(FlowAlertsWhaleTradesFlag),
+		liquidations: viper.GetBool(FlowAlertsLiquidationsFlag),
+		defaults:     defaults,
+		symbols:      symbols,
+		tiers:        tiers,
+		quoteVolumes: make(map[string]float64),
+		buckets:      make(map[string]*flowBucket),
+	}, nil
+}
+
+// Thresholds returns the thresholds of the symbol, from its own configuration, its quote volume tier or the defaults
+func (a *AlertOnFlow) Thresholds(symbol string) FlowThresholds {
+	a.Lock()
+	defer a.Unlock()
+	return a.thresholds(symbol)
+}
+
+func (a *AlertOnFlow) thresholds(symbol string) FlowThresholds {
+	thresholds := a.symbols[symbol]
+	if quoteVolume, ok := a.quoteVolumes[symbol]; ok {
+		for _, tier := range a.tiers {
+			if quoteVolume < tier.MinQuoteVolume {
+				continue
+			}
+			if thresholds.WhaleNotional <= 0 {
+				thresholds.WhaleNotional = tier.WhaleNotional
+			}
+			if thresholds.LiquidationNotional <= 0 {
+				thresholds.LiquidationNotional = tier.LiquidationNotional
+			}
+			break
+		}
+	}
+	if thresholds.WhaleNotional <= 0 {
+		thresholds.WhaleNotional = a.defaults.WhaleNotional
+	}
+	if thresholds.LiquidationNotional <= 0 {
+		thresholds.LiquidationNotional = a.defaults.LiquidationNotional
+	}
+	return thresholds
+}
+
+// RefreshQuoteVolumes fetches the 24h quote volume of every symbol, which selects the tier of its thresholds
+func (a *AlertOnFlow) RefreshQuoteVolumes(ctx context.Context) error {
+	stats, err := a.feeder.MarketStats(ctx)
+	if err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	for _, stat := range stats {
+		a.quoteVolumes[stat.Symbol] = stat.QuoteVolume
+	}
+	return nil
+}
+
+// OnTrade aggregates the trade when its quote amount reaches the whale notional of the symbol, the taker side is
+// reported
+func (a *AlertOnFlow) OnTrade(trade model.Trade) {
+	notional := trade.QuoteQuantity()
+	a.Lock()
+	if notional < a.thresholds(trade.Symbol).WhaleNotional {
+		a.Unlock()
+		return
+	}
+	bucket, ended := a.bucket(flowKindWhaleTrades, trade.Symbol, trade.Time)
+	bucket.add(!trade.IsBuyerMaker, trade.Price, notional)
+	a.Unlock()
+
+	if ended != nil {
+		a.send(ended)
+	}
+}
+
+// OnLiquidation aggregates the liquidation when its quote amount reaches the liquidation notional of the symbol
+func (a *AlertOnFlow) OnLiquidation(liquidation model.Liquidation) {
+	notional := liquidation.QuoteQuantity()
+	a.Lock()
+	if notional < a.thresholds(liquidation.Symbol).LiquidationNotional {
+		a.Unlock()
+		return
+	}
+	bucket, ended := a.bucket(flowKindLiquidations, liquidation.Symbol, liquidation.Time)
+	bucket.add(liquidation.Side == model.SideTypeBuy, liquidation.Price, notional)
+	a.Unlock()
+
+	if ended != nil {
+		a.send(ended)
+	}
+}
+
+// bucket returns the bucket of the period of t, and the bucket of a previous period to be sent
+func (a *AlertOnFlow) bucket(kind, symbol string, t time.Time) (bucket, ended *flowBucket) {
+	key := fmt.Sprintf("%s--%s", kind, symbol)
+	start := t.Truncate(FlowAlertInterval)
+	bucket, ok := a.buckets[key]
+	if ok && start.After(bucket.start) {
+		ended, ok = bucket, false
+	}
+	if !ok {
+		bucket = &flowBucket{kind: kind, symbol: symbol, start: start}
+		a.buckets[key] = bucket
+	}
+	return bucket, ended
+}
+
+// Flush sends the buckets whose period ended FlowAlertDelay before now, ordered by time
+func (a *AlertOnFlow) Flush(now time.Time) {
+	var ended = make([]*flowBucket, 0)
+	a.Lock()
+	for key, bucket := range a.buckets {
+		if bucket.start.Add(FlowAlertInterval + FlowAlertDelay).After(now) {
+			continue
+		}
+		ended = append(ended, bucket)
+		delete(a.buckets, key)
+	}
+	a.Unlock()
+
+	sort.SliceStable(ended, func(i, j int) bool {
+		if ended[i].start.Equal(ended[j].start) {
+			return ended[i].symbol < ended[j].symbol
+		}
+		return ended[i].start.Before(ended[j].start)
+	})
+	for _, bucket := range ended {
+		a.send(bucket)
+	}
+}
+
+// Start sends the aggregated alerts of each ended period until ctx is done, and refreshes the quote volumes when
+// tiers are configured
+func (a *AlertOnFlow) Start(ctx context.Context) {
+	var refreshCh <-chan time.Time
+	if len(a.tiers) > 0 {
+		a.refreshQuoteVolumes(ctx)
+		refreshTicker := time.NewTicker(QuoteVolumeRefreshInterval)
+		defer refreshTicker.Stop()
+		refreshCh = refreshTicker.C
+	}
+	ticker := time.NewTicker(FlowAlertDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.Flush(now)
+		case <-refreshCh:
+			a.refreshQuoteVolumes(ctx)
+		}
+	}
+}
+
+func (a *AlertOnFlow) refreshQuoteVolumes(ctx context.Context) {
+	if err := a.RefreshQuoteVolumes(ctx); err != nil {
+		if errors.Is(err, exchange.ErrNotSupported) {
+			a.l.Warnw("quote volume tiers not supported by the exchange, default thresholds are used", "error", err)
+			return
+		}
+		a.l.Warnw("refresh quote volumes error", "error", err)
+	}
+}
+
+// SetSymbols formats the prices of the alerts at the tick size of their symbol
+func (a *AlertOnFlow) SetSymbols(symbols strategy.SymbolInfoGetter) {
+	a.Lock()
+	defer a.Unlock()
+	a.symbolInfos = symbols
+}
+
+func (a *AlertOnFlow) send(bucket *flowBucket) {
+	a.l.Infow("flow alert", "kind", bucket.kind, "symbol", bucket.symbol, "start", bucket.start,
+		"buys", bucket.buys, "sells", bucket.sells, "largest", bucket.largest)
+	a.Lock()
+	symbols := a.symbolInfos
+	a.Unlock()
+	severity := controller.SeverityInfo
+	if bucket.kind == flowKindLiquidations {
+		severity = controller.SeverityWarning
+	}
+	// the events are aggregated per FlowAlertInterval
+	a.alerts.AddAlert(controller.Alert{
+		Name:       bucket.kind,
+		Symbol:     bucket.symbol,
+		Timeframe:  model.Timeframe1m,
+		LastUpdate: bucket.start,
+		Severity:   severity,
+		Message:    flowMessage(bucket, formatPrice(symbols, bucket.symbol, bucket.largestPrice)),
+	})
+}
+
+func flowMessage(bucket *flowBucket, largestPrice string) string {
+	var title, buyLabel, sellLabel, link string
+	switch bucket.kind {
+	case flowKindLiquidations:
+		title, buyLabel, sellLabel = notification.EmojiCollision+" Liquidations", "Shorts", "Longs"
+		link = fmt.Sprintf("https://www.binance.com/en/futures/%s", bucket.symbol)
+	default:
+		title, buyLabel, sellLabel = notification.EmojiWhale+" Whale trades", "Buys", "Sells"
+		link = fmt.Sprintf("https://www.binance.com/en/trade/%s", bucket.symbol)
+	}
+
+	msg := fmt.Sprintf("%s | <a href=\"%s\">Symbol %s</a> | %s", title, link, bucket.symbol,
+		bucket.start.UTC().Format("2006-01-02 15:04 MST"))
+	if bucket.buys > 0 {
+		msg += fmt.Sprintf(" \n%s: <b>%d</b> for <b>%.0f</b>", buyLabel, bucket.buys, bucket.buyNotional)
+	}
+	if bucket.sells > 0 {
+		msg += fmt.Sprintf(" \n%s: <b>%d</b> for <b>%.0f</b>", sellLabel, bucket.sells, bucket.sellNotional)
+	}
+	msg += fmt.Sprintf(" \nLargest: <b>%.0f</b> at <b>%s</b>", bucket.largest, largestPrice)
+	return msg
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/controller"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statsFeeder serves the 24h statistics of the quote volume tiers
+type statsFeeder struct {
+	exchange.Feeder
+	stats []model.MarketStats24h
+}
+
+func (f statsFeeder) MarketStats(ctx context.Context) ([]model.MarketStats24h, error) {
+	return f.stats, nil
+}
+
+func TestAlertOnFlow(t *testing.T) {
+	viper.Set(FlowAlertsWhaleNotionalFlag, 100000)
+	viper.Set(FlowAlertsLiquidationNotionalFlag, 10000)
+	viper.Set(FlowAlertsSymbolsFlag, map[string]interface{}{
+		"BTCUSDT": map[string]interface{}{"whale_notional": 5000000},
+	})
+	viper.Set(FlowAlertsTiersFlag, []interface{}{
+		map[string]interface{}{"min_quote_volume": 1000000, "whale_notional": 200000, "liquidation_notional": 20000},
+		map[string]interface{}{"min_quote_volume": 1000000000, "whale_notional": 2000000, "liquidation_notional": 500000},
+	})
+	defer func() {
+		for _, flag := range []string{FlowAlertsWhaleNotionalFlag, FlowAlertsLiquidationNotionalFlag, FlowAlertsSymbolsFlag, FlowAlertsTiersFlag} {
+			viper.Set(flag, nil)
+		}
+	}()
+
+	notifier := new(recordNotifier)
+	feeder := statsFeeder{stats: []model.MarketStats24h{
+		{Symbol: "BTCUSDT", QuoteVolume: 5000000000},
+		{Symbol: "ETHUSDT", QuoteVolume: 2000000000},
+		{Symbol: "KNCUSDT", QuoteVolume: 3000000},
+	}}
+	alerts, err := controller.NewAlertController(notifier)
+	require.NoError(t, err)
+	a, err := NewAlertOnFlow(alerts, feeder)
+	require.NoError(t, err)
+	require.NoError(t, a.RefreshQuoteVolumes(context.Background()))
+
+	t.Run("thresholds", func(t *testing.T) {
+		// the symbol whale notional is kept, its liquidation notional comes from the tier
+		assert.Equal(t, FlowThresholds{WhaleNotional: 5000000, LiquidationNotional: 500000}, a.Thresholds("BTCUSDT"))
+		assert.Equal(t, FlowThresholds{WhaleNotional: 2000000, LiquidationNotional: 500000}, a.Thresholds("ETHUSDT"))
+		assert.Equal(t, FlowThresholds{WhaleNotional: 200000, LiquidationNotional: 20000}, a.Thresholds("KNCUSDT"))
+		assert.Equal(t, FlowThresholds{WhaleNotional: 100000, LiquidationNotional: 10000}, a.Thresholds("XYZUSDT"))
+	})
+
+	minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("liquidation cascade", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			a.OnLiquidation(model.Liquidation{
+				Symbol:   "KNCUSDT",
+				Side:     model.SideTypeSell,
+				Price:    2 - float64(i)*0.01,
+				Quantity: 15000,
+				Time:     minute.Add(time.Duration(i) * 2 * time.Second),
+			})
+		}
+		// below the tier threshold
+		a.OnLiquidation(model.Liquidation{Symbol: "KNCUSDT", Side: model.SideTypeBuy, Price: 2, Quantity: 100, Time: minute})
+		assert.Empty(t, notifier.reset())
+
+		// the period is sent once the delay for late events has passed
+		a.Flush(minute.Add(FlowAlertInterval))
+		assert.Empty(t, notifier.reset())
+		a.Flush(minute.Add(FlowAlertInterval + FlowAlertDelay))
+		messages := notifier.reset()
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0], "Liquidations")
+		assert.Contains(t, messages[0], "Longs: <b>20</b>")
+		assert.NotContains(t, messages[0], "Shorts")
+		assert.Contains(t, messages[0], "Largest: <b>30000</b> at <b>2</b>")
+	})
+
+	t.Run("whale trades", func(t *testing.T) {
+		a.OnTrade(model.Trade{Symbol: "ETHUSDT", Price: 3000, Quantity: 1000, Time: minute.Add(10 * time.Second)})
+		a.OnTrade(model.Trade{Symbol: "ETHUSDT", Price: 3000, Quantity: 800, Time: minute.Add(20 * time.Second), IsBuyerMaker: true})
+		// below the ETHUSDT tier threshold
+		a.OnTrade(model.Trade{Symbol: "ETHUSDT", Price: 3000, Quantity: 100, Time: minute.Add(30 * time.Second)})
+		// below the BTCUSDT own threshold
+		a.OnTrade(model.Trade{Symbol: "BTCUSDT", Price: 40000, Quantity: 100, Time: minute.Add(30 * time.Second)})
+		assert.Empty(t, notifier.reset())
+
+		// a trade of the next minute sends the previous one
+		a.OnTrade(model.Trade{Symbol: "ETHUSDT", Price: 3100, Quantity: 1000, Time: minute.Add(time.Minute)})
+		messages := notifier.reset()
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0], "Whale trades")
+		assert.Contains(t, messages[0], "Buys: <b>1</b> for <b>3000000</b>")
+		assert.Contains(t, messages[0], "Sells: <b>1</b> for <b>2400000</b>")
+		assert.Contains(t, messages[0], "12:00")
+
+		a.Flush(minute.Add(2*FlowAlertInterval + FlowAlertDelay))
+		messages = notifier.reset()
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0], "12:01")
+		assert.Contains(t, messages[0], "Largest: <b>3100000</b> at <b>3100</b>")
+	})
+}
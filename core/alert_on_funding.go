@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/controller"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/notification"
+	"github.com/spf13/viper"
+)
+
+const (
+	// FundingExtremeRateFlag is the absolute funding rate reported as extreme on a MA200 cross, e.g. 0.001 for 0.1%
+	FundingExtremeRateFlag = "funding.extreme_rate"
+	// OpenInterestSpikePercentFlag is the open interest increase reported as a spike on a MA200 cross
+	OpenInterestSpikePercentFlag = "open_interest.spike_percent"
+	// OpenInterestWindowFlag is the period the open interest increase is measured over, e.g. `1h`
+	OpenInterestWindowFlag = "open_interest.window"
+
+	DefaultFundingExtremeRate       = 0.001
+	DefaultOpenInterestSpikePercent = 10.0
+	DefaultOpenInterestWindow       = time.Hour
+
+	// FundingLookback is the period searched for the last settled funding rate, funding is settled every 8 hours
+	FundingLookback = 24 * time.Hour
+)
+
+// AlertOnFundingStrategy sends the MA200 cross alerts of AlertOnMAStrategy, and a second alert when the funding rate
+// of the symbol is extreme or its open interest spiked at the time of the cross
+type AlertOnFundingStrategy struct {
+	*AlertOnMAStrategy
+	mu            sync.Mutex
+	feeder        exchange.Feeder
+	extremeRate   float64
+	spikePercent  float64
+	window        time.Duration
+	openInterests map[string][]model.OpenInterest // samples of the window, ordered by time
+}
+
+func NewAlertOnFundingStrategy(alerts *controller.AlertController, feeder exchange.Feeder) (*AlertOnFundingStrategy, error) {
+	maStrategy, err := NewAlertOnMAStrategy(alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	extremeRate := DefaultFundingExtremeRate
+	if viper.IsSet(FundingExtremeRateFlag) {
+		extremeRate = viper.GetFloat64(FundingExtremeRateFlag)
+	}
+	spikePercent := DefaultOpenInterestSpikePercent
+	if viper.IsSet(OpenInterestSpikePercentFlag) {
+		spikePercent = viper.GetFloat64(OpenInterestSpikePercentFlag)
+	}
+	window := viper.GetDuration(OpenInterestWindowFlag)
+	if window <= 0 {
+		window = DefaultOpenInterestWindow
+	}
+
+	s := &AlertOnFundingStrategy{
+		AlertOnMAStrategy: maStrategy,
+		feeder:            feeder,
+		extremeRate:       extremeRate,
+		spikePercent:      spikePercent,
+		window:            window,
+		openInterests:     make(map[string][]model.OpenInterest),
+	}
+	maStrategy.OnCross(s.onCross)
+	return s, nil
+}
+
+// OnOpenInterest records a sample of the open interest of the symbol, the samples older than the window of the last
+// one are dropped
+func (s *AlertOnFundingStrategy) OnOpenInterest(openInterest model.OpenInterest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := append(s.openInterests[openInterest.Symbol], openInterest)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	start := samples[len(samples)-1].Time.Add(-s.window)
+	var i int
+	for i < len(samples) && samples[i].Time.Before(start) {
+		i++
+	}
+	s.openInterests[openInterest.Symbol] = samples[i:]
+}
+
+// openInterestChange returns the open interest change in percent over the window ending at end, false when there
+// are not two samples in the window
+func (s *AlertOnFundingStrategy) openInterestChange(symbol string, end time.Time) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := end.Add(-s.window)
+	var first, last *model.OpenInterest
+	for i, sample := range s.openInterests[symbol] {
+		if sample.Time.Before(start) || sample.Time.After(end) {
+			continue
+		}
+		if first == nil {
+			first = &s.openInterests[symbol][i]
+		}
+		last = &s.openInterests[symbol][i]
+	}
+	if first == nil || first == last || first.OpenInterest == 0 {
+		return 0, false
+	}
+	return (last.OpenInterest - first.OpenInterest) / first.OpenInterest * 100, true
+}
+
+// lastFundingRate returns the last funding rate settled before end
+func (s *AlertOnFundingStrategy) lastFundingRate(ctx context.Context, symbol string, end time.Time) (model.FundingRate, bool, error) {
+	rates, err := s.feeder.FundingRates(ctx, symbol, end.Add(-FundingLookback), end)
+	if err != nil || len(rates) == 0 {
+		return model.FundingRate{}, false, err
+	}
+	return rates[len(rates)-1], true, nil
+}
+
+// onCross checks the funding and open interest at the close time of the crossing candle, so backtests read the data
+// of their period
+func (s *AlertOnFundingStrategy) onCross(isUp bool, params CandleParams) {
+	ctx, cancel := context.WithTimeout(context.Background(), OrderTimeout)
+	defer cancel()
+	end := params.Timeframe.CloseTime(params.LastUpdate)
+
+	rate, hasRate, err := s.lastFundingRate(ctx, params.Symbol, end)
+	if err != nil {
+		s.l.Warnw("get funding rate error", "error", err, "symbol", params.Symbol)
+	}
+	change, hasChange := s.openInterestChange(params.Symbol, end)
+
+	extreme := hasRate && math.Abs(rate.FundingRate) >= s.extremeRate
+	spike := hasChange && change >= s.spikePercent
+	if !extreme && !spike {
+		return
+	}
+
+	s.l.Infow("funding alert on MA 200 cross", "symbol", params.Symbol, "timeframe", params.Timeframe,
+		"is_up", isUp, "funding_rate", rate.FundingRate, "open_interest_change", change)
+	s.alerts.AddAlert(controller.Alert{
+		Name:       AlertFunding,
+		Symbol:     params.Symbol,
+		Timeframe:  params.Timeframe,
+		LastUpdate: params.LastUpdate,
+		Severity:   controller.SeverityWarning,
+		Message:    s.fundingMessage(isUp, params, rate, hasRate, change, hasChange),
+	})
+}
+
+func (s *AlertOnFundingStrategy) fundingMessage(isUp bool, params CandleParams, rate model.FundingRate, hasRate bool, change float64, hasChange bool) string {
+	emoji, direction := notification.EmojiArrowUp, "up"
+	if !isUp {
+		emoji, direction = notification.EmojiArrowDown, "down"
+	}
+
+	s.AlertOnMAStrategy.RLock()
+	symbols := s.symbols
+	s.AlertOnMAStrategy.RUnlock()
+
+	symbolInfo := fmt.Sprintf("<a href=\"https://www.binance.com/en/futures/%s\">Symbol %s</a>", params.Symbol, params.Symbol)
+	msg := fmt.Sprintf("%v Funding & OI on MA Cross %s | %s | Timeframe %v \nLast price: <b>%s</b>",
+		emoji, direction, symbolInfo, params.Timeframe, formatPrice(symbols, params.Symbol, params.LastClosePrice))
+	if hasRate {
+		msg += fmt.Sprintf(" \nFunding rate: <b>%.4f%%</b>", rate.FundingRate*100)
+		if math.Abs(rate.FundingRate) >= s.extremeRate {
+			msg += " (extreme)"
+		}
+	}
+	if hasChange {
+		msg += fmt.Sprintf(" \nOpen interest: <b>%+.2f%%</b> in %v", change, s.window)
+		if change >= s.spikePercent {
+			msg += " (spike)"
+		}
+	}
+	return msg
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/controller"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordNotifier struct {
+	sync.Mutex
+	messages []string
+}
+
+func (r *recordNotifier) SendMessage(msg string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+func (r *recordNotifier) OnError(err error) {}
+
+func (r *recordNotifier) reset() []string {
+	r.Lock()
+	defer r.Unlock()
+	messages := r.messages
+	r.messages = nil
+	return messages
+}
+
+func writeCSV(t *testing.T, dir, name string, lines [][]string) string {
+	file := filepath.Join(dir, name)
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, csv.NewWriter(f).WriteAll(lines))
+	return file
+}
+
+func TestAlertOnFundingStrategy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "funding")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	var rates, openInterests [][]string
+	for i, rate := range []float64{0.0001, 0.0015, -0.0002} {
+		rates = append(rates, model.FundingRate{
+			Symbol:      "BTCUSDT",
+			FundingRate: rate,
+			FundingTime: start.Add(time.Duration(i) * 8 * time.Hour),
+		}.ToSlice())
+	}
+	for i, value := range []float64{1000, 1050, 1150} {
+		openInterests = append(openInterests, model.OpenInterest{
+			Symbol:       "BTCUSDT",
+			OpenInterest: value,
+			Time:         start.Add(6*time.Hour + time.Duration(2*i+1)*10*time.Minute),
+		}.ToSlice())
+	}
+
+	feed, err := exchange.NewCSVFeed()
+	require.NoError(t, err)
+	require.NoError(t, feed.LoadFundingRates("BTCUSDT", writeCSV(t, dir, "funding.csv", rates)))
+	require.NoError(t, feed.LoadOpenInterest("BTCUSDT", writeCSV(t, dir, "open_interest.csv", openInterests)))
+
+	viper.Set(VolumePeriodFlag, 20)
+	viper.Set(VolumeMultiplierFlag, 1.5)
+	notifier := new(recordNotifier)
+	alerts, err := controller.NewAlertController(notifier)
+	require.NoError(t, err)
+	s, err := NewAlertOnFundingStrategy(alerts, feed)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	openInterestCh := make(chan model.OpenInterest)
+	go feed.OpenInterestSubscription(ctx, []string{"BTCUSDT"}, openInterestCh, make(chan error))
+	for openInterest := range openInterestCh {
+		s.OnOpenInterest(openInterest)
+	}
+
+	t.Run("open interest spike", func(t *testing.T) {
+		// the 1h candle closing at 07:00 sees the 1000 -> 1150 increase and the 0.01% funding of 00:00
+		s.onCross(true, CandleParams{Symbol: "BTCUSDT", Timeframe: model.Timeframe1h, LastUpdate: start.Add(6 * time.Hour)})
+		messages := notifier.reset()
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0], "Funding rate: <b>0.0100%</b>")
+		assert.Contains(t, messages[0], "Open interest: <b>+15.00%</b> in 1h0m0s (spike)")
+		assert.NotContains(t, messages[0], "(extreme)")
+	})
+
+	t.Run("extreme funding", func(t *testing.T) {
+		// the 0.15% funding of 08:00 is settled, the open interest has no sample in the last hour
+		s.onCross(false, CandleParams{Symbol: "BTCUSDT", Timeframe: model.Timeframe1h, LastUpdate: start.Add(9 * time.Hour)})
+		messages := notifier.reset()
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0], "Funding rate: <b>0.1500%</b> (extreme)")
+		assert.False(t, strings.Contains(messages[0], "Open interest"))
+	})
+
+	t.Run("nothing to report", func(t *testing.T) {
+		s.onCross(true, CandleParams{Symbol: "BTCUSDT", Timeframe: model.Timeframe1h, LastUpdate: start.Add(20 * time.Hour)})
+		assert.Empty(t, notifier.reset())
+	})
+}
@@ -1,16 +1,22 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/looplab/fsm"
+	"github.com/quangkeu95/binancebot/pkg/controller"
 	"github.com/quangkeu95/binancebot/pkg/exchange"
 	"github.com/quangkeu95/binancebot/pkg/model"
 	"github.com/quangkeu95/binancebot/pkg/notification"
 	"github.com/quangkeu95/binancebot/pkg/series"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+	"github.com/quangkeu95/binancebot/pkg/strategy"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -27,6 +33,24 @@ const (
 const (
 	VolumePeriodFlag     = "volume_period"
 	VolumeMultiplierFlag = "volume_multiplier"
+	// OrderBookDepthPercentFlag is the price range around the MA200 in which the order book liquidity is reported
+	OrderBookDepthPercentFlag = "order_book_depth_percent"
+	// OrderQuoteQuantityFlag is the quote amount bought at market when the price crosses up the MA200, the position
+	// is sold when it crosses down. Zero only sends alerts.
+	OrderQuoteQuantityFlag = "order_quote_quantity"
+
+	// MAStateReportMissedFlag reports the MA200 crosses of the candles closed while the bot was offline, once the
+	// saved state of a feed is restored. No order is placed for them.
+	MAStateReportMissedFlag = "ma_state.report_missed"
+	// MAStateMissedLookbackFlag is the number of candles preloaded on top of the MA200 to look for missed crosses
+	MAStateMissedLookbackFlag = "ma_state.missed_lookback"
+
+	DefaultOrderBookDepthPercent = 1.0
+	DefaultMAStateMissedLookback = 100
+	OrderTimeout                 = 10 * time.Second
+
+	// MAStateKeyPrefix prefixes the storage keys of the states of the feeds
+	MAStateKeyPrefix = "ma_state--"
 )
 
 const (
@@ -37,35 +61,78 @@ const (
 	MATrendDown = "DOWN"
 )
 
+// Names of the alerts, see controller.AlertController
+const (
+	AlertStart         = "start"
+	AlertMACross       = "ma_cross"
+	AlertMACrossMissed = "ma_cross_missed"
+	AlertFunding       = "funding"
+	AlertOrder         = "order"
+	AlertOrderFailed   = "order_failed"
+	AlertListed        = "listed"
+	AlertDelisted      = "delisted"
+)
+
 type State struct {
 	Symbol     string
-	Timeframe  string
+	Timeframe  model.Timeframe
 	MATrend    string
 	LastUpdate time.Time
 	Fsm        *fsm.FSM
 }
 
+// MAStateSnapshot is the state of a feed saved to the storage
+type MAStateSnapshot struct {
+	Symbol     string
+	Timeframe  model.Timeframe
+	State      string
+	LastUpdate time.Time
+}
+
+// missedCross is a cross of a candle closed while the bot was offline
+type missedCross struct {
+	isUp  bool
+	time  time.Time
+	price float64
+	ma200 float64
+}
+
 type CandleParams struct {
 	Symbol             string
-	Timeframe          string
+	Timeframe          model.Timeframe
 	LastUpdate         time.Time
 	LastClosePrice     float64
 	PreviousPriceMA200 float64
 	LastPriceMA200     float64
 	PreviousVolume     float64
 	LastVolume         float64
+	HasOrderBook       bool
+	BidDepth           float64 // quote amount of the bids within the depth percent of the MA200
+	AskDepth           float64
+	Imbalance          float64
 }
 
 type AlertOnMAStrategy struct {
 	sync.RWMutex
 	l                *zap.SugaredLogger
-	notifier         notification.Notifier
+	alerts           *controller.AlertController
+	symbols          strategy.SymbolInfoGetter
 	state            map[string]*State // store state of previous price vs MA price
 	volumePeriod     int
 	volumeMultiplier float64
+	depthPercent     float64
+	store            storage.KeyValueStorage // saves the states, nil keeps them in memory
+	reportMissed     bool
+	missedLookback   int
+
+	broker             exchange.Broker
+	orderQuoteQuantity float64
+	positions          map[string]float64 // base quantity bought for each symbol and timeframe
+
+	crossHandlers []func(isUp bool, params CandleParams)
 }
 
-func NewAlertOnMAStrategy(notifier notification.Notifier) (*AlertOnMAStrategy, error) {
+func NewAlertOnMAStrategy(alerts *controller.AlertController) (*AlertOnMAStrategy, error) {
 	l := zap.S()
 
 	volumePeriod := viper.GetInt(VolumePeriodFlag)
@@ -79,21 +146,73 @@ func NewAlertOnMAStrategy(notifier notification.Notifier) (*AlertOnMAStrategy, e
 		return nil, err
 	}
 
+	depthPercent := viper.GetFloat64(OrderBookDepthPercentFlag)
+	if depthPercent <= 0 {
+		depthPercent = DefaultOrderBookDepthPercent
+	}
+
+	missedLookback := viper.GetInt(MAStateMissedLookbackFlag)
+	if missedLookback <= 0 {
+		missedLookback = DefaultMAStateMissedLookback
+	}
+
 	return &AlertOnMAStrategy{
-		l:                l,
-		notifier:         notifier,
-		state:            make(map[string]*State),
-		volumePeriod:     volumePeriod,
-		volumeMultiplier: volumeMultiplier,
+		l:                  l,
+		alerts:             alerts,
+		state:              make(map[string]*State),
+		volumePeriod:       volumePeriod,
+		volumeMultiplier:   volumeMultiplier,
+		depthPercent:       depthPercent,
+		reportMissed:       viper.GetBool(MAStateReportMissedFlag),
+		missedLookback:     missedLookback,
+		orderQuoteQuantity: viper.GetFloat64(OrderQuoteQuantityFlag),
+		positions:          make(map[string]float64),
 	}, nil
 }
 
+// SetBroker lets the strategy open a position on MA200 cross up and close it on cross down, when
+// `order_quote_quantity` is set
+func (s *AlertOnMAStrategy) SetBroker(broker exchange.Broker) {
+	s.Lock()
+	defer s.Unlock()
+	s.broker = broker
+}
+
+// SetStorage saves the state of every feed to store, the saved states are restored on their first candle
+func (s *AlertOnMAStrategy) SetStorage(store storage.KeyValueStorage) {
+	s.Lock()
+	defer s.Unlock()
+	s.store = store
+}
+
+// SetSymbols formats the prices of the alerts at the tick size of their symbol
+func (s *AlertOnMAStrategy) SetSymbols(symbols strategy.SymbolInfoGetter) {
+	s.Lock()
+	defer s.Unlock()
+	s.symbols = symbols
+}
+
+// OnCross registers a handler called in its own goroutine after each MA200 cross alert
+func (s *AlertOnMAStrategy) OnCross(handler func(isUp bool, params CandleParams)) {
+	s.Lock()
+	defer s.Unlock()
+	s.crossHandlers = append(s.crossHandlers, handler)
+}
+
 // Init init is called one time before running strategy
 func (s *AlertOnMAStrategy) Init() {
-	s.notifier.SendMessage("Start Binance alert bot!!")
+	s.alerts.AddAlert(controller.Alert{
+		Name:     AlertStart,
+		Severity: controller.SeverityInfo,
+		Message:  "Start Binance alert bot!!",
+	})
 }
 
 func (s *AlertOnMAStrategy) WarmupPeriod() int {
+	// the missed crosses are looked for in the preloaded candles
+	if s.reportMissed {
+		return 201 + s.missedLookback
+	}
 	return 201
 }
 
@@ -108,7 +227,7 @@ func (s *AlertOnMAStrategy) OnCandle(df *model.Dataframe) {
 	previousCandleVolume := series.MA(volumes[:s.volumePeriod], s.volumePeriod)
 	lastCandleVolume := df.GetLast(model.CandleAttributeVolume, 0)
 
-	s.handleMACross(CandleParams{
+	params := CandleParams{
 		Symbol:             df.Symbol,
 		Timeframe:          df.Timeframe,
 		LastUpdate:         df.GetLastUpdate(),
@@ -117,10 +236,37 @@ func (s *AlertOnMAStrategy) OnCandle(df *model.Dataframe) {
 		PreviousPriceMA200: previousCandleMA200,
 		PreviousVolume:     previousCandleVolume,
 		LastVolume:         lastCandleVolume,
-	})
+	}
+	if df.OrderBook != nil && df.OrderBook.IsSynced() {
+		params.HasOrderBook = true
+		params.BidDepth, params.AskDepth = df.OrderBook.Depth(lastCandleMA200, s.depthPercent)
+		params.Imbalance = df.OrderBook.Imbalance(s.depthPercent)
+	}
+	// the alerts are added once the states are unlocked, their notifications are queued by the alert controller
+	if msg, lastCross := s.restoreState(df); msg != "" {
+		s.alerts.AddAlert(controller.Alert{
+			Name:       AlertMACrossMissed,
+			Symbol:     df.Symbol,
+			Timeframe:  df.Timeframe,
+			LastUpdate: lastCross,
+			Severity:   controller.SeverityInfo,
+			Message:    msg,
+		})
+	}
+	if msg := s.handleMACross(params); msg != "" {
+		s.alerts.AddAlert(controller.Alert{
+			Name:       AlertMACross,
+			Symbol:     params.Symbol,
+			Timeframe:  params.Timeframe,
+			LastUpdate: params.LastUpdate,
+			Severity:   controller.SeverityInfo,
+			Message:    msg,
+		})
+	}
 }
 
-func (s *AlertOnMAStrategy) handleMACross(params CandleParams) {
+// handleMACross moves the state machine of the candle feed, it returns the notification of a cross
+func (s *AlertOnMAStrategy) handleMACross(params CandleParams) string {
 	s.Lock()
 	defer s.Unlock()
 
@@ -148,35 +294,23 @@ func (s *AlertOnMAStrategy) handleMACross(params CandleParams) {
 			"last_update_unix", params.LastUpdate.Unix(),
 		)
 
-		// create new state machine for each symbol + timeframe
-		fsmIns := fsm.NewFSM(state, fsm.Events{
-			{Name: EventMA200CrossUp, Src: []string{MAStateEqual.String(), MAStateBelow.String()}, Dst: MAStateAbove.String()},
-			{Name: EventMA200CrossDown, Src: []string{MAStateEqual.String(), MAStateAbove.String()}, Dst: MAStateBelow.String()},
-		}, fsm.Callbacks{
-			"after_" + EventMA200CrossDown: func(e *fsm.Event) {
-
-			},
-			"after_" + EventMA200CrossUp: func(e *fsm.Event) {
-
-			},
-		})
-
 		s.state[key] = &State{
 			Symbol:     params.Symbol,
 			Timeframe:  params.Timeframe,
 			LastUpdate: params.LastUpdate,
-			Fsm:        fsmIns,
+			Fsm:        newMAFsm(state),
 		}
-		return
+		s.saveState(key, s.state[key])
+		return ""
 	}
 
 	currentFsm := s.state[key].Fsm
-	currentState := currentFsm.Current()
+	event := crossEvent(currentFsm.Current(), params.LastClosePrice, params.LastPriceMA200)
 
-	if (currentState == MAStateBelow.String() || currentState == MAStateEqual.String()) && params.LastClosePrice >= params.LastPriceMA200 {
+	if event == EventMA200CrossUp {
 		// avoid alert twice in the same timeframe period
 		if s.state[key].LastUpdate == params.LastUpdate {
-			return
+			return ""
 		}
 
 		// if !s.isEnoughVolume(params) {
@@ -184,7 +318,7 @@ func (s *AlertOnMAStrategy) handleMACross(params CandleParams) {
 		// }
 		if err := currentFsm.Event(EventMA200CrossUp); err != nil {
 			s.l.Errorw("emit event MA cross up error", "error", err)
-			return
+			return ""
 		}
 
 		maTrend := getMATrend(params.PreviousPriceMA200, params.LastPriceMA200)
@@ -197,21 +331,29 @@ func (s *AlertOnMAStrategy) handleMACross(params CandleParams) {
 			"previous_volume", params.PreviousVolume,
 			"last_update", params.LastUpdate)
 
-		s.sendNotification(true, maTrend, params)
+		msg := s.crossMessage(true, maTrend, params)
 		s.state[key].LastUpdate = params.LastUpdate
+		s.saveState(key, s.state[key])
+		for _, handler := range s.crossHandlers {
+			go handler(true, params)
+		}
+		if s.broker != nil && s.orderQuoteQuantity > 0 {
+			go s.openPosition(key, params)
+		}
+		return msg
 	}
 
-	if (currentState == MAStateAbove.String() || currentState == MAStateEqual.String()) && params.LastClosePrice <= params.LastPriceMA200 {
+	if event == EventMA200CrossDown {
 		// avoid alert twice in the same timeframe period
 		if s.state[key].LastUpdate == params.LastUpdate {
-			return
+			return ""
 		}
 		// if !s.isEnoughVolume(params) {
 		// 	return
 		// }
 		if err := currentFsm.Event(EventMA200CrossDown); err != nil {
 			s.l.Errorw("emit event MA cross down error", "error", err)
-			return
+			return ""
 		}
 		maTrend := getMATrend(params.PreviousPriceMA200, params.LastPriceMA200)
 		s.l.Infow("event MA 200 cross down",
@@ -224,18 +366,210 @@ func (s *AlertOnMAStrategy) handleMACross(params CandleParams) {
 			"previous_volume", params.PreviousVolume,
 			"last_update", params.LastUpdate)
 
-		s.sendNotification(false, maTrend, params)
+		msg := s.crossMessage(false, maTrend, params)
 		s.state[key].LastUpdate = params.LastUpdate
+		s.saveState(key, s.state[key])
+		for _, handler := range s.crossHandlers {
+			go handler(false, params)
+		}
+		if quantity := s.positions[key]; s.broker != nil && quantity > 0 {
+			delete(s.positions, key)
+			go s.closePosition(params, quantity)
+		}
+		return msg
+	}
+	return ""
+}
+
+// newMAFsm creates the state machine of a symbol + timeframe in state
+func newMAFsm(state string) *fsm.FSM {
+	return fsm.NewFSM(state, fsm.Events{
+		{Name: EventMA200CrossUp, Src: []string{MAStateEqual.String(), MAStateBelow.String()}, Dst: MAStateAbove.String()},
+		{Name: EventMA200CrossDown, Src: []string{MAStateEqual.String(), MAStateAbove.String()}, Dst: MAStateBelow.String()},
+	}, fsm.Callbacks{
+		"after_" + EventMA200CrossDown: func(e *fsm.Event) {
+
+		},
+		"after_" + EventMA200CrossUp: func(e *fsm.Event) {
+
+		},
+	})
+}
+
+// crossEvent returns the event moving the state machine from state with the close price against the MA200, empty
+// when the state holds
+func crossEvent(state string, price, ma200 float64) string {
+	switch {
+	case (state == MAStateBelow.String() || state == MAStateEqual.String()) && price >= ma200:
+		return EventMA200CrossUp
+	case (state == MAStateAbove.String() || state == MAStateEqual.String()) && price <= ma200:
+		return EventMA200CrossDown
 	}
+	return ""
+}
+
+// restoreState restores the saved state of the feed of df on its first candle. When the missed crosses are reported,
+// the closed candles after the saved state move the state machine and their crosses are returned in a single
+// message, with the time of the last one.
+func (s *AlertOnMAStrategy) restoreState(df *model.Dataframe) (string, time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := s.generateKey(df.Symbol, df.Timeframe)
+	if _, ok := s.state[key]; ok || s.store == nil {
+		return "", time.Time{}
+	}
+	var snapshot MAStateSnapshot
+	if err := s.store.Get(MAStateKeyPrefix+key, &snapshot); err != nil {
+		if !errors.Is(err, storage.ErrKeyNotFound) {
+			s.l.Warnw("restore state error", "error", err, "symbol", df.Symbol, "timeframe", df.Timeframe)
+		}
+		return "", time.Time{}
+	}
+
+	state := &State{
+		Symbol:     df.Symbol,
+		Timeframe:  df.Timeframe,
+		LastUpdate: snapshot.LastUpdate,
+		Fsm:        newMAFsm(snapshot.State),
+	}
+	s.state[key] = state
+	s.l.Infow("restore state", "symbol", df.Symbol, "timeframe", df.Timeframe, "state", snapshot.State,
+		"last_update", snapshot.LastUpdate)
+	if !s.reportMissed {
+		return "", time.Time{}
+	}
+
+	crosses := s.missedCrosses(df, state)
+	if len(crosses) == 0 {
+		return "", time.Time{}
+	}
+	s.l.Infow("missed MA 200 crosses", "symbol", df.Symbol, "timeframe", df.Timeframe, "crosses", len(crosses),
+		"next_state", state.Fsm.Current())
+	s.saveState(key, state)
+	return s.missedMessage(df.Symbol, df.Timeframe, crosses), crosses[len(crosses)-1].time
+}
+
+// missedCrosses replays the closed candles of df after the last update of state, s must be locked
+func (s *AlertOnMAStrategy) missedCrosses(df *model.Dataframe, state *State) []missedCross {
+	df.RLock()
+	defer df.RUnlock()
+
+	var crosses = make([]missedCross, 0)
+	// the last candle may still be open, it is handled as a live candle
+	for i := 199; i < len(df.Close)-1; i++ {
+		if !df.Time[i].After(state.LastUpdate) {
+			continue
+		}
+		price, ma200 := df.Close[i], series.MA(df.Close[i-199:i+1], 200)
+		event := crossEvent(state.Fsm.Current(), price, ma200)
+		if event == "" {
+			continue
+		}
+		if err := state.Fsm.Event(event); err != nil {
+			s.l.Errorw("replay missed MA cross error", "error", err, "symbol", df.Symbol, "timeframe", df.Timeframe)
+			continue
+		}
+		state.LastUpdate = df.Time[i]
+		crosses = append(crosses, missedCross{
+			isUp:  event == EventMA200CrossUp,
+			time:  df.Time[i],
+			price: price,
+			ma200: ma200,
+		})
+	}
+	return crosses
+}
+
+// saveState snapshots the state of the feed key to the storage, s must be locked
+func (s *AlertOnMAStrategy) saveState(key string, state *State) {
+	if s.store == nil {
+		return
+	}
+	snapshot := MAStateSnapshot{
+		Symbol:     state.Symbol,
+		Timeframe:  state.Timeframe,
+		State:      state.Fsm.Current(),
+		LastUpdate: state.LastUpdate,
+	}
+	if err := s.store.Set(MAStateKeyPrefix+key, snapshot); err != nil {
+		s.l.Warnw("save state error", "error", err, "symbol", state.Symbol, "timeframe", state.Timeframe)
+	}
+}
+
+// openPosition buys the configured quote amount at market, the bought quantity is sold by closePosition
+func (s *AlertOnMAStrategy) openPosition(key string, params CandleParams) {
+	ctx, cancel := context.WithTimeout(context.Background(), OrderTimeout)
+	defer cancel()
+
+	order, err := s.broker.OrderMarketQuote(ctx, model.SideTypeBuy, params.Symbol, s.orderQuoteQuantity)
+	if err != nil {
+		s.l.Errorw("open position error", "error", err, "symbol", params.Symbol, "timeframe", params.Timeframe)
+		s.orderFailed(params, fmt.Sprintf("Open position %s | Timeframe %v failed: %v", params.Symbol, params.Timeframe, err))
+		return
+	}
+
+	s.Lock()
+	s.positions[key] += order.ExecutedQuantity
+	s.Unlock()
+	s.sendOrderNotification(params, order)
+}
+
+func (s *AlertOnMAStrategy) closePosition(params CandleParams, quantity float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), OrderTimeout)
+	defer cancel()
+
+	order, err := s.broker.OrderMarket(ctx, model.SideTypeSell, params.Symbol, quantity)
+	if err != nil {
+		s.l.Errorw("close position error", "error", err, "symbol", params.Symbol, "timeframe", params.Timeframe,
+			"quantity", quantity)
+		s.orderFailed(params, fmt.Sprintf("Close position %s | Timeframe %v failed: %v", params.Symbol, params.Timeframe, err))
+		return
+	}
+	s.sendOrderNotification(params, order)
+}
+
+func (s *AlertOnMAStrategy) sendOrderNotification(params CandleParams, order model.Order) {
+	s.RLock()
+	symbols := s.symbols
+	s.RUnlock()
+	quantity := strconv.FormatFloat(order.ExecutedQuantity, 'f', -1, 64)
+	if symbols != nil {
+		if info, ok := symbols.SymbolInfo(order.Symbol); ok {
+			quantity = info.FormatQuantity(order.ExecutedQuantity)
+		}
+	}
+	// the position is opened and closed on different candles
+	s.alerts.AddAlert(controller.Alert{
+		Name:       AlertOrder,
+		Symbol:     params.Symbol,
+		Timeframe:  params.Timeframe,
+		LastUpdate: params.LastUpdate,
+		Severity:   controller.SeverityInfo,
+		Message: fmt.Sprintf("Order %v %s | Timeframe %v \nStatus: <b>%v</b> \nQuantity: <b>%s</b> \nAverage price: <b>%s</b>",
+			order.Side, order.Symbol, params.Timeframe, order.Status, quantity,
+			formatPrice(symbols, order.Symbol, order.AveragePrice())),
+	})
+}
 
+// orderFailed alerts that the order of the cross of params could not be placed
+func (s *AlertOnMAStrategy) orderFailed(params CandleParams, msg string) {
+	s.alerts.AddAlert(controller.Alert{
+		Name:       AlertOrderFailed,
+		Symbol:     params.Symbol,
+		Timeframe:  params.Timeframe,
+		LastUpdate: params.LastUpdate,
+		Severity:   controller.SeverityCritical,
+		Message:    msg,
+	})
 }
 
-func (s *AlertOnMAStrategy) generateKey(symbol, timeframe string) string {
+func (s *AlertOnMAStrategy) generateKey(symbol string, timeframe model.Timeframe) string {
 	key := fmt.Sprintf("%s--%s", symbol, timeframe)
 	return key
 }
 
-func (s *AlertOnMAStrategy) sendNotification(isUp bool, maTrend string, params CandleParams) {
+func (s *AlertOnMAStrategy) crossMessage(isUp bool, maTrend string, params CandleParams) string {
 	var emoji string
 	if isUp {
 		emoji = notification.EmojiArrowUp
@@ -244,8 +578,8 @@ func (s *AlertOnMAStrategy) sendNotification(isUp bool, maTrend string, params C
 	}
 
 	symbolInfo := fmt.Sprintf("<a href=\"https://www.binance.com/en/trade/%s\">Symbol %s</a>", params.Symbol, params.Symbol)
-	lastPriceInfo := fmt.Sprintf("Last price: <b>%v</b>", params.LastClosePrice)
-	lastMA200Info := fmt.Sprintf("Last MA200: <b>%v</b>", params.LastPriceMA200)
+	lastPriceInfo := fmt.Sprintf("Last price: <b>%s</b>", formatPrice(s.symbols, params.Symbol, params.LastClosePrice))
+	lastMA200Info := fmt.Sprintf("Last MA200: <b>%s</b>", formatPrice(s.symbols, params.Symbol, params.LastPriceMA200))
 	maTrendInfo := fmt.Sprintf("MA Trend: <b>%v</b>", maTrend)
 	lastUpdateInfo := fmt.Sprintf("Last Update: <b>%v</b>", params.LastUpdate)
 	lastVolumeInfo := fmt.Sprintf("Last Volume: <b>%f</b>", params.LastVolume)
@@ -257,27 +591,58 @@ func (s *AlertOnMAStrategy) sendNotification(isUp bool, maTrend string, params C
 	msg := fmt.Sprintf("%v MA Cross | %s | Timeframe %v \n%v \n%v \n%v \n%v \n%v \n%v \n%v",
 		emoji, symbolInfo, params.Timeframe,
 		lastPriceInfo, lastMA200Info, lastVolumeInfo, previousVolumeInfo, maTrendInfo, compareVolumeInfo, lastUpdateInfo)
-	s.notifier.SendMessage(msg)
+	if params.HasOrderBook {
+		msg += fmt.Sprintf(" \nLiquidity within %v%% of MA200: bids <b>%f</b> - asks <b>%f</b> - imbalance <b>%.2f</b>",
+			s.depthPercent, params.BidDepth, params.AskDepth, params.Imbalance)
+	}
+	return msg
 }
 
-func (s *AlertOnMAStrategy) isEnoughVolume(params CandleParams) bool {
-	timeframeInSeconds := exchange.ParseTimeframeToSeconds(params.Timeframe)
-	lastUpdate := params.LastUpdate.UnixNano() / 1_000_000
-	now := time.Now().UnixNano() / 1_000_000
-	ratio := float64(now-lastUpdate) / float64(timeframeInSeconds*1000)
+func (s *AlertOnMAStrategy) missedMessage(symbol string, timeframe model.Timeframe, crosses []missedCross) string {
+	symbolInfo := fmt.Sprintf("<a href=\"https://www.binance.com/en/trade/%s\">Symbol %s</a>", symbol, symbol)
+	msg := fmt.Sprintf("MA Cross missed while offline | %s | Timeframe %v", symbolInfo, timeframe)
+	for _, cross := range crosses {
+		emoji, direction := notification.EmojiArrowUp, "up"
+		if !cross.isUp {
+			emoji, direction = notification.EmojiArrowDown, "down"
+		}
+		msg += fmt.Sprintf(" \n%v Cross %s at <b>%v</b>: price <b>%s</b> - MA200 <b>%s</b>", emoji, direction,
+			cross.time, formatPrice(s.symbols, symbol, cross.price), formatPrice(s.symbols, symbol, cross.ma200))
+	}
+	return msg
+}
 
+// formatPrice formats price at the tick size of symbol, unknown symbols are formatted with the shortest
+// representation
+func formatPrice(symbols strategy.SymbolInfoGetter, symbol string, price float64) string {
+	if symbols != nil {
+		if info, ok := symbols.SymbolInfo(symbol); ok {
+			return info.FormatPrice(price)
+		}
+	}
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
+
+func (s *AlertOnMAStrategy) isEnoughVolume(params CandleParams) bool {
+	ratio := elapsedRatio(params, time.Now())
 	return params.LastVolume >= s.volumeMultiplier*params.PreviousVolume*ratio
 }
 
 func (s *AlertOnMAStrategy) getVolumeInfo(params CandleParams) string {
-	timeframeInSeconds := exchange.ParseTimeframeToSeconds(params.Timeframe)
-	lastUpdate := params.LastUpdate.UnixNano() / 1_000_000
-	now := time.Now().UnixNano() / 1_000_000
-	ratio := float64(now-lastUpdate) / float64(timeframeInSeconds*1000)
-
+	ratio := elapsedRatio(params, time.Now())
 	return fmt.Sprintf("Current volume <b>%f</b> - Previous Volume with ratio <b>%f</b>", params.LastVolume, s.volumeMultiplier*params.PreviousVolume*ratio)
 }
 
+// elapsedRatio returns the elapsed part of the last candle period, the last update being the candle open time
+func elapsedRatio(params CandleParams, now time.Time) float64 {
+	openTime := params.Timeframe.OpenTime(params.LastUpdate)
+	period := params.Timeframe.CloseTime(params.LastUpdate).Sub(openTime)
+	if period <= 0 {
+		return 1
+	}
+	return float64(now.Sub(openTime)) / float64(period)
+}
+
 func getMATrend(previousMA200, lastMA200 float64) string {
 	var maTrend string
 	if previousMA200 < lastMA200 {
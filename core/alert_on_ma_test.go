@@ -5,7 +5,13 @@ import (
 	"testing"
 	"time"
 
-	"github.com/quangkeu95/binancebot/config"
+	"github.com/quangkeu95/binancebot/pkg/controller"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/notification"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -20,8 +26,11 @@ func TestAlertOnMAStrategyTestSuite(t *testing.T) {
 
 func (ts *AlertOnMAStrategyTestSuite) SetupSuite() {
 	assert := ts.Assert()
-	config.InitConfig()
-	strategy, err := NewAlertOnMAStrategy()
+	viper.Set(VolumePeriodFlag, 20)
+	viper.Set(VolumeMultiplierFlag, 1.5)
+	alerts, err := controller.NewAlertController(notification.NewMocNotifier())
+	assert.NoError(err)
+	strategy, err := NewAlertOnMAStrategy(alerts)
 	assert.NoError(err)
 	assert.NotNil(strategy)
 
@@ -34,7 +43,7 @@ func (ts *AlertOnMAStrategyTestSuite) TestIsEnoughVolume() {
 
 	params := CandleParams{
 		Symbol:         "BTCUSDT",
-		Timeframe:      "4h",
+		Timeframe:      model.Timeframe4h,
 		LastUpdate:     time.Unix(1627315200, 0),
 		PreviousVolume: 10000.0,
 		LastVolume:     300.0,
@@ -42,3 +51,88 @@ func (ts *AlertOnMAStrategyTestSuite) TestIsEnoughVolume() {
 	isEnoughVolume := ts.strategy.isEnoughVolume(params)
 	log.Println(isEnoughVolume)
 }
+
+// memoryStorage encodes the values like BadgerDB does
+type memoryStorage map[string][]byte
+
+func (m memoryStorage) Set(key string, value interface{}) error {
+	data, err := storage.Encode(value)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+func (m memoryStorage) Get(key string, value interface{}) error {
+	data, ok := m[key]
+	if !ok {
+		return storage.ErrKeyNotFound
+	}
+	return storage.Decode(data, value)
+}
+
+func TestAlertOnMAStrategyRestoreState(t *testing.T) {
+	viper.Set(VolumePeriodFlag, 20)
+	viper.Set(VolumeMultiplierFlag, 1.5)
+	defer viper.Set(MAStateReportMissedFlag, nil)
+
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	dataframe := func(closes []float64) *model.Dataframe {
+		df := &model.Dataframe{Symbol: "BTCUSDT", Timeframe: model.Timeframe4h}
+		for i, price := range closes {
+			df.AddNewCandle(model.Candle{Time: start.Add(time.Duration(i) * 4 * time.Hour), Close: price, Volume: 1})
+		}
+		return df
+	}
+	var closes = make([]float64, 0)
+	for i := 0; i < 200; i++ {
+		closes = append(closes, 100)
+	}
+	closes = append(closes, 110)
+
+	store := make(memoryStorage)
+	notifier := new(recordNotifier)
+	newStrategy := func() *AlertOnMAStrategy {
+		alerts, err := controller.NewAlertController(notifier)
+		require.NoError(t, err)
+		s, err := NewAlertOnMAStrategy(alerts)
+		require.NoError(t, err)
+		s.SetStorage(store)
+		return s
+	}
+	snapshot := func() MAStateSnapshot {
+		var snapshot MAStateSnapshot
+		require.NoError(t, store.Get(MAStateKeyPrefix+"BTCUSDT--4h", &snapshot))
+		return snapshot
+	}
+
+	// the initial state above the MA200 is saved
+	newStrategy().OnCandle(dataframe(closes))
+	assert.Equal(t, MAStateSnapshot{Symbol: "BTCUSDT", Timeframe: model.Timeframe4h, State: MAStateAbove.String(),
+		LastUpdate: start.Add(200 * 4 * time.Hour)}, snapshot())
+	assert.Empty(t, notifier.reset())
+
+	// the price falls below the MA200 while offline and stays there
+	for i := 201; i < 250; i++ {
+		closes = append(closes, 110)
+	}
+	for i := 250; i < 301; i++ {
+		closes = append(closes, 50)
+	}
+
+	viper.Set(MAStateReportMissedFlag, true)
+	s := newStrategy()
+	assert.Equal(t, 301, s.WarmupPeriod())
+	s.OnCandle(dataframe(closes))
+	messages := notifier.reset()
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "MA Cross missed while offline")
+	assert.Contains(t, messages[0], "Cross down at <b>2021-11-11 16:00:00 +0000 UTC</b>: price <b>50</b>")
+	assert.Equal(t, MAStateBelow.String(), snapshot().State)
+	assert.Equal(t, start.Add(250*4*time.Hour), snapshot().LastUpdate)
+
+	// nothing was missed since the last restart
+	newStrategy().OnCandle(dataframe(closes))
+	assert.Empty(t, notifier.reset())
+}
@@ -2,10 +2,15 @@ package core
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/quangkeu95/binancebot/pkg/controller"
 	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
 	"github.com/quangkeu95/binancebot/pkg/strategy"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -15,45 +20,131 @@ const (
 	SelectedSymbolsFlag = "symbols"
 	ExcludedSymbolsFlag = "excluded_symbols"
 	ListTimeframesFlag  = "timeframes"
+	// ResampleSourceFlag is the timeframe streamed for every symbol to build the configured timeframes from, when
+	// empty the exchange streams each timeframe and only the custom ones are resampled
+	ResampleSourceFlag = "resample_source"
+	// OrderBookFlag enables the local order books of the symbols, read by the strategies next to the dataframes
+	OrderBookFlag = "order_book"
 )
 
 type Core struct {
 	sync.RWMutex
-	l                *zap.SugaredLogger
-	exchange         exchange.Exchange
-	candleController *controller.CandleController
-	symbolController *controller.SymbolsController
-	strategy         strategy.Strategy
-	// keyValueStorage  storage.KeyValueStorage
+	l                      *zap.SugaredLogger
+	exchange               exchange.Feeder
+	candleController       *controller.CandleController
+	orderBookController    *controller.OrderBookController
+	openInterestController *controller.OpenInterestController
+	liquidationController  *controller.LiquidationController
+	symbolController       *controller.SymbolsController
+	watchdog               *controller.Watchdog
+	health                 *controller.Health
+	strategy               strategy.Strategy
+	flowAlert              *AlertOnFlow
+	alerts                 *controller.AlertController
+	resampleSource         model.Timeframe
+	listTimeframes         []model.Timeframe
+	watchedSymbols         map[string]bool
+	strategyControllers    []*strategy.Controller
+	symbolSubscriptions    map[string][]*candleSubscription // candles of the symbols added while running
+	keyValueStorage        storage.KeyValueStorage
+	candleStore            storage.CandleStore
 }
 
-func New(ex exchange.Exchange, str strategy.Strategy) (*Core, error) {
-	symbolController, err := controller.NewSymbolsController(ex)
+// New watches the trading symbols of ex with str. The listing dates of the symbols and the state of the strategy are
+// saved to store, a nil store keeps them in memory.
+func New(ex exchange.Feeder, str strategy.Strategy, store storage.KeyValueStorage) (*Core, error) {
+	symbolController, err := controller.NewSymbolsController(ex, store)
 	if err != nil {
 		return nil, err
 	}
 
-	// badgerDB, err := storage.NewBadgerDB()
-	// if err != nil {
-	// 	return nil, err
-	// }
+	resampleSource := model.Timeframe(viper.GetString(ResampleSourceFlag))
+	if resampleSource != "" && !resampleSource.IsValid() {
+		return nil, fmt.Errorf("%w: `%s` %q is not streamed by the exchange", model.ErrInvalidTimeframe, ResampleSourceFlag, resampleSource)
+	}
 
+	candleController := controller.NewCandleController(ex)
+	watchdog := controller.NewWatchdog(candleController)
 	c := &Core{
-		l:                zap.S(),
-		exchange:         ex,
-		candleController: controller.NewCandleController(ex),
-		symbolController: symbolController,
-		strategy:         str,
-		// keyValueStorage:  badgerDB,
+		l:                      zap.S(),
+		exchange:               ex,
+		candleController:       candleController,
+		orderBookController:    controller.NewOrderBookController(ex),
+		openInterestController: controller.NewOpenInterestController(ex),
+		liquidationController:  controller.NewLiquidationController(ex),
+		symbolController:       symbolController,
+		watchdog:               watchdog,
+		health:                 controller.NewHealth(symbolController, candleController, watchdog),
+		strategy:               str,
+		resampleSource:         resampleSource,
+		watchedSymbols:         make(map[string]bool),
+		symbolSubscriptions:    make(map[string][]*candleSubscription),
 	}
 
+	if str, ok := c.strategy.(strategy.SymbolsStrategy); ok {
+		str.SetSymbols(symbolController)
+	}
 	c.strategy.Init()
+	if store != nil {
+		c.SetStorage(store)
+	}
 
 	return c, nil
 }
 
-func (c *Core) Run(ctx context.Context, listTimeframes []string) error {
+// SetStorage saves the state of the strategy to store when it keeps one, it must be set before running. The store given
+// to New is already set.
+func (c *Core) SetStorage(store storage.KeyValueStorage) {
+	c.Lock()
+	c.keyValueStorage = store
+	c.Unlock()
+	if str, ok := c.strategy.(strategy.StorageStrategy); ok {
+		str.SetStorage(store)
+	}
+}
+
+// SetCandleStore preloads the candles from store, only the candles missing from it are fetched from the exchange.
+// The closed candles streamed are saved to it.
+func (c *Core) SetCandleStore(store storage.CandleStore) {
+	c.candleController.SetCandleStore(store)
+	c.Lock()
+	defer c.Unlock()
+	c.candleStore = store
+}
+
+// SetFlowAlert reports the whale trades and liquidations of the watched symbols, as enabled in the flow alert
+func (c *Core) SetFlowAlert(flowAlert *AlertOnFlow) {
+	flowAlert.SetSymbols(c.symbolController)
+	c.Lock()
+	defer c.Unlock()
+	c.flowAlert = flowAlert
+}
+
+// Health checks that the symbols are fetched and their candle feeds streamed
+func (c *Core) Health() *controller.Health {
+	return c.health
+}
+
+// SetAlerts reports the symbols listed and delisted while running, and the stale feeds found by the watchdog
+func (c *Core) SetAlerts(alerts *controller.AlertController) {
+	c.watchdog.SetAlerts(alerts)
+	c.Lock()
+	defer c.Unlock()
+	c.alerts = alerts
+}
+
+func (c *Core) Run(ctx context.Context, listTimeframes []model.Timeframe) error {
 	c.l.Infow("Running core")
+	defer c.candleController.Close()
+
+	for _, timeframe := range listTimeframes {
+		if _, _, ok := timeframe.Bar(); ok {
+			continue
+		}
+		if _, err := c.sourceTimeframe(timeframe); err != nil {
+			return err
+		}
+	}
 
 	var listSymbols = make([]string, 0)
 	symbolInfos := c.symbolController.GetTradingSymbols()
@@ -68,6 +159,7 @@ func (c *Core) Run(ctx context.Context, listTimeframes []string) error {
 			}
 			listSymbols = append(listSymbols, symbol)
 		}
+		c.l.Infof("There are %v selected pairs", len(listSymbols))
 	} else {
 		for symbol := range symbolInfos {
 			if isInList(excludedSymbols, symbol) {
@@ -75,12 +167,28 @@ func (c *Core) Run(ctx context.Context, listTimeframes []string) error {
 			}
 			listSymbols = append(listSymbols, symbol)
 		}
+		c.l.Infof("There are %v pairs with %s", len(listSymbols), strings.Join(c.symbolController.QuoteAssets(), ", "))
+	}
+
+	c.Lock()
+	c.listTimeframes = listTimeframes
+	for _, symbol := range listSymbols {
+		c.watchedSymbols[symbol] = true
 	}
+	c.Unlock()
+	c.symbolController.OnSymbolEvent(func(event controller.SymbolEvent) {
+		c.onSymbolEvent(ctx, event)
+	})
 
-	c.l.Infof("There are %v pair with USDT", len(listSymbols))
+	orderBookEnabled := viper.GetBool(OrderBookFlag)
+	if orderBookEnabled {
+		for _, symbol := range listSymbols {
+			c.orderBookController.Subscribe(symbol)
+		}
+	}
 
 	for _, timeframe := range listTimeframes {
-		var mapSymbolTimeframe = make(map[string]string)
+		var mapSymbolTimeframe = make(map[string]model.Timeframe)
 		for _, symbol := range listSymbols {
 			mapSymbolTimeframe[symbol] = timeframe
 		}
@@ -90,52 +198,330 @@ func (c *Core) Run(ctx context.Context, listTimeframes []string) error {
 		}
 	}
 
+	if orderBookEnabled {
+		go c.orderBookController.Start(ctx)
+	}
+	if str, ok := c.strategy.(strategy.OpenInterestStrategy); ok {
+		for _, symbol := range listSymbols {
+			c.openInterestController.Subscribe(symbol, str.OnOpenInterest)
+		}
+		go c.openInterestController.Start(ctx)
+	}
+	c.RLock()
+	flowAlert := c.flowAlert
+	c.RUnlock()
+	if flowAlert != nil {
+		for _, symbol := range listSymbols {
+			if flowAlert.whaleTrades {
+				c.candleController.SubscribeTrades(symbol, flowAlert.OnTrade)
+			}
+			if flowAlert.liquidations {
+				c.liquidationController.Subscribe(symbol, flowAlert.OnLiquidation)
+			}
+		}
+		go c.liquidationController.Start(ctx)
+		go flowAlert.Start(ctx)
+	}
+	go c.watchdog.Start(ctx)
 	c.candleController.Start(ctx)
 
 	return nil
 }
 
-func (c *Core) SubscribeCandles(ctx context.Context, mapSymbolTimeframe map[string]string) error {
+// AddSymbol follows symbol while running like the symbols of Run: its candles are preloaded and streamed for every
+// timeframe, along with its order book, open interest, trades and liquidations when enabled
+func (c *Core) AddSymbol(ctx context.Context, symbol string) error {
+	c.Lock()
+	if c.watchedSymbols[symbol] {
+		c.Unlock()
+		return nil
+	}
+	c.watchedSymbols[symbol] = true
+	listTimeframes := c.listTimeframes
+	flowAlert := c.flowAlert
+	c.Unlock()
+
+	// the order book is set to the dataframes of the symbol when they are created
+	orderBookEnabled := viper.GetBool(OrderBookFlag)
+	if orderBookEnabled {
+		c.orderBookController.Subscribe(symbol)
+	}
+	var subscriptions = make([]*candleSubscription, 0, len(listTimeframes))
+	for _, timeframe := range listTimeframes {
+		subscription, err := c.subscribeCandles(ctx, map[string]model.Timeframe{symbol: timeframe})
+		if err != nil {
+			// the timeframes already subscribed are undone, so the symbol can be added again
+			for _, subscription := range subscriptions {
+				c.unsubscribeCandles(subscription)
+			}
+			if orderBookEnabled {
+				c.orderBookController.Unsubscribe(symbol)
+			}
+			c.Lock()
+			delete(c.watchedSymbols, symbol)
+			c.Unlock()
+			return err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	c.Lock()
+	c.symbolSubscriptions[symbol] = subscriptions
+	c.Unlock()
+
+	if str, ok := c.strategy.(strategy.OpenInterestStrategy); ok {
+		c.openInterestController.Subscribe(symbol, str.OnOpenInterest)
+	}
+	if flowAlert != nil {
+		if flowAlert.whaleTrades {
+			c.candleController.SubscribeTrades(symbol, flowAlert.OnTrade)
+		}
+		if flowAlert.liquidations {
+			c.liquidationController.Subscribe(symbol, flowAlert.OnLiquidation)
+		}
+	}
+	return nil
+}
+
+// RemoveSymbol stops following symbol, its streams are closed and its dataframes freed. The strategy controllers of
+// the symbol added while running are removed.
+func (c *Core) RemoveSymbol(symbol string) {
+	c.Lock()
+	if !c.watchedSymbols[symbol] {
+		c.Unlock()
+		return
+	}
+	delete(c.watchedSymbols, symbol)
+	subscriptions := c.symbolSubscriptions[symbol]
+	delete(c.symbolSubscriptions, symbol)
+	c.Unlock()
+
+	for _, subscription := range subscriptions {
+		c.unsubscribeCandles(subscription)
+	}
+	// the symbols of Run share their candle subscriptions and strategy controllers
+	c.candleController.UnsubscribeSymbol(symbol)
+	c.RLock()
+	strategyControllers := c.strategyControllers
+	c.RUnlock()
+	for _, strategyController := range strategyControllers {
+		strategyController.RemoveSymbol(symbol)
+	}
+	c.orderBookController.Unsubscribe(symbol)
+	c.openInterestController.Unsubscribe(symbol)
+	c.liquidationController.Unsubscribe(symbol)
+}
+
+// onSymbolEvent follows the changes of the trading symbols. Symbols set in `symbols` are only removed once
+// delisted, the universe is not followed.
+func (c *Core) onSymbolEvent(ctx context.Context, event controller.SymbolEvent) {
+	var (
+		symbol          = event.Symbol.Symbol
+		followsUniverse = len(viper.GetStringSlice(SelectedSymbolsFlag)) == 0
+		excluded        = isInList(viper.GetStringSlice(ExcludedSymbolsFlag), symbol)
+	)
+	switch {
+	case event.Added() && followsUniverse && !excluded:
+		if err := c.AddSymbol(ctx, symbol); err != nil {
+			c.l.Errorw("add symbol error", "error", err, "symbol", symbol)
+		}
+	case event.Type == controller.SymbolDelisted || (event.Type == controller.SymbolDeselected && followsUniverse):
+		c.RemoveSymbol(symbol)
+	}
+
+	c.RLock()
+	alerts := c.alerts
+	c.RUnlock()
+	if alerts == nil {
+		return
+	}
+	switch event.Type {
+	case controller.SymbolListed:
+		alerts.AddAlert(controller.Alert{Name: AlertListed, Symbol: symbol, Severity: controller.SeverityInfo,
+			Message: fmt.Sprintf("New listing: %s", symbol)})
+	case controller.SymbolDelisted:
+		alerts.AddAlert(controller.Alert{Name: AlertDelisted, Symbol: symbol, Severity: controller.SeverityInfo,
+			Message: fmt.Sprintf("Delisted: %s", symbol)})
+	}
+}
+
+func (c *Core) SubscribeCandles(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe) error {
+	_, err := c.subscribeCandles(ctx, mapSymbolTimeframe)
+	return err
+}
+
+// candleSubscription is what a call to subscribeCandles subscribed, so that it can be undone
+type candleSubscription struct {
+	mapSymbolTimeframe map[string]model.Timeframe
+	strategyController *strategy.Controller
+	ids                []controller.SubscriptionID
+}
+
+// subscribeCandles feeds the candles of the symbols to a new strategy controller once their history is preloaded. On
+// error, it waits for the other preloads and undoes every subscription made.
+func (c *Core) subscribeCandles(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe) (*candleSubscription, error) {
 	strategyController := strategy.NewStategyController(mapSymbolTimeframe, c.strategy)
+	c.Lock()
+	c.strategyControllers = append(c.strategyControllers, strategyController)
+	c.Unlock()
+	for symbol := range mapSymbolTimeframe {
+		if book, ok := c.orderBookController.OrderBook(symbol); ok {
+			strategyController.SetOrderBook(book)
+		}
+	}
 
 	var (
-		errCh  = make(chan error)
-		wg     = &sync.WaitGroup{}
-		doneCh = make(chan struct{})
+		subscription = &candleSubscription{
+			mapSymbolTimeframe: mapSymbolTimeframe,
+			strategyController: strategyController,
+		}
+		mu    sync.Mutex
+		errCh = make(chan error, len(mapSymbolTimeframe))
+		wg    = &sync.WaitGroup{}
 	)
+	subscribed := func(id controller.SubscriptionID) {
+		mu.Lock()
+		defer mu.Unlock()
+		subscription.ids = append(subscription.ids, id)
+	}
 
 	for symbol, timeframe := range mapSymbolTimeframe {
+		// bars built from trades have no history to preload
+		if _, _, ok := timeframe.Bar(); ok {
+			id, err := c.candleController.SubscribeBars(symbol, timeframe, strategyController.OnCandle, false)
+			if err != nil {
+				errCh <- err
+				break
+			}
+			subscribed(id)
+			continue
+		}
+
+		source, err := c.sourceTimeframe(timeframe)
+		if err != nil {
+			errCh <- err
+			break
+		}
+
 		wg.Add(1)
-		go func(symbol, timeframe string) {
+		go func(symbol string, timeframe, source model.Timeframe) {
 			defer wg.Done()
-			c.candleController.Subscribe(symbol, timeframe, strategyController.OnCandle, false)
-
-			// preload candles
-			candles, err := c.exchange.CandlesByLimit(ctx, symbol, timeframe, c.strategy.WarmupPeriod())
+			candles, err := c.preloadCandles(ctx, symbol, timeframe, source)
 			if err != nil {
-				c.l.Errorw("candles by limit error", "error", err, "symbol", symbol, "timeframe", timeframe)
+				c.l.Errorw("preload candles error", "error", err, "symbol", symbol, "timeframe", timeframe, "source", source)
 				errCh <- err
 				return
 			}
 
-			c.candleController.Preload(symbol, timeframe, candles)
-		}(symbol, timeframe)
+			// a feed subscribed while the controller runs is streamed once its history is queued, so the live candles
+			// follow the preloaded ones
+			release := c.candleController.Hold(symbol, source)
+			defer release()
+			if source == timeframe {
+				subscribed(c.candleController.Subscribe(symbol, timeframe, strategyController.OnCandle, false))
+			} else if id, err := c.candleController.Resample(symbol, source, timeframe, strategyController.OnCandle, false); err != nil {
+				errCh <- err
+				return
+			} else {
+				subscribed(id)
+			}
+			c.candleController.Preload(symbol, source, candles)
+		}(symbol, timeframe, source)
 	}
 
-	go func() {
-		wg.Wait()
-		close(doneCh)
-	}()
+	wg.Wait()
+	select {
+	case err := <-errCh:
+		c.unsubscribeCandles(subscription)
+		return nil, err
+	default:
+	}
+	strategyController.Start()
+	return subscription, nil
+}
 
-	for {
-		select {
-		case err := <-errCh:
-			return err
-		case <-doneCh:
-			strategyController.Start()
-			return nil
+// unsubscribeCandles removes the subscriptions of subscription, the dataframes of its strategy controller and the
+// controller itself
+func (c *Core) unsubscribeCandles(subscription *candleSubscription) {
+	for _, id := range subscription.ids {
+		c.candleController.Unsubscribe(id)
+	}
+	for symbol := range subscription.mapSymbolTimeframe {
+		subscription.strategyController.RemoveSymbol(symbol)
+	}
+	c.Lock()
+	defer c.Unlock()
+	for i, strategyController := range c.strategyControllers {
+		if strategyController == subscription.strategyController {
+			c.strategyControllers = append(c.strategyControllers[:i:i], c.strategyControllers[i+1:]...)
+			break
+		}
+	}
+}
+
+// sourceTimeframe returns the timeframe streamed by the exchange to build the candles of timeframe
+func (c *Core) sourceTimeframe(timeframe model.Timeframe) (model.Timeframe, error) {
+	if c.resampleSource != "" && controller.CanResample(c.resampleSource, timeframe) == nil {
+		return c.resampleSource, nil
+	}
+	return controller.ResampleSource(timeframe)
+}
+
+// preloadCandles fetches the source candles of the warmup period of timeframe
+func (c *Core) preloadCandles(ctx context.Context, symbol string, timeframe, source model.Timeframe) ([]model.Candle, error) {
+	c.RLock()
+	store := c.candleStore
+	c.RUnlock()
+	if store != nil {
+		return c.storedCandles(ctx, store, symbol, timeframe, source)
+	}
+
+	if source == timeframe {
+		return c.exchange.CandlesByLimit(ctx, symbol, timeframe, c.strategy.WarmupPeriod())
+	}
+	now := time.Now()
+	start := timeframe.Add(now, 1-c.strategy.WarmupPeriod())
+	return c.exchange.CandlesByPeriod(ctx, symbol, source, start, now)
+}
+
+// storedCandles reads the source candles of the warmup period of timeframe from store, the candles after the last
+// stored one are fetched from the exchange and stored. The whole period is fetched when the store misses its start
+// or has a gap.
+func (c *Core) storedCandles(ctx context.Context, store storage.CandleStore, symbol string, timeframe, source model.Timeframe) ([]model.Candle, error) {
+	now := time.Now()
+	start := timeframe.Add(now, 1-c.strategy.WarmupPeriod())
+
+	stored, err := store.Candles(symbol, source, start, now)
+	if err != nil {
+		c.l.Warnw("read stored candles error", "error", err, "symbol", symbol, "timeframe", source)
+		stored = nil
+	}
+	from := start
+	if len(stored) > 0 && stored[0].Time.Equal(start) && isContiguous(stored, source) {
+		from = source.Add(stored[len(stored)-1].Time, 1)
+	} else {
+		stored = nil
+	}
+
+	candles, err := c.exchange.CandlesByPeriod(ctx, symbol, source, from, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SaveCandles(candles); err != nil {
+		c.l.Warnw("store candles error", "error", err, "symbol", symbol, "timeframe", source)
+	}
+	c.l.Debugw("preload stored candles", "symbol", symbol, "timeframe", source, "stored", len(stored),
+		"fetched", len(candles))
+	return append(stored, candles...), nil
+}
+
+// isContiguous reports whether each candle opens when the previous one closes
+func isContiguous(candles []model.Candle, timeframe model.Timeframe) bool {
+	for i := 1; i < len(candles); i++ {
+		if !candles[i].Time.Equal(timeframe.Add(candles[i-1].Time, 1)) {
+			return false
 		}
 	}
+	return true
 }
 
 func isInList(list []string, value string) bool {
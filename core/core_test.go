@@ -0,0 +1,198 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/exchange/binancetest"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const coreHistoryLength = 100
+
+// recordStrategy keeps the open times of the dataframe of each symbol at its last candle
+type recordStrategy struct {
+	sync.Mutex
+	times map[string][]time.Time
+}
+
+func (s *recordStrategy) Init() {}
+
+func (s *recordStrategy) WarmupPeriod() int {
+	return 10
+}
+
+func (s *recordStrategy) OnCandle(dataframe *model.Dataframe) {
+	s.Lock()
+	defer s.Unlock()
+	s.times[dataframe.Symbol] = append([]time.Time(nil), dataframe.Time...)
+}
+
+func (s *recordStrategy) lastTimes(symbol string) []time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.times[symbol]
+}
+
+// failingFeeder fails the preload of a feed
+type failingFeeder struct {
+	exchange.Feeder
+	symbol    string
+	timeframe model.Timeframe
+}
+
+func (f failingFeeder) CandlesByLimit(ctx context.Context, symbol string, timeframe model.Timeframe, limit int) ([]model.Candle, error) {
+	if symbol == f.symbol && timeframe == f.timeframe {
+		return nil, errors.New("klines unavailable")
+	}
+	return f.Feeder.CandlesByLimit(ctx, symbol, timeframe, limit)
+}
+
+// runTestCore runs a core following KNCUSDT on a test server also listing BTCUSDT, with the history of both. The
+// candles after the history are returned by symbol.
+func runTestCore(t *testing.T, ctx context.Context, wrap func(exchange.Feeder) exchange.Feeder, timeframes ...model.Timeframe) (*Core, *recordStrategy, *binancetest.Server, map[string][]model.Candle) {
+	candles, err := binancetest.ReadCandlesCSV("../testdata/kncusdt-4h-test1.csv")
+	require.NoError(t, err)
+
+	server := binancetest.NewServer()
+	t.Cleanup(server.Close)
+	var live = make(map[string][]model.Candle)
+	for _, symbol := range []string{"KNCUSDT", "BTCUSDT"} {
+		server.AddSymbol(model.SymbolInfo{Symbol: symbol, BaseAsset: symbol[:3], QuoteAsset: "USDT",
+			Status: model.SymbolStatusTrading.String()})
+		var symbolCandles = make([]model.Candle, len(candles))
+		for i, candle := range candles {
+			candle.Symbol = symbol
+			symbolCandles[i] = candle
+		}
+		server.AddKlines(symbolCandles[:coreHistoryLength]...)
+		live[symbol] = symbolCandles[coreHistoryLength:]
+	}
+
+	viper.Set(exchange.BinanceApiKeyFlag, "api-key")
+	viper.Set(exchange.BinanceApiSecretFlag, "api-secret")
+	viper.Set(exchange.BinanceApiEndpointFlag, server.URL())
+	viper.Set(exchange.BinanceWsEndpointFlag, server.WsURL())
+	viper.Set(SelectedSymbolsFlag, []string{"KNCUSDT"})
+	t.Cleanup(func() {
+		for _, flag := range []string{exchange.BinanceApiKeyFlag, exchange.BinanceApiSecretFlag,
+			exchange.BinanceApiEndpointFlag, exchange.BinanceWsEndpointFlag, SelectedSymbolsFlag} {
+			viper.Set(flag, nil)
+		}
+	})
+
+	client, err := exchange.NewBinance()
+	require.NoError(t, err)
+	var feeder exchange.Feeder = client
+	if wrap != nil {
+		feeder = wrap(client)
+	}
+	str := &recordStrategy{times: make(map[string][]time.Time)}
+	c, err := New(feeder, str, nil)
+	require.NoError(t, err)
+	go c.Run(ctx, timeframes)
+	require.Eventually(t, func() bool {
+		return server.Subscribers(binancetest.KlineStream("KNCUSDT", timeframes[0])) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	return c, str, server, live
+}
+
+func TestCoreAddSymbolWhileRunning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c, str, server, live := runTestCore(t, ctx, nil, model.Timeframe4h)
+
+	// the live candle is pushed as soon as the stream of the new symbol opens
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for ctx.Err() == nil && server.PushKline(live["BTCUSDT"][0]) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	require.NoError(t, c.AddSymbol(ctx, "BTCUSDT"))
+	<-pushed
+	require.Eventually(t, func() bool {
+		server.PushKline(live["BTCUSDT"][1])
+		times := str.lastTimes("BTCUSDT")
+		return len(times) > 0 && times[len(times)-1].Equal(live["BTCUSDT"][1].Time)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// the live candles follow the preloaded ones, each once
+	times := str.lastTimes("BTCUSDT")
+	require.Len(t, times, str.WarmupPeriod()+2)
+	for i := 1; i < len(times); i++ {
+		assert.True(t, times[i].After(times[i-1]), "candle %d at %v after %v", i, times[i], times[i-1])
+	}
+	assert.True(t, times[len(times)-2].Equal(live["BTCUSDT"][0].Time))
+}
+
+func TestCoreAddSymbolError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c, _, _, _ := runTestCore(t, ctx, func(feeder exchange.Feeder) exchange.Feeder {
+		return failingFeeder{Feeder: feeder, symbol: "BTCUSDT", timeframe: model.Timeframe1d}
+	}, model.Timeframe4h, model.Timeframe1d)
+
+	c.RLock()
+	strategyControllers := len(c.strategyControllers)
+	c.RUnlock()
+
+	// the 4h feed subscribed before the failure is removed
+	require.Error(t, c.AddSymbol(ctx, "BTCUSDT"))
+	for _, feed := range c.candleController.ActiveFeeds() {
+		assert.Equal(t, "KNCUSDT", feed.Symbol)
+	}
+	c.RLock()
+	assert.Len(t, c.strategyControllers, strategyControllers)
+	assert.False(t, c.watchedSymbols["BTCUSDT"])
+	c.RUnlock()
+}
+
+func TestCoreRemoveAddedSymbol(t *testing.T) {
+	viper.Set(OrderBookFlag, true)
+	defer viper.Set(OrderBookFlag, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c, _, server, _ := runTestCore(t, ctx, nil, model.Timeframe4h)
+
+	c.RLock()
+	strategyControllers := len(c.strategyControllers)
+	c.RUnlock()
+
+	// the added symbol gets its order book like the symbols of Run
+	require.NoError(t, c.AddSymbol(ctx, "BTCUSDT"))
+	_, ok := c.orderBookController.OrderBook("BTCUSDT")
+	assert.True(t, ok)
+	require.Eventually(t, func() bool {
+		return server.Subscribers(binancetest.DepthStream("BTCUSDT")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	c.RLock()
+	assert.Len(t, c.strategyControllers, strategyControllers+1)
+	c.RUnlock()
+
+	// its strategy controller, candles and order book are removed with it
+	c.RemoveSymbol("BTCUSDT")
+	_, ok = c.orderBookController.OrderBook("BTCUSDT")
+	assert.False(t, ok)
+	require.Eventually(t, func() bool {
+		return server.Subscribers(binancetest.DepthStream("BTCUSDT")) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	// the candle connection is only closed once the handover delay is over
+	for _, feed := range c.candleController.ActiveFeeds() {
+		assert.Equal(t, "KNCUSDT", feed.Symbol)
+	}
+	c.RLock()
+	assert.Len(t, c.strategyControllers, strategyControllers)
+	assert.Empty(t, c.symbolSubscriptions)
+	c.RUnlock()
+	// the order book of the symbols of Run is still streamed
+	assert.Equal(t, 1, server.Subscribers(binancetest.DepthStream("KNCUSDT")))
+}
@@ -4,18 +4,20 @@ go 1.16
 
 require (
 	github.com/adshao/go-binance/v2 v2.3.0
-	github.com/dgraph-io/badger/v3 v3.2103.1 // indirect
-	github.com/go-ozzo/ozzo-validation v3.6.0+incompatible // indirect
-	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible // indirect
-	github.com/joho/godotenv v1.3.0 // indirect
-	github.com/looplab/fsm v0.2.0 // indirect
+	github.com/dgraph-io/badger/v3 v3.2103.1
+	github.com/go-ozzo/ozzo-validation v3.6.0+incompatible
+	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+	github.com/gorilla/websocket v1.2.0
+	github.com/joho/godotenv v1.3.0
+	github.com/looplab/fsm v0.2.0
 	github.com/markcheno/go-talib v0.0.0-20190307022042-cd53a9264d70 // indirect
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/prometheus/client_golang v1.11.0
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.8.1
-	github.com/stretchr/testify v1.7.0 // indirect
+	github.com/stretchr/testify v1.7.0
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
-	github.com/xhit/go-str2duration/v2 v2.0.0 // indirect
+	github.com/xhit/go-str2duration/v2 v2.0.0
 	go.uber.org/zap v1.18.1
-	golang.org/x/time v0.0.0-20210611083556-38a9dc6acbc6 // indirect
+	golang.org/x/time v0.0.0-20210611083556-38a9dc6acbc6
 )
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff computes capped exponential delays between retries. Each delay is randomized between half and the full
+// exponential value, so clients disconnected at the same time don't retry at the same time.
+type Backoff struct {
+	sync.Mutex
+	min     time.Duration
+	max     time.Duration
+	attempt int
+}
+
+// NewBackoff creates a backoff starting at min, doubling after each attempt and capped at max
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{
+		min: min,
+		max: max,
+	}
+}
+
+// Next returns the delay to wait before the next attempt
+func (b *Backoff) Next() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	delay := b.max
+	// avoid overflow, 2^30 times min already exceeds any reasonable max
+	if b.attempt < 30 {
+		if exp := b.min * time.Duration(1<<uint(b.attempt)); exp < b.max {
+			delay = exp
+		}
+	}
+	b.attempt++
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Attempt returns the number of delays returned since the last reset
+func (b *Backoff) Attempt() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.attempt
+}
+
+// Reset restarts the delays from min, it should be called after a successful attempt
+func (b *Backoff) Reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.attempt = 0
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// HttpAddressFlag is the address of the HTTP server exposing the metrics and health probes, e.g. `:9090`, no server when empty
+	HttpAddressFlag = "http.address"
+
+	HttpShutdownTimeout = 5 * time.Second
+)
+
+// ServeHTTP serves handler on address until ctx is done
+func ServeHTTP(ctx context.Context, address string, handler http.Handler) error {
+	server := &http.Server{Addr: address, Handler: handler}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), HttpShutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
@@ -16,6 +16,7 @@ var (
 type RateLimiter struct {
 	sync.RWMutex
 	limiter *rate.Limiter
+	onWait  func(wait time.Duration)
 }
 
 // rate is number of request per seconds
@@ -27,6 +28,13 @@ func NewRateLimiter(rateLimit int, burst int) *RateLimiter {
 	}
 }
 
+// OnWait registers observer of the time waited by each request, e.g. for metrics
+func (r *RateLimiter) OnWait(observer func(wait time.Duration)) {
+	r.Lock()
+	defer r.Unlock()
+	r.onWait = observer
+}
+
 // WaitN waits until enough resources are available for a request with given weight.
 func (r *RateLimiter) WaitN(timeout time.Duration, weight int) error {
 	r.Lock()
@@ -35,8 +43,12 @@ func (r *RateLimiter) WaitN(timeout time.Duration, weight int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	start := time.Now()
 	if err := r.limiter.WaitN(ctx, weight); err != nil {
 		return err
 	}
+	if r.onWait != nil {
+		r.onWait(time.Since(start))
+	}
 	return nil
 }
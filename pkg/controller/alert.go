@@ -1,51 +1,342 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/quangkeu95/binancebot/pkg/metrics"
+	"github.com/quangkeu95/binancebot/pkg/model"
 	"github.com/quangkeu95/binancebot/pkg/notification"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+const (
+	// AlertsCooldownFlag is the delay before an alert of the same symbol, timeframe and name is sent again, none by
+	// default
+	AlertsCooldownFlag = "alerts.cooldown"
+	// AlertsCooldownsFlag overrides the cooldown per alert name, e.g. `{"ma_cross": "4h"}`
+	AlertsCooldownsFlag = "alerts.cooldowns"
+	// AlertsMinSeverityFlag is the lowest severity notified, the alerts below are only recorded in the history
+	AlertsMinSeverityFlag = "alerts.min_severity"
+	// AlertsHistorySizeFlag is the number of fired alerts kept in the history
+	AlertsHistorySizeFlag = "alerts.history_size"
+	// AlertsQueueSizeFlag is the number of notifications waiting for the notifier once started, the alerts fired
+	// while it is full are only recorded in the history
+	AlertsQueueSizeFlag = "alerts.queue_size"
+
+	DefaultAlertsHistorySize = 1000
+	DefaultAlertsQueueSize   = 100
+)
+
+//go:generate stringer -type=Severity -linecomment
+type Severity int
+
+const (
+	SeverityInfo     Severity = iota // info
+	SeverityWarning                  // warning
+	SeverityCritical                 // critical
+)
+
+// ParseSeverity returns the severity named s
+func ParseSeverity(s string) (Severity, error) {
+	for severity := SeverityInfo; severity <= SeverityCritical; severity++ {
+		if strings.EqualFold(s, severity.String()) {
+			return severity, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown alert severity %q", s)
+}
+
+// MarshalText names the severity in the JSON history
+func (i Severity) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// Alert is a notification of a strategy. Alerts with a candle, i.e. a LastUpdate, are sent once per candle.
 type Alert struct {
 	Name       string
 	Symbol     string
-	Timeframe  string
-	LastUpdate time.Time
+	Timeframe  model.Timeframe
+	LastUpdate time.Time // open time of the candle of the alert, zero when the alert has no candle
+	Severity   Severity
 	Message    string
+	FiredAt    time.Time // set by the controller
+	Notified   bool      // the notifier sent the message, false below the min severity or on error
+}
+
+// AlertQuery filters the history of the fired alerts, zero fields match every alert
+type AlertQuery struct {
+	Name        string
+	Symbol      string
+	Timeframe   model.Timeframe
+	MinSeverity Severity
+	Since       time.Time
+	Limit       int
 }
 
+func (q AlertQuery) match(alert Alert) bool {
+	return (q.Name == "" || alert.Name == q.Name) &&
+		(q.Symbol == "" || alert.Symbol == q.Symbol) &&
+		(q.Timeframe == "" || alert.Timeframe == q.Timeframe) &&
+		alert.Severity >= q.MinSeverity &&
+		!alert.FiredAt.Before(q.Since)
+}
+
+// AlertController is the path of the alerts from the strategies to the notifier. It drops the duplicates of a candle
+// and the alerts within their cooldown, then keeps the fired alerts in a bounded history. The notifications are sent
+// by the caller of AddAlert until Start is called, then from a bounded queue so that a slow notifier does not delay
+// the callers.
 type AlertController struct {
 	sync.RWMutex
-	l        *zap.SugaredLogger
-	alerts   map[string]Alert
-	notifier notification.Notifier
+	l           *zap.SugaredLogger
+	notifier    notification.Notifier
+	cooldown    time.Duration
+	cooldowns   map[string]time.Duration
+	minSeverity Severity
+	alerts      map[string]Alert // last fired alert of each symbol, timeframe and name
+	history     []Alert          // ring of the fired alerts, next is the oldest once full
+	next        int
+	historySize int
+	queue       chan queuedAlert
+	started     bool
+	now         func() time.Time
 }
 
-func NewAlertController(notifier notification.Notifier) *AlertController {
-	notification.NewTelegram()
-	return &AlertController{
-		l:        zap.S(),
-		notifier: notifier,
+// queuedAlert is an alert waiting for the notifier, at index in the history
+type queuedAlert struct {
+	alert Alert
+	index int
+}
+
+func NewAlertController(notifier notification.Notifier) (*AlertController, error) {
+	l := zap.S()
+
+	var cooldowns = make(map[string]time.Duration)
+	for name, value := range viper.GetStringMapString(AlertsCooldownsFlag) {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil {
+			l.Errorw("parse `alerts.cooldowns` configuration error", "error", err, "name", name)
+			return nil, err
+		}
+		cooldowns[name] = cooldown
+	}
+
+	minSeverity := SeverityInfo
+	if value := viper.GetString(AlertsMinSeverityFlag); value != "" {
+		severity, err := ParseSeverity(value)
+		if err != nil {
+			l.Errorw("parse `alerts.min_severity` configuration error", "error", err)
+			return nil, err
+		}
+		minSeverity = severity
+	}
+
+	historySize := viper.GetInt(AlertsHistorySizeFlag)
+	if historySize <= 0 {
+		historySize = DefaultAlertsHistorySize
+	}
+	queueSize := viper.GetInt(AlertsQueueSizeFlag)
+	if queueSize <= 0 {
+		queueSize = DefaultAlertsQueueSize
 	}
+
+	return &AlertController{
+		l:           l,
+		notifier:    notifier,
+		cooldown:    viper.GetDuration(AlertsCooldownFlag),
+		cooldowns:   cooldowns,
+		minSeverity: minSeverity,
+		alerts:      make(map[string]Alert),
+		history:     make([]Alert, 0, historySize),
+		historySize: historySize,
+		queue:       make(chan queuedAlert, queueSize),
+		now:         time.Now,
+	}, nil
 }
 
-func (c *AlertController) AddAlert(alert Alert) {
+// SetCooldown sets the cooldown of the alerts named name
+func (c *AlertController) SetCooldown(name string, cooldown time.Duration) {
 	c.Lock()
 	defer c.Unlock()
+	c.cooldowns[name] = cooldown
+}
+
+// Start sends the queued notifications until ctx is done, the notifications queued before the end are sent before
+// returning
+func (c *AlertController) Start(ctx context.Context) {
+	c.Lock()
+	c.started = true
+	c.Unlock()
+	c.l.Infow("start alert controller", "queue_size", cap(c.queue))
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.Lock()
+			c.started = false
+			c.Unlock()
+			for {
+				select {
+				case queued := <-c.queue:
+					c.notify(queued.alert, queued.index)
+				default:
+					return
+				}
+			}
+		case queued := <-c.queue:
+			c.notify(queued.alert, queued.index)
+		}
+	}
+}
+
+// AddAlert fires the alert unless it duplicates the last alert of its candle or is within its cooldown, and reports
+// whether it was fired. The notification is queued once started, or sent once c is unlocked otherwise.
+func (c *AlertController) AddAlert(alert Alert) bool {
+	c.Lock()
+	alert.FiredAt = c.now()
 	key := c.generateKey(alert)
-	if val, ok := c.alerts[key]; ok {
-		if val.LastUpdate == alert.LastUpdate {
-			return
+	if last, ok := c.alerts[key]; ok {
+		if !alert.LastUpdate.IsZero() && last.LastUpdate.Equal(alert.LastUpdate) {
+			c.Unlock()
+			return false
+		}
+		if cooldown := c.cooldownOf(alert.Name); cooldown > 0 && alertTime(alert).Sub(alertTime(last)) < cooldown {
+			c.l.Debugw("alert within cooldown", "name", alert.Name, "symbol", alert.Symbol,
+				"timeframe", alert.Timeframe, "time", alert.LastUpdate)
+			c.Unlock()
+			return false
 		}
 	}
 
-	c.l.Debugw("add alert", "name", alert.Name, "symbol", alert.Symbol, "timeframe", alert.Timeframe, "time", alert.LastUpdate)
+	c.l.Debugw("add alert", "name", alert.Name, "symbol", alert.Symbol, "timeframe", alert.Timeframe,
+		"time", alert.LastUpdate, "severity", alert.Severity)
 	c.alerts[key] = alert
+	index := c.record(alert)
+	notify := alert.Severity >= c.minSeverity
+	started := c.started
+	c.Unlock()
+
+	metrics.Alerts.WithLabelValues(alert.Name, alert.Timeframe.String()).Inc()
+	switch {
+	case !notify:
+	case started:
+		select {
+		case c.queue <- queuedAlert{alert: alert, index: index}:
+		default:
+			c.l.Warnw("alert queue full, notification dropped", "name", alert.Name, "symbol", alert.Symbol)
+			metrics.AlertsDropped.WithLabelValues(alert.Name).Inc()
+		}
+	default:
+		c.notify(alert, index)
+	}
+	return true
+}
+
+// notify sends the alert at index in the history
+func (c *AlertController) notify(alert Alert, index int) {
+	if err := c.notifier.SendMessage(alert.Message); err != nil {
+		c.l.Warnw("send alert error", "error", err, "name", alert.Name, "symbol", alert.Symbol)
+		return
+	}
 
-	// c.notifier.SendMessage()
+	c.Lock()
+	defer c.Unlock()
+	// the history may have wrapped around meanwhile
+	if c.history[index].FiredAt.Equal(alert.FiredAt) && c.history[index].Name == alert.Name &&
+		c.history[index].Symbol == alert.Symbol {
+		c.history[index].Notified = true
+	}
+}
+
+// History returns the fired alerts matching query, newest first
+func (c *AlertController) History(query AlertQuery) []Alert {
+	c.RLock()
+	defer c.RUnlock()
+	var alerts = make([]Alert, 0)
+	for i := 1; i <= len(c.history); i++ {
+		alert := c.history[(c.next-i+len(c.history))%len(c.history)]
+		if !query.match(alert) {
+			continue
+		}
+		alerts = append(alerts, alert)
+		if query.Limit > 0 && len(alerts) == query.Limit {
+			break
+		}
+	}
+	return alerts
+}
+
+// HistoryHandler serves the history in JSON, filtered by the query parameters name, symbol, timeframe, severity,
+// since (RFC 3339) and limit
+func (c *AlertController) HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := AlertQuery{
+			Name:      params.Get("name"),
+			Symbol:    strings.ToUpper(params.Get("symbol")),
+			Timeframe: model.Timeframe(params.Get("timeframe")),
+		}
+		var err error
+		if value := params.Get("severity"); value != "" {
+			if query.MinSeverity, err = ParseSeverity(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := params.Get("since"); value != "" {
+			if query.Since, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := params.Get("limit"); value != "" {
+			if query.Limit, err = strconv.Atoi(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c.History(query)); err != nil {
+			c.l.Warnw("encode alert history error", "error", err)
+		}
+	})
+}
+
+// record adds alert to the history and returns its index, c must be locked
+func (c *AlertController) record(alert Alert) int {
+	if len(c.history) < c.historySize {
+		c.history = append(c.history, alert)
+		c.next = len(c.history) % c.historySize
+		return len(c.history) - 1
+	}
+	index := c.next
+	c.history[index] = alert
+	c.next = (c.next + 1) % c.historySize
+	return index
+}
+
+// cooldownOf returns the cooldown of the alerts named name, c must be locked
+func (c *AlertController) cooldownOf(name string) time.Duration {
+	if cooldown, ok := c.cooldowns[name]; ok {
+		return cooldown
+	}
+	return c.cooldown
+}
+
+// alertTime is the candle time of the alert, or its firing time without candle, so backtests cool down in candle time
+func alertTime(alert Alert) time.Time {
+	if alert.LastUpdate.IsZero() {
+		return alert.FiredAt
+	}
+	return alert.LastUpdate
 }
 
 func (c *AlertController) generateKey(alert Alert) string {
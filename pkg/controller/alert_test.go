@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertController(t *testing.T) {
+	viper.Set(AlertsCooldownsFlag, map[string]interface{}{"ma_cross": "8h"})
+	viper.Set(AlertsMinSeverityFlag, "warning")
+	viper.Set(AlertsHistorySizeFlag, 3)
+	defer func() {
+		for _, flag := range []string{AlertsCooldownsFlag, AlertsMinSeverityFlag, AlertsHistorySizeFlag} {
+			viper.Set(flag, nil)
+		}
+	}()
+
+	notifier := new(recordNotifier)
+	c, err := NewAlertController(notifier)
+	require.NoError(t, err)
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	alert := func(name string, candle int, severity Severity) Alert {
+		return Alert{
+			Name:       name,
+			Symbol:     "BTCUSDT",
+			Timeframe:  model.Timeframe4h,
+			LastUpdate: start.Add(time.Duration(candle) * 4 * time.Hour),
+			Severity:   severity,
+			Message:    name,
+		}
+	}
+
+	t.Run("dedup and cooldown", func(t *testing.T) {
+		assert.True(t, c.AddAlert(alert("ma_cross", 0, SeverityWarning)))
+		// the same candle is sent once
+		assert.False(t, c.AddAlert(alert("ma_cross", 0, SeverityWarning)))
+		// the next candle is within the cooldown of 8h
+		assert.False(t, c.AddAlert(alert("ma_cross", 1, SeverityWarning)))
+		assert.True(t, c.AddAlert(alert("ma_cross", 2, SeverityWarning)))
+		// other names have no cooldown
+		assert.True(t, c.AddAlert(alert("funding", 0, SeverityWarning)))
+		assert.True(t, c.AddAlert(alert("funding", 1, SeverityWarning)))
+		assert.Equal(t, []string{"ma_cross", "ma_cross", "funding", "funding"}, notifier.pop())
+	})
+
+	t.Run("severity", func(t *testing.T) {
+		// alerts below the min severity are only recorded
+		assert.True(t, c.AddAlert(alert("order", 3, SeverityInfo)))
+		assert.Empty(t, notifier.pop())
+
+		history := c.History(AlertQuery{})
+		require.Len(t, history, 3)
+		assert.Equal(t, "order", history[0].Name)
+		assert.False(t, history[0].Notified)
+		assert.True(t, history[1].Notified)
+		assert.Equal(t, now, history[0].FiredAt)
+
+		assert.Len(t, c.History(AlertQuery{MinSeverity: SeverityWarning}), 2)
+		assert.Len(t, c.History(AlertQuery{Name: "funding", Limit: 1}), 1)
+		assert.Empty(t, c.History(AlertQuery{Symbol: "ETHUSDT"}))
+	})
+
+	t.Run("history handler", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		c.HistoryHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/alerts?name=funding&severity=warning", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var history []map[string]interface{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &history))
+		require.Len(t, history, 2)
+		assert.Equal(t, "warning", history[0]["Severity"])
+
+		recorder = httptest.NewRecorder()
+		c.HistoryHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/alerts?severity=unknown", nil))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
+
+// blockingNotifier records the messages, each send waits until release is closed
+type blockingNotifier struct {
+	recordNotifier
+	sending chan string
+	release chan struct{}
+}
+
+func (n *blockingNotifier) SendMessage(msg string) error {
+	n.sending <- msg
+	<-n.release
+	return n.recordNotifier.SendMessage(msg)
+}
+
+func TestAlertControllerQueue(t *testing.T) {
+	viper.Set(AlertsQueueSizeFlag, 1)
+	defer viper.Set(AlertsQueueSizeFlag, nil)
+
+	notifier := &blockingNotifier{sending: make(chan string, 3), release: make(chan struct{})}
+	c, err := NewAlertController(notifier)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Start(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		c.RLock()
+		defer c.RUnlock()
+		return c.started
+	}, time.Second, time.Millisecond)
+
+	alert := func(symbol string) Alert {
+		return Alert{Name: "whale_trade", Symbol: symbol, Severity: SeverityWarning, Message: symbol}
+	}
+
+	// the notifier blocks on the first alert, the second waits in the queue and the third is dropped, none of them
+	// blocks the caller
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		assert.True(t, c.AddAlert(alert("BTCUSDT")))
+		assert.Equal(t, "BTCUSDT", <-notifier.sending)
+		assert.True(t, c.AddAlert(alert("ETHUSDT")))
+		assert.True(t, c.AddAlert(alert("BNBUSDT")))
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("alerts blocked by the notifier")
+	}
+
+	close(notifier.release)
+	cancel()
+	wg.Wait()
+	assert.Equal(t, []string{"BTCUSDT", "ETHUSDT"}, notifier.pop())
+	history := c.History(AlertQuery{})
+	require.Len(t, history, 3)
+	assert.False(t, history[0].Notified)
+	assert.True(t, history[1].Notified)
+	assert.True(t, history[2].Notified)
+}
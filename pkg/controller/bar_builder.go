@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+type TradeConsumer func(model.Trade)
+
+// BarBuilder builds candles from trades. Time bars follow an interval and are complete when a trade of the next
+// period arrives. Tick, volume and dollar bars are complete once the number of trades, the traded quantity or the
+// traded quote amount reaches the threshold, the trade crossing it belongs to the completed bar.
+type BarBuilder struct {
+	sync.Mutex
+	timeframe model.Timeframe
+	barType   model.BarType // empty for time bars
+	threshold float64
+	consumer  CandleConsumer
+	bars      map[string]*tradeBar // bar in progress of each symbol
+}
+
+type tradeBar struct {
+	candle    model.Candle
+	measure   float64
+	open      bool
+	lastTrade int64
+}
+
+// NewBarBuilder creates a builder of time bars for an interval, e.g. 1m, or of the bars labeled by
+// model.BarTimeframe, e.g. dollar:1000000
+func NewBarBuilder(timeframe model.Timeframe, consumer CandleConsumer) (*BarBuilder, error) {
+	builder := &BarBuilder{
+		timeframe: timeframe,
+		consumer:  consumer,
+		bars:      make(map[string]*tradeBar),
+	}
+	if barType, threshold, ok := timeframe.Bar(); ok {
+		builder.barType = barType
+		builder.threshold = threshold
+	} else if timeframe.Duration() == 0 {
+		return nil, fmt.Errorf("%w: %q", model.ErrInvalidTimeframe, timeframe)
+	}
+	return builder, nil
+}
+
+func (b *BarBuilder) Timeframe() model.Timeframe {
+	return b.timeframe
+}
+
+// OnTrade adds the trade to the bar of its symbol and emits the bar built so far
+func (b *BarBuilder) OnTrade(trade model.Trade) {
+	b.Lock()
+	defer b.Unlock()
+
+	bar, ok := b.bars[trade.Symbol]
+	if !ok {
+		bar = &tradeBar{lastTrade: -1}
+		b.bars[trade.Symbol] = bar
+	}
+	// skip trades received twice, e.g. after a reconnection
+	if trade.ID <= bar.lastTrade {
+		return
+	}
+	bar.lastTrade = trade.ID
+
+	if b.barType == "" && bar.open {
+		openTime := b.timeframe.OpenTime(trade.Time)
+		if openTime.Before(bar.candle.Time) {
+			return
+		}
+		if openTime.After(bar.candle.Time) {
+			bar.candle.Complete = true
+			b.consumer(bar.candle)
+			bar.open = false
+		}
+	}
+
+	if !bar.open {
+		openTime := trade.Time
+		if b.barType == "" {
+			openTime = b.timeframe.OpenTime(trade.Time)
+		} else if !bar.candle.Time.IsZero() && !openTime.After(bar.candle.Time) {
+			// candles are identified by their open time, bars opening in the same millisecond are spaced apart
+			openTime = bar.candle.Time.Add(time.Millisecond)
+		}
+		bar.candle = model.Candle{
+			Symbol:    trade.Symbol,
+			Timeframe: b.timeframe,
+			Time:      openTime,
+			Open:      trade.Price,
+			High:      trade.Price,
+			Low:       trade.Price,
+		}
+		bar.measure = 0
+		bar.open = true
+	}
+
+	if trade.Price > bar.candle.High {
+		bar.candle.High = trade.Price
+	}
+	if trade.Price < bar.candle.Low {
+		bar.candle.Low = trade.Price
+	}
+	bar.candle.Close = trade.Price
+	bar.candle.Volume += trade.Quantity
+	bar.candle.Trades++
+
+	switch b.barType {
+	case model.BarTypeTick:
+		bar.measure++
+	case model.BarTypeVolume:
+		bar.measure += trade.Quantity
+	case model.BarTypeDollar:
+		bar.measure += trade.QuoteQuantity()
+	}
+	if b.barType != "" && bar.measure >= b.threshold {
+		bar.candle.Complete = true
+		bar.open = false
+	}
+	b.consumer(bar.candle)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBarBuilder(t *testing.T) {
+	start := time.Date(2021, 8, 19, 10, 0, 0, 0, time.UTC)
+	// prices 1..10 with quantities 1..10, one trade every 20 seconds
+	var trades = make([]model.Trade, 0)
+	for i := 1; i <= 10; i++ {
+		trades = append(trades, model.Trade{
+			Symbol:   "BTCUSDT",
+			ID:       int64(i),
+			Price:    float64(i),
+			Quantity: float64(i),
+			Time:     start.Add(time.Duration(i-1) * 20 * time.Second),
+		})
+	}
+
+	tests := []struct {
+		timeframe model.Timeframe
+		closes    []float64 // close price of the complete bars
+		volumes   []float64
+	}{
+		{model.BarTimeframe(model.BarTypeTick, 4), []float64{4, 8}, []float64{10, 26}},
+		{model.BarTimeframe(model.BarTypeVolume, 10), []float64{4, 6, 8, 10}, []float64{10, 11, 15, 19}},
+		{model.BarTimeframe(model.BarTypeDollar, 50), []float64{5, 7, 8, 9, 10}, []float64{15, 13, 8, 9, 10}},
+		{model.Timeframe1m, []float64{3, 6, 9}, []float64{6, 15, 24}},
+	}
+	for _, test := range tests {
+		var complete []model.Candle
+		builder, err := NewBarBuilder(test.timeframe, func(candle model.Candle) {
+			assert.Equal(t, test.timeframe, candle.Timeframe)
+			if candle.Complete {
+				complete = append(complete, candle)
+			}
+		})
+		require.NoError(t, err)
+
+		for _, trade := range trades {
+			builder.OnTrade(trade)
+		}
+		// duplicated trades are ignored
+		builder.OnTrade(trades[9])
+
+		require.Len(t, complete, len(test.closes), test.timeframe)
+		for i, candle := range complete {
+			assert.Equal(t, test.closes[i], candle.Close, test.timeframe)
+			assert.Equal(t, test.volumes[i], candle.Volume, test.timeframe)
+			assert.True(t, candle.Low <= candle.Open && candle.High >= candle.Close, test.timeframe)
+			if i > 0 {
+				assert.True(t, candle.Time.After(complete[i-1].Time), test.timeframe)
+			}
+		}
+	}
+
+	_, err := NewBarBuilder("tick:0", func(model.Candle) {})
+	assert.ErrorIs(t, err, model.ErrInvalidTimeframe)
+}
@@ -3,50 +3,160 @@ package controller
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/quangkeu95/binancebot/lib/app"
 	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/metrics"
 	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
 	"go.uber.org/zap"
 )
 
 type CandleConsumer func(model.Candle)
 
+// SubscriptionID identifies a subscription of the controller to unsubscribe it
+type SubscriptionID int64
+
 type Subscription struct {
+	id            SubscriptionID
 	onCandleClose bool
 	consumer      CandleConsumer
+	lastClosed    time.Time // open time of the last complete candle consumed
+}
+
+// dispatch passes the candle to the consumer unless it was already consumed, e.g. preloaded for another subscriber
+// of the same feed
+func (s *Subscription) dispatch(candle model.Candle) {
+	if !s.lastClosed.IsZero() && !candle.Time.After(s.lastClosed) {
+		return
+	}
+	if candle.Complete {
+		s.lastClosed = candle.Time
+	}
+	if s.onCandleClose && !candle.Complete {
+		return
+	}
+	s.consumer(candle)
+}
+
+// StreamsPerConnection is the number of feeds subscribed through each combined websocket connection, it must not
+// exceed exchange.MaxStreamsPerConnection
+const StreamsPerConnection = 200
+
+const (
+	ReconnectMinDelay = 1 * time.Second
+	ReconnectMaxDelay = 2 * time.Minute
+	// HandoverDelay is how long a connection keeps streaming after its feeds moved to a new one, so that no update
+	// is lost while the new connection opens
+	HandoverDelay = 5 * time.Second
+)
+
+// Streams of the websocket reconnections in the metrics
+const (
+	StreamCandles      = "candles"
+	StreamTrades       = "trades"
+	StreamOrderBooks   = "order_books"
+	StreamLiquidations = "liquidations"
+)
+
+type TradeSubscription struct {
+	id       SubscriptionID
+	consumer TradeConsumer
+}
+
+// FeedStatus describes a feed of the controller
+type FeedStatus struct {
+	Symbol      string
+	Timeframe   model.Timeframe // empty for the aggregate trades of the symbol
+	Subscribers int
+	Preloaded   bool      // the history of the candle feed was passed to its subscribers
+	Streaming   bool      // a connection of the running controller streams the feed
+	LastUpdate  time.Time // reception of the last candle update, zero for the trades
+	ConnectedAt time.Time // start of the connection streaming the feed, or of its last reconnection
+}
+
+// feedConnection is a combined subscription started by the controller, canceled to stream its feeds differently
+type feedConnection struct {
+	feeds       []string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	restart     chan struct{} // subscribes the feeds again, e.g. when the connection is quiet without error
+	connectedAt time.Time
 }
 
 type CandleController struct {
 	sync.RWMutex
-	l             *zap.SugaredLogger
-	exchange      exchange.Exchange
-	Feeds         []string
-	Subscriptions map[string][]Subscription // each symbol_timeframe is a key, value is list of subscriber
+	l                    *zap.SugaredLogger
+	exchange             exchange.Feeder
+	streamsPerConnection int
+	reconnectMinDelay    time.Duration
+	reconnectMaxDelay    time.Duration
+	handoverDelay        time.Duration
+	Feeds                []string
+	Subscriptions        map[string][]*Subscription // each symbol_timeframe is a key, value is list of subscriber
+	lastClosed           map[string]time.Time       // open time of the last complete candle dispatched for each feed
+	lastUpdate           map[string]time.Time       // reception of the last candle update of each feed
+	preloaded            map[string]bool            // feeds whose history was preloaded
+	held                 map[string]int             // feeds not streamed until their history is preloaded
+	TradeFeeds           []string                   // symbols streaming aggregate trades
+	TradeSubscriptions   map[string][]TradeSubscription
+	lastID               SubscriptionID
+	dispatcher           *dispatcher                // nil when the candles are dispatched synchronously
+	candleStore          storage.CandleStore        // keeps the closed candles, nil when not stored
+	ctx                  context.Context            // context of the running controller, nil when not running
+	wg                   *sync.WaitGroup            // connections of the running controller
+	running              int                        // connections still streaming
+	done                 chan struct{}              // closed once every connection ended on its own
+	connections          map[string]*feedConnection // connection streaming each feed
+	tradeConnections     map[string]*feedConnection // connection streaming the trades of each symbol
 }
 
-// NewCandleController manage list of candle subscriptions for each symbol + timeframe
-func NewCandleController(ex exchange.Exchange) *CandleController {
-	return &CandleController{
-		l:             zap.S(),
-		exchange:      ex,
-		Feeds:         make([]string, 0),
-		Subscriptions: make(map[string][]Subscription),
+// NewCandleController manage list of candle subscriptions for each symbol + timeframe. The candles of each feed are
+// dispatched in order by the shard of the feed, see DispatchShardsFlag.
+func NewCandleController(ex exchange.Feeder) *CandleController {
+	c := &CandleController{
+		l:                    zap.S(),
+		exchange:             ex,
+		streamsPerConnection: StreamsPerConnection,
+		reconnectMinDelay:    ReconnectMinDelay,
+		reconnectMaxDelay:    ReconnectMaxDelay,
+		handoverDelay:        HandoverDelay,
+		Feeds:                make([]string, 0),
+		Subscriptions:        make(map[string][]*Subscription),
+		lastClosed:           make(map[string]time.Time),
+		lastUpdate:           make(map[string]time.Time),
+		preloaded:            make(map[string]bool),
+		held:                 make(map[string]int),
+		TradeFeeds:           make([]string, 0),
+		TradeSubscriptions:   make(map[string][]TradeSubscription),
+		connections:          make(map[string]*feedConnection),
+		tradeConnections:     make(map[string]*feedConnection),
 	}
+	c.dispatcher = dispatcherFromConfig(c.dispatch, c.dispatchTrade)
+	return c
 }
 
-func (c *CandleController) generateKey(symbol, timeframe string) string {
+func (c *CandleController) generateKey(symbol string, timeframe model.Timeframe) string {
 	return fmt.Sprintf("%s--%s", symbol, timeframe)
 }
 
-func (c *CandleController) extractKey(key string) (symbol, timeframe string) {
+// generateTradeKey is the dispatch feed of the trades of symbol
+func (c *CandleController) generateTradeKey(symbol string) string {
+	return fmt.Sprintf("%s--trades", symbol)
+}
+
+func (c *CandleController) extractKey(key string) (symbol string, timeframe model.Timeframe) {
 	parts := strings.Split(key, "--")
-	return parts[0], parts[1]
+	return parts[0], model.Timeframe(parts[1])
 }
 
-// Subscribe subscribe single symbol to consume data
-func (c *CandleController) Subscribe(symbol, timeframe string, consumer CandleConsumer, onCandleClose bool) {
+// Subscribe subscribes consumer to the candles of the symbol and timeframe. While the controller runs, a new feed
+// is streamed at once unless it is held, see Hold.
+func (c *CandleController) Subscribe(symbol string, timeframe model.Timeframe, consumer CandleConsumer, onCandleClose bool) SubscriptionID {
 	c.Lock()
 	defer c.Unlock()
 	key := c.generateKey(symbol, timeframe)
@@ -56,95 +166,710 @@ func (c *CandleController) Subscribe(symbol, timeframe string, consumer CandleCo
 	}
 
 	if _, ok := c.Subscriptions[key]; !ok {
-		c.Subscriptions[key] = make([]Subscription, 0)
+		c.Subscriptions[key] = make([]*Subscription, 0)
 	}
 
-	c.Subscriptions[key] = append(c.Subscriptions[key], Subscription{
+	c.lastID++
+	c.Subscriptions[key] = append(c.Subscriptions[key], &Subscription{
+		id:            c.lastID,
 		onCandleClose: onCandleClose,
 		consumer:      consumer,
 	})
+	c.connect()
+	return c.lastID
+}
+
+// Hold delays the stream of the feed until release is called, so that the candles preloaded meanwhile are dispatched
+// before the live ones. A feed already streaming is not stopped.
+func (c *CandleController) Hold(symbol string, timeframe model.Timeframe) (release func()) {
+	key := c.generateKey(symbol, timeframe)
+	c.Lock()
+	c.held[key]++
+	c.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.Lock()
+			defer c.Unlock()
+			if c.held[key]--; c.held[key] <= 0 {
+				delete(c.held, key)
+			}
+			c.connect()
+		})
+	}
+}
+
+// Resample subscribes consumer to the target candles built from the source feed of the symbol
+func (c *CandleController) Resample(symbol string, source, target model.Timeframe, consumer CandleConsumer, onCandleClose bool) (SubscriptionID, error) {
+	resampler, err := NewResampler(source, target, func(candle model.Candle) {
+		if onCandleClose && !candle.Complete {
+			return
+		}
+		consumer(candle)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return c.Subscribe(symbol, source, resampler.OnCandle, false), nil
+}
+
+// SubscribeTrades subscribes consumer to the aggregate trades of the symbol, streamed at once while the controller
+// runs
+func (c *CandleController) SubscribeTrades(symbol string, consumer TradeConsumer) SubscriptionID {
+	c.Lock()
+	defer c.Unlock()
+	if !isInList(c.TradeFeeds, symbol) {
+		c.TradeFeeds = append(c.TradeFeeds, symbol)
+	}
+	c.lastID++
+	c.TradeSubscriptions[symbol] = append(c.TradeSubscriptions[symbol], TradeSubscription{
+		id:       c.lastID,
+		consumer: consumer,
+	})
+	c.connect()
+	return c.lastID
+}
+
+// SubscribeBars subscribes consumer to the candles built from the trades of the symbol, timeframe is either an
+// interval or a bar label, e.g. volume:5000
+func (c *CandleController) SubscribeBars(symbol string, timeframe model.Timeframe, consumer CandleConsumer, onCandleClose bool) (SubscriptionID, error) {
+	builder, err := NewBarBuilder(timeframe, func(candle model.Candle) {
+		if onCandleClose && !candle.Complete {
+			return
+		}
+		consumer(candle)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return c.SubscribeTrades(symbol, builder.OnTrade), nil
 }
 
-func (c *CandleController) Preload(symbol, timeframe string, candles []model.Candle) {
+// Unsubscribe removes the subscription id and reports whether it was found. The stream of a feed left without
+// subscriber is stopped.
+func (c *CandleController) Unsubscribe(id SubscriptionID) bool {
 	c.Lock()
 	defer c.Unlock()
+	for feed, subscriptions := range c.Subscriptions {
+		for i, subscription := range subscriptions {
+			if subscription.id != id {
+				continue
+			}
+			c.Subscriptions[feed] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			if len(c.Subscriptions[feed]) == 0 {
+				c.removeFeeds([]string{feed}, nil)
+			}
+			return true
+		}
+	}
+	for symbol, subscriptions := range c.TradeSubscriptions {
+		for i, subscription := range subscriptions {
+			if subscription.id != id {
+				continue
+			}
+			c.TradeSubscriptions[symbol] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			if len(c.TradeSubscriptions[symbol]) == 0 {
+				c.removeFeeds(nil, []string{symbol})
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// UnsubscribeSymbol removes every candle and trade subscription of symbol and stops their streams
+func (c *CandleController) UnsubscribeSymbol(symbol string) {
+	c.Lock()
+	defer c.Unlock()
+	var feeds = make([]string, 0)
+	for _, feed := range c.Feeds {
+		if feedSymbol, _ := c.extractKey(feed); feedSymbol == symbol {
+			feeds = append(feeds, feed)
+		}
+	}
+	var tradeSymbols []string
+	if isInList(c.TradeFeeds, symbol) {
+		tradeSymbols = []string{symbol}
+	}
+	c.removeFeeds(feeds, tradeSymbols)
+}
+
+// ActiveFeeds lists the candle and trade feeds with their number of subscribers, sorted by symbol
+func (c *CandleController) ActiveFeeds() []FeedStatus {
+	c.RLock()
+	defer c.RUnlock()
+	var feeds = make([]FeedStatus, 0, len(c.Feeds)+len(c.TradeFeeds))
+	for _, feed := range c.Feeds {
+		symbol, timeframe := c.extractKey(feed)
+		status := FeedStatus{
+			Symbol:      symbol,
+			Timeframe:   timeframe,
+			Subscribers: len(c.Subscriptions[feed]),
+			Preloaded:   c.preloaded[feed],
+			LastUpdate:  c.lastUpdate[feed],
+		}
+		if connection, ok := c.connections[feed]; ok && c.ctx != nil {
+			status.Streaming, status.ConnectedAt = true, connection.connectedAt
+		}
+		feeds = append(feeds, status)
+	}
+	for _, symbol := range c.TradeFeeds {
+		status := FeedStatus{
+			Symbol:      symbol,
+			Subscribers: len(c.TradeSubscriptions[symbol]),
+		}
+		if connection, ok := c.tradeConnections[symbol]; ok && c.ctx != nil {
+			status.Streaming, status.ConnectedAt = true, connection.connectedAt
+		}
+		feeds = append(feeds, status)
+	}
+	sortFeeds(feeds)
+	return feeds
+}
+
+// sortFeeds sorts feeds by symbol, then by timeframe
+func sortFeeds(feeds []FeedStatus) {
+	sort.SliceStable(feeds, func(i, j int) bool {
+		if feeds[i].Symbol != feeds[j].Symbol {
+			return feeds[i].Symbol < feeds[j].Symbol
+		}
+		return feeds[i].Timeframe < feeds[j].Timeframe
+	})
+}
+
+// Preload passes the history of the feed to its subscribers, it returns once the candles are consumed
+func (c *CandleController) Preload(symbol string, timeframe model.Timeframe, candles []model.Candle) {
 	key := c.generateKey(symbol, timeframe)
-	for _, candle := range candles {
-		for _, sub := range c.Subscriptions[key] {
-			sub.consumer(candle)
+	c.Lock()
+	if _, ok := c.Subscriptions[key]; ok {
+		c.preloaded[key] = true
+	}
+	if len(candles) == 0 {
+		c.Unlock()
+		return
+	}
+	consumed := make(chan struct{})
+	for i, candle := range candles {
+		if candle.Complete && candle.Time.After(c.lastClosed[key]) {
+			c.lastClosed[key] = candle.Time
+		}
+		if c.dispatcher == nil {
+			continue
 		}
+		item := dispatchItem{candle: candle, preload: true}
+		if i == len(candles)-1 {
+			item.consumed = consumed
+		}
+		c.dispatcher.enqueue(key, item)
 	}
+	synchronous := c.dispatcher == nil
+	c.Unlock()
+
+	if synchronous {
+		for _, candle := range candles {
+			c.dispatch(key, candle)
+		}
+		return
+	}
+	<-consumed
 	// c.l.Infow("preloading candles", "symbol", symbol, "timeframe", timeframe)
 }
 
-func (c *CandleController) CandlesSubscription(ctx context.Context, feed string, wg *sync.WaitGroup) {
+// CombinedCandlesSubscription consumes the candles of a chunk of feeds sharing a single connection. When the
+// connection breaks, only this chunk is subscribed again after a backoff delay, and the candles closed during the
+// outage are replayed before the live updates. A signal on restart subscribes the chunk again at once, e.g. when the
+// connection stays quiet without error.
+func (c *CandleController) CombinedCandlesSubscription(ctx context.Context, feeds []string, restart <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
 	var (
-		candleCh = make(chan model.Candle)
-		errCh    = make(chan error)
+		candleCh           = make(chan model.Candle)
+		errCh              = make(chan error)
+		mapSymbolTimeframe = make(map[string]model.Timeframe)
+		backoff            = app.NewBackoff(c.reconnectMinDelay, c.reconnectMaxDelay)
 	)
-	symbol, timeframe := c.extractKey(feed)
+	for _, feed := range feeds {
+		symbol, timeframe := c.extractKey(feed)
+		mapSymbolTimeframe[symbol] = timeframe
+	}
 
-	go c.exchange.CandlesSubscription(ctx, symbol, timeframe, candleCh, errCh)
+	// each subscription has its own context so that a quiet one can be stopped
+	var stop context.CancelFunc
+	subscribe := func() {
+		if stop != nil {
+			stop()
+		}
+		var subscriptionCtx context.Context
+		subscriptionCtx, stop = context.WithCancel(ctx)
+		go c.exchange.CombinedCandlesSubscription(subscriptionCtx, mapSymbolTimeframe, candleCh, errCh)
+	}
+	subscribe()
+	defer func() {
+		stop()
+	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			close(candleCh)
 			return
+		case <-restart:
+			c.l.Warnw("combined candles subscription quiet, reconnecting", "feeds", len(feeds))
+			metrics.Reconnects.WithLabelValues(StreamCandles, metrics.ReconnectQuiet).Inc()
+			subscribe()
+			c.backfill(ctx, feeds)
 		case err := <-errCh:
-			// try to reset subscription
-			c.l.Debugw("candle subscription error", "error", err, "symbol", symbol, "timeframe", timeframe)
-			go c.exchange.CandlesSubscription(ctx, symbol, timeframe, candleCh, errCh)
+			delay := backoff.Next()
+			c.l.Warnw("combined candles subscription error, reconnecting", "error", err, "feeds", len(feeds),
+				"attempt", backoff.Attempt(), "delay", delay)
+			metrics.Reconnects.WithLabelValues(StreamCandles, metrics.ReconnectError).Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			// live candles wait in the new subscription until the missed ones are replayed
+			subscribe()
+			c.backfill(ctx, feeds)
 		case candle, ok := <-candleCh:
 			if !ok {
-				c.l.Debugw("no more candles", "symbol", symbol, "timeframe", timeframe)
-				wg.Done()
+				c.l.Debugw("no more candles", "feeds", len(feeds))
 				return
 			}
-			c.onCandle(feed, candle)
+			backoff.Reset()
+			c.onCandle(c.generateKey(candle.Symbol, candle.Timeframe), candle)
 		}
 	}
 }
 
-// func (c *CandleController) CombinedCandlesSubscription(ctx context.Context, feeds []string) {
-// 	var (
-// 		candleCh           = make(chan model.Candle)
-// 		errCh              = make(chan error)
-// 		mapSymbolTimeframe = make(map[string]string)
-// 	)
-// 	for _, feed := range feeds {
-// 		symbol, timeframe := c.extractKey(feed)
-// 		mapSymbolTimeframe[symbol] = timeframe
-// 	}
-// }
+// backfill fetches the candles closed since the last dispatched one and replays them in order
+func (c *CandleController) backfill(ctx context.Context, feeds []string) {
+	for _, feed := range feeds {
+		c.RLock()
+		lastClosed, ok := c.lastClosed[feed]
+		c.RUnlock()
+		if !ok {
+			continue
+		}
+
+		symbol, timeframe := c.extractKey(feed)
+		candles, err := c.exchange.CandlesByPeriod(ctx, symbol, timeframe, lastClosed, time.Now())
+		if err != nil {
+			c.l.Warnw("backfill candles error", "error", err, "symbol", symbol, "timeframe", timeframe)
+			continue
+		}
+
+		var replayed int
+		for _, candle := range candles {
+			// the candle still open is delivered by the live stream
+			if !candle.Complete || !candle.Time.After(lastClosed) {
+				continue
+			}
+			c.onCandle(feed, candle)
+			replayed++
+		}
+		if replayed > 0 {
+			c.l.Infow("backfill missed candles", "symbol", symbol, "timeframe", timeframe, "candles", replayed)
+		}
+	}
+}
 
 func (c *CandleController) onCandle(feed string, candle model.Candle) {
 	c.Lock()
-	defer c.Unlock()
-
+	// the feed was unsubscribed while its connection was stopping
 	if _, ok := c.Subscriptions[feed]; !ok {
+		c.Unlock()
 		return
 	}
-	for _, sub := range c.Subscriptions[feed] {
-		if sub.onCandleClose && !candle.Complete {
-			continue
+	c.lastUpdate[feed] = time.Now()
+	metrics.CandlesReceived.WithLabelValues(candle.Symbol, candle.Timeframe.String()).Inc()
+	// skip candles already dispatched, e.g. received again after a backfill
+	if lastClosed, ok := c.lastClosed[feed]; ok && !candle.Time.After(lastClosed) {
+		c.Unlock()
+		return
+	}
+	if candle.Complete {
+		c.lastClosed[feed] = candle.Time
+	}
+	store := c.candleStore
+	// the candles are queued under the lock to keep their order
+	if c.dispatcher != nil {
+		c.dispatcher.enqueue(feed, dispatchItem{candle: candle})
+		c.Unlock()
+	} else {
+		c.Unlock()
+		c.dispatch(feed, candle)
+	}
+
+	if candle.Complete && store != nil {
+		if err := store.SaveCandles([]model.Candle{candle}); err != nil {
+			c.l.Warnw("store candle error", "error", err, "symbol", candle.Symbol, "timeframe", candle.Timeframe)
 		}
-		sub.consumer(candle)
 	}
 }
 
+// SetCandleStore saves the closed candles of the streamed feeds to store
+func (c *CandleController) SetCandleStore(store storage.CandleStore) {
+	c.Lock()
+	defer c.Unlock()
+	c.candleStore = store
+}
+
+// dispatch passes the candle to the subscribers of feed, called by the dispatcher shard of the feed
+func (c *CandleController) dispatch(feed string, candle model.Candle) {
+	c.RLock()
+	subscriptions := c.Subscriptions[feed]
+	c.RUnlock()
+	for _, subscription := range subscriptions {
+		subscription.dispatch(candle)
+	}
+}
+
+// DispatchStats returns the backpressure metrics of the dispatch shards, none when the candles are dispatched
+// synchronously
+func (c *CandleController) DispatchStats() []ShardStats {
+	c.RLock()
+	dispatcher := c.dispatcher
+	c.RUnlock()
+	if dispatcher == nil {
+		return nil
+	}
+	return dispatcher.stats()
+}
+
+// CombinedTradesSubscription consumes the trades of a chunk of symbols sharing a single connection. When the
+// connection breaks, the chunk is subscribed again after a backoff delay, the trades of the outage are lost.
+func (c *CandleController) CombinedTradesSubscription(ctx context.Context, symbols []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var (
+		tradeCh = make(chan model.Trade)
+		errCh   = make(chan error)
+		backoff = app.NewBackoff(c.reconnectMinDelay, c.reconnectMaxDelay)
+	)
+
+	go c.exchange.CombinedTradesSubscription(ctx, symbols, tradeCh, errCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errCh:
+			delay := backoff.Next()
+			c.l.Warnw("combined trades subscription error, reconnecting", "error", err, "symbols", len(symbols),
+				"attempt", backoff.Attempt(), "delay", delay)
+			metrics.Reconnects.WithLabelValues(StreamTrades, metrics.ReconnectError).Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			go c.exchange.CombinedTradesSubscription(ctx, symbols, tradeCh, errCh)
+		case trade, ok := <-tradeCh:
+			if !ok {
+				c.l.Debugw("no more trades", "symbols", len(symbols))
+				return
+			}
+			backoff.Reset()
+			c.onTrade(trade)
+		}
+	}
+}
+
+// onTrade queues the trade on the dispatch shard of its symbol, so a slow consumer neither holds the lock of c nor
+// delays the other feeds of the connection
+func (c *CandleController) onTrade(trade model.Trade) {
+	c.RLock()
+	if _, ok := c.TradeSubscriptions[trade.Symbol]; !ok {
+		c.RUnlock()
+		return
+	}
+	if c.dispatcher != nil {
+		c.dispatcher.enqueue(c.generateTradeKey(trade.Symbol), dispatchItem{trade: &trade})
+		c.RUnlock()
+		return
+	}
+	c.RUnlock()
+	c.dispatchTrade(trade)
+}
+
+// dispatchTrade passes the trade to the subscribers of its symbol, called by the dispatcher shard of the symbol
+func (c *CandleController) dispatchTrade(trade model.Trade) {
+	c.RLock()
+	subscriptions := c.TradeSubscriptions[trade.Symbol]
+	c.RUnlock()
+	for _, subscription := range subscriptions {
+		subscription.consumer(trade)
+	}
+}
+
+// Start streams the subscribed feeds until ctx is done, or until every connection ends on its own, e.g. once the
+// CSV files are consumed. Without any feed, it returns at once.
 func (c *CandleController) Start(ctx context.Context) {
-	wg := new(sync.WaitGroup)
-	for _, feed := range c.Feeds {
-		wg.Add(1)
-		go c.CandlesSubscription(ctx, feed, wg)
+	c.Lock()
+	c.ctx, c.wg, c.done = ctx, new(sync.WaitGroup), make(chan struct{})
+	connections := c.connectPending()
+	totalFeeds := len(c.Feeds)
+	totalTradeFeeds := len(c.TradeFeeds)
+	wg, done := c.wg, c.done
+	c.Unlock()
+
+	c.l.Infow("start candle controller", "feeds", totalFeeds, "trade_feeds", totalTradeFeeds,
+		"connections", connections)
+
+	if connections > 0 {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
 	}
-	c.l.Infow("start candle controller")
 
+	c.Lock()
+	c.ctx = nil
+	c.Unlock()
 	wg.Wait()
+	// the candles received before the end are consumed before returning
+	c.RLock()
+	dispatcher := c.dispatcher
+	c.RUnlock()
+	if dispatcher != nil {
+		dispatcher.drain()
+	}
 	c.l.Infow("candle controller finishes")
 }
 
+// Close stops the dispatch workers once the queued candles are consumed, the candles received afterwards are
+// dispatched synchronously
+func (c *CandleController) Close() {
+	c.Lock()
+	dispatcher := c.dispatcher
+	c.dispatcher = nil
+	c.Unlock()
+	if dispatcher != nil {
+		dispatcher.close()
+	}
+}
+
+// Running reports whether the controller streams its feeds
+func (c *CandleController) Running() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ctx != nil
+}
+
+// Reconnect subscribes again the candle connections whose every feed is in feeds, e.g. the feeds without update for
+// too long, and returns their number. The candles closed while they were quiet are backfilled.
+func (c *CandleController) Reconnect(feeds []string) int {
+	c.Lock()
+	defer c.Unlock()
+	if c.ctx == nil {
+		return 0
+	}
+	var restarted = make(map[*feedConnection]bool)
+	for _, feed := range feeds {
+		connection, ok := c.connections[feed]
+		if !ok || restarted[connection] {
+			continue
+		}
+		all := true
+		for _, connectionFeed := range connection.feeds {
+			if c.connections[connectionFeed] == connection && !isInList(feeds, connectionFeed) {
+				all = false
+				break
+			}
+		}
+		if !all {
+			continue
+		}
+		select {
+		case connection.restart <- struct{}{}:
+		default:
+		}
+		connection.connectedAt = time.Now()
+		restarted[connection] = true
+	}
+	return len(restarted)
+}
+
+// connectionEnded counts the connections still streaming, the controller is done once all of them ended without
+// being stopped
+func (c *CandleController) connectionEnded(ctx context.Context) {
+	c.Lock()
+	defer c.Unlock()
+	c.running--
+	if c.running == 0 && ctx.Err() == nil && c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+}
+
+// connect streams the feeds without connection when the controller runs, c must be locked
+func (c *CandleController) connect() {
+	if c.ctx == nil {
+		return
+	}
+	if connections := c.connectPending(); connections > 0 {
+		c.l.Infow("connect new feeds", "connections", connections)
+	}
+}
+
+// removeFeeds removes the candle feeds and the trades of the symbols with their subscriptions. The connections
+// streaming them are replaced by new ones without them, c must be locked.
+func (c *CandleController) removeFeeds(feeds []string, tradeSymbols []string) {
+	var stopped = make(map[*feedConnection]bool) // stopped connections, true for the trade connections
+	if len(feeds) > 0 {
+		remaining := make([]string, 0, len(c.Feeds))
+		for _, feed := range c.Feeds {
+			if !isInList(feeds, feed) {
+				remaining = append(remaining, feed)
+			}
+		}
+		c.Feeds = remaining
+	}
+	for _, feed := range feeds {
+		delete(c.Subscriptions, feed)
+		delete(c.lastClosed, feed)
+		delete(c.lastUpdate, feed)
+		delete(c.preloaded, feed)
+		if c.dispatcher != nil {
+			c.dispatcher.remove(feed)
+		}
+		if connection, ok := c.connections[feed]; ok {
+			stopped[connection] = false
+			delete(c.connections, feed)
+		}
+	}
+
+	if len(tradeSymbols) > 0 {
+		remaining := make([]string, 0, len(c.TradeFeeds))
+		for _, symbol := range c.TradeFeeds {
+			if !isInList(tradeSymbols, symbol) {
+				remaining = append(remaining, symbol)
+			}
+		}
+		c.TradeFeeds = remaining
+	}
+	for _, symbol := range tradeSymbols {
+		delete(c.TradeSubscriptions, symbol)
+		if c.dispatcher != nil {
+			c.dispatcher.remove(c.generateTradeKey(symbol))
+		}
+		if connection, ok := c.tradeConnections[symbol]; ok {
+			stopped[connection] = true
+			delete(c.tradeConnections, symbol)
+		}
+	}
+
+	// the other feeds of the stopped connections are pending again. Candle connections keep streaming until the
+	// handover ends as candle updates can be received twice, trades would be counted twice so their connections
+	// stop at once.
+	for connection, isTrade := range stopped {
+		if isTrade {
+			connection.cancel()
+		} else {
+			time.AfterFunc(c.handoverDelay, connection.cancel)
+		}
+		for _, feed := range connection.feeds {
+			if c.connections[feed] == connection {
+				delete(c.connections, feed)
+			}
+			if c.tradeConnections[feed] == connection {
+				delete(c.tradeConnections, feed)
+			}
+		}
+	}
+	if len(stopped) > 0 {
+		c.connect()
+	}
+}
+
+// connectPending starts the connections of the feeds without one, except the held feeds, and returns their number, c
+// must be locked
+func (c *CandleController) connectPending() int {
+	var pending = make([]string, 0)
+	for _, feed := range c.Feeds {
+		if _, ok := c.connections[feed]; !ok && c.held[feed] == 0 {
+			pending = append(pending, feed)
+		}
+	}
+	var pendingTrades = make([]string, 0)
+	for _, symbol := range c.TradeFeeds {
+		if _, ok := c.tradeConnections[symbol]; !ok {
+			pendingTrades = append(pendingTrades, symbol)
+		}
+	}
+
+	chunks := c.chunkFeeds(pending, c.streamsPerConnection)
+	for _, chunk := range chunks {
+		connection, wg := c.newConnection(chunk, c.connections), c.wg
+		go func(chunk []string) {
+			c.CombinedCandlesSubscription(connection.ctx, chunk, connection.restart, wg)
+			c.connectionEnded(connection.ctx)
+		}(chunk)
+	}
+	tradeChunks := chunkList(pendingTrades, c.streamsPerConnection)
+	for _, chunk := range tradeChunks {
+		connection, wg := c.newConnection(chunk, c.tradeConnections), c.wg
+		go func(chunk []string) {
+			c.CombinedTradesSubscription(connection.ctx, chunk, wg)
+			c.connectionEnded(connection.ctx)
+		}(chunk)
+	}
+	return len(chunks) + len(tradeChunks)
+}
+
+// newConnection registers a connection streaming feeds, c must be locked
+func (c *CandleController) newConnection(feeds []string, connections map[string]*feedConnection) *feedConnection {
+	ctx, cancel := context.WithCancel(c.ctx)
+	connection := &feedConnection{
+		feeds:       feeds,
+		ctx:         ctx,
+		cancel:      cancel,
+		restart:     make(chan struct{}, 1),
+		connectedAt: time.Now(),
+	}
+	for _, feed := range feeds {
+		connections[feed] = connection
+	}
+	c.running++
+	c.wg.Add(1)
+	return connection
+}
+
+// chunkFeeds splits feeds into chunks of at most size feeds. A combined subscription maps each symbol to a single
+// timeframe, so feeds of different timeframes never share a chunk.
+func (c *CandleController) chunkFeeds(feeds []string, size int) [][]string {
+	var (
+		timeframes       = make([]model.Timeframe, 0)
+		feedsByTimeframe = make(map[model.Timeframe][]string)
+	)
+	for _, feed := range feeds {
+		_, timeframe := c.extractKey(feed)
+		if _, ok := feedsByTimeframe[timeframe]; !ok {
+			timeframes = append(timeframes, timeframe)
+		}
+		feedsByTimeframe[timeframe] = append(feedsByTimeframe[timeframe], feed)
+	}
+
+	var chunks = make([][]string, 0)
+	for _, timeframe := range timeframes {
+		chunks = append(chunks, chunkList(feedsByTimeframe[timeframe], size)...)
+	}
+	return chunks
+}
+
+// chunkList splits list into chunks of at most size items
+func chunkList(list []string, size int) [][]string {
+	var chunks = make([][]string, 0)
+	for start := 0; start < len(list); start += size {
+		end := start + size
+		if end > len(list) {
+			end = len(list)
+		}
+		chunks = append(chunks, list[start:end])
+	}
+	return chunks
+}
+
 func isInList(list []string, value string) bool {
 	for _, item := range list {
 		if item == value {
@@ -153,3 +878,14 @@ func isInList(list []string, value string) bool {
 	}
 	return false
 }
+
+// removeFromList returns list without value, in a new slice
+func removeFromList(list []string, value string) []string {
+	var result = make([]string, 0, len(list))
+	for _, item := range list {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
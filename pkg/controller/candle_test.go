@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/exchange/binancetest"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+const historyLength = 1700
+
+type CandleControllerTestSuite struct {
+	suite.Suite
+	server  *binancetest.Server
+	client  *exchange.Binance
+	candles []model.Candle
+}
+
+func TestCandleControllerTestSuite(t *testing.T) {
+	suite.Run(t, new(CandleControllerTestSuite))
+}
+
+func (ts *CandleControllerTestSuite) SetupTest() {
+	require := ts.Require()
+	l, _ := zap.NewDevelopment()
+	zap.ReplaceGlobals(l)
+
+	candles, err := binancetest.ReadCandlesCSV("../../testdata/kncusdt-4h-test1.csv")
+	require.NoError(err)
+	ts.candles = candles
+
+	ts.server = binancetest.NewServer()
+	ts.server.AddKlines(candles[:historyLength]...)
+
+	viper.Set(exchange.BinanceApiKeyFlag, "api-key")
+	viper.Set(exchange.BinanceApiSecretFlag, "api-secret")
+	viper.Set(exchange.BinanceApiEndpointFlag, ts.server.URL())
+	viper.Set(exchange.BinanceWsEndpointFlag, ts.server.WsURL())
+
+	ts.client, err = exchange.NewBinance()
+	require.NoError(err)
+}
+
+func (ts *CandleControllerTestSuite) TearDownTest() {
+	ts.server.Close()
+}
+
+func (ts *CandleControllerTestSuite) TestReconnectBackfill() {
+	assert := ts.Assert()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		received = make([]model.Candle, 0)
+		stream   = binancetest.KlineStream("KNCUSDT", model.Timeframe4h)
+		live     = ts.candles[historyLength:]
+	)
+	consumer := func(candle model.Candle) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, candle)
+	}
+	receivedCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+
+	c := NewCandleController(ts.client)
+	defer c.Close()
+	c.reconnectMinDelay = 10 * time.Millisecond
+	c.Subscribe("KNCUSDT", model.Timeframe4h, consumer, true)
+
+	preload, err := ts.client.CandlesByLimit(ctx, "KNCUSDT", model.Timeframe4h, 201)
+	ts.Require().NoError(err)
+	c.Preload("KNCUSDT", model.Timeframe4h, preload)
+	ts.Require().Equal(201, receivedCount())
+
+	go c.Start(ctx)
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(stream) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ts.server.PushKline(live[0])
+	ts.Require().Eventually(func() bool {
+		return receivedCount() == 202
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// candles closing during the outage are only available over REST
+	ts.server.AddKlines(live[1:6]...)
+	ts.server.DropConnections()
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(stream) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a candle already replayed by the backfill is not dispatched twice
+	ts.server.PushKline(live[5])
+	ts.server.PushKline(live[6])
+	ts.Require().Eventually(func() bool {
+		return receivedCount() == 208
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, candle := range live[:7] {
+		assert.True(candle.Time.Equal(received[201+i].Time), "expected %v, actual %v", candle.Time, received[201+i].Time)
+		assert.Equal(candle.Close, received[201+i].Close)
+	}
+	assert.Len(received, 208)
+}
+
+func (ts *CandleControllerTestSuite) TestSubscribeWhileRunning() {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string]int)
+		kncFeed  = binancetest.KlineStream("KNCUSDT", model.Timeframe4h)
+		btcFeed  = binancetest.KlineStream("BTCUSDT", model.Timeframe4h)
+	)
+	consumer := func(candle model.Candle) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[candle.Symbol]++
+	}
+	receivedCount := func(symbol string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return received[symbol]
+	}
+
+	c := NewCandleController(ts.client)
+	defer c.Close()
+	c.handoverDelay = 10 * time.Millisecond
+	// the feeds without their update times
+	activeFeeds := func() []FeedStatus {
+		feeds := c.ActiveFeeds()
+		for i := range feeds {
+			ts.Equal(feeds[i].Streaming, !feeds[i].ConnectedAt.IsZero())
+			feeds[i].LastUpdate, feeds[i].ConnectedAt = time.Time{}, time.Time{}
+		}
+		return feeds
+	}
+	c.Subscribe("KNCUSDT", model.Timeframe4h, consumer, true)
+	go c.Start(ctx)
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(kncFeed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a feed subscribed while running gets its own connection
+	first := c.Subscribe("BTCUSDT", model.Timeframe4h, consumer, true)
+	second := c.Subscribe("BTCUSDT", model.Timeframe4h, func(model.Candle) {}, true)
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(btcFeed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	ts.Equal(1, ts.server.Subscribers(kncFeed))
+	ts.Equal([]FeedStatus{
+		{Symbol: "BTCUSDT", Timeframe: model.Timeframe4h, Subscribers: 2, Streaming: true},
+		{Symbol: "KNCUSDT", Timeframe: model.Timeframe4h, Subscribers: 1, Streaming: true},
+	}, activeFeeds())
+
+	candle := ts.candles[historyLength]
+	ts.server.PushKline(candle)
+	candle.Symbol = "BTCUSDT"
+	ts.server.PushKline(candle)
+	ts.Require().Eventually(func() bool {
+		return receivedCount("KNCUSDT") == 1 && receivedCount("BTCUSDT") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the stream stops with the last subscriber of the feed
+	ts.True(c.Unsubscribe(second))
+	ts.False(c.Unsubscribe(second))
+	ts.Equal(1, c.ActiveFeeds()[0].Subscribers)
+	ts.True(c.Unsubscribe(first))
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(btcFeed) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	ts.Equal(1, ts.server.Subscribers(kncFeed))
+	ts.Equal([]FeedStatus{
+		{Symbol: "KNCUSDT", Timeframe: model.Timeframe4h, Subscribers: 1, Streaming: true},
+	}, activeFeeds())
+
+	// every feed of a symbol is removed at once
+	c.SubscribeTrades("KNCUSDT", func(model.Trade) {})
+	c.UnsubscribeSymbol("KNCUSDT")
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(kncFeed) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	ts.Empty(c.ActiveFeeds())
+}
+
+func (ts *CandleControllerTestSuite) TestHoldWhileRunning() {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		received = make([]model.Candle, 0)
+		kncFeed  = binancetest.KlineStream("KNCUSDT", model.Timeframe4h)
+		btcFeed  = binancetest.KlineStream("BTCUSDT", model.Timeframe4h)
+	)
+	c := NewCandleController(ts.client)
+	defer c.Close()
+	c.Subscribe("KNCUSDT", model.Timeframe4h, func(model.Candle) {}, true)
+	go c.Start(ctx)
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(kncFeed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a held feed is not streamed, a live candle pushed meanwhile is not received
+	release := c.Hold("BTCUSDT", model.Timeframe4h)
+	c.Subscribe("BTCUSDT", model.Timeframe4h, func(candle model.Candle) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, candle)
+	}, true)
+	live := ts.candles[historyLength]
+	live.Symbol = "BTCUSDT"
+	time.Sleep(50 * time.Millisecond)
+	ts.Equal(0, ts.server.PushKline(live))
+
+	var history = make([]model.Candle, 0, 3)
+	for _, candle := range ts.candles[historyLength-3 : historyLength] {
+		candle.Symbol = "BTCUSDT"
+		history = append(history, candle)
+	}
+	c.Preload("BTCUSDT", model.Timeframe4h, history)
+	release()
+	release()
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(btcFeed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ts.server.PushKline(live)
+	ts.Require().Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	for i, candle := range append(history, live) {
+		ts.True(candle.Time.Equal(received[i].Time), "expected %v, actual %v", candle.Time, received[i].Time)
+	}
+}
+
+func TestSlowTradeConsumer(t *testing.T) {
+	for _, synchronous := range []bool{false, true} {
+		viper.Set(DispatchSynchronousFlag, synchronous)
+		c := NewCandleController(new(quietFeeder))
+		defer c.Close()
+		viper.Set(DispatchSynchronousFlag, nil)
+
+		var (
+			entered = make(chan struct{})
+			release = make(chan struct{})
+			candles = make(chan model.Candle, 1)
+		)
+		c.SubscribeTrades("BTCUSDT", func(model.Trade) {
+			close(entered)
+			<-release
+		})
+		c.Subscribe("ETHUSDT", model.Timeframe1m, func(candle model.Candle) {
+			candles <- candle
+		}, false)
+
+		go c.onTrade(model.Trade{Symbol: "BTCUSDT", Price: 1, Quantity: 1})
+		<-entered
+
+		// the candles of the other feeds go through while the trade consumer is busy
+		done := make(chan struct{})
+		go func() {
+			c.onCandle(c.generateKey("ETHUSDT", model.Timeframe1m), model.Candle{Symbol: "ETHUSDT",
+				Timeframe: model.Timeframe1m, Time: time.Now(), Complete: true})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("candle blocked by the trade consumer, synchronous: %v", synchronous)
+		}
+		close(release)
+		select {
+		case candle := <-candles:
+			require.Equal(t, "ETHUSDT", candle.Symbol)
+		case <-time.After(time.Second):
+			t.Fatalf("candle not dispatched, synchronous: %v", synchronous)
+		}
+	}
+}
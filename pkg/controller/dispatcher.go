@@ -0,0 +1,338 @@
+package controller
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+
+	"github.com/quangkeu95/binancebot/pkg/metrics"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// DispatchShardsFlag is the number of workers dispatching the candles, the CPU count by default
+	DispatchShardsFlag = "candle_dispatch.shards"
+	// DispatchQueueSizeFlag is the number of candle updates waiting for each feed before partial updates are dropped
+	DispatchQueueSizeFlag = "candle_dispatch.queue_size"
+	// DispatchMaxQueueSizeFlag is the hard limit of each feed queue: complete candles are queued above the queue size
+	// up to it, and the trades of a symbol up to it, then the oldest of them are dropped
+	DispatchMaxQueueSizeFlag = "candle_dispatch.max_queue_size"
+	// DispatchPartialPolicyFlag tells which partial candle updates wait in the feed queues, see PartialPolicy
+	DispatchPartialPolicyFlag = "candle_dispatch.partial_policy"
+	// DispatchSynchronousFlag dispatches the candles in the goroutine of their connection without queue, in the order
+	// they are received across feeds, e.g. for backtests
+	DispatchSynchronousFlag = "candle_dispatch.synchronous"
+
+	DefaultDispatchQueueSize    = 16
+	DefaultDispatchMaxQueueSize = 256
+)
+
+// PartialPolicy tells which partial candle updates wait in a feed queue. Complete candles are never dropped.
+type PartialPolicy string
+
+const (
+	// PartialPolicyLatest keeps the latest update of each candle, a queued partial update is stale once a newer
+	// update of the same candle arrives
+	PartialPolicyLatest PartialPolicy = "latest"
+	// PartialPolicyAll queues every partial update
+	PartialPolicyAll PartialPolicy = "all"
+)
+
+// ShardStats are the backpressure metrics of a dispatch shard
+type ShardStats struct {
+	Feeds      int    // feed queues of the shard
+	Queued     int    // updates waiting in the feed queues
+	MaxQueued  int    // most updates waiting in a single feed queue so far
+	Dispatched uint64 // updates passed to the subscribers
+	Replaced   uint64 // stale partial updates replaced by a newer update of the same candle
+	Dropped    uint64 // partial updates dropped from a full queue
+	Overflows  uint64 // complete candles queued in a full queue
+	Evicted    uint64 // complete candles and trades dropped from a queue at its hard limit
+}
+
+type dispatchItem struct {
+	candle   model.Candle
+	trade    *model.Trade  // set instead of the candle for the trades of a symbol
+	preload  bool          // preloaded candles are not bounded, Preload waits for them so a feed has a single history queued
+	consumed chan struct{} // closed once the candle is dispatched, nil unless waited for
+}
+
+func (i dispatchItem) droppable() bool {
+	return i.trade == nil && !i.candle.Complete && i.consumed == nil
+}
+
+// bounded tells whether the item counts against the queue size, the preloaded candles and the trades do not
+func (i dispatchItem) bounded() bool {
+	return !i.preload && i.trade == nil
+}
+
+// evictable tells whether the item is dropped from a queue at its hard limit
+func (i dispatchItem) evictable() bool {
+	return !i.preload && i.consumed == nil
+}
+
+type feedQueue struct {
+	feed  string
+	items []dispatchItem
+	ready bool // waiting in the ready list of its shard
+}
+
+// dispatchShard passes the candles of its feeds in order, the feeds with pending updates take turns
+type dispatchShard struct {
+	sync.Mutex
+	cond   *sync.Cond // signaled when a feed gets ready
+	idle   *sync.Cond // broadcast when the last pending update is dispatched
+	busy   bool       // the worker is dispatching an update
+	queues map[string]*feedQueue
+	ready  []*feedQueue
+	closed bool // the worker stops once the ready feeds are dispatched
+	stats  ShardStats
+}
+
+// dispatcher spreads the feeds over shards, each shard is consumed by its own worker so a slow subscriber only
+// delays the feeds of its shard. The trades of a symbol are a feed of their own.
+type dispatcher struct {
+	l             *zap.SugaredLogger
+	shards        []*dispatchShard
+	queueSize     int
+	maxQueueSize  int
+	policy        PartialPolicy
+	dispatch      func(feed string, candle model.Candle)
+	dispatchTrade func(trade model.Trade)
+	workers       sync.WaitGroup
+}
+
+func newDispatcher(shards, queueSize, maxQueueSize int, policy PartialPolicy, dispatch func(feed string, candle model.Candle), dispatchTrade func(trade model.Trade)) *dispatcher {
+	if maxQueueSize < queueSize {
+		maxQueueSize = queueSize
+	}
+	d := &dispatcher{
+		l:             zap.S(),
+		shards:        make([]*dispatchShard, shards),
+		queueSize:     queueSize,
+		maxQueueSize:  maxQueueSize,
+		policy:        policy,
+		dispatch:      dispatch,
+		dispatchTrade: dispatchTrade,
+	}
+	d.workers.Add(shards)
+	for i := range d.shards {
+		shard := &dispatchShard{queues: make(map[string]*feedQueue)}
+		shard.cond = sync.NewCond(shard)
+		shard.idle = sync.NewCond(shard)
+		d.shards[i] = shard
+		go d.work(shard)
+	}
+	return d
+}
+
+// dispatcherFromConfig reads the dispatch settings of the configuration, there is no dispatcher when the candles are
+// dispatched synchronously
+func dispatcherFromConfig(dispatch func(feed string, candle model.Candle), dispatchTrade func(trade model.Trade)) *dispatcher {
+	l := zap.S()
+	if viper.GetBool(DispatchSynchronousFlag) {
+		return nil
+	}
+	shards := viper.GetInt(DispatchShardsFlag)
+	if shards <= 0 {
+		shards = runtime.NumCPU()
+	}
+	queueSize := viper.GetInt(DispatchQueueSizeFlag)
+	if queueSize <= 0 {
+		queueSize = DefaultDispatchQueueSize
+	}
+	maxQueueSize := viper.GetInt(DispatchMaxQueueSizeFlag)
+	if maxQueueSize <= 0 {
+		maxQueueSize = DefaultDispatchMaxQueueSize
+	}
+	policy := PartialPolicy(viper.GetString(DispatchPartialPolicyFlag))
+	switch policy {
+	case PartialPolicyLatest, PartialPolicyAll:
+	case "":
+		policy = PartialPolicyLatest
+	default:
+		l.Warnw("unknown partial candles policy, the latest updates are kept", "policy", policy)
+		policy = PartialPolicyLatest
+	}
+	return newDispatcher(shards, queueSize, maxQueueSize, policy, dispatch, dispatchTrade)
+}
+
+func (d *dispatcher) shard(feed string) *dispatchShard {
+	h := fnv.New32a()
+	h.Write([]byte(feed))
+	return d.shards[h.Sum32()%uint32(len(d.shards))]
+}
+
+// enqueue queues the candle or trade of feed without blocking. A full queue first drops its oldest partial update,
+// complete candles are queued above the queue size. A queue at its hard limit drops its oldest complete candle or
+// trade.
+func (d *dispatcher) enqueue(feed string, item dispatchItem) {
+	shard := d.shard(feed)
+	shard.Lock()
+	defer shard.Unlock()
+
+	queue, ok := shard.queues[feed]
+	if !ok {
+		queue = &feedQueue{feed: feed}
+		shard.queues[feed] = queue
+		shard.stats.Feeds++
+	}
+
+	if d.policy == PartialPolicyLatest && item.trade == nil && len(queue.items) > 0 {
+		last := &queue.items[len(queue.items)-1]
+		if last.droppable() && last.candle.Time.Equal(item.candle.Time) {
+			last.candle = item.candle
+			last.consumed = item.consumed
+			shard.stats.Replaced++
+			return
+		}
+	}
+
+	if item.bounded() && len(queue.items) >= d.queueSize {
+		if i := oldestDroppable(queue.items); i >= 0 {
+			queue.items = append(queue.items[:i], queue.items[i+1:]...)
+			shard.stats.Queued--
+			shard.stats.Dropped++
+			metrics.DispatchDropped.WithLabelValues(metrics.DropPartial).Inc()
+		} else if item.droppable() {
+			shard.stats.Dropped++
+			metrics.DispatchDropped.WithLabelValues(metrics.DropPartial).Inc()
+			return
+		} else {
+			shard.stats.Overflows++
+		}
+	}
+	if item.evictable() && len(queue.items) >= d.maxQueueSize {
+		if i := oldestEvictable(queue.items); i >= 0 {
+			reason := metrics.DropCandle
+			if evicted := queue.items[i]; evicted.trade != nil {
+				reason = metrics.DropTrade
+			} else if !evicted.candle.Complete {
+				reason = metrics.DropPartial
+			}
+			queue.items = append(queue.items[:i], queue.items[i+1:]...)
+			shard.stats.Queued--
+			shard.stats.Evicted++
+			metrics.DispatchDropped.WithLabelValues(reason).Inc()
+		}
+	}
+
+	queue.items = append(queue.items, item)
+	shard.stats.Queued++
+	if len(queue.items) > shard.stats.MaxQueued {
+		shard.stats.MaxQueued = len(queue.items)
+	}
+	if !queue.ready {
+		queue.ready = true
+		shard.ready = append(shard.ready, queue)
+		shard.cond.Signal()
+	}
+}
+
+func oldestDroppable(items []dispatchItem) int {
+	for i, item := range items {
+		if item.droppable() && item.bounded() {
+			return i
+		}
+	}
+	return -1
+}
+
+func oldestEvictable(items []dispatchItem) int {
+	for i, item := range items {
+		if item.evictable() {
+			return i
+		}
+	}
+	return -1
+}
+
+// remove forgets the queue of feed, its pending updates are still dispatched
+func (d *dispatcher) remove(feed string) {
+	shard := d.shard(feed)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, ok := shard.queues[feed]; ok {
+		delete(shard.queues, feed)
+		shard.stats.Feeds--
+	}
+}
+
+func (d *dispatcher) work(shard *dispatchShard) {
+	defer d.workers.Done()
+	for {
+		shard.Lock()
+		for len(shard.ready) == 0 && !shard.closed {
+			shard.cond.Wait()
+		}
+		if len(shard.ready) == 0 {
+			shard.idle.Broadcast()
+			shard.Unlock()
+			return
+		}
+		queue := shard.ready[0]
+		shard.ready = shard.ready[1:]
+		item := queue.items[0]
+		queue.items = queue.items[1:]
+		if len(queue.items) > 0 {
+			shard.ready = append(shard.ready, queue)
+		} else {
+			queue.ready = false
+		}
+		shard.stats.Queued--
+		shard.stats.Dispatched++
+		shard.busy = true
+		shard.Unlock()
+
+		if item.trade != nil {
+			d.dispatchTrade(*item.trade)
+		} else {
+			d.dispatch(queue.feed, item.candle)
+		}
+		if item.consumed != nil {
+			close(item.consumed)
+		}
+
+		shard.Lock()
+		shard.busy = false
+		if len(shard.ready) == 0 {
+			shard.idle.Broadcast()
+		}
+		shard.Unlock()
+	}
+}
+
+// drain waits until every queued update is dispatched
+func (d *dispatcher) drain() {
+	for _, shard := range d.shards {
+		shard.Lock()
+		for shard.busy || len(shard.ready) > 0 {
+			shard.idle.Wait()
+		}
+		shard.Unlock()
+	}
+}
+
+// close stops the workers once every queued update is dispatched
+func (d *dispatcher) close() {
+	for _, shard := range d.shards {
+		shard.Lock()
+		shard.closed = true
+		shard.cond.Signal()
+		shard.Unlock()
+	}
+	d.workers.Wait()
+}
+
+// stats returns the metrics of every shard
+func (d *dispatcher) stats() []ShardStats {
+	var stats = make([]ShardStats, 0, len(d.shards))
+	for _, shard := range d.shards {
+		shard.Lock()
+		stats = append(stats, shard.stats)
+		shard.Unlock()
+	}
+	return stats
+}
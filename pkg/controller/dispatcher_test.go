@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockedDispatch records the dispatched candles, the feeds of blocked wait until it is closed
+type blockedDispatch struct {
+	sync.Mutex
+	blocked    map[string]chan struct{}
+	dispatched map[string][]model.Candle
+}
+
+func newBlockedDispatch(feeds ...string) *blockedDispatch {
+	d := &blockedDispatch{
+		blocked:    make(map[string]chan struct{}),
+		dispatched: make(map[string][]model.Candle),
+	}
+	for _, feed := range feeds {
+		d.blocked[feed] = make(chan struct{})
+	}
+	return d
+}
+
+func (d *blockedDispatch) dispatch(feed string, candle model.Candle) {
+	if blocked, ok := d.blocked[feed]; ok {
+		<-blocked
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.dispatched[feed] = append(d.dispatched[feed], candle)
+}
+
+func (d *blockedDispatch) candles(feed string) []model.Candle {
+	d.Lock()
+	defer d.Unlock()
+	return d.dispatched[feed]
+}
+
+func TestDispatcherPartialPolicy(t *testing.T) {
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	candle := func(i int, close float64, complete bool) dispatchItem {
+		return dispatchItem{candle: model.Candle{
+			Symbol:    "BTCUSDT",
+			Timeframe: model.Timeframe1m,
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Close:     close,
+			Complete:  complete,
+		}}
+	}
+
+	t.Run("latest", func(t *testing.T) {
+		consumer := newBlockedDispatch("blocker")
+		d := newDispatcher(1, 3, DefaultDispatchMaxQueueSize, PartialPolicyLatest, consumer.dispatch, nil)
+		defer d.close()
+		// the worker waits on the blocker while the feed is queued
+		d.enqueue("blocker", dispatchItem{})
+		d.enqueue("feed", candle(0, 1, false))
+		d.enqueue("feed", candle(0, 2, false))
+		d.enqueue("feed", candle(0, 3, true))
+		d.enqueue("feed", candle(1, 4, false))
+		d.enqueue("feed", candle(1, 5, false))
+		close(consumer.blocked["blocker"])
+		d.drain()
+
+		// the complete candle replaces its partial updates too
+		candles := consumer.candles("feed")
+		require.Len(t, candles, 2)
+		assert.Equal(t, []float64{3, 5}, []float64{candles[0].Close, candles[1].Close})
+		stats := d.stats()[0]
+		assert.Equal(t, uint64(3), stats.Replaced)
+		assert.Equal(t, uint64(3), stats.Dispatched)
+		assert.Zero(t, stats.Queued)
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		consumer := newBlockedDispatch("blocker")
+		d := newDispatcher(1, 2, DefaultDispatchMaxQueueSize, PartialPolicyAll, consumer.dispatch, nil)
+		defer d.close()
+		d.enqueue("blocker", dispatchItem{})
+		d.enqueue("feed", candle(0, 1, false))
+		d.enqueue("feed", candle(0, 2, true))
+		// the oldest partial update leaves room to the new updates
+		d.enqueue("feed", candle(1, 3, false))
+		d.enqueue("feed", candle(1, 4, true))
+		// complete candles overflow the queue
+		d.enqueue("feed", candle(2, 5, true))
+		// no partial update left to drop, the new one is dropped
+		d.enqueue("feed", candle(3, 6, false))
+		close(consumer.blocked["blocker"])
+		d.drain()
+
+		candles := consumer.candles("feed")
+		require.Len(t, candles, 3)
+		assert.Equal(t, []float64{2, 4, 5}, []float64{candles[0].Close, candles[1].Close, candles[2].Close})
+		stats := d.stats()[0]
+		assert.Equal(t, uint64(3), stats.Dropped)
+		assert.Equal(t, uint64(1), stats.Overflows)
+		assert.Equal(t, 3, stats.MaxQueued)
+		assert.Equal(t, 2, stats.Feeds)
+	})
+
+	t.Run("hard limit", func(t *testing.T) {
+		consumer := newBlockedDispatch("blocker")
+		var (
+			mu     sync.Mutex
+			trades []float64
+		)
+		d := newDispatcher(1, 1, 2, PartialPolicyAll, consumer.dispatch, func(trade model.Trade) {
+			mu.Lock()
+			defer mu.Unlock()
+			trades = append(trades, trade.Price)
+		})
+		defer d.close()
+		d.enqueue("blocker", dispatchItem{})
+		// complete candles overflow the queue size up to the hard limit, then the oldest one is dropped
+		for i := 0; i < 3; i++ {
+			d.enqueue("feed", candle(i, float64(i), true))
+		}
+		// the trades are only bounded by the hard limit
+		for i := 0; i < 3; i++ {
+			d.enqueue("BTCUSDT--trades", dispatchItem{trade: &model.Trade{Symbol: "BTCUSDT", Price: float64(i)}})
+		}
+		close(consumer.blocked["blocker"])
+		d.drain()
+
+		candles := consumer.candles("feed")
+		require.Len(t, candles, 2)
+		assert.Equal(t, []float64{1, 2}, []float64{candles[0].Close, candles[1].Close})
+		mu.Lock()
+		assert.Equal(t, []float64{1, 2}, trades)
+		mu.Unlock()
+		stats := d.stats()[0]
+		assert.Equal(t, uint64(2), stats.Overflows)
+		assert.Equal(t, uint64(2), stats.Evicted)
+		assert.Zero(t, stats.Dropped)
+	})
+}
+
+func TestDispatcherClose(t *testing.T) {
+	consumer := newBlockedDispatch("feed")
+	d := newDispatcher(2, DefaultDispatchQueueSize, DefaultDispatchMaxQueueSize, PartialPolicyLatest,
+		consumer.dispatch, nil)
+	d.enqueue("feed", dispatchItem{candle: model.Candle{Complete: true}})
+
+	// the workers stop once the queued candles are dispatched
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		d.close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("dispatcher closed before dispatching its queued candles")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(consumer.blocked["feed"])
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher workers not stopped")
+	}
+	assert.Len(t, consumer.candles("feed"), 1)
+}
+
+func TestDispatcherSlowConsumer(t *testing.T) {
+	// find two feeds handled by different shards
+	consumer := newBlockedDispatch("slow")
+	d := newDispatcher(4, DefaultDispatchQueueSize, DefaultDispatchMaxQueueSize, PartialPolicyLatest,
+		consumer.dispatch, nil)
+	defer d.close()
+	var fast string
+	for i := 0; fast == ""; i++ {
+		if feed := fmt.Sprintf("feed%d", i); d.shard(feed) != d.shard("slow") {
+			fast = feed
+		}
+	}
+
+	d.enqueue("slow", dispatchItem{candle: model.Candle{Complete: true}})
+	for i := 0; i < 100; i++ {
+		d.enqueue(fast, dispatchItem{candle: model.Candle{Time: time.Unix(int64(i), 0), Complete: true}})
+	}
+	assert.Eventually(t, func() bool {
+		return len(consumer.candles(fast)) == 100
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, consumer.candles("slow"))
+
+	close(consumer.blocked["slow"])
+	d.drain()
+	assert.Len(t, consumer.candles("slow"), 1)
+}
+
+func benchmarkDispatcher(b *testing.B, feeds int) {
+	var names = make([]string, feeds)
+	for i := range names {
+		names[i] = fmt.Sprintf("SYM%dUSDT--1m", i)
+	}
+	d := newDispatcher(runtime.NumCPU(), DefaultDispatchQueueSize, DefaultDispatchMaxQueueSize, PartialPolicyLatest,
+		func(feed string, candle model.Candle) {}, nil)
+	defer d.close()
+	start := time.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// every feed gets a partial update, each candle completes after 10 updates
+		candle := model.Candle{Time: start.Add(time.Duration(i/10) * time.Minute), Complete: i%10 == 9}
+		for _, feed := range names {
+			d.enqueue(feed, dispatchItem{candle: candle})
+		}
+	}
+	d.drain()
+}
+
+func BenchmarkDispatcher1000Feeds(b *testing.B) {
+	benchmarkDispatcher(b, 1000)
+}
+
+func BenchmarkDispatcher5000Feeds(b *testing.B) {
+	benchmarkDispatcher(b, 5000)
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health checks that the bot follows its symbols, for the probes of a supervisor
+type Health struct {
+	symbols  *SymbolsController
+	candles  *CandleController
+	watchdog *Watchdog
+}
+
+// Readiness is the result of a readiness check, Failing explains why the bot is not ready
+type Readiness struct {
+	Ready   bool
+	Failing []string
+}
+
+func NewHealth(symbols *SymbolsController, candles *CandleController, watchdog *Watchdog) *Health {
+	return &Health{
+		symbols:  symbols,
+		candles:  candles,
+		watchdog: watchdog,
+	}
+}
+
+// Readiness tells whether the trading symbols are fetched and every candle feed is preloaded, streamed and updated,
+// without being stale at the last check of the watchdog
+func (h *Health) Readiness() Readiness {
+	var failing = make([]string, 0)
+	if !h.symbols.Fetched() {
+		failing = append(failing, "symbols: not fetched")
+	}
+	running := h.candles.Running()
+	if !running {
+		failing = append(failing, "candles: not streaming")
+	}
+
+	var stale = make(map[string]time.Time)
+	for _, status := range h.watchdog.StaleFeeds() {
+		stale[h.candles.generateKey(status.Symbol, status.Timeframe)] = status.LastUpdate
+	}
+	for _, status := range h.candles.ActiveFeeds() {
+		name := fmt.Sprintf("%s %s", status.Symbol, status.Timeframe)
+		// the trades have no history nor cadence
+		if status.Timeframe == "" {
+			if running && !status.Streaming {
+				failing = append(failing, fmt.Sprintf("%s trades: not streaming", status.Symbol))
+			}
+			continue
+		}
+		lastUpdate, isStale := stale[h.candles.generateKey(status.Symbol, status.Timeframe)]
+		switch {
+		case !status.Preloaded:
+			failing = append(failing, fmt.Sprintf("%s: not preloaded", name))
+		case !running:
+		case !status.Streaming:
+			failing = append(failing, fmt.Sprintf("%s: not streaming", name))
+		case status.LastUpdate.IsZero():
+			failing = append(failing, fmt.Sprintf("%s: no live update", name))
+		case isStale:
+			failing = append(failing, fmt.Sprintf("%s: stale, last update at %s", name,
+				lastUpdate.UTC().Format(time.RFC3339)))
+		}
+	}
+	return Readiness{Ready: len(failing) == 0, Failing: failing}
+}
+
+// LiveHandler answers the liveness probe, the bot is alive while it serves HTTP
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadyHandler answers the readiness probe, with the status 503 and the failing checks when the bot is not ready
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readiness := h.Readiness()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if readiness.Ready {
+			fmt.Fprintln(w, "ready")
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready\n%s\n", strings.Join(readiness.Failing, "\n"))
+	})
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthReadiness(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	infos := new(infoFeeder)
+	infos.setSymbols(model.SymbolInfo{Symbol: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+		Status: model.SymbolStatusTrading.String()})
+	symbols, err := NewSymbolsController(infos, nil)
+	require.NoError(t, err)
+
+	feeder := new(quietFeeder)
+	candles := NewCandleController(feeder)
+	defer candles.Close()
+	watchdog := NewWatchdog(candles)
+	h := NewHealth(symbols, candles, watchdog)
+
+	candles.Subscribe("BTCUSDT", model.Timeframe1m, func(candle model.Candle) {}, false)
+	assert.Equal(t, []string{"candles: not streaming", "BTCUSDT 1m: not preloaded"}, h.Readiness().Failing)
+
+	go candles.Start(ctx)
+	require.Eventually(t, func() bool { return feeder.count() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"BTCUSDT 1m: not preloaded"}, h.Readiness().Failing)
+
+	candles.Preload("BTCUSDT", model.Timeframe1m, nil)
+	assert.Equal(t, []string{"BTCUSDT 1m: no live update"}, h.Readiness().Failing)
+
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	candles.Lock()
+	candles.lastUpdate[candles.generateKey("BTCUSDT", model.Timeframe1m)] = now
+	candles.Unlock()
+	readiness := h.Readiness()
+	assert.True(t, readiness.Ready)
+	assert.Empty(t, readiness.Failing)
+
+	recorder := httptest.NewRecorder()
+	h.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "ready\n", recorder.Body.String())
+
+	// the feed is stale once the watchdog checks it
+	watchdog.check(now)
+	watchdog.check(now.Add(3 * time.Minute))
+	recorder = httptest.NewRecorder()
+	h.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "not ready\nBTCUSDT 1m: stale, last update at 2021-10-01T00:00:00Z\n", recorder.Body.String())
+}
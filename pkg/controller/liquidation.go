@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/metrics"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"go.uber.org/zap"
+)
+
+// LiquidationController dispatches the liquidations of the subscribed futures symbols to their handlers
+type LiquidationController struct {
+	sync.RWMutex
+	l                    *zap.SugaredLogger
+	exchange             exchange.Feeder
+	streamsPerConnection int
+	reconnectMinDelay    time.Duration
+	reconnectMaxDelay    time.Duration
+	symbols              []string
+	handlers             map[string][]func(liquidation model.Liquidation)
+	streams              *symbolStreams
+}
+
+func NewLiquidationController(ex exchange.Feeder) *LiquidationController {
+	c := &LiquidationController{
+		l:                    zap.S(),
+		exchange:             ex,
+		streamsPerConnection: StreamsPerConnection,
+		reconnectMinDelay:    ReconnectMinDelay,
+		reconnectMaxDelay:    ReconnectMaxDelay,
+		symbols:              make([]string, 0),
+		handlers:             make(map[string][]func(liquidation model.Liquidation)),
+	}
+	c.streams = newSymbolStreams(c.streamsPerConnection, c.CombinedLiquidationsSubscription)
+	return c
+}
+
+// Subscribe registers a handler of the liquidations of the symbol, it is called once the controller is started. A
+// symbol subscribed while running is streamed at once.
+func (c *LiquidationController) Subscribe(symbol string, handler func(liquidation model.Liquidation)) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.handlers[symbol]; !ok {
+		c.symbols = append(c.symbols, symbol)
+		c.streams.add(symbol)
+	}
+	c.handlers[symbol] = append(c.handlers[symbol], handler)
+}
+
+// Unsubscribe removes the handlers of the symbol and stops streaming it
+func (c *LiquidationController) Unsubscribe(symbol string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.handlers[symbol]; !ok {
+		return
+	}
+	delete(c.handlers, symbol)
+	c.symbols = removeFromList(c.symbols, symbol)
+	c.streams.remove(symbol)
+}
+
+// Start consumes the liquidations of every symbol until ctx is done, through one connection per chunk of
+// streamsPerConnection symbols
+func (c *LiquidationController) Start(ctx context.Context) {
+	c.Lock()
+	wg, connections := c.streams.start(ctx, c.symbols)
+	totalSymbols := len(c.symbols)
+	c.Unlock()
+	c.l.Infow("start liquidation controller", "symbols", totalSymbols, "connections", connections)
+
+	<-ctx.Done()
+	c.Lock()
+	c.streams.stop()
+	c.Unlock()
+	wg.Wait()
+	c.l.Infow("liquidation controller finishes")
+}
+
+// CombinedLiquidationsSubscription consumes the liquidations of a chunk of symbols sharing a single connection. When
+// the connection breaks, the chunk is subscribed again after a backoff delay, the liquidations of the outage are
+// lost. Markets without liquidations stop the subscription.
+func (c *LiquidationController) CombinedLiquidationsSubscription(ctx context.Context, symbols []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var (
+		liquidationCh = make(chan model.Liquidation)
+		errCh         = make(chan error)
+		backoff       = app.NewBackoff(c.reconnectMinDelay, c.reconnectMaxDelay)
+	)
+
+	go c.exchange.CombinedLiquidationsSubscription(ctx, symbols, liquidationCh, errCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errCh:
+			if errors.Is(err, exchange.ErrNotSupported) {
+				c.l.Warnw("liquidations not supported by the exchange", "error", err)
+				return
+			}
+			delay := backoff.Next()
+			c.l.Warnw("combined liquidations subscription error, reconnecting", "error", err, "symbols", len(symbols),
+				"attempt", backoff.Attempt(), "delay", delay)
+			metrics.Reconnects.WithLabelValues(StreamLiquidations, metrics.ReconnectError).Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			go c.exchange.CombinedLiquidationsSubscription(ctx, symbols, liquidationCh, errCh)
+		case liquidation, ok := <-liquidationCh:
+			if !ok {
+				c.l.Debugw("no more liquidations", "symbols", len(symbols))
+				return
+			}
+			backoff.Reset()
+			c.RLock()
+			handlers := c.handlers[liquidation.Symbol]
+			c.RUnlock()
+			for _, handler := range handlers {
+				handler(liquidation)
+			}
+		}
+	}
+}
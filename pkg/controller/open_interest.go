@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"go.uber.org/zap"
+)
+
+// OpenInterestController dispatches the open interest of the subscribed symbols to their handlers
+type OpenInterestController struct {
+	sync.RWMutex
+	l                    *zap.SugaredLogger
+	exchange             exchange.Feeder
+	streamsPerConnection int
+	reconnectMinDelay    time.Duration
+	reconnectMaxDelay    time.Duration
+	symbols              []string
+	handlers             map[string][]func(openInterest model.OpenInterest)
+	streams              *symbolStreams
+}
+
+func NewOpenInterestController(ex exchange.Feeder) *OpenInterestController {
+	c := &OpenInterestController{
+		l:                    zap.S(),
+		exchange:             ex,
+		streamsPerConnection: StreamsPerConnection,
+		reconnectMinDelay:    ReconnectMinDelay,
+		reconnectMaxDelay:    ReconnectMaxDelay,
+		symbols:              make([]string, 0),
+		handlers:             make(map[string][]func(openInterest model.OpenInterest)),
+	}
+	c.streams = newSymbolStreams(c.streamsPerConnection, c.OpenInterestSubscription)
+	return c
+}
+
+// Subscribe registers a handler of the open interest of the symbol, it is called once the controller is started. A
+// symbol subscribed while running is polled at once.
+func (c *OpenInterestController) Subscribe(symbol string, handler func(openInterest model.OpenInterest)) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.handlers[symbol]; !ok {
+		c.symbols = append(c.symbols, symbol)
+		c.streams.add(symbol)
+	}
+	c.handlers[symbol] = append(c.handlers[symbol], handler)
+}
+
+// Unsubscribe removes the handlers of the symbol and stops polling it
+func (c *OpenInterestController) Unsubscribe(symbol string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.handlers[symbol]; !ok {
+		return
+	}
+	delete(c.handlers, symbol)
+	c.symbols = removeFromList(c.symbols, symbol)
+	c.streams.remove(symbol)
+}
+
+// Start polls the open interest of every symbol until ctx is done, through one subscription per chunk of
+// streamsPerConnection symbols
+func (c *OpenInterestController) Start(ctx context.Context) {
+	c.Lock()
+	wg, subscriptions := c.streams.start(ctx, c.symbols)
+	totalSymbols := len(c.symbols)
+	c.Unlock()
+	c.l.Infow("start open interest controller", "symbols", totalSymbols, "subscriptions", subscriptions)
+
+	<-ctx.Done()
+	c.Lock()
+	c.streams.stop()
+	c.Unlock()
+	wg.Wait()
+	c.l.Infow("open interest controller finishes")
+}
+
+// OpenInterestSubscription consumes the open interest of a chunk of symbols, the subscription is started again after
+// a backoff delay when it fails. Markets without open interest stop the subscription.
+func (c *OpenInterestController) OpenInterestSubscription(ctx context.Context, symbols []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var (
+		openInterestCh = make(chan model.OpenInterest)
+		errCh          = make(chan error)
+		backoff        = app.NewBackoff(c.reconnectMinDelay, c.reconnectMaxDelay)
+	)
+	go c.exchange.OpenInterestSubscription(ctx, symbols, openInterestCh, errCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errCh:
+			if errors.Is(err, exchange.ErrNotSupported) {
+				c.l.Warnw("open interest not supported by the exchange", "error", err)
+				return
+			}
+			delay := backoff.Next()
+			c.l.Warnw("open interest subscription error, reconnecting", "error", err, "symbols", len(symbols),
+				"attempt", backoff.Attempt(), "delay", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			go c.exchange.OpenInterestSubscription(ctx, symbols, openInterestCh, errCh)
+		case openInterest, ok := <-openInterestCh:
+			if !ok {
+				c.l.Debugw("no more open interest", "symbols", len(symbols))
+				return
+			}
+			backoff.Reset()
+			c.RLock()
+			handlers := c.handlers[openInterest.Symbol]
+			c.RUnlock()
+			for _, handler := range handlers {
+				handler(openInterest)
+			}
+		}
+	}
+}
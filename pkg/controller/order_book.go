@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/metrics"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"go.uber.org/zap"
+)
+
+// OrderBookController keeps a local order book of each subscribed symbol
+type OrderBookController struct {
+	sync.RWMutex
+	l                    *zap.SugaredLogger
+	exchange             exchange.Feeder
+	streamsPerConnection int
+	reconnectMinDelay    time.Duration
+	reconnectMaxDelay    time.Duration
+	symbols              []string
+	books                map[string]*model.OrderBook
+	streams              *symbolStreams
+}
+
+func NewOrderBookController(ex exchange.Feeder) *OrderBookController {
+	c := &OrderBookController{
+		l:                    zap.S(),
+		exchange:             ex,
+		streamsPerConnection: StreamsPerConnection,
+		reconnectMinDelay:    ReconnectMinDelay,
+		reconnectMaxDelay:    ReconnectMaxDelay,
+		symbols:              make([]string, 0),
+		books:                make(map[string]*model.OrderBook),
+	}
+	c.streams = newSymbolStreams(c.streamsPerConnection, c.symbolsSubscription)
+	return c
+}
+
+// Subscribe returns the order book of the symbol, it is synced once the controller is started. A symbol subscribed
+// while running is synced at once.
+func (c *OrderBookController) Subscribe(symbol string) *model.OrderBook {
+	c.Lock()
+	defer c.Unlock()
+	if book, ok := c.books[symbol]; ok {
+		return book
+	}
+	book := model.NewOrderBook(symbol)
+	c.books[symbol] = book
+	c.symbols = append(c.symbols, symbol)
+	c.streams.add(symbol)
+	return book
+}
+
+// Unsubscribe forgets the order book of the symbol and stops streaming it
+func (c *OrderBookController) Unsubscribe(symbol string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.books[symbol]; !ok {
+		return
+	}
+	delete(c.books, symbol)
+	c.symbols = removeFromList(c.symbols, symbol)
+	c.streams.remove(symbol)
+}
+
+// OrderBook returns the order book of a subscribed symbol
+func (c *OrderBookController) OrderBook(symbol string) (*model.OrderBook, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	book, ok := c.books[symbol]
+	return book, ok
+}
+
+// OrderBookSubscription keeps the books of a chunk of symbols sharing a single connection in sync, the chunk is
+// subscribed again after a backoff delay when the connection breaks
+func (c *OrderBookController) OrderBookSubscription(ctx context.Context, books []*model.OrderBook, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var (
+		errCh   = make(chan error)
+		backoff = app.NewBackoff(c.reconnectMinDelay, c.reconnectMaxDelay)
+	)
+
+	for {
+		started := time.Now()
+		go c.exchange.OrderBookSubscription(ctx, books, errCh)
+
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errCh:
+			// a connection that lasted longer than the backoff limit is considered healthy
+			if time.Since(started) > c.reconnectMaxDelay {
+				backoff.Reset()
+			}
+			delay := backoff.Next()
+			c.l.Warnw("order book subscription error, reconnecting", "error", err, "books", len(books),
+				"attempt", backoff.Attempt(), "delay", delay)
+			metrics.Reconnects.WithLabelValues(StreamOrderBooks, metrics.ReconnectError).Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// symbolsSubscription keeps the books of a chunk of symbols in sync, see OrderBookSubscription
+func (c *OrderBookController) symbolsSubscription(ctx context.Context, symbols []string, wg *sync.WaitGroup) {
+	c.RLock()
+	var books = make([]*model.OrderBook, 0, len(symbols))
+	for _, symbol := range symbols {
+		if book, ok := c.books[symbol]; ok {
+			books = append(books, book)
+		}
+	}
+	c.RUnlock()
+	c.OrderBookSubscription(ctx, books, wg)
+}
+
+// Start keeps the order books in sync until ctx is done, through one connection per chunk of streamsPerConnection
+// symbols
+func (c *OrderBookController) Start(ctx context.Context) {
+	c.Lock()
+	wg, connections := c.streams.start(ctx, c.symbols)
+	totalBooks := len(c.symbols)
+	c.Unlock()
+	c.l.Infow("start order book controller", "books", totalBooks, "connections", connections)
+
+	<-ctx.Done()
+	c.Lock()
+	c.streams.stop()
+	c.Unlock()
+	wg.Wait()
+	c.l.Infow("order book controller finishes")
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+var ErrInvalidResample = errors.New("invalid resample")
+
+// Resampler aggregates the candles of a source timeframe streamed by the exchange into candles of a larger target
+// timeframe. Every source update emits the target candle built so far, which is complete once the last source
+// candle of the period is closed.
+type Resampler struct {
+	sync.Mutex
+	source   model.Timeframe
+	target   model.Timeframe
+	consumer CandleConsumer
+	buckets  map[string]*resampleBucket // current target candle of each symbol
+}
+
+type resampleBucket struct {
+	openTime   time.Time
+	closed     model.Candle // aggregate of the complete source candles
+	count      int          // number of complete source candles
+	lastSource time.Time    // open time of the last complete source candle
+	complete   bool
+	skip       bool // the first source candles received do not cover the period from its start
+}
+
+func NewResampler(source, target model.Timeframe, consumer CandleConsumer) (*Resampler, error) {
+	if err := CanResample(source, target); err != nil {
+		return nil, err
+	}
+	return &Resampler{
+		source:   source,
+		target:   target,
+		consumer: consumer,
+		buckets:  make(map[string]*resampleBucket),
+	}, nil
+}
+
+// CanResample checks that every target candle is made of whole source candles
+func CanResample(source, target model.Timeframe) error {
+	if !source.IsValid() {
+		return fmt.Errorf("%w: source %v is not streamed by the exchange", ErrInvalidResample, source)
+	}
+	if _, err := model.ParseTimeframe(target.String()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResample, err)
+	}
+	if source == target {
+		return fmt.Errorf("%w: same source and target %v", ErrInvalidResample, source)
+	}
+
+	period := target.Duration()
+	if target.IsMonthly() {
+		// months have different lengths, so the source has to fit in a day
+		period = 24 * time.Hour
+	}
+	if source.IsMonthly() || period%source.Duration() != 0 {
+		return fmt.Errorf("%w: %v is not a multiple of %v", ErrInvalidResample, target, source)
+	}
+	openTime := target.OpenTime(time.Unix(0, 0))
+	if !source.OpenTime(openTime).Equal(openTime) {
+		return fmt.Errorf("%w: %v candles do not open with %v candles", ErrInvalidResample, target, source)
+	}
+	return nil
+}
+
+// ResampleSource returns the largest timeframe streamed by the exchange the target can be built from
+func ResampleSource(target model.Timeframe) (model.Timeframe, error) {
+	if target.IsValid() {
+		return target, nil
+	}
+	for i := len(model.Timeframes) - 1; i >= 0; i-- {
+		if CanResample(model.Timeframes[i], target) == nil {
+			return model.Timeframes[i], nil
+		}
+	}
+	return "", fmt.Errorf("%w: no source timeframe for %v", ErrInvalidResample, target)
+}
+
+func (r *Resampler) Source() model.Timeframe {
+	return r.source
+}
+
+func (r *Resampler) Target() model.Timeframe {
+	return r.target
+}
+
+// OnCandle consumes a source candle, either a partial update or a complete one
+func (r *Resampler) OnCandle(candle model.Candle) {
+	if candle.Timeframe != r.source {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	openTime := r.target.OpenTime(candle.Time)
+	bucket, ok := r.buckets[candle.Symbol]
+	switch {
+	case !ok:
+		// a period is emitted only when all of its source candles are known
+		bucket = &resampleBucket{openTime: openTime, skip: !candle.Time.Equal(openTime)}
+		r.buckets[candle.Symbol] = bucket
+	case openTime.Before(bucket.openTime):
+		return
+	case openTime.After(bucket.openTime):
+		// the last source candle of the previous period was missed, it is closed by the next period
+		if !bucket.complete && !bucket.skip && bucket.count > 0 {
+			closed := bucket.closed
+			closed.Complete = true
+			closed.EventTime = candle.EventTime
+			r.consumer(closed)
+		}
+		bucket = &resampleBucket{openTime: openTime}
+		r.buckets[candle.Symbol] = bucket
+	}
+	if bucket.skip || bucket.complete {
+		return
+	}
+	if bucket.count > 0 && !candle.Time.After(bucket.lastSource) {
+		return
+	}
+
+	aggregated := r.merge(bucket, candle)
+	if candle.Complete {
+		bucket.closed = aggregated
+		bucket.count++
+		bucket.lastSource = candle.Time
+	}
+	aggregated.Complete = candle.Complete && !r.source.CloseTime(candle.Time).Before(r.target.CloseTime(candle.Time))
+	aggregated.EventTime = candle.EventTime
+	bucket.complete = aggregated.Complete
+	r.consumer(aggregated)
+}
+
+func (r *Resampler) merge(bucket *resampleBucket, candle model.Candle) model.Candle {
+	if bucket.count == 0 {
+		aggregated := candle
+		aggregated.Timeframe = r.target
+		aggregated.Time = bucket.openTime
+		return aggregated
+	}
+
+	aggregated := bucket.closed
+	if candle.High > aggregated.High {
+		aggregated.High = candle.High
+	}
+	if candle.Low < aggregated.Low {
+		aggregated.Low = candle.Low
+	}
+	aggregated.Close = candle.Close
+	aggregated.Volume += candle.Volume
+	aggregated.Trades += candle.Trades
+	return aggregated
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/exchange/binancetest"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResampler(t *testing.T) {
+	candles, err := binancetest.ReadCandlesCSV("../../testdata/kncusdt-4h-test1.csv")
+	require.NoError(t, err)
+
+	var emitted []model.Candle
+	resampler, err := NewResampler(model.Timeframe4h, model.Timeframe1d, func(candle model.Candle) {
+		emitted = append(emitted, candle)
+	})
+	require.NoError(t, err)
+
+	// the first day is not emitted as it starts after midnight
+	for _, candle := range candles[1:12] {
+		resampler.OnCandle(candle)
+	}
+	require.Len(t, emitted, 6)
+	for i, candle := range emitted[:5] {
+		assert.False(t, candle.Complete, i)
+	}
+
+	day := emitted[5]
+	assert.True(t, day.Complete)
+	assert.Equal(t, model.Timeframe1d, day.Timeframe)
+	assert.True(t, candles[6].Time.Equal(day.Time))
+	assert.Equal(t, candles[6].Open, day.Open)
+	assert.Equal(t, candles[11].Close, day.Close)
+
+	var volume, high, low = 0.0, 0.0, candles[6].Low
+	for _, candle := range candles[6:12] {
+		volume += candle.Volume
+		if candle.High > high {
+			high = candle.High
+		}
+		if candle.Low < low {
+			low = candle.Low
+		}
+	}
+	assert.InDelta(t, volume, day.Volume, 1e-6)
+	assert.Equal(t, high, day.High)
+	assert.Equal(t, low, day.Low)
+
+	// a partial update opens the next day, duplicates are ignored
+	partial := candles[12]
+	partial.Complete = false
+	resampler.OnCandle(candles[11])
+	resampler.OnCandle(partial)
+	require.Len(t, emitted, 7)
+	assert.False(t, emitted[6].Complete)
+	assert.True(t, candles[12].Time.Equal(emitted[6].Time))
+	assert.Equal(t, candles[12].Open, emitted[6].Open)
+}
+
+func TestResamplerOffset(t *testing.T) {
+	var (
+		// 6h sessions of UTC+7 open at 17:00 UTC
+		timeframe = model.Timeframe("6h@17h")
+		start     = time.Date(2021, 1, 1, 17, 0, 0, 0, time.UTC)
+		emitted   []model.Candle
+	)
+	resampler, err := NewResampler(model.Timeframe1h, timeframe, func(candle model.Candle) {
+		if candle.Complete {
+			emitted = append(emitted, candle)
+		}
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 12; i++ {
+		resampler.OnCandle(model.Candle{
+			Symbol:    "BTCUSDT",
+			Timeframe: model.Timeframe1h,
+			Time:      start.Add(time.Duration(i) * time.Hour),
+			Open:      float64(i),
+			Close:     float64(i + 1),
+			Low:       float64(i),
+			High:      float64(i + 1),
+			Volume:    1,
+			Complete:  true,
+		})
+	}
+
+	require.Len(t, emitted, 2)
+	for i, candle := range emitted {
+		assert.Equal(t, timeframe, candle.Timeframe)
+		assert.True(t, start.Add(time.Duration(i)*6*time.Hour).Equal(candle.Time))
+		assert.Equal(t, float64(i*6), candle.Open)
+		assert.Equal(t, float64(i*6+6), candle.Close)
+		assert.Equal(t, 6.0, candle.Volume)
+	}
+}
+
+func TestCanResample(t *testing.T) {
+	tests := []struct {
+		source model.Timeframe
+		target model.Timeframe
+		valid  bool
+	}{
+		{model.Timeframe1h, model.Timeframe4h, true},
+		{model.Timeframe1d, "2d", true},
+		{model.Timeframe1d, "3d@24h", true},
+		{model.Timeframe1w, "2w", true},
+		{model.Timeframe1h, "6h@17h", true},
+		{model.Timeframe1d, model.Timeframe1M, true},
+		{model.Timeframe4h, "6h@17h", false},
+		{model.Timeframe3d, model.Timeframe1w, false},
+		{model.Timeframe1w, model.Timeframe1M, false},
+		{model.Timeframe4h, model.Timeframe1h, false},
+		{model.Timeframe4h, model.Timeframe4h, false},
+		{"2d", "4d", false},
+	}
+	for _, test := range tests {
+		err := CanResample(test.source, test.target)
+		if test.valid {
+			assert.NoError(t, err, "%v to %v", test.source, test.target)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidResample, "%v to %v", test.source, test.target)
+		}
+	}
+
+	source, err := ResampleSource("6h@17h")
+	require.NoError(t, err)
+	assert.Equal(t, model.Timeframe1h, source)
+}
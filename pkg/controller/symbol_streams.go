@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"sync"
+)
+
+// symbolStreams runs the connections of a controller streaming symbols, one per chunk of symbols. A symbol subscribed
+// while running gets a connection of its own, a connection losing a symbol is started again with the other symbols of
+// its chunk. Its methods are called with the lock of the controller held.
+type symbolStreams struct {
+	size        int
+	serve       func(ctx context.Context, symbols []string, wg *sync.WaitGroup)
+	ctx         context.Context // context of the running controller, nil when not running
+	wg          *sync.WaitGroup // connections of the running controller
+	connections map[string]*symbolConnection
+}
+
+// symbolConnection is a connection started by symbolStreams, canceled to stream its symbols differently
+type symbolConnection struct {
+	symbols []string
+	cancel  context.CancelFunc
+}
+
+func newSymbolStreams(size int, serve func(ctx context.Context, symbols []string, wg *sync.WaitGroup)) *symbolStreams {
+	return &symbolStreams{
+		size:        size,
+		serve:       serve,
+		connections: make(map[string]*symbolConnection),
+	}
+}
+
+// start connects the symbols until ctx is done or stop is called, and returns the wait group of the connections with
+// their number
+func (s *symbolStreams) start(ctx context.Context, symbols []string) (*sync.WaitGroup, int) {
+	s.ctx, s.wg = ctx, new(sync.WaitGroup)
+	chunks := chunkList(symbols, s.size)
+	for _, chunk := range chunks {
+		s.connect(chunk)
+	}
+	return s.wg, len(chunks)
+}
+
+// stop cancels the connections
+func (s *symbolStreams) stop() {
+	for _, connection := range s.connections {
+		connection.cancel()
+	}
+	s.ctx = nil
+	s.connections = make(map[string]*symbolConnection)
+}
+
+// add connects symbol when running
+func (s *symbolStreams) add(symbol string) {
+	if s.ctx == nil {
+		return
+	}
+	if _, ok := s.connections[symbol]; !ok {
+		s.connect([]string{symbol})
+	}
+}
+
+// remove stops the connection of symbol, its other symbols are connected again
+func (s *symbolStreams) remove(symbol string) {
+	connection, ok := s.connections[symbol]
+	if !ok {
+		return
+	}
+	connection.cancel()
+	var remaining = make([]string, 0, len(connection.symbols))
+	for _, other := range connection.symbols {
+		delete(s.connections, other)
+		if other != symbol {
+			remaining = append(remaining, other)
+		}
+	}
+	if len(remaining) > 0 && s.ctx != nil {
+		s.connect(remaining)
+	}
+}
+
+func (s *symbolStreams) connect(symbols []string) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	connection := &symbolConnection{symbols: symbols, cancel: cancel}
+	for _, symbol := range symbols {
+		s.connections[symbol] = connection
+	}
+	s.wg.Add(1)
+	go s.serve(ctx, symbols, s.wg)
+}
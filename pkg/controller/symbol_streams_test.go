@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// activeConnections records the symbols of the connections still running
+type activeConnections struct {
+	sync.Mutex
+	symbols map[string]int
+}
+
+func (a *activeConnections) serve(ctx context.Context, symbols []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	key := strings.Join(symbols, ",")
+	a.Lock()
+	a.symbols[key]++
+	a.Unlock()
+	<-ctx.Done()
+	a.Lock()
+	if a.symbols[key]--; a.symbols[key] == 0 {
+		delete(a.symbols, key)
+	}
+	a.Unlock()
+}
+
+func (a *activeConnections) list() []string {
+	a.Lock()
+	defer a.Unlock()
+	var list = make([]string, 0, len(a.symbols))
+	for key := range a.symbols {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestSymbolStreams(t *testing.T) {
+	active := &activeConnections{symbols: make(map[string]int)}
+	streams := newSymbolStreams(2, active.serve)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// not running, the symbol waits for start
+	streams.add("ETHUSDT")
+	assert.Empty(t, active.list())
+
+	wg, connections := streams.start(ctx, []string{"BTCUSDT", "ETHUSDT", "BNBUSDT"})
+	assert.Equal(t, 2, connections)
+	eventually := func(expected ...string) {
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(expected, active.list())
+		}, time.Second, time.Millisecond, "expected %v, got %v", expected, active.list())
+	}
+	eventually("BNBUSDT", "BTCUSDT,ETHUSDT")
+
+	// a symbol added while running gets its own connection
+	streams.add("XRPUSDT")
+	eventually("BNBUSDT", "BTCUSDT,ETHUSDT", "XRPUSDT")
+
+	// the other symbols of a removed one are connected again
+	streams.remove("BTCUSDT")
+	eventually("BNBUSDT", "ETHUSDT", "XRPUSDT")
+	streams.remove("BNBUSDT")
+	eventually("ETHUSDT", "XRPUSDT")
+
+	streams.stop()
+	wg.Wait()
+	assert.Empty(t, active.list())
+}
@@ -2,33 +2,89 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/quangkeu95/binancebot/pkg/exchange"
 	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
 	"go.uber.org/zap"
 )
 
-const FetchSymbolInterval = 30 * time.Minute
+const (
+	FetchSymbolInterval = 30 * time.Minute
+
+	// ListingDateKeyPrefix prefixes the storage keys of the open time of the first candle of a symbol
+	ListingDateKeyPrefix = "listing_date--"
+)
+
+//go:generate stringer -type=SymbolEventType -linecomment
+
+// SymbolEventType tells why a symbol joins or leaves the trading symbols
+type SymbolEventType int
+
+const (
+	// SymbolListed is a symbol new to the exchange, or trading again after a BREAK
+	SymbolListed SymbolEventType = iota // listed
+	// SymbolDelisted is a symbol removed from the exchange or not trading anymore, e.g. BREAK
+	SymbolDelisted // delisted
+	// SymbolSelected is a trading symbol entering the universe, e.g. its 24h quote volume rose
+	SymbolSelected // selected
+	// SymbolDeselected is a trading symbol leaving the universe
+	SymbolDeselected // deselected
+)
+
+// SymbolEvent is a change of the trading symbols found by a refetch of the exchange info
+type SymbolEvent struct {
+	Type   SymbolEventType
+	Symbol model.SymbolInfo
+}
+
+// Added reports whether the symbol joins the trading symbols
+func (e SymbolEvent) Added() bool {
+	return e.Type == SymbolListed || e.Type == SymbolSelected
+}
+
+type SymbolEventHandler func(event SymbolEvent)
 
 type SymbolsController struct {
 	sync.RWMutex
 	l                 *zap.SugaredLogger
-	exchange          exchange.Exchange
+	exchange          exchange.Feeder
+	universe          Universe
+	fetched           bool
+	exchangeStatus    map[string]string // status of every symbol of the last exchange info
 	tradingSymbols    map[string]model.SymbolInfo
 	deprecatedSymbols map[string]model.SymbolInfo
+	listingDates      map[string]time.Time    // open time of the first candle of the symbols without onboard date
+	store             storage.KeyValueStorage // saves the listing dates, nil keeps them in memory
+	handlers          []SymbolEventHandler
 }
 
-func NewSymbolsController(ex exchange.Exchange) (*SymbolsController, error) {
+// NewSymbolsController fetches the trading symbols of ex. The listing dates are saved to store, so a restart only dates
+// the symbols listed since, a nil store keeps them in memory.
+func NewSymbolsController(ex exchange.Feeder, store storage.KeyValueStorage) (*SymbolsController, error) {
+	l := zap.S()
+	universe, err := UniverseFromConfig()
+	if err != nil {
+		l.Errorw("parse universe configuration error", "error", err)
+		return nil, err
+	}
+
 	c := &SymbolsController{
-		l:                 zap.S(),
+		l:                 l,
 		exchange:          ex,
+		universe:          universe,
+		exchangeStatus:    make(map[string]string),
 		tradingSymbols:    make(map[string]model.SymbolInfo),
 		deprecatedSymbols: make(map[string]model.SymbolInfo),
+		listingDates:      make(map[string]time.Time),
+		store:             store,
 	}
 
-	if err := c.FetchSymbolsPairUSDT(context.Background()); err != nil {
+	if err := c.FetchSymbols(context.Background()); err != nil {
 		c.l.Errorw("error initial fetch symbols", "error", err)
 		return nil, err
 	}
@@ -36,69 +92,242 @@ func NewSymbolsController(ex exchange.Exchange) (*SymbolsController, error) {
 	return c, nil
 }
 
-func (c *SymbolsController) FetchSymbolsPairUSDT(ctx context.Context) error {
+// OnSymbolEvent registers handler to the symbols joining or leaving the trading symbols after the initial fetch
+func (c *SymbolsController) OnSymbolEvent(handler SymbolEventHandler) {
+	c.Lock()
+	defer c.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// FetchSymbols recomputes the trading symbols of the universe from the exchange info and the 24h quote volumes, the
+// changes since the previous fetch are passed to the handlers
+func (c *SymbolsController) FetchSymbols(ctx context.Context) error {
 	exchangeInfo, err := c.exchange.GetExchangeInfo(ctx)
 	if err != nil {
 		c.l.Errorw("error get exchange info", "error", err)
 		return err
 	}
-	var newSymbolInfo = make(map[string]model.SymbolInfo)
-	for _, item := range exchangeInfo.Symbols {
-		if item.QuoteAsset != "USDT" || item.Status != model.SymbolStatusTrading.String() {
-			continue
+
+	var quoteVolumes map[string]float64
+	if c.universe.NeedsVolume() {
+		stats, err := c.exchange.MarketStats(ctx)
+		switch {
+		case errors.Is(err, exchange.ErrNotSupported):
+			c.l.Warnw("volume rules of the universe not supported by the exchange", "error", err)
+		case err != nil:
+			c.l.Errorw("error get market stats", "error", err)
+			return err
+		default:
+			quoteVolumes = make(map[string]float64, len(stats))
+			for _, stat := range stats {
+				quoteVolumes[stat.Symbol] = stat.QuoteVolume
+			}
+		}
+	}
+
+	symbols := exchangeInfo.Symbols
+	if c.universe.MinListingAge > 0 {
+		if symbols, err = c.dateListings(ctx, symbols); err != nil {
+			return err
 		}
-		newSymbolInfo[item.Symbol] = item
 	}
 
+	newSymbolInfo := c.universe.Filter(symbols, quoteVolumes, time.Now())
+	var exchangeStatus = make(map[string]string, len(exchangeInfo.Symbols))
+	for _, item := range exchangeInfo.Symbols {
+		exchangeStatus[item.Symbol] = item.Status
+	}
+
+	events := c.symbolEvents(newSymbolInfo, exchangeStatus)
+
 	c.filterDeprecatedSymbols(newSymbolInfo)
 
-	c.saveTradingSymbols(newSymbolInfo)
+	c.saveTradingSymbols(newSymbolInfo, exchangeStatus)
+
+	c.RLock()
+	handlers := c.handlers
+	c.RUnlock()
+	for _, event := range events {
+		c.l.Infow("trading symbols change", "symbol", event.Symbol.Symbol, "type", event.Type,
+			"status", event.Symbol.Status)
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
 
 	return nil
 }
 
+// dateListings sets the onboard date of the candidate symbols without one, i.e. the spot symbols, to the open time of
+// their first candle. The dates are requested once per symbol, and saved to the store.
+func (c *SymbolsController) dateListings(ctx context.Context, symbols []model.SymbolInfo) ([]model.SymbolInfo, error) {
+	var dated = make([]model.SymbolInfo, len(symbols))
+	copy(dated, symbols)
+	for i, item := range dated {
+		if !item.OnboardDate.IsZero() || !c.universe.candidate(item) {
+			continue
+		}
+		listingDate, ok := c.listingDate(item.Symbol)
+		if !ok {
+			candle, err := c.exchange.FirstCandle(ctx, item.Symbol, model.Timeframe1m)
+			switch {
+			case errors.Is(err, exchange.ErrNotSupported):
+				c.l.Warnw("listing age rule of the universe not supported by the exchange", "error", err)
+				return symbols, nil
+			case errors.Is(err, exchange.ErrInsufficientData):
+				// a symbol without candle yet was just listed, it is dated again at the next fetch
+				dated[i].OnboardDate = time.Now()
+				continue
+			case err != nil:
+				c.l.Errorw("error get first candle", "error", err, "symbol", item.Symbol)
+				return nil, err
+			}
+			listingDate = candle.Time
+			c.saveListingDate(item.Symbol, listingDate)
+		}
+		dated[i].OnboardDate = listingDate
+	}
+	return dated, nil
+}
+
+// listingDate returns the listing date of symbol dated before, from memory or from the store
+func (c *SymbolsController) listingDate(symbol string) (time.Time, bool) {
+	c.RLock()
+	listingDate, ok := c.listingDates[symbol]
+	c.RUnlock()
+	if ok || c.store == nil {
+		return listingDate, ok
+	}
+	if err := c.store.Get(ListingDateKeyPrefix+symbol, &listingDate); err != nil {
+		if !errors.Is(err, storage.ErrKeyNotFound) {
+			c.l.Warnw("error get listing date", "error", err, "symbol", symbol)
+		}
+		return time.Time{}, false
+	}
+	c.Lock()
+	c.listingDates[symbol] = listingDate
+	c.Unlock()
+	return listingDate, true
+}
+
+func (c *SymbolsController) saveListingDate(symbol string, listingDate time.Time) {
+	c.Lock()
+	c.listingDates[symbol] = listingDate
+	c.Unlock()
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Set(ListingDateKeyPrefix+symbol, listingDate); err != nil {
+		c.l.Warnw("error save listing date", "error", err, "symbol", symbol)
+	}
+}
+
+// symbolEvents compares the new trading symbols with the current ones, sorted by symbol. The initial fetch has no
+// event.
+func (c *SymbolsController) symbolEvents(newSymbolInfo map[string]model.SymbolInfo, exchangeStatus map[string]string) []SymbolEvent {
+	c.RLock()
+	defer c.RUnlock()
+	var events = make([]SymbolEvent, 0)
+	if !c.fetched {
+		return events
+	}
+
+	trading := model.SymbolStatusTrading.String()
+	for symbol, info := range newSymbolInfo {
+		if _, ok := c.tradingSymbols[symbol]; ok {
+			continue
+		}
+		eventType := SymbolSelected
+		if c.exchangeStatus[symbol] != trading {
+			eventType = SymbolListed
+		}
+		events = append(events, SymbolEvent{Type: eventType, Symbol: info})
+	}
+	for symbol, info := range c.tradingSymbols {
+		if _, ok := newSymbolInfo[symbol]; ok {
+			continue
+		}
+		eventType := SymbolDeselected
+		if status := exchangeStatus[symbol]; status != trading {
+			eventType = SymbolDelisted
+			info.Status = status
+		}
+		events = append(events, SymbolEvent{Type: eventType, Symbol: info})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Symbol.Symbol < events[j].Symbol.Symbol
+	})
+	return events
+}
+
+// Fetched reports whether the trading symbols were fetched once
+func (c *SymbolsController) Fetched() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.fetched
+}
+
 func (c *SymbolsController) GetTradingSymbols() map[string]model.SymbolInfo {
 	c.RLock()
 	defer c.RUnlock()
 	return c.tradingSymbols
 }
 
+// QuoteAssets returns the quote assets of the universe
+func (c *SymbolsController) QuoteAssets() []string {
+	return c.universe.QuoteAssets
+}
+
+// SymbolInfo returns the info of a trading or deprecated symbol
+func (c *SymbolsController) SymbolInfo(symbol string) (model.SymbolInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	if info, ok := c.tradingSymbols[symbol]; ok {
+		return info, true
+	}
+	info, ok := c.deprecatedSymbols[symbol]
+	return info, ok
+}
+
 func (c *SymbolsController) GetDeprecatedSymbols() map[string]model.SymbolInfo {
 	c.RLock()
 	defer c.RUnlock()
 	return c.deprecatedSymbols
 }
 
+// intervalFetchSymbols refetches the symbols every FetchSymbolInterval, a failed fetch is tried again at the next
+// interval
 func (c *SymbolsController) intervalFetchSymbols() {
 	ticker := time.NewTicker(FetchSymbolInterval)
 	defer ticker.Stop()
 
-	for {
-		if err := c.FetchSymbolsPairUSDT(context.Background()); err != nil {
+	for range ticker.C {
+		if err := c.FetchSymbols(context.Background()); err != nil {
 			c.l.Warnw("interval fetch symbol infos error", "error", err)
-			continue
 		}
-		<-ticker.C
 	}
 }
 
+// filterDeprecatedSymbols keeps the info of the symbols leaving the trading symbols, a symbol trading again is not
+// deprecated anymore
 func (c *SymbolsController) filterDeprecatedSymbols(newSymbolInfo map[string]model.SymbolInfo) {
 	c.Lock()
 	defer c.Unlock()
-	var deprecatedSymbols = make(map[string]model.SymbolInfo)
-	for symbol := range c.tradingSymbols {
-		if val, ok := newSymbolInfo[symbol]; !ok {
-			deprecatedSymbols[symbol] = val
+	for symbol, info := range c.tradingSymbols {
+		if _, ok := newSymbolInfo[symbol]; !ok {
+			c.deprecatedSymbols[symbol] = info
 		}
 	}
-
-	for symbol, val := range deprecatedSymbols {
-		c.deprecatedSymbols[symbol] = val
+	for symbol := range newSymbolInfo {
+		delete(c.deprecatedSymbols, symbol)
 	}
 }
 
-func (c *SymbolsController) saveTradingSymbols(info map[string]model.SymbolInfo) {
+func (c *SymbolsController) saveTradingSymbols(info map[string]model.SymbolInfo, exchangeStatus map[string]string) {
 	c.Lock()
 	defer c.Unlock()
 	c.tradingSymbols = info
+	c.exchangeStatus = exchangeStatus
+	c.fetched = true
 }
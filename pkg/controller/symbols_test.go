@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// infoFeeder serves an exchange info changed by the test between the fetches, and the first candles of its symbols
+type infoFeeder struct {
+	exchange.Feeder
+	sync.Mutex
+	symbols      []model.SymbolInfo
+	firstCandles map[string]time.Time
+	firstCalls   int
+}
+
+func (f *infoFeeder) FirstCandle(ctx context.Context, symbol string, timeframe model.Timeframe) (model.Candle, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.firstCalls++
+	openTime, ok := f.firstCandles[symbol]
+	if !ok {
+		return model.Candle{}, exchange.ErrInsufficientData
+	}
+	return model.Candle{Symbol: symbol, Timeframe: timeframe, Time: openTime}, nil
+}
+
+func (f *infoFeeder) GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, error) {
+	f.Lock()
+	defer f.Unlock()
+	return model.ExchangeInfo{Symbols: f.symbols}, nil
+}
+
+func (f *infoFeeder) setSymbols(symbols ...model.SymbolInfo) {
+	f.Lock()
+	defer f.Unlock()
+	f.symbols = symbols
+}
+
+func TestSymbolsController(t *testing.T) {
+	symbol := func(name, baseAsset, status string) model.SymbolInfo {
+		return model.SymbolInfo{Symbol: name, BaseAsset: baseAsset, QuoteAsset: "USDT", Status: status}
+	}
+	var (
+		trading = model.SymbolStatusTrading.String()
+		btc     = symbol("BTCUSDT", "BTC", trading)
+		eth     = symbol("ETHUSDT", "ETH", trading)
+		knc     = symbol("KNCUSDT", "KNC", trading)
+	)
+
+	feeder := new(infoFeeder)
+	feeder.setSymbols(btc, eth, knc)
+	c, err := NewSymbolsController(feeder, nil)
+	require.NoError(t, err)
+
+	var events = make([]SymbolEvent, 0)
+	c.OnSymbolEvent(func(event SymbolEvent) {
+		events = append(events, event)
+	})
+	require.NoError(t, c.FetchSymbols(context.Background()))
+	assert.Empty(t, events)
+
+	// KNCUSDT breaks, ETHUSDT is removed and XYZUSDT listed
+	feeder.setSymbols(btc, symbol("KNCUSDT", "KNC", "BREAK"), symbol("XYZUSDT", "XYZ", trading))
+	require.NoError(t, c.FetchSymbols(context.Background()))
+	require.Len(t, events, 3)
+	assert.Equal(t, SymbolDelisted, events[0].Type)
+	assert.Equal(t, "ETHUSDT", events[0].Symbol.Symbol)
+	assert.Equal(t, SymbolDelisted, events[1].Type)
+	assert.Equal(t, "KNCUSDT", events[1].Symbol.Symbol)
+	assert.Equal(t, "BREAK", events[1].Symbol.Status)
+	assert.Equal(t, SymbolListed, events[2].Type)
+	assert.True(t, events[2].Added())
+	assert.Equal(t, "XYZUSDT", events[2].Symbol.Symbol)
+
+	// the info of the deprecated symbols is kept
+	assert.Equal(t, eth, c.GetDeprecatedSymbols()["ETHUSDT"])
+	assert.Len(t, c.GetTradingSymbols(), 2)
+
+	// a symbol trading again after a break is listed again and not deprecated anymore
+	events = events[:0]
+	feeder.setSymbols(btc, knc, symbol("XYZUSDT", "XYZ", trading))
+	require.NoError(t, c.FetchSymbols(context.Background()))
+	require.Len(t, events, 1)
+	assert.Equal(t, SymbolListed, events[0].Type)
+	assert.Equal(t, "KNCUSDT", events[0].Symbol.Symbol)
+	assert.NotContains(t, c.GetDeprecatedSymbols(), "KNCUSDT")
+	assert.Contains(t, c.GetDeprecatedSymbols(), "ETHUSDT")
+}
+
+func TestSymbolsControllerListingAge(t *testing.T) {
+	viper.Set(UniverseMinListingAgeFlag, "720h")
+	defer viper.Set(UniverseMinListingAgeFlag, nil)
+
+	var (
+		trading = model.SymbolStatusTrading.String()
+		now     = time.Now()
+		old     = model.SymbolInfo{Symbol: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT", Status: trading}
+		recent  = model.SymbolInfo{Symbol: "XYZUSDT", BaseAsset: "XYZ", QuoteAsset: "USDT", Status: trading}
+		empty   = model.SymbolInfo{Symbol: "NEWUSDT", BaseAsset: "NEW", QuoteAsset: "USDT", Status: trading}
+		other   = model.SymbolInfo{Symbol: "BTCBUSD", BaseAsset: "BTC", QuoteAsset: "BUSD", Status: trading}
+	)
+	feeder := &infoFeeder{firstCandles: map[string]time.Time{
+		"BTCUSDT": now.Add(-365 * 24 * time.Hour),
+		"XYZUSDT": now.Add(-24 * time.Hour),
+	}}
+	feeder.setSymbols(old, recent, empty, other)
+
+	// spot symbols are dated by their first candle, without candle a symbol is just listed
+	c, err := NewSymbolsController(feeder, nil)
+	require.NoError(t, err)
+	symbols := c.GetTradingSymbols()
+	require.Len(t, symbols, 1)
+	assert.Contains(t, symbols, "BTCUSDT")
+	assert.True(t, symbols["BTCUSDT"].OnboardDate.Equal(now.Add(-365*24*time.Hour)))
+	// BTCBUSD is not a candidate of the universe
+	assert.Equal(t, 3, feeder.firstCalls)
+
+	// the dates are cached, the symbol without candle is requested again
+	require.NoError(t, c.FetchSymbols(context.Background()))
+	assert.Equal(t, 4, feeder.firstCalls)
+
+	t.Run("stored", func(t *testing.T) {
+		store := make(memoryStorage)
+		feeder.firstCalls = 0
+		_, err := NewSymbolsController(feeder, store)
+		require.NoError(t, err)
+		assert.Equal(t, 3, feeder.firstCalls)
+
+		// after a restart, only the symbol without candle is requested again
+		c, err := NewSymbolsController(feeder, store)
+		require.NoError(t, err)
+		assert.Equal(t, 4, feeder.firstCalls)
+		symbols := c.GetTradingSymbols()
+		require.Len(t, symbols, 1)
+		assert.True(t, symbols["BTCUSDT"].OnboardDate.Equal(now.Add(-365*24*time.Hour)))
+	})
+}
+
+// memoryStorage encodes the values like BadgerDB does
+type memoryStorage map[string][]byte
+
+func (m memoryStorage) Set(key string, value interface{}) error {
+	data, err := storage.Encode(value)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+func (m memoryStorage) Get(key string, value interface{}) error {
+	data, ok := m[key]
+	if !ok {
+		return storage.ErrKeyNotFound
+	}
+	return storage.Decode(data, value)
+}
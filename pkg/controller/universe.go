@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+)
+
+const (
+	// UniverseQuoteAssetsFlag lists the quote assets of the watched symbols, e.g. `["USDT", "BUSD"]`
+	UniverseQuoteAssetsFlag = "universe.quote_assets"
+	// UniverseExcludedBaseAssetsFlag lists the patterns of the base assets never watched, e.g. `["BTCUP", "USD?"]`,
+	// see path.Match for the syntax. A suffix pattern like `*UP` also matches regular assets, e.g. JUP.
+	UniverseExcludedBaseAssetsFlag = "universe.excluded_base_assets"
+	// UniverseMinQuoteVolumeFlag is the minimum 24h quote volume of the watched symbols
+	UniverseMinQuoteVolumeFlag = "universe.min_quote_volume"
+	// UniverseTopVolumeFlag keeps only the symbols with the largest 24h quote volume, zero keeps all of them
+	UniverseTopVolumeFlag = "universe.top_volume"
+	// UniverseMinListingAgeFlag is the minimum time since the listing of the watched symbols, e.g. `720h`. Spot
+	// symbols have no onboard date, they are dated by their first candle.
+	UniverseMinListingAgeFlag = "universe.min_listing_age"
+
+	DefaultQuoteAsset = "USDT"
+)
+
+// Universe are the rules selecting the watched symbols among the trading symbols of the exchange
+type Universe struct {
+	QuoteAssets        []string
+	ExcludedBaseAssets []string // path.Match patterns
+	MinQuoteVolume     float64
+	TopVolume          int
+	MinListingAge      time.Duration
+}
+
+// UniverseFromConfig reads the universe rules of the configuration, the USDT symbols are watched by default
+func UniverseFromConfig() (Universe, error) {
+	universe := Universe{
+		QuoteAssets:        viper.GetStringSlice(UniverseQuoteAssetsFlag),
+		ExcludedBaseAssets: viper.GetStringSlice(UniverseExcludedBaseAssetsFlag),
+		MinQuoteVolume:     viper.GetFloat64(UniverseMinQuoteVolumeFlag),
+		TopVolume:          viper.GetInt(UniverseTopVolumeFlag),
+		MinListingAge:      viper.GetDuration(UniverseMinListingAgeFlag),
+	}
+	if len(universe.QuoteAssets) == 0 {
+		universe.QuoteAssets = []string{DefaultQuoteAsset}
+	}
+	for i, asset := range universe.QuoteAssets {
+		universe.QuoteAssets[i] = strings.ToUpper(asset)
+	}
+	for _, pattern := range universe.ExcludedBaseAssets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Universe{}, fmt.Errorf("invalid `%s` pattern %q: %w", UniverseExcludedBaseAssetsFlag, pattern, err)
+		}
+	}
+	return universe, nil
+}
+
+// NeedsVolume reports whether the rules filter on the 24h quote volume
+func (u Universe) NeedsVolume() bool {
+	return u.MinQuoteVolume > 0 || u.TopVolume > 0
+}
+
+// Filter returns the trading symbols following the rules. Without quote volumes, the volume rules are not applied,
+// and the symbols without listing date are not filtered by age.
+func (u Universe) Filter(symbols []model.SymbolInfo, quoteVolumes map[string]float64, now time.Time) map[string]model.SymbolInfo {
+	var selected = make([]model.SymbolInfo, 0, len(symbols))
+	for _, item := range symbols {
+		if !u.candidate(item) {
+			continue
+		}
+		if u.MinListingAge > 0 && !item.OnboardDate.IsZero() && now.Sub(item.OnboardDate) < u.MinListingAge {
+			continue
+		}
+		if quoteVolumes != nil && quoteVolumes[item.Symbol] < u.MinQuoteVolume {
+			continue
+		}
+		selected = append(selected, item)
+	}
+
+	if quoteVolumes != nil && u.TopVolume > 0 && len(selected) > u.TopVolume {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return quoteVolumes[selected[i].Symbol] > quoteVolumes[selected[j].Symbol]
+		})
+		selected = selected[:u.TopVolume]
+	}
+
+	var result = make(map[string]model.SymbolInfo, len(selected))
+	for _, item := range selected {
+		result[item.Symbol] = item
+	}
+	return result
+}
+
+// candidate reports whether the symbol follows the rules on its exchange info, before its age and volume
+func (u Universe) candidate(item model.SymbolInfo) bool {
+	if item.Status != model.SymbolStatusTrading.String() || !isInList(u.QuoteAssets, item.QuoteAsset) {
+		return false
+	}
+	// delivery contracts expire, only perpetual contracts are followed on futures markets
+	if item.ContractType != "" && !item.IsPerpetual() {
+		return false
+	}
+	return !u.isExcluded(item.BaseAsset)
+}
+
+func (u Universe) isExcluded(baseAsset string) bool {
+	for _, pattern := range u.ExcludedBaseAssets {
+		if ok, _ := path.Match(strings.ToUpper(pattern), baseAsset); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniverse(t *testing.T) {
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	trading := model.SymbolStatusTrading.String()
+	symbols := []model.SymbolInfo{
+		{Symbol: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT", Status: trading},
+		{Symbol: "ETHUSDT", BaseAsset: "ETH", QuoteAsset: "USDT", Status: trading},
+		{Symbol: "ETHBUSD", BaseAsset: "ETH", QuoteAsset: "BUSD", Status: trading},
+		{Symbol: "BTCUPUSDT", BaseAsset: "BTCUP", QuoteAsset: "USDT", Status: trading},
+		{Symbol: "EOSBEARUSDT", BaseAsset: "EOSBEAR", QuoteAsset: "USDT", Status: trading},
+		{Symbol: "JUPUSDT", BaseAsset: "JUP", QuoteAsset: "USDT", Status: trading},
+		{Symbol: "USDCUSDT", BaseAsset: "USDC", QuoteAsset: "USDT", Status: trading},
+		{Symbol: "KNCUSDT", BaseAsset: "KNC", QuoteAsset: "USDT", Status: "BREAK"},
+		{Symbol: "NEWUSDT", BaseAsset: "NEW", QuoteAsset: "USDT", Status: trading, OnboardDate: now.Add(-24 * time.Hour)},
+		{Symbol: "OLDUSDT", BaseAsset: "OLD", QuoteAsset: "USDT", Status: trading, OnboardDate: now.Add(-60 * 24 * time.Hour)},
+	}
+	quoteVolumes := map[string]float64{
+		"BTCUSDT":     5e9,
+		"ETHUSDT":     2e9,
+		"ETHBUSD":     1e8,
+		"BTCUPUSDT":   1e7,
+		"EOSBEARUSDT": 1e6,
+		"JUPUSDT":     6e6,
+		"USDCUSDT":    3e8,
+		"NEWUSDT":     4e7,
+		"OLDUSDT":     2e6,
+	}
+
+	keys := func(symbols map[string]model.SymbolInfo) []string {
+		var result = make([]string, 0, len(symbols))
+		for symbol := range symbols {
+			result = append(result, symbol)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	t.Run("default", func(t *testing.T) {
+		universe, err := UniverseFromConfig()
+		require.NoError(t, err)
+		assert.False(t, universe.NeedsVolume())
+		assert.Equal(t, []string{"BTCUPUSDT", "BTCUSDT", "EOSBEARUSDT", "ETHUSDT", "JUPUSDT", "NEWUSDT", "OLDUSDT", "USDCUSDT"},
+			keys(universe.Filter(symbols, nil, now)))
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		viper.Set(UniverseQuoteAssetsFlag, []string{"usdt", "BUSD"})
+		viper.Set(UniverseExcludedBaseAssetsFlag, []string{"BTCUP", "BTCDOWN", "EOSBEAR", "USD?"})
+		viper.Set(UniverseMinQuoteVolumeFlag, 5e6)
+		viper.Set(UniverseTopVolumeFlag, 3)
+		viper.Set(UniverseMinListingAgeFlag, "720h")
+		defer func() {
+			for _, flag := range []string{UniverseQuoteAssetsFlag, UniverseExcludedBaseAssetsFlag, UniverseMinQuoteVolumeFlag, UniverseTopVolumeFlag, UniverseMinListingAgeFlag} {
+				viper.Set(flag, nil)
+			}
+		}()
+
+		universe, err := UniverseFromConfig()
+		require.NoError(t, err)
+		assert.True(t, universe.NeedsVolume())
+		// OLDUSDT trades too little, NEWUSDT is too recent
+		assert.Equal(t, []string{"BTCUSDT", "ETHBUSD", "ETHUSDT"}, keys(universe.Filter(symbols, quoteVolumes, now)))
+
+		// the volume rules are skipped without quote volumes, JUP only ends like a leveraged token
+		assert.Equal(t, []string{"BTCUSDT", "ETHBUSD", "ETHUSDT", "JUPUSDT", "OLDUSDT"}, keys(universe.Filter(symbols, nil, now)))
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		viper.Set(UniverseExcludedBaseAssetsFlag, []string{"[UP"})
+		defer viper.Set(UniverseExcludedBaseAssetsFlag, nil)
+		_, err := UniverseFromConfig()
+		assert.Error(t, err)
+	})
+}
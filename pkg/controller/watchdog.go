@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// WatchdogEnabledFlag watches the candle feeds while running, true unless set to false, e.g. for backtests
+	WatchdogEnabledFlag = "watchdog.enabled"
+	// WatchdogIntervalFlag is the delay between two checks of the feeds
+	WatchdogIntervalFlag = "watchdog.interval"
+	// WatchdogGraceFlag is how long a feed may stay quiet after its expected update before it is stale
+	WatchdogGraceFlag = "watchdog.grace"
+	// WatchdogMaxSilenceFlag caps the expected delay between two updates of a feed, the candle period by default.
+	// Binance pushes the klines of active symbols every 2 seconds, so e.g. `5m` detects a quiet 4h feed early.
+	WatchdogMaxSilenceFlag = "watchdog.max_silence"
+	// WatchdogAlertAfterFlag is how long a feed, or every feed of the exchange, stays stale before it is notified
+	WatchdogAlertAfterFlag = "watchdog.alert_after"
+
+	DefaultWatchdogInterval   = 30 * time.Second
+	DefaultWatchdogGrace      = time.Minute
+	DefaultWatchdogAlertAfter = 5 * time.Minute
+
+	// maxListedFeeds is the number of feeds listed by a notification
+	maxListedFeeds = 20
+)
+
+// Names of the alerts of the watchdog, see AlertController
+const (
+	AlertStaleFeeds        = "stale_feeds"
+	AlertFeedsRecovered    = "feeds_recovered"
+	AlertExchangeOutage    = "exchange_outage"
+	AlertExchangeRecovered = "exchange_recovered"
+)
+
+type feedWatch struct {
+	status     FeedStatus
+	firstSeen  time.Time // first check streaming the feed
+	lastSeen   time.Time // last update of the feed, or first check without update
+	staleSince time.Time
+	alerted    bool
+}
+
+// Watchdog checks that every streamed candle feed is updated at the cadence of its timeframe. The connections whose
+// feeds are all stale are subscribed again, and the feeds staying stale are notified until they recover. When every
+// feed is stale, a single exchange outage is notified.
+type Watchdog struct {
+	sync.Mutex
+	l               *zap.SugaredLogger
+	candles         *CandleController
+	alerts          *AlertController
+	enabled         bool
+	interval        time.Duration
+	grace           time.Duration
+	maxSilence      time.Duration
+	alertAfter      time.Duration
+	feeds           map[string]*feedWatch
+	exchangeStale   time.Time // all the feeds are stale since, zero when any feed is updated
+	exchangeAlerted bool
+	pending         []Alert // alerts of the current check
+}
+
+func NewWatchdog(candles *CandleController) *Watchdog {
+	w := &Watchdog{
+		l:          zap.S(),
+		candles:    candles,
+		enabled:    !viper.IsSet(WatchdogEnabledFlag) || viper.GetBool(WatchdogEnabledFlag),
+		interval:   DefaultWatchdogInterval,
+		grace:      DefaultWatchdogGrace,
+		maxSilence: viper.GetDuration(WatchdogMaxSilenceFlag),
+		alertAfter: DefaultWatchdogAlertAfter,
+		feeds:      make(map[string]*feedWatch),
+	}
+	if interval := viper.GetDuration(WatchdogIntervalFlag); interval > 0 {
+		w.interval = interval
+	}
+	if viper.IsSet(WatchdogGraceFlag) {
+		w.grace = viper.GetDuration(WatchdogGraceFlag)
+	}
+	if viper.IsSet(WatchdogAlertAfterFlag) {
+		w.alertAfter = viper.GetDuration(WatchdogAlertAfterFlag)
+	}
+	return w
+}
+
+// SetAlerts sends the stale feeds and their recovery through alerts, they are only logged otherwise
+func (w *Watchdog) SetAlerts(alerts *AlertController) {
+	w.Lock()
+	defer w.Unlock()
+	w.alerts = alerts
+}
+
+// Start checks the feeds every interval until ctx is done
+func (w *Watchdog) Start(ctx context.Context) {
+	if !w.enabled {
+		return
+	}
+	w.l.Infow("start watchdog", "interval", w.interval, "grace", w.grace, "max_silence", w.maxSilence,
+		"alert_after", w.alertAfter)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+// StaleFeeds lists the candle feeds stale at the last check
+func (w *Watchdog) StaleFeeds() []FeedStatus {
+	w.Lock()
+	defer w.Unlock()
+	var feeds = make([]FeedStatus, 0)
+	for _, watch := range w.feeds {
+		if !watch.staleSince.IsZero() {
+			feeds = append(feeds, watch.status)
+		}
+	}
+	sortFeeds(feeds)
+	return feeds
+}
+
+// threshold is how long a feed of timeframe may stay without update
+func (w *Watchdog) threshold(timeframe model.Timeframe) time.Duration {
+	silence := timeframe.Duration()
+	if w.maxSilence > 0 && (silence == 0 || w.maxSilence < silence) {
+		silence = w.maxSilence
+	}
+	return silence + w.grace
+}
+
+// check updates the feeds, the alerts are sent once w is unlocked as the notifier may be slow
+func (w *Watchdog) check(now time.Time) {
+	w.update(now)
+
+	w.Lock()
+	pending, alerts := w.pending, w.alerts
+	w.pending = nil
+	w.Unlock()
+	if alerts == nil {
+		return
+	}
+	for _, alert := range pending {
+		alerts.AddAlert(alert)
+	}
+}
+
+func (w *Watchdog) update(now time.Time) {
+	w.Lock()
+	defer w.Unlock()
+
+	var (
+		streaming  int
+		stale      = make([]*feedWatch, 0)
+		recovered  = make([]*feedWatch, 0)
+		reconnects = make([]string, 0)
+		seen       = make(map[string]bool)
+	)
+	for _, status := range w.candles.ActiveFeeds() {
+		// the trades have no cadence
+		if status.Timeframe == "" || !status.Streaming {
+			continue
+		}
+		feed := w.candles.generateKey(status.Symbol, status.Timeframe)
+		seen[feed] = true
+		streaming++
+
+		watch, ok := w.feeds[feed]
+		if !ok {
+			watch = &feedWatch{firstSeen: now}
+			w.feeds[feed] = watch
+		}
+		watch.status = status
+		watch.lastSeen = status.LastUpdate
+		if watch.lastSeen.Before(watch.firstSeen) {
+			watch.lastSeen = watch.firstSeen
+		}
+
+		threshold := w.threshold(status.Timeframe)
+		if now.Sub(watch.lastSeen) <= threshold {
+			if watch.alerted {
+				recovered = append(recovered, watch)
+			}
+			watch.staleSince, watch.alerted = time.Time{}, false
+			continue
+		}
+
+		if watch.staleSince.IsZero() {
+			watch.staleSince = watch.lastSeen.Add(threshold)
+		}
+		stale = append(stale, watch)
+		// a connection just subscribed again gets a full period to stream
+		if now.Sub(status.ConnectedAt) > threshold {
+			reconnects = append(reconnects, feed)
+		}
+	}
+	for feed := range w.feeds {
+		if !seen[feed] {
+			delete(w.feeds, feed)
+		}
+	}
+
+	if len(reconnects) > 0 {
+		if connections := w.candles.Reconnect(reconnects); connections > 0 {
+			w.l.Warnw("reconnect stale feeds", "feeds", len(reconnects), "connections", connections)
+		}
+	}
+
+	w.checkExchange(now, streaming, stale)
+	// the feeds of an exchange outage are notified by the outage
+	if w.exchangeAlerted {
+		return
+	}
+
+	var alerts = make([]*feedWatch, 0)
+	for _, watch := range stale {
+		if !watch.alerted && now.Sub(watch.staleSince) >= w.alertAfter {
+			watch.alerted = true
+			alerts = append(alerts, watch)
+		}
+	}
+	if len(alerts) > 0 {
+		lines := make([]string, 0, len(alerts))
+		for _, watch := range alerts {
+			lines = append(lines, fmt.Sprintf("%s %s: no update for %v", watch.status.Symbol, watch.status.Timeframe,
+				now.Sub(watch.lastSeen).Round(time.Second)))
+		}
+		w.l.Warnw("stale feeds", "feeds", len(alerts))
+		w.notify(AlertStaleFeeds, SeverityWarning, fmt.Sprintf("Stale feeds:\n%s", listLines(lines)))
+	}
+	if len(recovered) > 0 {
+		lines := make([]string, 0, len(recovered))
+		for _, watch := range recovered {
+			lines = append(lines, fmt.Sprintf("%s %s", watch.status.Symbol, watch.status.Timeframe))
+		}
+		w.l.Infow("recovered feeds", "feeds", len(recovered))
+		w.notify(AlertFeedsRecovered, SeverityInfo, fmt.Sprintf("Recovered feeds:\n%s", listLines(lines)))
+	}
+}
+
+// checkExchange notifies when every streamed feed stays stale, then when any of them is updated again
+func (w *Watchdog) checkExchange(now time.Time, streaming int, stale []*feedWatch) {
+	if streaming == 0 || len(stale) < streaming {
+		if w.exchangeAlerted {
+			w.l.Infow("exchange recovered", "outage", now.Sub(w.exchangeStale))
+			w.notify(AlertExchangeRecovered, SeverityInfo, fmt.Sprintf("Exchange recovered after %v",
+				now.Sub(w.exchangeStale).Round(time.Second)))
+			// the feeds still stale get a full delay to recover before being notified
+			for _, watch := range stale {
+				watch.staleSince, watch.alerted = now, false
+			}
+		}
+		w.exchangeStale, w.exchangeAlerted = time.Time{}, false
+		return
+	}
+
+	if w.exchangeStale.IsZero() {
+		for _, watch := range stale {
+			if watch.staleSince.After(w.exchangeStale) {
+				w.exchangeStale = watch.staleSince
+			}
+		}
+	}
+	if !w.exchangeAlerted && now.Sub(w.exchangeStale) >= w.alertAfter {
+		w.exchangeAlerted = true
+		w.l.Warnw("exchange outage", "feeds", streaming, "since", w.exchangeStale)
+		w.notify(AlertExchangeOutage, SeverityCritical, fmt.Sprintf("Exchange outage: no candle update on the %d feeds for %v",
+			streaming, now.Sub(w.exchangeStale).Round(time.Second)))
+	}
+}
+
+// notify queues the alert until the end of the check, w must be locked
+func (w *Watchdog) notify(name string, severity Severity, msg string) {
+	w.pending = append(w.pending, Alert{Name: name, Severity: severity, Message: msg})
+}
+
+// listLines joins the first maxListedFeeds lines
+func listLines(lines []string) string {
+	if len(lines) <= maxListedFeeds {
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("%s\n... and %d more", strings.Join(lines[:maxListedFeeds], "\n"), len(lines)-maxListedFeeds)
+}
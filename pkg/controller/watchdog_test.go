@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/exchange"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// quietFeeder opens candle subscriptions that never stream nor fail
+type quietFeeder struct {
+	exchange.Feeder
+	sync.Mutex
+	subscriptions int
+}
+
+func (f *quietFeeder) CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	f.Lock()
+	f.subscriptions++
+	f.Unlock()
+	<-ctx.Done()
+}
+
+func (f *quietFeeder) CandlesByPeriod(ctx context.Context, symbol string, period model.Timeframe, start, end time.Time) ([]model.Candle, error) {
+	return nil, nil
+}
+
+func (f *quietFeeder) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.subscriptions
+}
+
+type recordNotifier struct {
+	sync.Mutex
+	messages []string
+}
+
+func (n *recordNotifier) SendMessage(msg string) error {
+	n.Lock()
+	defer n.Unlock()
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+func (n *recordNotifier) OnError(err error) {}
+
+// pop returns the messages sent since the last call
+func (n *recordNotifier) pop() []string {
+	n.Lock()
+	defer n.Unlock()
+	messages := n.messages
+	n.messages = nil
+	return messages
+}
+
+func TestWatchdog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feeder := new(quietFeeder)
+	c := NewCandleController(feeder)
+	defer c.Close()
+	c.Subscribe("BTCUSDT", model.Timeframe1m, func(candle model.Candle) {}, false)
+	c.Subscribe("ETHUSDT", model.Timeframe1m, func(candle model.Candle) {}, false)
+	go c.Start(ctx)
+	require.Eventually(t, func() bool { return feeder.count() == 1 }, time.Second, 10*time.Millisecond)
+
+	notifier := new(recordNotifier)
+	alerts, err := NewAlertController(notifier)
+	require.NoError(t, err)
+	w := NewWatchdog(c)
+	w.SetAlerts(alerts)
+
+	now := time.Now()
+	update := func(symbol string, at time.Time) {
+		c.Lock()
+		defer c.Unlock()
+		c.lastUpdate[c.generateKey(symbol, model.Timeframe1m)] = at
+	}
+	update("BTCUSDT", now)
+	w.check(now)
+	assert.Empty(t, w.StaleFeeds())
+
+	// 1m feeds are stale after 2m, their quiet connection is subscribed again
+	w.check(now.Add(3 * time.Minute))
+	assert.Len(t, w.StaleFeeds(), 2)
+	assert.Eventually(t, func() bool { return feeder.count() == 2 }, time.Second, 10*time.Millisecond)
+	assert.Empty(t, notifier.pop())
+
+	// every feed stays stale
+	w.check(now.Add(8 * time.Minute))
+	assert.Equal(t, []string{"Exchange outage: no candle update on the 2 feeds for 6m0s"}, notifier.pop())
+	w.check(now.Add(8*time.Minute + 30*time.Second))
+	assert.Empty(t, notifier.pop())
+
+	update("ETHUSDT", now.Add(8*time.Minute))
+	w.check(now.Add(9 * time.Minute))
+	assert.Equal(t, []string{"Exchange recovered after 7m0s"}, notifier.pop())
+	stale := w.StaleFeeds()
+	require.Len(t, stale, 1)
+	assert.Equal(t, "BTCUSDT", stale[0].Symbol)
+
+	// the feed still stale after the outage gets a full delay
+	update("ETHUSDT", now.Add(13*time.Minute))
+	w.check(now.Add(13 * time.Minute))
+	assert.Empty(t, notifier.pop())
+	w.check(now.Add(14 * time.Minute))
+	assert.Equal(t, []string{"Stale feeds:\nBTCUSDT 1m: no update for 14m0s"}, notifier.pop())
+
+	update("BTCUSDT", now.Add(14*time.Minute))
+	update("ETHUSDT", now.Add(14*time.Minute))
+	w.check(now.Add(14*time.Minute + 30*time.Second))
+	assert.Equal(t, []string{"Recovered feeds:\nBTCUSDT 1m"}, notifier.pop())
+	assert.Empty(t, w.StaleFeeds())
+}
@@ -7,7 +7,8 @@ import (
 	"time"
 
 	"github.com/quangkeu95/binancebot/pkg/exchange"
-	"github.com/xhit/go-str2duration/v2"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
 	"go.uber.org/zap"
 )
 
@@ -15,10 +16,10 @@ const batchSize = 500
 
 type Downloader struct {
 	l        *zap.SugaredLogger
-	exchange exchange.Exchange
+	exchange exchange.Feeder
 }
 
-func NewDownloader(exchange exchange.Exchange) *Downloader {
+func NewDownloader(exchange exchange.Feeder) *Downloader {
 	return &Downloader{
 		l:        zap.S(),
 		exchange: exchange,
@@ -46,21 +47,40 @@ func WithDays(days int) Option {
 	}
 }
 
-func candlesCount(start, end time.Time, timeframe string) (int, time.Duration, error) {
-	totalDuration := end.Sub(start)
-	interval, err := str2duration.ParseDuration(timeframe)
-	if err != nil {
-		return 0, 0, err
-	}
-	return int(totalDuration / interval), interval, nil
+func candlesCount(start, end time.Time, timeframe model.Timeframe) int {
+	return int(end.Sub(start) / timeframe.Duration())
 }
 
-func (d *Downloader) Download(ctx context.Context, symbol, timeframe string, output string, options ...Option) error {
+func (d *Downloader) Download(ctx context.Context, symbol string, timeframe model.Timeframe, output string, options ...Option) error {
 	recordFile, err := os.Create(output)
 	if err != nil {
 		return err
 	}
+	defer recordFile.Close()
 
+	writer := csv.NewWriter(recordFile)
+	err = d.download(ctx, symbol, timeframe, func(candles []model.Candle) error {
+		for _, candle := range candles {
+			if err := writer.Write(candle.ToSlice()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, options...)
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Store downloads the candles into the candle store instead of a csv file
+func (d *Downloader) Store(ctx context.Context, store storage.CandleStore, symbol string, timeframe model.Timeframe, options ...Option) error {
+	return d.download(ctx, symbol, timeframe, store.SaveCandles, options...)
+}
+
+// download passes the candles of the period to write, one batch at a time
+func (d *Downloader) download(ctx context.Context, symbol string, timeframe model.Timeframe, write func(candles []model.Candle) error, options ...Option) error {
 	now := time.Now()
 	parameters := &Parameters{
 		Start: now.AddDate(0, -1, 0),
@@ -76,15 +96,12 @@ func (d *Downloader) Download(ctx context.Context, symbol, timeframe string, out
 	parameters.End = time.Date(parameters.End.Year(), parameters.End.Month(), parameters.End.Day(),
 		0, 0, 0, 0, time.UTC)
 
-	candlesCount, interval, err := candlesCount(parameters.Start, parameters.End, timeframe)
-	if err != nil {
-		return err
-	}
+	candlesCount := candlesCount(parameters.Start, parameters.End, timeframe)
 
 	d.l.Infow("Downloading candle..", "symbol", symbol, "timeframe", timeframe, "candle_count", candlesCount)
-	writer := csv.NewWriter(recordFile)
-	for begin := parameters.Start; begin.Before(parameters.End); begin = begin.Add(interval * batchSize) {
-		end := begin.Add(interval * batchSize)
+	var lastTime time.Time
+	for begin := parameters.Start; begin.Before(parameters.End); begin = timeframe.Add(begin, batchSize) {
+		end := timeframe.Add(begin, batchSize)
 		if end.After(parameters.End) {
 			end = parameters.End
 		}
@@ -94,14 +111,19 @@ func (d *Downloader) Download(ctx context.Context, symbol, timeframe string, out
 			return err
 		}
 
+		var batch = make([]model.Candle, 0, len(candles))
 		for _, candle := range candles {
-			err := writer.Write(candle.ToSlice())
-			if err != nil {
-				return err
+			// consecutive batches share the candle opened at their boundary
+			if !candle.Time.After(lastTime) {
+				continue
 			}
+			lastTime = candle.Time
+			batch = append(batch, candle)
+		}
+		if err := write(batch); err != nil {
+			return err
 		}
 	}
-	writer.Flush()
 	d.l.Infow("Downloading done")
-	return writer.Error()
+	return nil
 }
@@ -3,23 +3,33 @@ package exchange
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
 	validation "github.com/go-ozzo/ozzo-validation"
-	"github.com/quangkeu95/binancebot/lib/app"
 	"github.com/quangkeu95/binancebot/pkg/model"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 const (
-	BinanceApiKeyFlag    = "binance.api_key"
-	BinanceApiSecretFlag = "binance.api_secret"
+	BinanceApiKeyFlag      = "binance.api_key"
+	BinanceApiSecretFlag   = "binance.api_secret"
+	BinanceApiEndpointFlag = "binance.api_endpoint"
+	BinanceWsEndpointFlag  = "binance.ws_endpoint"
 )
 
 const (
+	DefaultBinanceApiEndpoint = "https://api.binance.com"
+	DefaultBinanceWsEndpoint  = "wss://stream.binance.com:9443"
+)
+
+const (
+	MaxKlinesLimit   = 1000
 	RequestPerSecond = 20
 	OrderPerSecond   = 5
 	OrderPerDay      = 160000
@@ -32,12 +42,15 @@ func init() {
 }
 
 type Binance struct {
-	l         *zap.SugaredLogger
+	binanceStreams
 	apiKey    string
 	apiSecret string
 
-	client      *binance.Client
-	rateLimiter *app.RateLimiter
+	client        *binance.Client
+	weightLimiter *WeightLimiter
+
+	symbolsMutex sync.RWMutex
+	symbols      map[string]model.SymbolInfo // filters checked before placing orders
 }
 
 func NewBinance() (*Binance, error) {
@@ -52,16 +65,28 @@ func NewBinance() (*Binance, error) {
 		l.Errorw("invalid binance api secret", "error", err, "api_secret", apiSecret)
 		return nil, err
 	}
-	client := binance.NewClient(apiKey, apiSecret)
+	apiEndpoint := viper.GetString(BinanceApiEndpointFlag)
+	if apiEndpoint == "" {
+		apiEndpoint = DefaultBinanceApiEndpoint
+	}
+	wsEndpoint := viper.GetString(BinanceWsEndpointFlag)
+	if wsEndpoint == "" {
+		wsEndpoint = DefaultBinanceWsEndpoint
+	}
 
-	rateLimiter := app.NewRateLimiter(RequestPerSecond, RequestPerSecond)
+	// every REST request goes through the weight limiter
+	weightLimiter := NewWeightLimiter(http.DefaultTransport)
+	client := binance.NewClient(apiKey, apiSecret)
+	client.BaseURL = apiEndpoint
+	client.HTTPClient = &http.Client{Transport: weightLimiter}
 
 	b := &Binance{
-		l:           l,
-		apiKey:      apiKey,
-		apiSecret:   apiSecret,
-		client:      client,
-		rateLimiter: rateLimiter,
+		binanceStreams: binanceStreams{l: l, wsEndpoint: wsEndpoint},
+		apiKey:         apiKey,
+		apiSecret:      apiSecret,
+		client:         client,
+		weightLimiter:  weightLimiter,
+		symbols:        make(map[string]model.SymbolInfo),
 	}
 
 	// test ping
@@ -72,180 +97,138 @@ func NewBinance() (*Binance, error) {
 	return b, nil
 }
 
+// RateLimitStats returns the state of the REST request weight limiter
+func (b *Binance) RateLimitStats() RateLimitStats {
+	return b.weightLimiter.Stats()
+}
+
 func (b *Binance) GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, error) {
-	if err := b.rateLimiter.WaitN(RequestTimeout, 10); err != nil {
-		return model.ExchangeInfo{}, err
-	}
 	resp, err := b.client.NewExchangeInfoService().Do(ctx)
 	if err != nil {
 		b.l.Errorw("error get binance exchange info", "error", err)
 		return model.ExchangeInfo{}, err
 	}
 
+	for _, rateLimit := range resp.RateLimits {
+		if rateLimit.RateLimitType == "REQUEST_WEIGHT" && rateLimit.Interval == "MINUTE" && rateLimit.IntervalNum == 1 {
+			b.weightLimiter.SetWeightLimit(int(rateLimit.Limit))
+		}
+	}
+
 	var symbolInfo = make([]model.SymbolInfo, 0)
 	for _, item := range resp.Symbols {
-		symbolInfo = append(symbolInfo, model.SymbolInfo{
-			Symbol:     item.Symbol,
-			BaseAsset:  item.BaseAsset,
-			QuoteAsset: item.QuoteAsset,
-			Status:     item.Status,
-		})
+		symbolInfo = append(symbolInfo, SymbolInfoFromBinance(item))
 	}
 
+	b.symbolsMutex.Lock()
+	for _, info := range symbolInfo {
+		b.symbols[info.Symbol] = info
+	}
+	b.symbolsMutex.Unlock()
+
 	var result = model.ExchangeInfo{
 		Symbols: symbolInfo,
 	}
 	return result, nil
 }
 
-func (b *Binance) CandlesByLimit(ctx context.Context, symbol, timeframe string, limit int) ([]model.Candle, error) {
-	candles := make([]model.Candle, 0)
-	klineService := b.client.NewKlinesService()
-
-	data, err := klineService.Symbol(symbol).
-		Interval(timeframe).
-		Limit(limit).
-		Do(ctx)
-
-	if err != nil {
-		return nil, err
-	}
-
-	for _, d := range data {
-		candles = append(candles, CandleFromKline(symbol, timeframe, *d))
-	}
+// CandlesByLimit returns the last limit candles, pages of MaxKlinesLimit candles are requested backward until the
+// limit is reached or there is no older candle
+func (b *Binance) CandlesByLimit(ctx context.Context, symbol string, timeframe model.Timeframe, limit int) ([]model.Candle, error) {
+	return candlesByLimit(limit, func(start, end time.Time, limit int) ([]model.Candle, error) {
+		return b.klines(ctx, symbol, timeframe, start, end, limit)
+	})
+}
 
-	return candles, nil
+// CandlesByPeriod returns the candles opened between start and end, pages of MaxKlinesLimit candles are requested
+// forward until the end of the period
+func (b *Binance) CandlesByPeriod(ctx context.Context, symbol string, period model.Timeframe, start, end time.Time) ([]model.Candle, error) {
+	return candlesByPeriod(start, end, func(start, end time.Time, limit int) ([]model.Candle, error) {
+		return b.klines(ctx, symbol, period, start, end, limit)
+	})
 }
 
-func (b *Binance) CandlesByPeriod(ctx context.Context, symbol, period string, start, end time.Time) ([]model.Candle, error) {
-	candles := make([]model.Candle, 0)
-	klineService := b.client.NewKlinesService()
+// FirstCandle returns the oldest candle of the symbol, spot symbols have no onboard date to date their listing
+func (b *Binance) FirstCandle(ctx context.Context, symbol string, timeframe model.Timeframe) (model.Candle, error) {
+	return firstCandle(symbol, timeframe, func(start, end time.Time, limit int) ([]model.Candle, error) {
+		return b.klines(ctx, symbol, timeframe, start, end, limit)
+	})
+}
 
-	data, err := klineService.Symbol(symbol).
-		Interval(period).
-		StartTime(start.UnixNano() / int64(time.Millisecond)).
-		EndTime(end.UnixNano() / int64(time.Millisecond)).
-		Do(ctx)
+// klines requests a single page of candles, zero start or end times are not sent
+func (b *Binance) klines(ctx context.Context, symbol string, timeframe model.Timeframe, start, end time.Time, limit int) ([]model.Candle, error) {
+	klineService := b.client.NewKlinesService().
+		Symbol(symbol).
+		Interval(timeframe.String()).
+		Limit(limit)
+	if !start.IsZero() {
+		klineService.StartTime(start.UnixNano() / int64(time.Millisecond))
+	}
+	if !end.IsZero() {
+		klineService.EndTime(end.UnixNano() / int64(time.Millisecond))
+	}
 
+	data, err := klineService.Do(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	candles := make([]model.Candle, 0, len(data))
 	for _, d := range data {
-		candles = append(candles, CandleFromKline(symbol, period, *d))
+		candles = append(candles, CandleFromKline(symbol, timeframe, *d))
 	}
-
 	return candles, nil
 }
 
-// CandlesSubscription subscribe kline for specific symbol and timeframe
-func (b *Binance) CandlesSubscription(ctx context.Context, symbol, timeframe string, candleCh chan<- model.Candle, errCh chan<- error) {
-	wsKlineHandler := func(event *binance.WsKlineEvent) {
-		candleCh <- CandleFromWsKline(event.Kline)
-	}
-	errHandler := func(err error) {
-		errCh <- err
-	}
-
-	doneCh, stopCh, err := binance.WsKlineServe(symbol, timeframe, wsKlineHandler, errHandler)
-	if err != nil {
-		b.l.Errorw("candles subscription error", "error", err)
-		errCh <- err
-		return
-	}
-
-	b.l.Debugw("binance candle subscription", "symbol", symbol, "timeframe", timeframe)
-	for {
-		select {
-		case <-ctx.Done():
-			stopCh <- struct{}{}
-			return
-		case <-doneCh:
-			errCh <- fmt.Errorf("candles subscription stopped")
-			return
-		}
-	}
+// FundingRates is not supported, spot symbols have no funding
+func (b *Binance) FundingRates(ctx context.Context, symbol string, start, end time.Time) ([]model.FundingRate, error) {
+	return nil, fmt.Errorf("%w: spot market has no funding rate", ErrNotSupported)
 }
 
-func (b *Binance) CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]string, candleCh chan<- model.Candle, errCh chan<- error) {
-	wsKlineHandler := func(event *binance.WsKlineEvent) {
-		candleCh <- CandleFromWsKline(event.Kline)
-	}
-	errHandler := func(err error) {
-		errCh <- err
-	}
-
-	doneCh, stopCh, err := binance.WsCombinedKlineServe(mapSymbolTimeframe, wsKlineHandler, errHandler)
-	if err != nil {
-		b.l.Errorw("combined candles subscription error", "error", err)
-		errCh <- err
-		return
-	}
+// OpenInterest is not supported, spot symbols have no open interest
+func (b *Binance) OpenInterest(ctx context.Context, symbol string) (model.OpenInterest, error) {
+	return model.OpenInterest{}, fmt.Errorf("%w: spot market has no open interest", ErrNotSupported)
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			stopCh <- struct{}{}
-			return
-		case <-doneCh:
-			errCh <- fmt.Errorf("combined candles subscription stopped")
-			return
-		}
-	}
+func (b *Binance) OpenInterestSubscription(ctx context.Context, symbols []string, openInterestCh chan<- model.OpenInterest, errCh chan<- error) {
+	sendError(ctx, errCh, fmt.Errorf("%w: spot market has no open interest", ErrNotSupported))
 }
 
-func (b *Binance) MarketStatsSubscription(ctx context.Context, symbol string, statCh chan<- model.MarketStats24h, errCh chan<- error) {
-	wsMarketStatHandler := func(event *binance.WsMarketStatEvent) {
-		statCh <- MarketStatsFromEvent(event)
-	}
-	errHandler := func(err error) {
-		errCh <- err
-	}
-	doneCh, stopCh, err := binance.WsMarketStatServe(symbol, wsMarketStatHandler, errHandler)
-	if err != nil {
-		b.l.Errorw("market stats subscription error", "error", err)
-		errCh <- err
-		return
-	}
-	for {
-		select {
-		case <-ctx.Done():
-			stopCh <- struct{}{}
-			return
-		case <-doneCh:
-			errCh <- fmt.Errorf("market stats subscription stopped")
-			return
-		}
-	}
+// CombinedLiquidationsSubscription is not supported, spot symbols are never liquidated
+func (b *Binance) CombinedLiquidationsSubscription(ctx context.Context, symbols []string, liquidationCh chan<- model.Liquidation, errCh chan<- error) {
+	sendError(ctx, errCh, fmt.Errorf("%w: spot market has no liquidation", ErrNotSupported))
 }
 
-func (b *Binance) CombinedMarketStatsSubscription(ctx context.Context, symbols []string, statCh chan<- model.MarketStats24h, errCh chan<- error) {
-	wsMarketStatHandler := func(event *binance.WsMarketStatEvent) {
-		statCh <- MarketStatsFromEvent(event)
-	}
-	errHandler := func(err error) {
-		errCh <- err
-	}
-	doneCh, stopCh, err := binance.WsCombinedMarketStatServe(symbols, wsMarketStatHandler, errHandler)
+// MarketStats returns the 24h statistics of every symbol
+func (b *Binance) MarketStats(ctx context.Context) ([]model.MarketStats24h, error) {
+	data, err := b.client.NewListPriceChangeStatsService().Do(ctx)
 	if err != nil {
-		b.l.Errorw("combined market stats subscription error", "error", err)
-		errCh <- err
-		return
+		b.l.Errorw("error get binance market stats", "error", err)
+		return nil, err
 	}
-	for {
-		select {
-		case <-ctx.Done():
-			stopCh <- struct{}{}
-			return
-		case <-doneCh:
-			errCh <- fmt.Errorf("combined market stats subscription stopped")
-			return
+
+	var stats = make([]model.MarketStats24h, 0, len(data))
+	for _, item := range data {
+		stat := model.MarketStats24h{
+			Symbol:             item.Symbol,
+			PriceChange:        item.PriceChange,
+			PriceChangePercent: item.PriceChangePercent,
+			OpenTime:           time.Unix(0, item.OpenTime*int64(time.Millisecond)),
+			CloseTime:          time.Unix(0, item.CloseTime*int64(time.Millisecond)),
+			FirstTradeId:       item.FristID,
+			LastTradeId:        item.LastID,
+			TotalTrades:        item.Count,
 		}
+		stat.LastPrice, _ = strconv.ParseFloat(item.LastPrice, 64)
+		stat.LastQty, _ = strconv.ParseFloat(item.LastQty, 64)
+		stat.BaseVolume, _ = strconv.ParseFloat(item.Volume, 64)
+		stat.QuoteVolume, _ = strconv.ParseFloat(item.QuoteVolume, 64)
+		stats = append(stats, stat)
 	}
+	return stats, nil
 }
 
-func CandleFromKline(symbol, timeframe string, k binance.Kline) model.Candle {
+func CandleFromKline(symbol string, timeframe model.Timeframe, k binance.Kline) model.Candle {
 	candle := model.Candle{
 		Symbol:    symbol,
 		Timeframe: timeframe,
@@ -257,14 +240,15 @@ func CandleFromKline(symbol, timeframe string, k binance.Kline) model.Candle {
 	candle.Low, _ = strconv.ParseFloat(k.Low, 64)
 	candle.Volume, _ = strconv.ParseFloat(k.Volume, 64)
 	candle.Trades = k.TradeNum
-	candle.Complete = true
+	// the most recent kline is still open until its close time
+	candle.Complete = time.Unix(0, k.CloseTime*int64(time.Millisecond)).Before(time.Now())
 	return candle
 }
 
 func CandleFromWsKline(k binance.WsKline) model.Candle {
 	candle := model.Candle{
 		Symbol:    k.Symbol,
-		Timeframe: k.Interval,
+		Timeframe: model.Timeframe(k.Interval),
 		Time:      time.Unix(0, k.StartTime*int64(time.Millisecond)),
 	}
 	candle.Open, _ = strconv.ParseFloat(k.Open, 64)
@@ -277,6 +261,79 @@ func CandleFromWsKline(k binance.WsKline) model.Candle {
 	return candle
 }
 
+func TradeFromWsAggTrade(event *binance.WsAggTradeEvent) model.Trade {
+	trade := model.Trade{
+		Symbol:       event.Symbol,
+		ID:           event.AggTradeID,
+		Time:         time.Unix(0, event.TradeTime*int64(time.Millisecond)),
+		IsBuyerMaker: event.IsBuyerMaker,
+	}
+	trade.Price, _ = strconv.ParseFloat(event.Price, 64)
+	trade.Quantity, _ = strconv.ParseFloat(event.Quantity, 64)
+	return trade
+}
+
+func SymbolInfoFromBinance(symbol binance.Symbol) model.SymbolInfo {
+	info := model.SymbolInfo{
+		Symbol:      symbol.Symbol,
+		BaseAsset:   symbol.BaseAsset,
+		QuoteAsset:  symbol.QuoteAsset,
+		Status:      symbol.Status,
+		Permissions: symbol.Permissions,
+	}
+	// older exchange infos only flag the spot and margin trading
+	if len(info.Permissions) == 0 {
+		if symbol.IsSpotTradingAllowed {
+			info.Permissions = append(info.Permissions, model.PermissionSpot)
+		}
+		if symbol.IsMarginTradingAllowed {
+			info.Permissions = append(info.Permissions, model.PermissionMargin)
+		}
+	}
+	for _, orderType := range symbol.OrderTypes {
+		info.OrderTypes = append(info.OrderTypes, model.OrderType(orderType))
+	}
+	// the quote and base asset precisions are not the decimals of the prices and quantities, which follow the tick
+	// and step sizes
+	if filter := symbol.PriceFilter(); filter != nil {
+		info.TickSize, _ = strconv.ParseFloat(filter.TickSize, 64)
+		info.MinPrice, _ = strconv.ParseFloat(filter.MinPrice, 64)
+		info.MaxPrice, _ = strconv.ParseFloat(filter.MaxPrice, 64)
+		info.PricePrecision = filterDecimals(filter.TickSize)
+	}
+	if filter := symbol.LotSizeFilter(); filter != nil {
+		info.StepSize, _ = strconv.ParseFloat(filter.StepSize, 64)
+		info.QuantityPrecision = filterDecimals(filter.StepSize)
+		info.MinQuantity, _ = strconv.ParseFloat(filter.MinQuantity, 64)
+		info.MaxQuantity, _ = strconv.ParseFloat(filter.MaxQuantity, 64)
+	}
+	if filter := symbol.MinNotionalFilter(); filter != nil {
+		info.MinNotional, _ = strconv.ParseFloat(filter.MinNotional, 64)
+	}
+	// the current exchange info sends a NOTIONAL filter instead of MIN_NOTIONAL
+	for _, filter := range symbol.Filters {
+		if filter["filterType"] != SymbolFilterTypeNotional {
+			continue
+		}
+		if value, ok := filter["minNotional"].(string); ok {
+			info.MinNotional, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+	return info
+}
+
+// SymbolFilterTypeNotional is the filter of the min and max notional of the spot orders, which replaced MIN_NOTIONAL
+const SymbolFilterTypeNotional = "NOTIONAL"
+
+// filterDecimals returns the decimals of a tick or step size of the exchange info, e.g. 2 for "0.01000000"
+func filterDecimals(size string) int {
+	i := strings.Index(size, ".")
+	if i < 0 {
+		return 0
+	}
+	return len(strings.TrimRight(size[i+1:], "0"))
+}
+
 func MarketStatsFromEvent(event *binance.WsMarketStatEvent) model.MarketStats24h {
 	stat := model.MarketStats24h{
 		Symbol:             event.Symbol,
@@ -0,0 +1,253 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+// QuoteQuantityDecimals is the precision of the quote amount of market orders, the quote asset precision of the
+// spot symbols
+const QuoteQuantityDecimals = 8
+
+// Balances returns the balances of the account, assets without any balance are left out
+func (b *Binance) Balances(ctx context.Context) (map[string]model.Balance, error) {
+	account, err := b.client.NewGetAccountService().Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance account", "error", err)
+		return nil, err
+	}
+
+	var balances = make(map[string]model.Balance)
+	for _, item := range account.Balances {
+		balance := model.Balance{Asset: item.Asset}
+		balance.Free, _ = strconv.ParseFloat(item.Free, 64)
+		balance.Locked, _ = strconv.ParseFloat(item.Locked, 64)
+		if balance.Total() > 0 {
+			balances[item.Asset] = balance
+		}
+	}
+	return balances, nil
+}
+
+// OrderMarket places a market order of quantity rounded down to the lot step size
+func (b *Binance) OrderMarket(ctx context.Context, side model.SideType, symbol string, quantity float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeMarket)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity = info.FloorQuantity(quantity)
+
+	// the value of a market order is estimated with the last price
+	var lastPrice float64
+	if info.MinNotional > 0 {
+		if lastPrice, err = b.lastPrice(ctx, symbol); err != nil {
+			return model.Order{}, err
+		}
+	}
+	if err := info.ValidateOrder(quantity, 0, lastPrice); err != nil {
+		return model.Order{}, fmt.Errorf("%s market order: %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(binance.SideType(side)).
+		Type(binance.OrderTypeMarket).
+		Quantity(info.FormatQuantity(quantity)))
+}
+
+func (b *Binance) OrderMarketQuote(ctx context.Context, side model.SideType, symbol string, quoteQuantity float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeMarket)
+	if err != nil {
+		return model.Order{}, err
+	}
+	precision := math.Pow10(QuoteQuantityDecimals)
+	quoteQuantity = math.Floor(quoteQuantity*precision) / precision
+	if quoteQuantity <= 0 {
+		return model.Order{}, fmt.Errorf("%s market order: %w: %v", symbol, model.ErrInvalidQuantity, quoteQuantity)
+	}
+	if quoteQuantity < info.MinNotional {
+		return model.Order{}, fmt.Errorf("%s market order: %w: %v is lower than %v", symbol, model.ErrMinNotional,
+			quoteQuantity, info.MinNotional)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(binance.SideType(side)).
+		Type(binance.OrderTypeMarket).
+		QuoteOrderQty(strconv.FormatFloat(quoteQuantity, 'f', QuoteQuantityDecimals, 64)))
+}
+
+// OrderLimit places a good till canceled limit order, the price is rounded to the tick size and the quantity down
+// to the lot step size
+func (b *Binance) OrderLimit(ctx context.Context, side model.SideType, symbol string, quantity, price float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeLimit)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity, price = info.FloorQuantity(quantity), info.RoundPrice(price)
+	if err := info.ValidateOrder(quantity, price, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s limit order: %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(binance.SideType(side)).
+		Type(binance.OrderTypeLimit).
+		TimeInForce(binance.TimeInForceTypeGTC).
+		Quantity(info.FormatQuantity(quantity)).
+		Price(info.FormatPrice(price)))
+}
+
+// OrderStop places a good till canceled stop loss limit order, rounded like OrderLimit
+func (b *Binance) OrderStop(ctx context.Context, side model.SideType, symbol string, quantity, stopPrice, price float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeStopLossLimit)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity, stopPrice, price = info.FloorQuantity(quantity), info.RoundPrice(stopPrice), info.RoundPrice(price)
+	if err := info.ValidateOrder(quantity, price, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s stop order: %w", symbol, err)
+	}
+	if err := info.ValidateOrder(quantity, stopPrice, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s stop order: stop %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(binance.SideType(side)).
+		Type(binance.OrderTypeStopLossLimit).
+		TimeInForce(binance.TimeInForceTypeGTC).
+		Quantity(info.FormatQuantity(quantity)).
+		StopPrice(info.FormatPrice(stopPrice)).
+		Price(info.FormatPrice(price)))
+}
+
+func (b *Binance) Cancel(ctx context.Context, symbol string, id int64) (model.Order, error) {
+	resp, err := b.client.NewCancelOrderService().Symbol(symbol).OrderID(id).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error cancel binance order", "error", err, "symbol", symbol, "id", id)
+		return model.Order{}, err
+	}
+	order := newOrder(resp.Symbol, resp.OrderID, resp.ClientOrderID, resp.Side, resp.Type, resp.Status, resp.Price,
+		resp.OrigQuantity, resp.ExecutedQuantity, resp.CummulativeQuoteQuantity)
+	order.UpdatedAt = time.Unix(0, resp.TransactTime*int64(time.Millisecond))
+	b.l.Infow("binance order canceled", "symbol", symbol, "id", id)
+	return order, nil
+}
+
+func (b *Binance) OpenOrders(ctx context.Context, symbol string) ([]model.Order, error) {
+	resp, err := b.client.NewListOpenOrdersService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error list binance open orders", "error", err, "symbol", symbol)
+		return nil, err
+	}
+	var orders = make([]model.Order, 0, len(resp))
+	for _, item := range resp {
+		orders = append(orders, OrderFromBinance(item))
+	}
+	return orders, nil
+}
+
+func (b *Binance) Order(ctx context.Context, symbol string, id int64) (model.Order, error) {
+	resp, err := b.client.NewGetOrderService().Symbol(symbol).OrderID(id).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance order", "error", err, "symbol", symbol, "id", id)
+		return model.Order{}, err
+	}
+	return OrderFromBinance(resp), nil
+}
+
+func (b *Binance) createOrder(ctx context.Context, service *binance.CreateOrderService) (model.Order, error) {
+	resp, err := service.Do(ctx)
+	if err != nil {
+		b.l.Errorw("error create binance order", "error", err)
+		return model.Order{}, err
+	}
+	order := newOrder(resp.Symbol, resp.OrderID, resp.ClientOrderID, resp.Side, resp.Type, resp.Status, resp.Price,
+		resp.OrigQuantity, resp.ExecutedQuantity, resp.CummulativeQuoteQuantity)
+	order.CreatedAt = time.Unix(0, resp.TransactTime*int64(time.Millisecond))
+	order.UpdatedAt = order.CreatedAt
+	b.l.Infow("binance order created", "symbol", order.Symbol, "id", order.ID, "side", order.Side,
+		"type", order.Type, "status", order.Status, "quantity", order.Quantity, "price", order.Price)
+	return order, nil
+}
+
+// orderSymbolInfo returns the filters of the symbol once spot orders of orderType are known to be allowed
+func (b *Binance) orderSymbolInfo(ctx context.Context, symbol string, orderType model.OrderType) (model.SymbolInfo, error) {
+	info, err := b.symbolInfo(ctx, symbol)
+	if err != nil {
+		return model.SymbolInfo{}, err
+	}
+	if err := info.ValidatePermission(model.PermissionSpot); err != nil {
+		return model.SymbolInfo{}, fmt.Errorf("%s: %w", symbol, err)
+	}
+	if err := info.ValidateOrderType(orderType); err != nil {
+		return model.SymbolInfo{}, fmt.Errorf("%s: %w", symbol, err)
+	}
+	return info, nil
+}
+
+// symbolInfo returns the filters of the symbol, the exchange info is fetched when the symbol is not known yet
+func (b *Binance) symbolInfo(ctx context.Context, symbol string) (model.SymbolInfo, error) {
+	b.symbolsMutex.RLock()
+	info, ok := b.symbols[symbol]
+	b.symbolsMutex.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	if _, err := b.GetExchangeInfo(ctx); err != nil {
+		return model.SymbolInfo{}, err
+	}
+	b.symbolsMutex.RLock()
+	info, ok = b.symbols[symbol]
+	b.symbolsMutex.RUnlock()
+	if !ok {
+		return model.SymbolInfo{}, fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+	return info, nil
+}
+
+func (b *Binance) lastPrice(ctx context.Context, symbol string) (float64, error) {
+	prices, err := b.client.NewListPricesService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance price", "error", err, "symbol", symbol)
+		return 0, err
+	}
+	if len(prices) == 0 {
+		return 0, fmt.Errorf("%w: no price for %s", ErrUnknownSymbol, symbol)
+	}
+	return strconv.ParseFloat(prices[0].Price, 64)
+}
+
+func OrderFromBinance(o *binance.Order) model.Order {
+	order := newOrder(o.Symbol, o.OrderID, o.ClientOrderID, o.Side, o.Type, o.Status, o.Price, o.OrigQuantity,
+		o.ExecutedQuantity, o.CummulativeQuoteQuantity)
+	order.StopPrice, _ = strconv.ParseFloat(o.StopPrice, 64)
+	order.CreatedAt = time.Unix(0, o.Time*int64(time.Millisecond))
+	order.UpdatedAt = time.Unix(0, o.UpdateTime*int64(time.Millisecond))
+	return order
+}
+
+func newOrder(symbol string, id int64, clientOrderID string, side binance.SideType, orderType binance.OrderType,
+	status binance.OrderStatusType, price, quantity, executedQuantity, quoteQuantity string) model.Order {
+	order := model.Order{
+		ID:            id,
+		ClientOrderID: clientOrderID,
+		Symbol:        symbol,
+		Side:          model.SideType(side),
+		Type:          model.OrderType(orderType),
+		Status:        model.OrderStatus(status),
+	}
+	order.Price, _ = strconv.ParseFloat(price, 64)
+	order.Quantity, _ = strconv.ParseFloat(quantity, 64)
+	order.ExecutedQuantity, _ = strconv.ParseFloat(executedQuantity, 64)
+	order.QuoteQuantity, _ = strconv.ParseFloat(quoteQuantity, 64)
+	return order
+}
@@ -0,0 +1,684 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// BinanceMarketFlag selects the market of the exchange, spot or futures
+	BinanceMarketFlag = "binance.market"
+	// BinanceFuturesApiEndpointFlag is the REST endpoint of the USDT-M futures market
+	BinanceFuturesApiEndpointFlag = "binance.futures_api_endpoint"
+	// BinanceFuturesWsEndpointFlag is the websocket endpoint of the USDT-M futures market
+	BinanceFuturesWsEndpointFlag = "binance.futures_ws_endpoint"
+	// BinanceFuturesPriceFlag selects the price the futures candles are built from, last or mark
+	BinanceFuturesPriceFlag = "binance.futures_price"
+)
+
+const (
+	MarketSpot    = "spot"
+	MarketFutures = "futures"
+
+	FuturesPriceLast = "last"
+	FuturesPriceMark = "mark"
+)
+
+const (
+	// MaxFundingRateLimit is the maximum number of funding rates returned by a request
+	MaxFundingRateLimit = 1000
+	// OpenInterestPollInterval is the delay between two requests of the open interest of a symbol, the open
+	// interest has no websocket stream
+	OpenInterestPollInterval = time.Minute
+)
+
+const (
+	DefaultBinanceFuturesApiEndpoint = "https://fapi.binance.com"
+	DefaultBinanceFuturesWsEndpoint  = "wss://fstream.binance.com"
+)
+
+// FuturesFeeder is a Feeder of a futures market, which also streams the mark price and funding rate of its contracts
+type FuturesFeeder interface {
+	Feeder
+	MarkPriceSubscription(ctx context.Context, symbols []string, markPriceCh chan<- model.MarkPrice, errCh chan<- error)
+}
+
+// NewBinanceExchange creates the exchange of the configured market
+func NewBinanceExchange() (Exchange, error) {
+	market := viper.GetString(BinanceMarketFlag)
+	switch market {
+	case "", MarketSpot:
+		b, err := NewBinance()
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case MarketFutures:
+		b, err := NewBinanceFutures()
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown binance market %q", market)
+}
+
+// BinanceFutures is the USDT-M futures market of Binance. Its candles are built from the last price by default, or
+// from the mark price when configured so, which is the price liquidations are triggered at.
+type BinanceFutures struct {
+	binanceStreams
+
+	client        *futures.Client
+	httpClient    *http.Client
+	apiEndpoint   string
+	weightLimiter *WeightLimiter
+	markPrice     bool
+
+	symbolsMutex sync.RWMutex
+	symbols      map[string]model.SymbolInfo // filters checked before placing orders
+}
+
+func NewBinanceFutures() (*BinanceFutures, error) {
+	l := zap.S()
+	apiKey := viper.GetString(BinanceApiKeyFlag)
+	if err := validation.Validate(apiKey, validation.Required); err != nil {
+		l.Errorw("invalid binance api key", "error", err, "api_key", apiKey)
+		return nil, err
+	}
+	apiSecret := viper.GetString(BinanceApiSecretFlag)
+	if err := validation.Validate(apiSecret, validation.Required); err != nil {
+		l.Errorw("invalid binance api secret", "error", err, "api_secret", apiSecret)
+		return nil, err
+	}
+	apiEndpoint := viper.GetString(BinanceFuturesApiEndpointFlag)
+	if apiEndpoint == "" {
+		apiEndpoint = DefaultBinanceFuturesApiEndpoint
+	}
+	wsEndpoint := viper.GetString(BinanceFuturesWsEndpointFlag)
+	if wsEndpoint == "" {
+		wsEndpoint = DefaultBinanceFuturesWsEndpoint
+	}
+	price := viper.GetString(BinanceFuturesPriceFlag)
+	if err := validation.Validate(price, validation.In("", FuturesPriceLast, FuturesPriceMark)); err != nil {
+		l.Errorw("invalid binance futures price", "error", err, "price", price)
+		return nil, err
+	}
+
+	// the futures weights are counted apart from the spot ones
+	weightLimiter := NewWeightLimiter(http.DefaultTransport)
+	httpClient := &http.Client{Transport: weightLimiter}
+	client := futures.NewClient(apiKey, apiSecret)
+	client.BaseURL = apiEndpoint
+	client.HTTPClient = httpClient
+
+	b := &BinanceFutures{
+		binanceStreams: binanceStreams{l: l, wsEndpoint: wsEndpoint},
+		client:         client,
+		httpClient:     httpClient,
+		apiEndpoint:    strings.TrimSuffix(apiEndpoint, "/"),
+		weightLimiter:  weightLimiter,
+		markPrice:      price == FuturesPriceMark,
+		symbols:        make(map[string]model.SymbolInfo),
+	}
+
+	// test ping
+	if err := b.client.NewPingService().Do(context.Background()); err != nil {
+		l.Errorw("error ping to binance futures", "error", err)
+		return nil, err
+	}
+	return b, nil
+}
+
+// RateLimitStats returns the state of the REST request weight limiter
+func (b *BinanceFutures) RateLimitStats() RateLimitStats {
+	return b.weightLimiter.Stats()
+}
+
+func (b *BinanceFutures) GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, error) {
+	resp, err := b.client.NewExchangeInfoService().Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance futures exchange info", "error", err)
+		return model.ExchangeInfo{}, err
+	}
+
+	for _, rateLimit := range resp.RateLimits {
+		if rateLimit.RateLimitType == "REQUEST_WEIGHT" && rateLimit.Interval == "MINUTE" && rateLimit.IntervalNum == 1 {
+			b.weightLimiter.SetWeightLimit(int(rateLimit.Limit))
+		}
+	}
+
+	var symbolInfo = make([]model.SymbolInfo, 0)
+	for _, item := range resp.Symbols {
+		symbolInfo = append(symbolInfo, SymbolInfoFromFutures(item))
+	}
+
+	b.symbolsMutex.Lock()
+	for _, info := range symbolInfo {
+		b.symbols[info.Symbol] = info
+	}
+	b.symbolsMutex.Unlock()
+
+	return model.ExchangeInfo{Symbols: symbolInfo}, nil
+}
+
+// CandlesByLimit returns the last limit candles of the configured price
+func (b *BinanceFutures) CandlesByLimit(ctx context.Context, symbol string, timeframe model.Timeframe, limit int) ([]model.Candle, error) {
+	return candlesByLimit(limit, func(start, end time.Time, limit int) ([]model.Candle, error) {
+		return b.klines(ctx, symbol, timeframe, start, end, limit)
+	})
+}
+
+// CandlesByPeriod returns the candles of the configured price opened between start and end
+func (b *BinanceFutures) CandlesByPeriod(ctx context.Context, symbol string, period model.Timeframe, start, end time.Time) ([]model.Candle, error) {
+	return candlesByPeriod(start, end, func(start, end time.Time, limit int) ([]model.Candle, error) {
+		return b.klines(ctx, symbol, period, start, end, limit)
+	})
+}
+
+// FirstCandle returns the oldest candle of the configured price
+func (b *BinanceFutures) FirstCandle(ctx context.Context, symbol string, timeframe model.Timeframe) (model.Candle, error) {
+	return firstCandle(symbol, timeframe, func(start, end time.Time, limit int) ([]model.Candle, error) {
+		return b.klines(ctx, symbol, timeframe, start, end, limit)
+	})
+}
+
+// klines requests a single page of candles. The futures client has no mark price klines service, so both kinds of
+// klines are requested directly, they share the same payload.
+func (b *BinanceFutures) klines(ctx context.Context, symbol string, timeframe model.Timeframe, start, end time.Time, limit int) ([]model.Candle, error) {
+	path := "/fapi/v1/klines"
+	if b.markPrice {
+		path = "/fapi/v1/markPriceKlines"
+	}
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("interval", timeframe.String())
+	params.Set("limit", strconv.Itoa(limit))
+	if !start.IsZero() {
+		params.Set("startTime", strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10))
+	}
+	if !end.IsZero() {
+		params.Set("endTime", strconv.FormatInt(end.UnixNano()/int64(time.Millisecond), 10))
+	}
+
+	var rows [][]interface{}
+	if err := b.get(ctx, path, params, &rows); err != nil {
+		return nil, err
+	}
+	candles := make([]model.Candle, 0, len(rows))
+	for _, row := range rows {
+		candle, err := candleFromFuturesKline(symbol, timeframe, row)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+// get requests a public endpoint and decodes its JSON response, API errors are returned as *common.APIError
+func (b *BinanceFutures) get(ctx context.Context, path string, params url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.apiEndpoint+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := new(common.APIError)
+		if err := json.Unmarshal(data, apiErr); err != nil {
+			return fmt.Errorf("%s: status %d: %s", path, resp.StatusCode, data)
+		}
+		return apiErr
+	}
+	return json.Unmarshal(data, v)
+}
+
+// candleFromFuturesKline parses a kline row, [open time, open, high, low, close, volume, close time, quote volume,
+// trades, ...]
+func candleFromFuturesKline(symbol string, timeframe model.Timeframe, row []interface{}) (model.Candle, error) {
+	if len(row) < 9 {
+		return model.Candle{}, fmt.Errorf("invalid kline: %v", row)
+	}
+	openTime, _ := row[0].(float64)
+	closeTime, _ := row[6].(float64)
+	trades, _ := row[8].(float64)
+	candle := model.Candle{
+		Symbol:    symbol,
+		Timeframe: timeframe,
+		Time:      time.Unix(0, int64(openTime)*int64(time.Millisecond)),
+		Trades:    int64(trades),
+		// the most recent kline is still open until its close time
+		Complete: time.Unix(0, int64(closeTime)*int64(time.Millisecond)).Before(time.Now()),
+	}
+	var values = make([]float64, 5)
+	for i := range values {
+		value, ok := row[i+1].(string)
+		if !ok {
+			return model.Candle{}, fmt.Errorf("invalid kline: %v", row)
+		}
+		values[i], _ = strconv.ParseFloat(value, 64)
+	}
+	candle.Open, candle.High, candle.Low, candle.Close, candle.Volume = values[0], values[1], values[2], values[3], values[4]
+	return candle, nil
+}
+
+// FundingRates returns the funding rates settled between start and end, at most MaxFundingRateLimit of them
+func (b *BinanceFutures) FundingRates(ctx context.Context, symbol string, start, end time.Time) ([]model.FundingRate, error) {
+	service := b.client.NewFundingRateService().Symbol(symbol).Limit(MaxFundingRateLimit)
+	if !start.IsZero() {
+		service.StartTime(start.UnixNano() / int64(time.Millisecond))
+	}
+	if !end.IsZero() {
+		service.EndTime(end.UnixNano() / int64(time.Millisecond))
+	}
+	data, err := service.Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance futures funding rates", "error", err, "symbol", symbol)
+		return nil, err
+	}
+
+	var rates = make([]model.FundingRate, 0, len(data))
+	for _, item := range data {
+		rate := model.FundingRate{
+			Symbol:      item.Symbol,
+			FundingTime: time.Unix(0, item.FundingTime*int64(time.Millisecond)),
+		}
+		rate.FundingRate, _ = strconv.ParseFloat(item.FundingRate, 64)
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// OpenInterest returns the current open interest of the symbol, the futures client has no service for it
+func (b *BinanceFutures) OpenInterest(ctx context.Context, symbol string) (model.OpenInterest, error) {
+	var resp struct {
+		Symbol       string `json:"symbol"`
+		OpenInterest string `json:"openInterest"`
+		Time         int64  `json:"time"`
+	}
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	if err := b.get(ctx, "/fapi/v1/openInterest", params, &resp); err != nil {
+		return model.OpenInterest{}, err
+	}
+
+	openInterest := model.OpenInterest{
+		Symbol: resp.Symbol,
+		Time:   time.Unix(0, resp.Time*int64(time.Millisecond)),
+	}
+	openInterest.OpenInterest, _ = strconv.ParseFloat(resp.OpenInterest, 64)
+	return openInterest, nil
+}
+
+// OpenInterestSubscription polls the open interest of the symbols every OpenInterestPollInterval until ctx is done.
+// The symbols failing to be requested are skipped until the next poll.
+func (b *BinanceFutures) OpenInterestSubscription(ctx context.Context, symbols []string, openInterestCh chan<- model.OpenInterest, errCh chan<- error) {
+	b.l.Debugw("binance futures open interest subscription", "symbols", len(symbols))
+	ticker := time.NewTicker(OpenInterestPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, symbol := range symbols {
+			openInterest, err := b.OpenInterest(ctx, symbol)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.l.Warnw("poll open interest error", "error", err, "symbol", symbol)
+				continue
+			}
+			select {
+			case openInterestCh <- openInterest:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CombinedLiquidationsSubscription subscribe the liquidation orders of multiple symbols, the exchange pushes at most
+// one liquidation per symbol every second
+func (b *BinanceFutures) CombinedLiquidationsSubscription(ctx context.Context, symbols []string, liquidationCh chan<- model.Liquidation, errCh chan<- error) {
+	if len(symbols) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(symbols), MaxStreamsPerConnection))
+		return
+	}
+	handler := func(event *futures.WsLiquidationOrderEvent) {
+		select {
+		case liquidationCh <- LiquidationFromEvent(event):
+		case <-ctx.Done():
+		}
+	}
+	b.l.Debugw("binance futures liquidations subscription", "streams", len(symbols))
+	b.serveSubscription(ctx, "liquidations subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsCombinedForceOrderServe(b.wsEndpoint, symbols, handler, errHandler)
+	}, errCh)
+}
+
+// MarketStats returns the 24h statistics of every contract
+func (b *BinanceFutures) MarketStats(ctx context.Context) ([]model.MarketStats24h, error) {
+	data, err := b.client.NewListPriceChangeStatsService().Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance futures market stats", "error", err)
+		return nil, err
+	}
+
+	var stats = make([]model.MarketStats24h, 0, len(data))
+	for _, item := range data {
+		stat := model.MarketStats24h{
+			Symbol:             item.Symbol,
+			PriceChange:        item.PriceChange,
+			PriceChangePercent: item.PriceChangePercent,
+			OpenTime:           time.Unix(0, item.OpenTime*int64(time.Millisecond)),
+			CloseTime:          time.Unix(0, item.CloseTime*int64(time.Millisecond)),
+			FirstTradeId:       item.FristID,
+			LastTradeId:        item.LastID,
+			TotalTrades:        item.Count,
+		}
+		stat.LastPrice, _ = strconv.ParseFloat(item.LastPrice, 64)
+		stat.LastQty, _ = strconv.ParseFloat(item.LastQuantity, 64)
+		stat.BaseVolume, _ = strconv.ParseFloat(item.Volume, 64)
+		stat.QuoteVolume, _ = strconv.ParseFloat(item.QuoteVolume, 64)
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// CandlesSubscription subscribe klines of the last price, or builds candles from the mark price stream. Mark price
+// candles have no volume.
+func (b *BinanceFutures) CandlesSubscription(ctx context.Context, symbol string, timeframe model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	if !b.markPrice {
+		b.binanceStreams.CandlesSubscription(ctx, symbol, timeframe, candleCh, errCh)
+		return
+	}
+	b.CombinedCandlesSubscription(ctx, map[string]model.Timeframe{symbol: timeframe}, candleCh, errCh)
+}
+
+func (b *BinanceFutures) CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	if !b.markPrice {
+		b.binanceStreams.CombinedCandlesSubscription(ctx, mapSymbolTimeframe, candleCh, errCh)
+		return
+	}
+	if len(mapSymbolTimeframe) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(mapSymbolTimeframe), MaxStreamsPerConnection))
+		return
+	}
+
+	var (
+		symbols    = make([]string, 0, len(mapSymbolTimeframe))
+		timeframes = make(map[string]model.Timeframe, len(mapSymbolTimeframe))
+	)
+	for symbol, timeframe := range mapSymbolTimeframe {
+		symbols = append(symbols, symbol)
+		timeframes[strings.ToUpper(symbol)] = timeframe
+	}
+	builder := newMarkPriceCandles(timeframes, b.openMarkPriceCandles(ctx, timeframes),
+		func(symbol string, timeframe model.Timeframe, openTime time.Time) (model.Candle, bool) {
+			return b.closedMarkPriceCandle(ctx, symbol, timeframe, openTime)
+		})
+	if ctx.Err() != nil {
+		return
+	}
+	handler := func(event *futures.WsMarkPriceEvent) {
+		for _, candle := range builder.update(MarkPriceFromEvent(event)) {
+			select {
+			case candleCh <- candle:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	b.l.Debugw("binance futures mark price candle subscription", "streams", len(symbols))
+	b.serveSubscription(ctx, "mark price candles subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsCombinedMarkPriceServe(b.wsEndpoint, symbols, handler, errHandler)
+	}, errCh)
+}
+
+// openMarkPriceCandles returns the mark price candle open on the exchange for each symbol, the symbols whose candle
+// cannot be fetched are skipped
+func (b *BinanceFutures) openMarkPriceCandles(ctx context.Context, timeframes map[string]model.Timeframe) map[string]model.Candle {
+	var candles = make(map[string]model.Candle, len(timeframes))
+	for symbol, timeframe := range timeframes {
+		last, err := b.klines(ctx, symbol, timeframe, time.Time{}, time.Time{}, 1)
+		if err != nil {
+			if ctx.Err() != nil {
+				return candles
+			}
+			b.l.Warnw("fetch open mark price candle error", "error", err, "symbol", symbol, "timeframe", timeframe)
+			continue
+		}
+		if len(last) > 0 {
+			candles[symbol] = last[0]
+		}
+	}
+	return candles
+}
+
+// closedMarkPriceCandle returns the mark price candle opened at openTime, once closed
+func (b *BinanceFutures) closedMarkPriceCandle(ctx context.Context, symbol string, timeframe model.Timeframe, openTime time.Time) (model.Candle, bool) {
+	candles, err := b.klines(ctx, symbol, timeframe, openTime, openTime, 1)
+	if err != nil {
+		b.l.Warnw("fetch closed mark price candle error", "error", err, "symbol", symbol, "timeframe", timeframe)
+		return model.Candle{}, false
+	}
+	if len(candles) == 0 || !candles[0].Time.Equal(openTime) {
+		b.l.Warnw("closed mark price candle not found", "symbol", symbol, "timeframe", timeframe, "time", openTime)
+		return model.Candle{}, false
+	}
+	return candles[0], true
+}
+
+// MarkPriceSubscription subscribe the mark price and funding rate of multiple symbols, updated every second
+func (b *BinanceFutures) MarkPriceSubscription(ctx context.Context, symbols []string, markPriceCh chan<- model.MarkPrice, errCh chan<- error) {
+	if len(symbols) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(symbols), MaxStreamsPerConnection))
+		return
+	}
+	handler := func(event *futures.WsMarkPriceEvent) {
+		select {
+		case markPriceCh <- MarkPriceFromEvent(event):
+		case <-ctx.Done():
+		}
+	}
+	b.l.Debugw("binance futures mark price subscription", "streams", len(symbols))
+	b.serveSubscription(ctx, "mark price subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsCombinedMarkPriceServe(b.wsEndpoint, symbols, handler, errHandler)
+	}, errCh)
+}
+
+// OrderBookSubscription keeps the order books in sync with the futures depth streams, see Binance.OrderBookSubscription
+func (b *BinanceFutures) OrderBookSubscription(ctx context.Context, books []*model.OrderBook, errCh chan<- error) {
+	b.orderBookSubscription(ctx, books, b.depthSnapshot, errCh)
+}
+
+func (b *BinanceFutures) depthSnapshot(ctx context.Context, symbol string) (int64, []model.PriceLevel, []model.PriceLevel, error) {
+	snapshot, err := b.client.NewDepthService().Symbol(symbol).Limit(DepthSnapshotLimit).Do(ctx)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return snapshot.LastUpdateID, levelsFromBinance(snapshot.Bids), levelsFromBinance(snapshot.Asks), nil
+}
+
+// markPriceCandles builds the candles of each symbol from its mark price updates. Every update emits the open
+// candle, the previous candle is emitted again as complete when the first update of the next one is received.
+// The stream starts in the middle of a candle, so the first candle of each symbol is seeded with the open candle
+// fetched from the exchange. A first candle without seed missed the updates since its open, so the closed candle of
+// the exchange is emitted instead once it completes, or none when it cannot be fetched.
+type markPriceCandles struct {
+	timeframes map[string]model.Timeframe
+	seeds      map[string]model.Candle
+	closed     func(symbol string, timeframe model.Timeframe, openTime time.Time) (model.Candle, bool)
+	candles    map[string]model.Candle
+	partial    map[string]bool // symbols whose open candle missed updates
+}
+
+func newMarkPriceCandles(timeframes map[string]model.Timeframe, seeds map[string]model.Candle,
+	closed func(symbol string, timeframe model.Timeframe, openTime time.Time) (model.Candle, bool)) *markPriceCandles {
+	return &markPriceCandles{
+		timeframes: timeframes,
+		seeds:      seeds,
+		closed:     closed,
+		candles:    make(map[string]model.Candle, len(timeframes)),
+		partial:    make(map[string]bool),
+	}
+}
+
+func (m *markPriceCandles) update(markPrice model.MarkPrice) []model.Candle {
+	timeframe, ok := m.timeframes[markPrice.Symbol]
+	if !ok || markPrice.MarkPrice == 0 {
+		return nil
+	}
+	var (
+		result   = make([]model.Candle, 0, 2)
+		openTime = timeframe.OpenTime(markPrice.Time)
+	)
+	candle, ok := m.candles[markPrice.Symbol]
+	switch {
+	case !ok:
+		candle = m.open(markPrice, timeframe, openTime)
+	case openTime.After(candle.Time):
+		complete := !m.partial[markPrice.Symbol]
+		if !complete {
+			delete(m.partial, markPrice.Symbol)
+			candle, complete = m.closed(markPrice.Symbol, timeframe, candle.Time)
+		}
+		if complete {
+			candle.Complete = true
+			candle.EventTime = markPrice.Time
+			result = append(result, candle)
+		}
+		candle = newMarkPriceCandle(markPrice, timeframe, openTime)
+	}
+	candle.High = math.Max(candle.High, markPrice.MarkPrice)
+	candle.Low = math.Min(candle.Low, markPrice.MarkPrice)
+	candle.Close = markPrice.MarkPrice
+	candle.EventTime = markPrice.Time
+	m.candles[markPrice.Symbol] = candle
+	return append(result, candle)
+}
+
+// open returns the first candle of the symbol, merged with its seed when the seed is the same candle
+func (m *markPriceCandles) open(markPrice model.MarkPrice, timeframe model.Timeframe, openTime time.Time) model.Candle {
+	candle := newMarkPriceCandle(markPrice, timeframe, openTime)
+	seed, ok := m.seeds[markPrice.Symbol]
+	switch {
+	case ok && seed.Time.Equal(openTime):
+		candle.Open, candle.High, candle.Low = seed.Open, seed.High, seed.Low
+	case ok && seed.Time.Before(openTime), markPrice.Time.Equal(openTime):
+		// the candle opened after the stream started
+	default:
+		m.partial[markPrice.Symbol] = true
+	}
+	return candle
+}
+
+func newMarkPriceCandle(markPrice model.MarkPrice, timeframe model.Timeframe, openTime time.Time) model.Candle {
+	return model.Candle{
+		Symbol:    markPrice.Symbol,
+		Timeframe: timeframe,
+		Time:      openTime,
+		Open:      markPrice.MarkPrice,
+		High:      markPrice.MarkPrice,
+		Low:       markPrice.MarkPrice,
+	}
+}
+
+func MarkPriceFromEvent(event *futures.WsMarkPriceEvent) model.MarkPrice {
+	markPrice := model.MarkPrice{
+		Symbol:          event.Symbol,
+		NextFundingTime: time.Unix(0, event.NextFundingTime*int64(time.Millisecond)),
+		Time:            time.Unix(0, event.Time*int64(time.Millisecond)),
+	}
+	markPrice.MarkPrice, _ = strconv.ParseFloat(event.MarkPrice, 64)
+	markPrice.IndexPrice, _ = strconv.ParseFloat(event.IndexPrice, 64)
+	markPrice.FundingRate, _ = strconv.ParseFloat(event.FundingRate, 64)
+	return markPrice
+}
+
+// LiquidationFromEvent converts a liquidation order, its average price and filled quantity are used once known
+func LiquidationFromEvent(event *futures.WsLiquidationOrderEvent) model.Liquidation {
+	order := event.LiquidationOrder
+	liquidation := model.Liquidation{
+		Symbol: order.Symbol,
+		Side:   model.SideType(order.Side),
+		Time:   time.Unix(0, order.TradeTime*int64(time.Millisecond)),
+	}
+	liquidation.Price, _ = strconv.ParseFloat(order.AvgPrice, 64)
+	if liquidation.Price == 0 {
+		liquidation.Price, _ = strconv.ParseFloat(order.Price, 64)
+	}
+	liquidation.Quantity, _ = strconv.ParseFloat(order.AccumulatedFilledQty, 64)
+	if liquidation.Quantity == 0 {
+		liquidation.Quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
+	}
+	return liquidation
+}
+
+func SymbolInfoFromFutures(symbol futures.Symbol) model.SymbolInfo {
+	info := model.SymbolInfo{
+		Symbol:       symbol.Symbol,
+		BaseAsset:    symbol.BaseAsset,
+		QuoteAsset:   symbol.QuoteAsset,
+		Status:       symbol.Status,
+		ContractType: string(symbol.ContractType),
+		MarginAsset:  symbol.MarginAsset,
+
+		PricePrecision:    symbol.PricePrecision,
+		QuantityPrecision: symbol.QuantityPrecision,
+	}
+	for _, orderType := range symbol.OrderType {
+		// stop limit orders are named STOP on futures markets
+		if orderType == futures.OrderTypeStop {
+			info.OrderTypes = append(info.OrderTypes, model.OrderTypeStopLossLimit)
+			continue
+		}
+		info.OrderTypes = append(info.OrderTypes, model.OrderType(orderType))
+	}
+	if symbol.OnboardDate > 0 {
+		info.OnboardDate = time.Unix(0, symbol.OnboardDate*int64(time.Millisecond))
+	}
+	if filter := symbol.PriceFilter(); filter != nil {
+		info.TickSize, _ = strconv.ParseFloat(filter.TickSize, 64)
+		info.MinPrice, _ = strconv.ParseFloat(filter.MinPrice, 64)
+		info.MaxPrice, _ = strconv.ParseFloat(filter.MaxPrice, 64)
+	}
+	if filter := symbol.LotSizeFilter(); filter != nil {
+		info.StepSize, _ = strconv.ParseFloat(filter.StepSize, 64)
+		info.MinQuantity, _ = strconv.ParseFloat(filter.MinQuantity, 64)
+		info.MaxQuantity, _ = strconv.ParseFloat(filter.MaxQuantity, 64)
+	}
+	if filter := symbol.MinNotionalFilter(); filter != nil {
+		info.MinNotional, _ = strconv.ParseFloat(filter.Notional, 64)
+	}
+	return info
+}
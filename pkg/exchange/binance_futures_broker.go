@@ -0,0 +1,250 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+// Balances returns the wallet balances of the margin assets, the margin of the open positions and orders is locked
+func (b *BinanceFutures) Balances(ctx context.Context) (map[string]model.Balance, error) {
+	resp, err := b.client.NewGetBalanceService().Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance futures balance", "error", err)
+		return nil, err
+	}
+
+	var balances = make(map[string]model.Balance)
+	for _, item := range resp {
+		balance := model.Balance{Asset: item.Asset}
+		total, _ := strconv.ParseFloat(item.Balance, 64)
+		balance.Free, _ = strconv.ParseFloat(item.AvailableBalance, 64)
+		balance.Locked = total - balance.Free
+		if balance.Total() > 0 {
+			balances[item.Asset] = balance
+		}
+	}
+	return balances, nil
+}
+
+// OrderMarket places a market order of quantity rounded down to the lot step size
+func (b *BinanceFutures) OrderMarket(ctx context.Context, side model.SideType, symbol string, quantity float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeMarket)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity = info.FloorQuantity(quantity)
+
+	var lastPrice float64
+	if info.MinNotional > 0 {
+		if lastPrice, err = b.lastPrice(ctx, symbol); err != nil {
+			return model.Order{}, err
+		}
+	}
+	if err := info.ValidateOrder(quantity, 0, lastPrice); err != nil {
+		return model.Order{}, fmt.Errorf("%s market order: %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(futures.SideType(side)).
+		Type(futures.OrderTypeMarket).
+		Quantity(info.FormatQuantity(quantity)))
+}
+
+// OrderMarketQuote places a market order of the quantity worth quoteQuantity at the last price, futures orders
+// have no quote quantity
+func (b *BinanceFutures) OrderMarketQuote(ctx context.Context, side model.SideType, symbol string, quoteQuantity float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeMarket)
+	if err != nil {
+		return model.Order{}, err
+	}
+	lastPrice, err := b.lastPrice(ctx, symbol)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity := info.FloorQuantity(quoteQuantity / lastPrice)
+	if err := info.ValidateOrder(quantity, 0, lastPrice); err != nil {
+		return model.Order{}, fmt.Errorf("%s market order: %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(futures.SideType(side)).
+		Type(futures.OrderTypeMarket).
+		Quantity(info.FormatQuantity(quantity)))
+}
+
+// OrderLimit places a good till canceled limit order, the price is rounded to the tick size and the quantity down
+// to the lot step size
+func (b *BinanceFutures) OrderLimit(ctx context.Context, side model.SideType, symbol string, quantity, price float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeLimit)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity, price = info.FloorQuantity(quantity), info.RoundPrice(price)
+	if err := info.ValidateOrder(quantity, price, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s limit order: %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(futures.SideType(side)).
+		Type(futures.OrderTypeLimit).
+		TimeInForce(futures.TimeInForceTypeGTC).
+		Quantity(info.FormatQuantity(quantity)).
+		Price(info.FormatPrice(price)))
+}
+
+// OrderStop places a good till canceled stop limit order, rounded like OrderLimit
+func (b *BinanceFutures) OrderStop(ctx context.Context, side model.SideType, symbol string, quantity, stopPrice, price float64) (model.Order, error) {
+	info, err := b.orderSymbolInfo(ctx, symbol, model.OrderTypeStopLossLimit)
+	if err != nil {
+		return model.Order{}, err
+	}
+	quantity, stopPrice, price = info.FloorQuantity(quantity), info.RoundPrice(stopPrice), info.RoundPrice(price)
+	if err := info.ValidateOrder(quantity, price, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s stop order: %w", symbol, err)
+	}
+	if err := info.ValidateOrder(quantity, stopPrice, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s stop order: stop %w", symbol, err)
+	}
+
+	return b.createOrder(ctx, b.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(futures.SideType(side)).
+		Type(futures.OrderTypeStop).
+		TimeInForce(futures.TimeInForceTypeGTC).
+		Quantity(info.FormatQuantity(quantity)).
+		StopPrice(info.FormatPrice(stopPrice)).
+		Price(info.FormatPrice(price)))
+}
+
+func (b *BinanceFutures) Cancel(ctx context.Context, symbol string, id int64) (model.Order, error) {
+	resp, err := b.client.NewCancelOrderService().Symbol(symbol).OrderID(id).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error cancel binance futures order", "error", err, "symbol", symbol, "id", id)
+		return model.Order{}, err
+	}
+	order := newFuturesOrder(resp.Symbol, resp.OrderID, resp.ClientOrderID, resp.Side, resp.Type, resp.Status,
+		resp.Price, resp.StopPrice, resp.OrigQuantity, resp.ExecutedQuantity, resp.CumQuote)
+	order.UpdatedAt = time.Unix(0, resp.UpdateTime*int64(time.Millisecond))
+	b.l.Infow("binance futures order canceled", "symbol", symbol, "id", id)
+	return order, nil
+}
+
+func (b *BinanceFutures) OpenOrders(ctx context.Context, symbol string) ([]model.Order, error) {
+	resp, err := b.client.NewListOpenOrdersService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error list binance futures open orders", "error", err, "symbol", symbol)
+		return nil, err
+	}
+	var orders = make([]model.Order, 0, len(resp))
+	for _, item := range resp {
+		orders = append(orders, OrderFromFutures(item))
+	}
+	return orders, nil
+}
+
+func (b *BinanceFutures) Order(ctx context.Context, symbol string, id int64) (model.Order, error) {
+	resp, err := b.client.NewGetOrderService().Symbol(symbol).OrderID(id).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance futures order", "error", err, "symbol", symbol, "id", id)
+		return model.Order{}, err
+	}
+	return OrderFromFutures(resp), nil
+}
+
+func (b *BinanceFutures) createOrder(ctx context.Context, service *futures.CreateOrderService) (model.Order, error) {
+	resp, err := service.Do(ctx)
+	if err != nil {
+		b.l.Errorw("error create binance futures order", "error", err)
+		return model.Order{}, err
+	}
+	order := newFuturesOrder(resp.Symbol, resp.OrderID, resp.ClientOrderID, resp.Side, resp.Type, resp.Status,
+		resp.Price, resp.StopPrice, resp.OrigQuantity, resp.ExecutedQuantity, resp.CumQuote)
+	order.CreatedAt = time.Unix(0, resp.UpdateTime*int64(time.Millisecond))
+	order.UpdatedAt = order.CreatedAt
+	b.l.Infow("binance futures order created", "symbol", order.Symbol, "id", order.ID, "side", order.Side,
+		"type", order.Type, "status", order.Status, "quantity", order.Quantity, "price", order.Price)
+	return order, nil
+}
+
+// orderSymbolInfo returns the filters of the symbol once orders of orderType are known to be allowed
+func (b *BinanceFutures) orderSymbolInfo(ctx context.Context, symbol string, orderType model.OrderType) (model.SymbolInfo, error) {
+	info, err := b.symbolInfo(ctx, symbol)
+	if err != nil {
+		return model.SymbolInfo{}, err
+	}
+	if err := info.ValidateOrderType(orderType); err != nil {
+		return model.SymbolInfo{}, fmt.Errorf("%s: %w", symbol, err)
+	}
+	return info, nil
+}
+
+// symbolInfo returns the filters of the symbol, the exchange info is fetched when the symbol is not known yet
+func (b *BinanceFutures) symbolInfo(ctx context.Context, symbol string) (model.SymbolInfo, error) {
+	b.symbolsMutex.RLock()
+	info, ok := b.symbols[symbol]
+	b.symbolsMutex.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	if _, err := b.GetExchangeInfo(ctx); err != nil {
+		return model.SymbolInfo{}, err
+	}
+	b.symbolsMutex.RLock()
+	info, ok = b.symbols[symbol]
+	b.symbolsMutex.RUnlock()
+	if !ok {
+		return model.SymbolInfo{}, fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+	return info, nil
+}
+
+func (b *BinanceFutures) lastPrice(ctx context.Context, symbol string) (float64, error) {
+	prices, err := b.client.NewListPricesService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		b.l.Errorw("error get binance futures price", "error", err, "symbol", symbol)
+		return 0, err
+	}
+	if len(prices) == 0 {
+		return 0, fmt.Errorf("%w: no price for %s", ErrUnknownSymbol, symbol)
+	}
+	return strconv.ParseFloat(prices[0].Price, 64)
+}
+
+func OrderFromFutures(o *futures.Order) model.Order {
+	order := newFuturesOrder(o.Symbol, o.OrderID, o.ClientOrderID, o.Side, o.Type, o.Status, o.Price, o.StopPrice,
+		o.OrigQuantity, o.ExecutedQuantity, o.CumQuote)
+	order.CreatedAt = time.Unix(0, o.Time*int64(time.Millisecond))
+	order.UpdatedAt = time.Unix(0, o.UpdateTime*int64(time.Millisecond))
+	return order
+}
+
+// newFuturesOrder maps the futures stop limit orders to the stop loss limit type of the spot market
+func newFuturesOrder(symbol string, id int64, clientOrderID string, side futures.SideType, orderType futures.OrderType,
+	status futures.OrderStatusType, price, stopPrice, quantity, executedQuantity, quoteQuantity string) model.Order {
+	order := model.Order{
+		ID:            id,
+		ClientOrderID: clientOrderID,
+		Symbol:        symbol,
+		Side:          model.SideType(side),
+		Type:          model.OrderType(orderType),
+		Status:        model.OrderStatus(status),
+	}
+	if orderType == futures.OrderTypeStop {
+		order.Type = model.OrderTypeStopLossLimit
+	}
+	order.Price, _ = strconv.ParseFloat(price, 64)
+	order.StopPrice, _ = strconv.ParseFloat(stopPrice, 64)
+	order.Quantity, _ = strconv.ParseFloat(quantity, 64)
+	order.ExecutedQuantity, _ = strconv.ParseFloat(executedQuantity, 64)
+	order.QuoteQuantity, _ = strconv.ParseFloat(quoteQuantity, 64)
+	return order
+}
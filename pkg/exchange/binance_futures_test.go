@@ -0,0 +1,331 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/exchange/binancetest"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFuturesTestClient(t *testing.T, server *binancetest.Server, price string) *BinanceFutures {
+	viper.Set(BinanceApiKeyFlag, "api-key")
+	viper.Set(BinanceApiSecretFlag, "api-secret")
+	viper.Set(BinanceFuturesApiEndpointFlag, server.URL())
+	viper.Set(BinanceFuturesWsEndpointFlag, server.WsURL())
+	viper.Set(BinanceFuturesPriceFlag, price)
+	defer viper.Set(BinanceFuturesPriceFlag, "")
+
+	client, err := NewBinanceFutures()
+	require.NoError(t, err)
+	return client
+}
+
+func TestBinanceFutures(t *testing.T) {
+	server := binancetest.NewServer()
+	defer server.Close()
+
+	onboardDate := time.Date(2019, 9, 25, 8, 0, 0, 0, time.UTC)
+	server.AddSymbol(model.SymbolInfo{
+		Symbol:      "BTCUSDT",
+		BaseAsset:   "BTC",
+		QuoteAsset:  "USDT",
+		Status:      model.SymbolStatusTrading.String(),
+		OnboardDate: onboardDate,
+		TickSize:    0.1,
+		StepSize:    0.001,
+		MinNotional: 5,
+	})
+	server.AddSymbol(model.SymbolInfo{
+		Symbol:       "BTCUSDT_211231",
+		BaseAsset:    "BTC",
+		QuoteAsset:   "USDT",
+		Status:       model.SymbolStatusTrading.String(),
+		ContractType: "CURRENT_QUARTER",
+	})
+
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		candle := model.Candle{
+			Symbol:    "BTCUSDT",
+			Timeframe: model.Timeframe1h,
+			Time:      start.Add(time.Duration(i) * time.Hour),
+			Open:      100,
+			Close:     101,
+			High:      102,
+			Low:       99,
+			Volume:    10,
+			Trades:    5,
+			Complete:  true,
+		}
+		server.AddKlines(candle)
+		candle.Open, candle.Close, candle.High, candle.Low, candle.Volume, candle.Trades = 200, 201, 202, 199, 0, 0
+		server.AddMarkPriceKlines(candle)
+	}
+
+	t.Run("exchange info", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceLast)
+		info, err := client.GetExchangeInfo(context.Background())
+		require.NoError(t, err)
+		require.Len(t, info.Symbols, 2)
+
+		perpetual := info.Symbols[0]
+		assert.Equal(t, "BTCUSDT", perpetual.Symbol)
+		assert.True(t, perpetual.IsPerpetual())
+		assert.Equal(t, "USDT", perpetual.MarginAsset)
+		assert.True(t, onboardDate.Equal(perpetual.OnboardDate))
+		assert.Equal(t, 0.1, perpetual.TickSize)
+		assert.Equal(t, 0.001, perpetual.StepSize)
+		assert.Equal(t, 5.0, perpetual.MinNotional)
+
+		assert.False(t, info.Symbols[1].IsPerpetual())
+		assert.True(t, info.Symbols[1].OnboardDate.IsZero())
+	})
+
+	t.Run("last price klines", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceLast)
+		candles, err := client.CandlesByLimit(context.Background(), "BTCUSDT", model.Timeframe1h, 4)
+		require.NoError(t, err)
+		require.Len(t, candles, 4)
+		assert.True(t, start.Add(6*time.Hour).Equal(candles[0].Time))
+		assert.Equal(t, 101.0, candles[0].Close)
+		assert.Equal(t, 10.0, candles[0].Volume)
+		assert.Equal(t, int64(5), candles[0].Trades)
+	})
+
+	t.Run("mark price klines", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceMark)
+		candles, err := client.CandlesByPeriod(context.Background(), "BTCUSDT", model.Timeframe1h, start, start.Add(2*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 3)
+		assert.Equal(t, 200.0, candles[0].Open)
+		assert.Equal(t, 201.0, candles[0].Close)
+		assert.Equal(t, 0.0, candles[0].Volume)
+	})
+
+	t.Run("funding rate and open interest", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceLast)
+		for i := 0; i < 3; i++ {
+			server.AddFundingRates(model.FundingRate{
+				Symbol:      "BTCUSDT",
+				FundingRate: 0.0001 * float64(i+1),
+				FundingTime: start.Add(time.Duration(i) * 8 * time.Hour),
+			})
+		}
+		server.SetOpenInterest(model.OpenInterest{Symbol: "BTCUSDT", OpenInterest: 1234.5, Time: start})
+
+		rates, err := client.FundingRates(context.Background(), "BTCUSDT", start.Add(time.Hour), time.Time{})
+		require.NoError(t, err)
+		require.Len(t, rates, 2)
+		assert.Equal(t, 0.0002, rates[0].FundingRate)
+		assert.True(t, start.Add(8*time.Hour).Equal(rates[0].FundingTime))
+
+		openInterest, err := client.OpenInterest(context.Background(), "BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, 1234.5, openInterest.OpenInterest)
+		assert.True(t, start.Equal(openInterest.Time))
+
+		_, err = client.OpenInterest(context.Background(), "ETHUSDT")
+		assert.Error(t, err)
+
+		// the first poll is sent at once
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		openInterestCh := make(chan model.OpenInterest)
+		go client.OpenInterestSubscription(ctx, []string{"BTCUSDT"}, openInterestCh, make(chan error, 1))
+		select {
+		case openInterest := <-openInterestCh:
+			assert.Equal(t, 1234.5, openInterest.OpenInterest)
+		case <-ctx.Done():
+			t.Fatal("open interest subscription timeout")
+		}
+	})
+
+	t.Run("liquidations subscription and market stats", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceLast)
+		server.SetMarketStats(model.MarketStats24h{Symbol: "BTCUSDT", LastPrice: 43000, QuoteVolume: 5e9})
+		stats, err := client.MarketStats(context.Background())
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.Equal(t, 5e9, stats[0].QuoteVolume)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var (
+			liquidationCh = make(chan model.Liquidation)
+			errCh         = make(chan error, 1)
+		)
+		go client.CombinedLiquidationsSubscription(ctx, []string{"BTCUSDT"}, liquidationCh, errCh)
+		require.Eventually(t, func() bool {
+			return server.Subscribers(binancetest.ForceOrderStream("BTCUSDT")) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		liquidationTime := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		go server.PushLiquidation(model.Liquidation{
+			Symbol:   "BTCUSDT",
+			Side:     model.SideTypeSell,
+			Price:    42000,
+			Quantity: 2.5,
+			Time:     liquidationTime,
+		})
+		select {
+		case liquidation := <-liquidationCh:
+			assert.Equal(t, model.SideTypeSell, liquidation.Side)
+			assert.Equal(t, 105000.0, liquidation.QuoteQuantity())
+			assert.True(t, liquidationTime.Equal(liquidation.Time))
+		case err := <-errCh:
+			t.Fatalf("liquidations subscription error: %v", err)
+		case <-ctx.Done():
+			t.Fatal("liquidations subscription timeout")
+		}
+	})
+
+	t.Run("mark price candles subscription", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceMark)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var (
+			candleCh = make(chan model.Candle)
+			errCh    = make(chan error, 1)
+		)
+		go client.CandlesSubscription(ctx, "BTCUSDT", model.Timeframe1m, candleCh, errCh)
+		require.Eventually(t, func() bool {
+			return server.Subscribers(binancetest.MarkPriceStream("BTCUSDT")) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		go func() {
+			for i, price := range []float64{100, 103, 98, 101, 105} {
+				server.PushMarkPrice(model.MarkPrice{
+					Symbol:      "BTCUSDT",
+					MarkPrice:   price,
+					FundingRate: 0.0001,
+					Time:        minute.Add(time.Duration(i) * 15 * time.Second),
+				})
+			}
+		}()
+
+		var received = make([]model.Candle, 0)
+		for len(received) < 6 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("mark price candles subscription timeout, received %d candles", len(received))
+			case err := <-errCh:
+				t.Fatalf("mark price candles subscription error: %v", err)
+			case candle := <-candleCh:
+				received = append(received, candle)
+			}
+		}
+
+		// the fifth update opens the next minute and completes the first one
+		complete := received[4]
+		assert.True(t, complete.Complete)
+		assert.True(t, minute.Equal(complete.Time))
+		assert.Equal(t, 100.0, complete.Open)
+		assert.Equal(t, 103.0, complete.High)
+		assert.Equal(t, 98.0, complete.Low)
+		assert.Equal(t, 101.0, complete.Close)
+
+		next := received[5]
+		assert.False(t, next.Complete)
+		assert.True(t, minute.Add(time.Minute).Equal(next.Time))
+		assert.Equal(t, 105.0, next.Open)
+	})
+
+	t.Run("mark price candles subscription started mid-candle", func(t *testing.T) {
+		client := newFuturesTestClient(t, server, FuturesPriceMark)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// the candle open on the exchange when the stream connects
+		minute := time.Date(2021, 10, 1, 13, 0, 0, 0, time.UTC)
+		server.AddMarkPriceKlines(model.Candle{Symbol: "ETHUSDT", Timeframe: model.Timeframe1m, Time: minute,
+			Open: 150, High: 160, Low: 140, Close: 155})
+
+		var (
+			candleCh = make(chan model.Candle)
+			errCh    = make(chan error, 1)
+		)
+		go client.CandlesSubscription(ctx, "ETHUSDT", model.Timeframe1m, candleCh, errCh)
+		require.Eventually(t, func() bool {
+			return server.Subscribers(binancetest.MarkPriceStream("ETHUSDT")) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		go func() {
+			for i, price := range []float64{152, 151} {
+				server.PushMarkPrice(model.MarkPrice{
+					Symbol:    "ETHUSDT",
+					MarkPrice: price,
+					Time:      minute.Add(30*time.Second + time.Duration(i)*30*time.Second),
+				})
+			}
+		}()
+
+		var received = make([]model.Candle, 0)
+		for len(received) < 3 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("mark price candles subscription timeout, received %d candles", len(received))
+			case err := <-errCh:
+				t.Fatalf("mark price candles subscription error: %v", err)
+			case candle := <-candleCh:
+				received = append(received, candle)
+			}
+		}
+
+		// the first candle keeps the open, high and low of the exchange
+		complete := received[1]
+		assert.True(t, complete.Complete)
+		assert.True(t, minute.Equal(complete.Time))
+		assert.Equal(t, 150.0, complete.Open)
+		assert.Equal(t, 160.0, complete.High)
+		assert.Equal(t, 140.0, complete.Low)
+		assert.Equal(t, 152.0, complete.Close)
+		assert.False(t, received[2].Complete)
+	})
+}
+
+func TestMarkPriceCandlesWithoutSeed(t *testing.T) {
+	minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	exchangeCandle := model.Candle{Symbol: "BTCUSDT", Timeframe: model.Timeframe1m, Time: minute,
+		Open: 100, High: 110, Low: 90, Close: 102}
+	var fetched bool
+	builder := newMarkPriceCandles(map[string]model.Timeframe{"BTCUSDT": model.Timeframe1m}, nil,
+		func(symbol string, timeframe model.Timeframe, openTime time.Time) (model.Candle, bool) {
+			fetched = true
+			return exchangeCandle, openTime.Equal(minute)
+		})
+	update := func(price float64, offset time.Duration) []model.Candle {
+		return builder.update(model.MarkPrice{Symbol: "BTCUSDT", MarkPrice: price, Time: minute.Add(offset)})
+	}
+
+	// the stream starts in the middle of the candle, which is replaced by the one of the exchange
+	candles := update(101, 30*time.Second)
+	require.Len(t, candles, 1)
+	assert.False(t, candles[0].Complete)
+	assert.False(t, fetched)
+
+	candles = update(103, time.Minute)
+	require.Len(t, candles, 2)
+	assert.True(t, fetched)
+	assert.True(t, candles[0].Complete)
+	assert.Equal(t, 100.0, candles[0].Open)
+	assert.Equal(t, 110.0, candles[0].High)
+	assert.Equal(t, 90.0, candles[0].Low)
+	assert.Equal(t, 102.0, candles[0].Close)
+
+	// the next candles are seen from their open
+	fetched = false
+	candles = update(104, 2*time.Minute)
+	require.Len(t, candles, 2)
+	assert.False(t, fetched)
+	assert.True(t, candles[0].Complete)
+	assert.Equal(t, 103.0, candles[0].Open)
+	assert.Equal(t, 103.0, candles[0].Close)
+	assert.Equal(t, 104.0, candles[1].Open)
+}
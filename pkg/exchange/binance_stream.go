@@ -0,0 +1,140 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"go.uber.org/zap"
+)
+
+// binanceStreams subscribes the websocket streams, whose payloads are shared by the spot and futures markets
+type binanceStreams struct {
+	l          *zap.SugaredLogger
+	wsEndpoint string
+}
+
+// CandlesSubscription subscribe kline for specific symbol and timeframe
+func (s *binanceStreams) CandlesSubscription(ctx context.Context, symbol string, timeframe model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	s.l.Debugw("binance candle subscription", "symbol", symbol, "timeframe", timeframe)
+	s.serveSubscription(ctx, "candles subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsKlineServe(s.wsEndpoint, symbol, timeframe, s.candleHandler(ctx, candleCh), errHandler)
+	}, errCh)
+}
+
+// CombinedCandlesSubscription subscribe klines of multiple symbols through a single connection, the number of
+// symbols must not exceed MaxStreamsPerConnection
+func (s *binanceStreams) CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	if len(mapSymbolTimeframe) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(mapSymbolTimeframe), MaxStreamsPerConnection))
+		return
+	}
+	s.l.Debugw("binance combined candle subscription", "streams", len(mapSymbolTimeframe))
+	s.serveSubscription(ctx, "combined candles subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsCombinedKlineServe(s.wsEndpoint, mapSymbolTimeframe, s.candleHandler(ctx, candleCh), errHandler)
+	}, errCh)
+}
+
+func (s *binanceStreams) MarketStatsSubscription(ctx context.Context, symbol string, statCh chan<- model.MarketStats24h, errCh chan<- error) {
+	s.serveSubscription(ctx, "market stats subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsMarketStatServe(s.wsEndpoint, symbol, s.marketStatHandler(ctx, statCh), errHandler)
+	}, errCh)
+}
+
+func (s *binanceStreams) CombinedMarketStatsSubscription(ctx context.Context, symbols []string, statCh chan<- model.MarketStats24h, errCh chan<- error) {
+	if len(symbols) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(symbols), MaxStreamsPerConnection))
+		return
+	}
+	s.serveSubscription(ctx, "combined market stats subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsCombinedMarketStatServe(s.wsEndpoint, symbols, s.marketStatHandler(ctx, statCh), errHandler)
+	}, errCh)
+}
+
+// TradesSubscription subscribe aggregate trades of a symbol
+func (s *binanceStreams) TradesSubscription(ctx context.Context, symbol string, tradeCh chan<- model.Trade, errCh chan<- error) {
+	s.l.Debugw("binance trades subscription", "symbol", symbol)
+	s.serveSubscription(ctx, "trades subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsAggTradeServe(s.wsEndpoint, symbol, s.tradeHandler(ctx, tradeCh), errHandler)
+	}, errCh)
+}
+
+// CombinedTradesSubscription subscribe aggregate trades of multiple symbols through a single connection, the
+// number of symbols must not exceed MaxStreamsPerConnection
+func (s *binanceStreams) CombinedTradesSubscription(ctx context.Context, symbols []string, tradeCh chan<- model.Trade, errCh chan<- error) {
+	if len(symbols) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(symbols), MaxStreamsPerConnection))
+		return
+	}
+	s.l.Debugw("binance combined trades subscription", "streams", len(symbols))
+	s.serveSubscription(ctx, "combined trades subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		return wsCombinedAggTradeServe(s.wsEndpoint, symbols, s.tradeHandler(ctx, tradeCh), errHandler)
+	}, errCh)
+}
+
+func (s *binanceStreams) candleHandler(ctx context.Context, candleCh chan<- model.Candle) func(event *binance.WsKlineEvent) {
+	return func(event *binance.WsKlineEvent) {
+		candle := CandleFromWsKline(event.Kline)
+		candle.EventTime = time.Unix(0, event.Time*int64(time.Millisecond))
+		select {
+		case candleCh <- candle:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (s *binanceStreams) marketStatHandler(ctx context.Context, statCh chan<- model.MarketStats24h) func(event *binance.WsMarketStatEvent) {
+	return func(event *binance.WsMarketStatEvent) {
+		select {
+		case statCh <- MarketStatsFromEvent(event):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (s *binanceStreams) tradeHandler(ctx context.Context, tradeCh chan<- model.Trade) func(event *binance.WsAggTradeEvent) {
+	return func(event *binance.WsAggTradeEvent) {
+		select {
+		case tradeCh <- TradeFromWsAggTrade(event):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// serveSubscription keeps the websocket connection open until ctx is done. When the connection is terminated by
+// the server or the network, a single error is sent to errCh.
+func (s *binanceStreams) serveSubscription(ctx context.Context, name string, serve func(errHandler func(err error)) (doneC, stopC chan struct{}, err error), errCh chan<- error) {
+	var (
+		mu      sync.Mutex
+		lastErr error
+	)
+	errHandler := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lastErr = err
+	}
+
+	doneCh, stopCh, err := serve(errHandler)
+	if err != nil {
+		s.l.Errorw(name+" error", "error", err)
+		sendError(ctx, errCh, err)
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		close(stopCh)
+	case <-doneCh:
+		mu.Lock()
+		err := lastErr
+		mu.Unlock()
+		if err != nil {
+			sendError(ctx, errCh, fmt.Errorf("%s stopped: %w", name, err))
+		} else {
+			sendError(ctx, errCh, fmt.Errorf("%s stopped", name))
+		}
+	}
+}
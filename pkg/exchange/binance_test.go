@@ -2,18 +2,26 @@ package exchange
 
 import (
 	"context"
+	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
-	"github.com/quangkeu95/binancebot/config"
+	"github.com/adshao/go-binance/v2"
+	"github.com/quangkeu95/binancebot/pkg/exchange/binancetest"
 	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
 )
 
+const historyLength = 1700
+
 type BinanceTestSuite struct {
 	suite.Suite
-	client *Binance
+	server  *binancetest.Server
+	client  *Binance
+	candles map[string][]model.Candle
 }
 
 func TestBinanceTestSuite(t *testing.T) {
@@ -21,91 +29,512 @@ func TestBinanceTestSuite(t *testing.T) {
 }
 
 func (ts *BinanceTestSuite) SetupSuite() {
-	assert := ts.Assert()
+	require := ts.Require()
 	l, _ := zap.NewDevelopment()
 	zap.ReplaceGlobals(l)
 
-	config.InitConfig()
+	ts.server = binancetest.NewServer()
+	ts.candles = make(map[string][]model.Candle)
+
+	for symbol, file := range map[string]string{
+		"KNCUSDT": "../../testdata/kncusdt-4h-test1.csv",
+		"SXPUSDT": "../../testdata/sxpusdt-4h-test1.csv",
+	} {
+		candles, err := binancetest.ReadCandlesCSV(file)
+		require.NoError(err)
+		require.Greater(len(candles), historyLength)
+
+		ts.server.AddSymbol(model.SymbolInfo{
+			Symbol:      symbol,
+			BaseAsset:   symbol[:len(symbol)-4],
+			QuoteAsset:  "USDT",
+			Status:      model.SymbolStatusTrading.String(),
+			TickSize:    0.001,
+			MinPrice:    0.001,
+			MaxPrice:    1000,
+			StepSize:    0.1,
+			MinQuantity: 0.1,
+			MaxQuantity: 90000,
+			MinNotional: 10,
+
+			PricePrecision:    8,
+			QuantityPrecision: 8,
+			Permissions:       []string{model.PermissionSpot, model.PermissionMargin},
+			OrderTypes:        []model.OrderType{model.OrderTypeLimit, model.OrderTypeMarket, model.OrderTypeStopLossLimit},
+		})
+		// the first candles are history served over REST, the others are pushed to the websocket streams
+		ts.server.AddKlines(candles[:historyLength]...)
+		ts.candles[symbol] = candles
+	}
+
+	viper.Set(BinanceApiKeyFlag, "api-key")
+	viper.Set(BinanceApiSecretFlag, "api-secret")
+	viper.Set(BinanceApiEndpointFlag, ts.server.URL())
+	viper.Set(BinanceWsEndpointFlag, ts.server.WsURL())
 
 	client, err := NewBinance()
-	assert.NoError(err)
-	assert.NotNil(client)
+	require.NoError(err)
+	require.NotNil(client)
 
 	ts.client = client
 }
 
+func (ts *BinanceTestSuite) TearDownSuite() {
+	ts.server.Close()
+}
+
+func (ts *BinanceTestSuite) TestSymbolInfoLegacyMinNotional() {
+	info := SymbolInfoFromBinance(binance.Symbol{
+		Symbol: "KNCUSDT",
+		Filters: []map[string]interface{}{
+			{"filterType": string(binance.SymbolFilterTypeMinNotional), "minNotional": "10.00000000"},
+		},
+	})
+	ts.Equal(10.0, info.MinNotional)
+}
+
+func (ts *BinanceTestSuite) TestGetExchangeInfo() {
+	assert := ts.Assert()
+
+	info, err := ts.client.GetExchangeInfo(context.Background())
+	assert.NoError(err)
+	assert.Len(info.Symbols, 2)
+	for _, symbol := range info.Symbols {
+		assert.Equal("USDT", symbol.QuoteAsset)
+		assert.Equal(model.SymbolStatusTrading.String(), symbol.Status)
+		// the precisions follow the tick and step sizes, not the asset precisions
+		assert.Equal(3, symbol.PricePrecision)
+		assert.Equal(1, symbol.QuantityPrecision)
+		// read from the NOTIONAL filter sent by the server
+		assert.Equal(10.0, symbol.MinNotional)
+		assert.True(symbol.HasPermission(model.PermissionMargin))
+		assert.True(symbol.AllowsOrderType(model.OrderTypeStopLossLimit))
+		assert.False(symbol.AllowsOrderType("TAKE_PROFIT_LIMIT"))
+	}
+}
+
+func (ts *BinanceTestSuite) TestCandlesByLimit() {
+	assert := ts.Assert()
+	history := ts.candles["KNCUSDT"][:historyLength]
+
+	candles, err := ts.client.CandlesByLimit(context.Background(), "KNCUSDT", model.Timeframe4h, 201)
+	assert.NoError(err)
+	ts.assertCandles(history[historyLength-201:], candles)
+}
+
+func (ts *BinanceTestSuite) TestCandlesByPeriod() {
+	assert := ts.Assert()
+	history := ts.candles["KNCUSDT"][:historyLength]
+
+	candles, err := ts.client.CandlesByPeriod(context.Background(), "KNCUSDT", model.Timeframe4h, history[100].Time, history[199].Time)
+	assert.NoError(err)
+	ts.assertCandles(history[100:200], candles)
+}
+
+func (ts *BinanceTestSuite) TestFirstCandle() {
+	assert := ts.Assert()
+	history := ts.candles["KNCUSDT"][:historyLength]
+
+	candle, err := ts.client.FirstCandle(context.Background(), "KNCUSDT", model.Timeframe4h)
+	assert.NoError(err)
+	ts.assertCandles(history[:1], []model.Candle{candle})
+
+	_, err = ts.client.FirstCandle(context.Background(), "KNCUSDT", model.Timeframe1m)
+	assert.ErrorIs(err, ErrInsufficientData)
+}
+
+func (ts *BinanceTestSuite) TestCandlesByLimitPaginated() {
+	assert := ts.Assert()
+	history := ts.candles["KNCUSDT"][:historyLength]
+
+	candles, err := ts.client.CandlesByLimit(context.Background(), "KNCUSDT", model.Timeframe4h, 1500)
+	assert.NoError(err)
+	ts.assertCandles(history[historyLength-1500:], candles)
+
+	// the limit exceeds the available history
+	candles, err = ts.client.CandlesByLimit(context.Background(), "KNCUSDT", model.Timeframe4h, 5000)
+	assert.NoError(err)
+	ts.assertCandles(history, candles)
+}
+
+func (ts *BinanceTestSuite) TestCandlesByPeriodPaginated() {
+	assert := ts.Assert()
+	history := ts.candles["KNCUSDT"][:historyLength]
+
+	candles, err := ts.client.CandlesByPeriod(context.Background(), "KNCUSDT", model.Timeframe4h, history[0].Time, history[historyLength-1].Time)
+	assert.NoError(err)
+	ts.assertCandles(history, candles)
+
+	// the period ends on a page boundary
+	candles, err = ts.client.CandlesByPeriod(context.Background(), "KNCUSDT", model.Timeframe4h, history[0].Time, history[MaxKlinesLimit-1].Time)
+	assert.NoError(err)
+	ts.assertCandles(history[:MaxKlinesLimit], candles)
+}
+
+func (ts *BinanceTestSuite) TestRateLimitUsedWeight() {
+	assert := ts.Assert()
+
+	_, err := ts.client.CandlesByLimit(context.Background(), "KNCUSDT", model.Timeframe4h, 10)
+	assert.NoError(err)
+
+	// the used weight is synced with the server, which also counts the requests of the other tests
+	stats := ts.client.RateLimitStats()
+	assert.GreaterOrEqual(stats.UsedWeight, ts.server.UsedWeight())
+	assert.Greater(stats.Requests, int64(0))
+
+	// requests exceeding the weight limit wait for the next window
+	ts.client.weightLimiter.SetWeightLimit(stats.UsedWeight)
+	defer ts.client.weightLimiter.SetWeightLimit(DefaultWeightLimit)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = ts.client.CandlesByLimit(ctx, "KNCUSDT", model.Timeframe4h, 10)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (ts *BinanceTestSuite) TestRateLimitWeightShare() {
+	assert := ts.Assert()
+	limiter := NewWeightLimiter(http.DefaultTransport)
+	limiter.SetWeightLimit(20)
+	shared := withWeightShare(context.Background(), 0.5)
+	assert.NoError(limiter.reserve(shared, 10))
+
+	// the requests limited to a share of the weight wait for the next window, the others use the rest
+	ctx, cancel := context.WithTimeout(shared, 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(limiter.reserve(ctx, 1), context.DeadlineExceeded)
+	assert.NoError(limiter.reserve(context.Background(), 10))
+	assert.Equal(20, limiter.Stats().UsedWeight)
+}
+
+func (ts *BinanceTestSuite) TestRequestWeight() {
+	assert := ts.Assert()
+	query := func(params ...string) url.Values {
+		values := make(url.Values)
+		for i := 0; i < len(params); i += 2 {
+			values.Set(params[i], params[i+1])
+		}
+		return values
+	}
+
+	// the open orders of every symbol weigh more
+	assert.Equal(3, RequestWeight("/api/v3/openOrders", query("symbol", "KNCUSDT")))
+	assert.Equal(40, RequestWeight("/api/v3/openOrders", query()))
+	assert.Equal(1, RequestWeight("/fapi/v1/openOrders", query("symbol", "KNCUSDT")))
+	assert.Equal(40, RequestWeight("/fapi/v1/openOrders", query()))
+
+	// without limit, the default limit of the endpoint is weighted
+	assert.Equal(10, RequestWeight("/api/v3/depth", query("symbol", "KNCUSDT", "limit", "1000")))
+	assert.Equal(1, RequestWeight("/api/v3/depth", query("symbol", "KNCUSDT")))
+	assert.Equal(1, RequestWeight("/fapi/v1/klines", query("symbol", "KNCUSDT", "limit", "10")))
+	assert.Equal(5, RequestWeight("/fapi/v1/klines", query("symbol", "KNCUSDT")))
+	assert.Equal(10, RequestWeight("/fapi/v1/depth", query("symbol", "KNCUSDT", "limit", "0")))
+}
+
+func (ts *BinanceTestSuite) TestRateLimitRetryAfter() {
+	assert := ts.Assert()
+	history := ts.candles["KNCUSDT"][:historyLength]
+	throttled := ts.client.RateLimitStats().Throttled
+
+	ts.server.Throttle(1, time.Second)
+	start := time.Now()
+	candles, err := ts.client.CandlesByLimit(context.Background(), "KNCUSDT", model.Timeframe4h, 10)
+	assert.NoError(err)
+	ts.assertCandles(history[historyLength-10:], candles)
+
+	assert.GreaterOrEqual(time.Since(start), time.Second)
+	assert.Equal(throttled+1, ts.client.RateLimitStats().Throttled)
+}
+
 func (ts *BinanceTestSuite) TestCandlesSubscription() {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var (
-		symbol   = "BTCUSDT"
-		period   = "1m"
+		symbol   = "KNCUSDT"
+		period   = model.Timeframe4h
 		candleCh = make(chan model.Candle)
-		errCh    = make(chan error)
+		errCh    = make(chan error, 1)
 	)
 
 	go ts.client.CandlesSubscription(ctx, symbol, period, candleCh, errCh)
+	ts.waitSubscribers(binancetest.KlineStream(symbol, period))
+
+	live := ts.candles[symbol][historyLength:]
+	go func() {
+		for _, candle := range live {
+			partial := candle
+			partial.Complete = false
+			ts.server.PushKline(partial)
+			ts.server.PushKline(candle)
+		}
+	}()
 
-	for {
+	var received = make([]model.Candle, 0)
+	for len(received) < 2*len(live) {
 		select {
 		case <-ctx.Done():
-			return
+			ts.FailNow("candles subscription timeout", "received %d candles", len(received))
 		case err := <-errCh:
-			ts.client.l.Error(err)
+			ts.FailNow("candles subscription error", err.Error())
 		case candle := <-candleCh:
-			ts.client.l.Infof("%+v\n", candle)
+			received = append(received, candle)
 		}
 	}
+
+	for i, candle := range live {
+		ts.Assert().False(received[2*i].Complete)
+		ts.Assert().True(received[2*i+1].Complete)
+		ts.assertCandles([]model.Candle{candle}, []model.Candle{received[2*i+1]})
+	}
 }
 
 func (ts *BinanceTestSuite) TestCombinedCandlesSubscription() {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var (
 		candleCh           = make(chan model.Candle)
-		errCh              = make(chan error)
-		mapSymbolTimeframe = make(map[string]string)
+		errCh              = make(chan error, 1)
+		mapSymbolTimeframe = map[string]model.Timeframe{
+			"KNCUSDT": model.Timeframe4h,
+			"SXPUSDT": model.Timeframe4h,
+		}
 	)
-	mapSymbolTimeframe["BTCUSDT"] = "1m"
-	mapSymbolTimeframe["ETHUSDT"] = "1m"
-	mapSymbolTimeframe["KNCUSDT"] = "1m"
 
 	go ts.client.CombinedCandlesSubscription(ctx, mapSymbolTimeframe, candleCh, errCh)
+	for symbol, timeframe := range mapSymbolTimeframe {
+		ts.waitSubscribers(binancetest.KlineStream(symbol, timeframe))
+	}
 
-	for {
+	go func() {
+		for i := historyLength; i < historyLength+10; i++ {
+			ts.server.PushKline(ts.candles["KNCUSDT"][i])
+			ts.server.PushKline(ts.candles["SXPUSDT"][i])
+		}
+	}()
+
+	var received = make(map[string][]model.Candle)
+	for i := 0; i < 20; i++ {
 		select {
 		case <-ctx.Done():
-			return
+			ts.FailNow("combined candles subscription timeout")
 		case err := <-errCh:
-			ts.client.l.Error(err)
+			ts.FailNow("combined candles subscription error", err.Error())
 		case candle := <-candleCh:
-			ts.client.l.Infof("%+v\n", candle)
+			received[candle.Symbol] = append(received[candle.Symbol], candle)
 		}
 	}
+
+	for symbol := range mapSymbolTimeframe {
+		ts.assertCandles(ts.candles[symbol][historyLength:historyLength+10], received[symbol])
+	}
 }
 
 func (ts *BinanceTestSuite) TestMarketStatsSubscription() {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	assert := ts.Assert()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var (
-		symbol       = "BTCUSDT"
+		symbol       = "KNCUSDT"
 		marketStatCh = make(chan model.MarketStats24h)
-		errCh        = make(chan error)
+		errCh        = make(chan error, 1)
 	)
 
 	go ts.client.MarketStatsSubscription(ctx, symbol, marketStatCh, errCh)
+	ts.waitSubscribers(binancetest.MarketStatsStream(symbol))
+
+	go ts.server.PushMarketStats(model.MarketStats24h{
+		Symbol:      symbol,
+		LastPrice:   1.25,
+		QuoteVolume: 1000000,
+		TotalTrades: 42,
+	})
+
+	select {
+	case <-ctx.Done():
+		ts.FailNow("market stats subscription timeout")
+	case err := <-errCh:
+		ts.FailNow("market stats subscription error", err.Error())
+	case marketStat := <-marketStatCh:
+		assert.Equal(symbol, marketStat.Symbol)
+		assert.Equal(1.25, marketStat.LastPrice)
+		assert.Equal(float64(1000000), marketStat.QuoteVolume)
+		assert.Equal(int64(42), marketStat.TotalTrades)
+	}
+}
+
+func (ts *BinanceTestSuite) TestCombinedTradesSubscription() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		tradeCh = make(chan model.Trade)
+		errCh   = make(chan error, 1)
+		symbols = []string{"KNCUSDT", "SXPUSDT"}
+		start   = time.Unix(1629000000, 0)
+	)
+
+	go ts.client.CombinedTradesSubscription(ctx, symbols, tradeCh, errCh)
+	for _, symbol := range symbols {
+		ts.waitSubscribers(binancetest.AggTradeStream(symbol))
+	}
 
-	for {
+	var trades = make([]model.Trade, 0)
+	for i := 0; i < 10; i++ {
+		trades = append(trades, model.Trade{
+			Symbol:       symbols[i%2],
+			ID:           int64(1000 + i),
+			Price:        1.5 + float64(i)/100,
+			Quantity:     float64(10 * (i + 1)),
+			Time:         start.Add(time.Duration(i) * time.Second),
+			IsBuyerMaker: i%3 == 0,
+		})
+	}
+	go func() {
+		for _, trade := range trades {
+			ts.server.PushAggTrade(trade)
+		}
+	}()
+
+	for _, expected := range trades {
 		select {
 		case <-ctx.Done():
-			return
+			ts.FailNow("combined trades subscription timeout")
 		case err := <-errCh:
-			ts.client.l.Error(err)
-		case marketStat := <-marketStatCh:
-			ts.client.l.Infof("%+v\n", marketStat)
+			ts.FailNow("combined trades subscription error", err.Error())
+		case trade := <-tradeCh:
+			ts.Equal(expected.Symbol, trade.Symbol)
+			ts.Equal(expected.ID, trade.ID)
+			ts.Equal(expected.Price, trade.Price)
+			ts.Equal(expected.Quantity, trade.Quantity)
+			ts.True(expected.Time.Equal(trade.Time))
+			ts.Equal(expected.IsBuyerMaker, trade.IsBuyerMaker)
 		}
 	}
 }
+
+func (ts *BinanceTestSuite) TestOrderBookSubscription() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		errCh = make(chan error, 1)
+		book  = model.NewOrderBook("KNCUSDT")
+	)
+	ts.server.SetDepth("KNCUSDT", 100, []model.PriceLevel{{Price: 1.5, Quantity: 10}, {Price: 1.4, Quantity: 20}},
+		[]model.PriceLevel{{Price: 1.6, Quantity: 5}})
+
+	go ts.client.OrderBookSubscription(ctx, []*model.OrderBook{book}, errCh)
+	ts.waitSubscribers(binancetest.DepthStream("KNCUSDT"))
+
+	ts.server.PushDepth("KNCUSDT", 101, 102, []model.PriceLevel{{Price: 1.5, Quantity: 0}}, nil)
+	ts.server.PushDepth("KNCUSDT", 103, 103, nil, []model.PriceLevel{{Price: 1.7, Quantity: 1}})
+	ts.Require().Eventually(func() bool {
+		return book.IsSynced() && book.LastUpdateID() == 103
+	}, 5*time.Second, 10*time.Millisecond)
+	ts.Equal([]model.PriceLevel{{Price: 1.4, Quantity: 20}}, book.Bids(0))
+	ts.Equal([]model.PriceLevel{{Price: 1.6, Quantity: 5}, {Price: 1.7, Quantity: 1}}, book.Asks(0))
+
+	// the updates 104 to 109 are missed, the book is rebuilt from a new snapshot
+	ts.server.PushDepth("KNCUSDT", 104, 109, []model.PriceLevel{{Price: 1.45, Quantity: 3}}, nil)
+	ts.server.PushDepth("KNCUSDT", 110, 110, nil, []model.PriceLevel{{Price: 1.6, Quantity: 0}})
+	ts.Require().Eventually(func() bool {
+		return book.IsSynced() && book.LastUpdateID() == 110
+	}, 5*time.Second, 10*time.Millisecond)
+	ts.Equal([]model.PriceLevel{{Price: 1.45, Quantity: 3}, {Price: 1.4, Quantity: 20}}, book.Bids(0))
+	ts.Equal([]model.PriceLevel{{Price: 1.7, Quantity: 1}}, book.Asks(0))
+
+	select {
+	case err := <-errCh:
+		ts.FailNow("order book subscription error", err.Error())
+	default:
+	}
+}
+
+func (ts *BinanceTestSuite) TestOrders() {
+	require := ts.Require()
+	ctx := context.Background()
+
+	ts.server.SetPrice("SXPUSDT", 2)
+	ts.server.SetBalance("USDT", 1000)
+	ts.server.SetBalance("SXP", 0)
+
+	// the order is rounded to the filters and locks its quote amount until it is canceled
+	order, err := ts.client.OrderLimit(ctx, model.SideTypeBuy, "SXPUSDT", 10.05, 1.23456)
+	require.NoError(err)
+	ts.Equal(model.OrderStatusNew, order.Status)
+	ts.Equal(10.0, order.Quantity)
+	ts.Equal(1.235, order.Price)
+	ts.InDelta(12.35, ts.server.Balance("USDT").Locked, 1e-9)
+
+	openOrders, err := ts.client.OpenOrders(ctx, "SXPUSDT")
+	require.NoError(err)
+	require.Len(openOrders, 1)
+	ts.Equal(order.ID, openOrders[0].ID)
+
+	canceled, err := ts.client.Cancel(ctx, "SXPUSDT", order.ID)
+	require.NoError(err)
+	ts.Equal(model.OrderStatusCanceled, canceled.Status)
+	openOrders, err = ts.client.OpenOrders(ctx, "SXPUSDT")
+	require.NoError(err)
+	ts.Empty(openOrders)
+	ts.Equal(1000.0, ts.server.Balance("USDT").Free)
+
+	// orders breaking the filters are not sent
+	sent := len(ts.server.Orders())
+	_, err = ts.client.OrderLimit(ctx, model.SideTypeBuy, "SXPUSDT", 2, 1)
+	ts.ErrorIs(err, model.ErrMinNotional)
+	_, err = ts.client.OrderMarket(ctx, model.SideTypeBuy, "SXPUSDT", 0.05)
+	ts.ErrorIs(err, model.ErrInvalidQuantity)
+	_, err = ts.client.OrderStop(ctx, model.SideTypeSell, "SXPUSDT", 10, 1.5, 2000)
+	ts.ErrorIs(err, model.ErrInvalidPrice)
+	_, err = ts.client.OrderMarketQuote(ctx, model.SideTypeBuy, "SXPUSDT", 5)
+	ts.ErrorIs(err, model.ErrMinNotional)
+	ts.Len(ts.server.Orders(), sent)
+
+	bought, err := ts.client.OrderMarketQuote(ctx, model.SideTypeBuy, "SXPUSDT", 20)
+	require.NoError(err)
+	ts.Equal(model.OrderStatusFilled, bought.Status)
+	ts.Equal(10.0, bought.ExecutedQuantity)
+	ts.Equal(2.0, bought.AveragePrice())
+
+	balances, err := ts.client.Balances(ctx)
+	require.NoError(err)
+	ts.Equal(10.0, balances["SXP"].Free)
+	ts.Equal(980.0, balances["USDT"].Free)
+
+	sold, err := ts.client.OrderMarket(ctx, model.SideTypeSell, "SXPUSDT", bought.ExecutedQuantity)
+	require.NoError(err)
+	ts.Equal(model.OrderStatusFilled, sold.Status)
+	ts.Equal(1000.0, ts.server.Balance("USDT").Free)
+
+	filled, err := ts.client.Order(ctx, "SXPUSDT", sold.ID)
+	require.NoError(err)
+	ts.Equal(model.SideTypeSell, filled.Side)
+	ts.Equal(10.0, filled.ExecutedQuantity)
+}
+
+func (ts *BinanceTestSuite) waitSubscribers(stream string) {
+	ts.Require().Eventually(func() bool {
+		return ts.server.Subscribers(stream) > 0
+	}, 5*time.Second, 10*time.Millisecond, "no subscriber for stream %s", stream)
+}
+
+func (ts *BinanceTestSuite) assertCandles(expected, actual []model.Candle) {
+	assert := ts.Assert()
+	if !assert.Len(actual, len(expected)) {
+		return
+	}
+	for i := range expected {
+		assert.Equal(expected[i].Symbol, actual[i].Symbol)
+		assert.Equal(expected[i].Timeframe, actual[i].Timeframe)
+		assert.True(expected[i].Time.Equal(actual[i].Time), "expected time %v, actual %v", expected[i].Time, actual[i].Time)
+		assert.Equal(expected[i].Open, actual[i].Open)
+		assert.Equal(expected[i].Close, actual[i].Close)
+		assert.Equal(expected[i].High, actual[i].High)
+		assert.Equal(expected[i].Low, actual[i].Low)
+		assert.Equal(expected[i].Volume, actual[i].Volume)
+		assert.Equal(expected[i].Trades, actual[i].Trades)
+	}
+}
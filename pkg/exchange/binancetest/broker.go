@@ -0,0 +1,292 @@
+package binancetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+// SetPrice sets the last price of the symbol, served by the ticker and used to fill market orders
+func (s *Server) SetPrice(symbol string, price float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.prices[symbol] = price
+}
+
+// SetBalance sets the free balance of an asset
+func (s *Server) SetBalance(asset string, free float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.balances[asset] = model.Balance{Asset: asset, Free: free}
+}
+
+func (s *Server) Balance(asset string) model.Balance {
+	s.RLock()
+	defer s.RUnlock()
+	balance := s.balances[asset]
+	balance.Asset = asset
+	return balance
+}
+
+// Orders returns every order accepted by the server, oldest first
+func (s *Server) Orders() []model.Order {
+	s.RLock()
+	defer s.RUnlock()
+	var orders = make([]model.Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		orders = append(orders, *order)
+	}
+	return orders
+}
+
+func (s *Server) handlePrice(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	s.RLock()
+	price, ok := s.prices[symbol]
+	s.RUnlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, -1121, "invalid symbol")
+		return
+	}
+	writeJSON(w, binance.SymbolPrice{Symbol: symbol, Price: formatFloat(price)})
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.createOrder(w, params)
+	case http.MethodDelete:
+		s.cancelOrder(w, params)
+	default:
+		id, _ := strconv.ParseInt(params.Get("orderId"), 10, 64)
+		s.RLock()
+		defer s.RUnlock()
+		order := s.findOrder(params.Get("symbol"), id)
+		if order == nil {
+			writeError(w, http.StatusBadRequest, -2013, "Order does not exist.")
+			return
+		}
+		writeJSON(w, binanceOrder(order))
+	}
+}
+
+func (s *Server) handleOpenOrders(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	s.RLock()
+	defer s.RUnlock()
+	var orders = make([]*binance.Order, 0)
+	for _, order := range s.orders {
+		if order.IsOpen() && (symbol == "" || order.Symbol == symbol) {
+			orders = append(orders, binanceOrder(order))
+		}
+	}
+	writeJSON(w, orders)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+	var balances = make([]binance.Balance, 0, len(s.balances))
+	for asset, balance := range s.balances {
+		balances = append(balances, binance.Balance{
+			Asset:  asset,
+			Free:   formatFloat(balance.Free),
+			Locked: formatFloat(balance.Locked),
+		})
+	}
+	writeJSON(w, binance.Account{
+		CanTrade:    true,
+		AccountType: "SPOT",
+		Balances:    balances,
+	})
+}
+
+// createOrder fills market orders at the last price and locks the balance of limit orders
+func (s *Server) createOrder(w http.ResponseWriter, params url.Values) {
+	s.Lock()
+	defer s.Unlock()
+
+	info, ok := s.symbols[params.Get("symbol")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+	order := &model.Order{
+		ID:            s.nextOrderID,
+		ClientOrderID: params.Get("newClientOrderId"),
+		Symbol:        info.Symbol,
+		Side:          model.SideType(params.Get("side")),
+		Type:          model.OrderType(params.Get("type")),
+		Status:        model.OrderStatusNew,
+		CreatedAt:     time.Now(),
+	}
+	order.UpdatedAt = order.CreatedAt
+	order.Quantity, _ = strconv.ParseFloat(params.Get("quantity"), 64)
+	order.Price, _ = strconv.ParseFloat(params.Get("price"), 64)
+	order.StopPrice, _ = strconv.ParseFloat(params.Get("stopPrice"), 64)
+
+	lastPrice := s.prices[info.Symbol]
+	if order.Type == model.OrderTypeMarket {
+		if lastPrice == 0 {
+			writeError(w, http.StatusBadRequest, -2010, "No price to fill the market order.")
+			return
+		}
+		if quoteQuantity, _ := strconv.ParseFloat(params.Get("quoteOrderQty"), 64); quoteQuantity > 0 {
+			order.Quantity = info.FloorQuantity(quoteQuantity / lastPrice)
+		}
+	}
+	if err := info.ValidateOrder(order.Quantity, order.Price, lastPrice); err != nil {
+		writeError(w, http.StatusBadRequest, -1013, "Filter failure: "+err.Error())
+		return
+	}
+
+	// the asset spent by the order
+	var (
+		asset  = info.QuoteAsset
+		amount = order.Quantity * order.Price
+	)
+	if order.Type == model.OrderTypeMarket {
+		amount = order.Quantity * lastPrice
+	}
+	if order.Side == model.SideTypeSell {
+		asset, amount = info.BaseAsset, order.Quantity
+	}
+	balance := s.balances[asset]
+	if balance.Free < amount {
+		writeError(w, http.StatusBadRequest, -2010, "Account has insufficient balance for requested action.")
+		return
+	}
+	balance.Asset = asset
+	balance.Free -= amount
+
+	if order.Type == model.OrderTypeMarket {
+		order.Status = model.OrderStatusFilled
+		order.ExecutedQuantity = order.Quantity
+		order.QuoteQuantity = order.Quantity * lastPrice
+		received := s.balances[info.BaseAsset]
+		received.Asset = info.BaseAsset
+		received.Free += order.Quantity
+		if order.Side == model.SideTypeSell {
+			received = s.balances[info.QuoteAsset]
+			received.Asset = info.QuoteAsset
+			received.Free += order.QuoteQuantity
+		}
+		s.balances[asset] = balance
+		s.balances[received.Asset] = received
+	} else {
+		balance.Locked += amount
+		s.balances[asset] = balance
+	}
+
+	s.orders = append(s.orders, order)
+	s.nextOrderID++
+
+	resp := binance.CreateOrderResponse{
+		Symbol:                   order.Symbol,
+		OrderID:                  order.ID,
+		ClientOrderID:            order.ClientOrderID,
+		TransactTime:             toMilliseconds(order.CreatedAt),
+		Price:                    formatFloat(order.Price),
+		OrigQuantity:             formatFloat(order.Quantity),
+		ExecutedQuantity:         formatFloat(order.ExecutedQuantity),
+		CummulativeQuoteQuantity: formatFloat(order.QuoteQuantity),
+		Status:                   binance.OrderStatusType(order.Status),
+		Type:                     binance.OrderType(order.Type),
+		Side:                     binance.SideType(order.Side),
+	}
+	writeJSON(w, resp)
+}
+
+// cancelOrder cancels an open order and unlocks its balance
+func (s *Server) cancelOrder(w http.ResponseWriter, params url.Values) {
+	s.Lock()
+	defer s.Unlock()
+
+	id, _ := strconv.ParseInt(params.Get("orderId"), 10, 64)
+	order := s.findOrder(params.Get("symbol"), id)
+	if order == nil || !order.IsOpen() {
+		writeError(w, http.StatusBadRequest, -2011, "Unknown order sent.")
+		return
+	}
+	info := s.symbols[order.Symbol]
+	asset, amount := info.QuoteAsset, order.Quantity*order.Price
+	if order.Side == model.SideTypeSell {
+		asset, amount = info.BaseAsset, order.Quantity
+	}
+	balance := s.balances[asset]
+	balance.Free += amount
+	balance.Locked -= amount
+	s.balances[asset] = balance
+
+	order.Status = model.OrderStatusCanceled
+	order.UpdatedAt = time.Now()
+	writeJSON(w, binance.CancelOrderResponse{
+		Symbol:                   order.Symbol,
+		OrderID:                  order.ID,
+		ClientOrderID:            order.ClientOrderID,
+		TransactTime:             toMilliseconds(order.UpdatedAt),
+		Price:                    formatFloat(order.Price),
+		OrigQuantity:             formatFloat(order.Quantity),
+		ExecutedQuantity:         formatFloat(order.ExecutedQuantity),
+		CummulativeQuoteQuantity: formatFloat(order.QuoteQuantity),
+		Status:                   binance.OrderStatusType(order.Status),
+		Type:                     binance.OrderType(order.Type),
+		Side:                     binance.SideType(order.Side),
+	})
+}
+
+func (s *Server) findOrder(symbol string, id int64) *model.Order {
+	for _, order := range s.orders {
+		if order.ID == id && order.Symbol == symbol {
+			return order
+		}
+	}
+	return nil
+}
+
+func binanceOrder(order *model.Order) *binance.Order {
+	return &binance.Order{
+		Symbol:                   order.Symbol,
+		OrderID:                  order.ID,
+		ClientOrderID:            order.ClientOrderID,
+		Price:                    formatFloat(order.Price),
+		OrigQuantity:             formatFloat(order.Quantity),
+		ExecutedQuantity:         formatFloat(order.ExecutedQuantity),
+		CummulativeQuoteQuantity: formatFloat(order.QuoteQuantity),
+		Status:                   binance.OrderStatusType(order.Status),
+		Type:                     binance.OrderType(order.Type),
+		Side:                     binance.SideType(order.Side),
+		StopPrice:                formatFloat(order.StopPrice),
+		Time:                     toMilliseconds(order.CreatedAt),
+		UpdateTime:               toMilliseconds(order.UpdatedAt),
+		IsWorking:                order.IsOpen(),
+	}
+}
+
+// requestParams merges the query and the form body, which net/http does not parse for DELETE requests
+func requestParams(r *http.Request) (url.Values, error) {
+	params := r.URL.Query()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range form {
+		params[key] = values
+	}
+	return params, nil
+}
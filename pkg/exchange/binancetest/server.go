@@ -0,0 +1,867 @@
+// Package binancetest provides a local fake of the Binance REST and WebSocket API, so exchange.Binance and
+// exchange.BinanceFutures can be tested offline.
+package binancetest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/gorilla/websocket"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+const (
+	DefaultKlinesLimit = 500
+	MaxKlinesLimit     = 1000
+)
+
+// Server is a fake Binance server. Klines served by the REST API are loaded with AddKlines and order books with
+// SetDepth, websocket events are pushed to the connected subscribers with PushKline, PushMarketStats, PushAggTrade,
+// PushDepth, PushMarkPrice and PushLiquidation. Orders are checked against the symbol filters, market orders fill at
+// the price set with SetPrice and limit orders stay open.
+type Server struct {
+	sync.RWMutex
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+
+	symbols     map[string]model.SymbolInfo
+	klines      map[string][]model.Candle
+	markKlines  map[string][]model.Candle // futures mark price klines
+	fundings    map[string][]model.FundingRate
+	openInts    map[string]model.OpenInterest
+	stats       map[string]model.MarketStats24h
+	depths      map[string]*model.OrderBook
+	subscribers map[string]map[*wsConn]struct{} // each stream name is a key
+
+	prices      map[string]float64
+	balances    map[string]model.Balance
+	orders      []*model.Order
+	nextOrderID int64
+
+	usedWeight       int
+	weightWindow     time.Time
+	throttleRequests int
+	retryAfter       time.Duration
+}
+
+// requestWeights are the weights reported in the used weight header, other endpoints weigh 1
+var requestWeights = map[string]int{
+	"/api/v3/exchangeInfo": 10,
+	"/api/v3/depth":        10,
+	"/api/v3/order":        2,
+	"/api/v3/openOrders":   3,
+	"/api/v3/account":      10,
+	"/fapi/v1/depth":       10,
+}
+
+type wsConn struct {
+	sync.Mutex
+	conn     *websocket.Conn
+	combined bool
+}
+
+// NewServer starts a fake Binance server listening on a local port. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		symbols:     make(map[string]model.SymbolInfo),
+		klines:      make(map[string][]model.Candle),
+		markKlines:  make(map[string][]model.Candle),
+		fundings:    make(map[string][]model.FundingRate),
+		openInts:    make(map[string]model.OpenInterest),
+		stats:       make(map[string]model.MarketStats24h),
+		depths:      make(map[string]*model.OrderBook),
+		subscribers: make(map[string]map[*wsConn]struct{}),
+		prices:      make(map[string]float64),
+		balances:    make(map[string]model.Balance),
+		nextOrderID: 1,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/ping", s.weighted(s.handlePing))
+	mux.HandleFunc("/api/v3/time", s.weighted(s.handleTime))
+	mux.HandleFunc("/api/v3/exchangeInfo", s.weighted(s.handleExchangeInfo))
+	mux.HandleFunc("/api/v3/klines", s.weighted(s.handleKlines))
+	mux.HandleFunc("/api/v3/depth", s.weighted(s.handleDepth))
+	mux.HandleFunc("/api/v3/ticker/price", s.weighted(s.handlePrice))
+	mux.HandleFunc("/api/v3/ticker/24hr", s.weighted(s.handleMarketStats))
+	mux.HandleFunc("/api/v3/order", s.weighted(s.handleOrder))
+	mux.HandleFunc("/api/v3/openOrders", s.weighted(s.handleOpenOrders))
+	mux.HandleFunc("/api/v3/account", s.weighted(s.handleAccount))
+	mux.HandleFunc("/fapi/v1/ping", s.weighted(s.handlePing))
+	mux.HandleFunc("/fapi/v1/time", s.weighted(s.handleTime))
+	mux.HandleFunc("/fapi/v1/exchangeInfo", s.weighted(s.handleFuturesExchangeInfo))
+	mux.HandleFunc("/fapi/v1/klines", s.weighted(s.handleKlines))
+	mux.HandleFunc("/fapi/v1/markPriceKlines", s.weighted(s.handleMarkPriceKlines))
+	mux.HandleFunc("/fapi/v1/depth", s.weighted(s.handleDepth))
+	mux.HandleFunc("/fapi/v1/fundingRate", s.weighted(s.handleFundingRate))
+	mux.HandleFunc("/fapi/v1/openInterest", s.weighted(s.handleOpenInterest))
+	mux.HandleFunc("/fapi/v1/ticker/24hr", s.weighted(s.handleMarketStats))
+	mux.HandleFunc("/ws/", s.handleStream)
+	mux.HandleFunc("/stream", s.handleCombinedStream)
+
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+// URL returns the REST endpoint, to be used as `binance.api_endpoint`
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// WsURL returns the websocket endpoint, to be used as `binance.ws_endpoint`
+func (s *Server) WsURL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+// Close closes every websocket connection and shuts down the server
+func (s *Server) Close() {
+	s.DropConnections()
+	s.server.Close()
+}
+
+// DropConnections closes every websocket connection, as a network failure would
+func (s *Server) DropConnections() {
+	s.RLock()
+	defer s.RUnlock()
+	for _, conns := range s.subscribers {
+		for conn := range conns {
+			conn.conn.Close()
+		}
+	}
+}
+
+// AddSymbol adds a symbol to the exchange info
+func (s *Server) AddSymbol(info model.SymbolInfo) {
+	s.Lock()
+	defer s.Unlock()
+	s.symbols[info.Symbol] = info
+}
+
+// AddKlines appends candles to the kline history served by the REST API
+func (s *Server) AddKlines(candles ...model.Candle) {
+	s.Lock()
+	defer s.Unlock()
+	addKlines(s.klines, candles)
+}
+
+// AddMarkPriceKlines appends candles to the futures mark price kline history
+func (s *Server) AddMarkPriceKlines(candles ...model.Candle) {
+	s.Lock()
+	defer s.Unlock()
+	addKlines(s.markKlines, candles)
+}
+
+func addKlines(klines map[string][]model.Candle, candles []model.Candle) {
+	for _, candle := range candles {
+		key := generateKey(candle.Symbol, candle.Timeframe)
+		klines[key] = append(klines[key], candle)
+	}
+	for key := range klines {
+		list := klines[key]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Time.Before(list[j].Time)
+		})
+	}
+}
+
+// AddFundingRates appends funding rates to the history served by the futures REST API
+func (s *Server) AddFundingRates(rates ...model.FundingRate) {
+	s.Lock()
+	defer s.Unlock()
+	for _, rate := range rates {
+		s.fundings[rate.Symbol] = append(s.fundings[rate.Symbol], rate)
+	}
+}
+
+// SetOpenInterest replaces the open interest served by the futures REST API
+func (s *Server) SetOpenInterest(openInterest model.OpenInterest) {
+	s.Lock()
+	defer s.Unlock()
+	s.openInts[openInterest.Symbol] = openInterest
+}
+
+// SetMarketStats replaces the 24h statistics of the symbol served by the REST API
+func (s *Server) SetMarketStats(stat model.MarketStats24h) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats[stat.Symbol] = stat
+}
+
+// SetDepth replaces the order book snapshot served by the REST API
+func (s *Server) SetDepth(symbol string, lastUpdateID int64, bids, asks []model.PriceLevel) {
+	s.Lock()
+	defer s.Unlock()
+	book := model.NewOrderBook(symbol)
+	book.Reset(lastUpdateID, bids, asks)
+	s.depths[symbol] = book
+}
+
+// Throttle answers the next n REST requests with HTTP 429 and the given Retry-After delay
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.throttleRequests = n
+	s.retryAfter = retryAfter
+}
+
+// UsedWeight returns the request weight used in the current minute
+func (s *Server) UsedWeight() int {
+	s.RLock()
+	defer s.RUnlock()
+	if s.weightWindow.Before(time.Now().Truncate(time.Minute)) {
+		return 0
+	}
+	return s.usedWeight
+}
+
+// Subscribers returns the number of connections subscribing to the stream, e.g. `kncusdt@kline_4h`
+func (s *Server) Subscribers(stream string) int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.subscribers[stream])
+}
+
+// PushKline sends a kline event to every subscriber of the candle stream and returns the number of receivers
+func (s *Server) PushKline(candle model.Candle) int {
+	event := binance.WsKlineEvent{
+		Event:  "kline",
+		Time:   toMilliseconds(time.Now()),
+		Symbol: candle.Symbol,
+		Kline: binance.WsKline{
+			StartTime: toMilliseconds(candle.Time),
+			EndTime:   closeTime(candle),
+			Symbol:    candle.Symbol,
+			Interval:  candle.Timeframe.String(),
+			Open:      formatFloat(candle.Open),
+			Close:     formatFloat(candle.Close),
+			High:      formatFloat(candle.High),
+			Low:       formatFloat(candle.Low),
+			Volume:    formatFloat(candle.Volume),
+			TradeNum:  candle.Trades,
+			IsFinal:   candle.Complete,
+		},
+	}
+	return s.push(KlineStream(candle.Symbol, candle.Timeframe), event)
+}
+
+// PushMarketStats sends a 24h ticker event to every subscriber of the symbol ticker stream
+func (s *Server) PushMarketStats(stat model.MarketStats24h) int {
+	event := binance.WsMarketStatEvent{
+		Event:              "24hrTicker",
+		Time:               toMilliseconds(time.Now()),
+		Symbol:             stat.Symbol,
+		PriceChange:        stat.PriceChange,
+		PriceChangePercent: stat.PriceChangePercent,
+		LastPrice:          formatFloat(stat.LastPrice),
+		CloseQty:           formatFloat(stat.LastQty),
+		BaseVolume:         formatFloat(stat.BaseVolume),
+		QuoteVolume:        formatFloat(stat.QuoteVolume),
+		OpenTime:           toMilliseconds(stat.OpenTime),
+		CloseTime:          toMilliseconds(stat.CloseTime),
+		FirstID:            stat.FirstTradeId,
+		LastID:             stat.LastTradeId,
+		Count:              stat.TotalTrades,
+	}
+	return s.push(MarketStatsStream(stat.Symbol), event)
+}
+
+// PushAggTrade sends an aggregate trade event to every subscriber of the symbol trade stream
+func (s *Server) PushAggTrade(trade model.Trade) int {
+	event := binance.WsAggTradeEvent{
+		Event:        "aggTrade",
+		Time:         toMilliseconds(time.Now()),
+		Symbol:       trade.Symbol,
+		AggTradeID:   trade.ID,
+		Price:        formatFloat(trade.Price),
+		Quantity:     formatFloat(trade.Quantity),
+		TradeTime:    toMilliseconds(trade.Time),
+		IsBuyerMaker: trade.IsBuyerMaker,
+	}
+	return s.push(AggTradeStream(trade.Symbol), event)
+}
+
+// PushDepth applies a depth update to the order book snapshot and sends it to every subscriber of the symbol depth
+// stream. Pushing an update whose firstUpdateID does not follow the previous one simulates a missed update.
+func (s *Server) PushDepth(symbol string, firstUpdateID, lastUpdateID int64, bids, asks []model.PriceLevel) int {
+	s.Lock()
+	book, ok := s.depths[symbol]
+	if !ok {
+		book = model.NewOrderBook(symbol)
+		s.depths[symbol] = book
+	}
+	book.Update(lastUpdateID, bids, asks)
+	s.Unlock()
+
+	event := struct {
+		Event         string      `json:"e"`
+		Time          int64       `json:"E"`
+		Symbol        string      `json:"s"`
+		FirstUpdateID int64       `json:"U"`
+		LastUpdateID  int64       `json:"u"`
+		Bids          [][2]string `json:"b"`
+		Asks          [][2]string `json:"a"`
+	}{
+		Event:         "depthUpdate",
+		Time:          toMilliseconds(time.Now()),
+		Symbol:        symbol,
+		FirstUpdateID: firstUpdateID,
+		LastUpdateID:  lastUpdateID,
+		Bids:          formatLevels(bids),
+		Asks:          formatLevels(asks),
+	}
+	return s.push(DepthStream(symbol), event)
+}
+
+// PushMarkPrice sends a mark price event to every subscriber of the symbol mark price stream
+func (s *Server) PushMarkPrice(markPrice model.MarkPrice) int {
+	event := futures.WsMarkPriceEvent{
+		Event:           "markPriceUpdate",
+		Time:            toMilliseconds(markPrice.Time),
+		Symbol:          markPrice.Symbol,
+		MarkPrice:       formatFloat(markPrice.MarkPrice),
+		IndexPrice:      formatFloat(markPrice.IndexPrice),
+		FundingRate:     formatFloat(markPrice.FundingRate),
+		NextFundingTime: toMilliseconds(markPrice.NextFundingTime),
+	}
+	return s.push(MarkPriceStream(markPrice.Symbol), event)
+}
+
+// PushLiquidation sends a futures liquidation order event to every subscriber of the symbol force order stream
+func (s *Server) PushLiquidation(liquidation model.Liquidation) int {
+	event := futures.WsLiquidationOrderEvent{
+		Event: "forceOrder",
+		Time:  toMilliseconds(liquidation.Time),
+		LiquidationOrder: futures.WsLiquidationOrder{
+			Symbol:               liquidation.Symbol,
+			Side:                 futures.SideType(liquidation.Side),
+			OrderType:            futures.OrderTypeLimit,
+			TimeInForce:          futures.TimeInForceTypeIOC,
+			OrigQuantity:         formatFloat(liquidation.Quantity),
+			Price:                formatFloat(liquidation.Price),
+			AvgPrice:             formatFloat(liquidation.Price),
+			OrderStatus:          futures.OrderStatusTypeFilled,
+			LastFilledQty:        formatFloat(liquidation.Quantity),
+			AccumulatedFilledQty: formatFloat(liquidation.Quantity),
+			TradeTime:            toMilliseconds(liquidation.Time),
+		},
+	}
+	return s.push(ForceOrderStream(liquidation.Symbol), event)
+}
+
+// KlineStream returns the stream name of the symbol candles
+func KlineStream(symbol string, timeframe model.Timeframe) string {
+	return fmt.Sprintf("%s@kline_%s", strings.ToLower(symbol), timeframe)
+}
+
+// MarketStatsStream returns the stream name of the symbol 24h ticker
+func MarketStatsStream(symbol string) string {
+	return fmt.Sprintf("%s@ticker", strings.ToLower(symbol))
+}
+
+// AggTradeStream returns the stream name of the symbol aggregate trades
+func AggTradeStream(symbol string) string {
+	return fmt.Sprintf("%s@aggTrade", strings.ToLower(symbol))
+}
+
+// DepthStream returns the stream name of the symbol depth updates
+func DepthStream(symbol string) string {
+	return fmt.Sprintf("%s@depth@100ms", strings.ToLower(symbol))
+}
+
+// MarkPriceStream returns the stream name of the futures symbol mark price, updated every second
+func MarkPriceStream(symbol string) string {
+	return fmt.Sprintf("%s@markPrice@1s", strings.ToLower(symbol))
+}
+
+// ForceOrderStream returns the stream name of the futures symbol liquidation orders
+func ForceOrderStream(symbol string) string {
+	return fmt.Sprintf("%s@forceOrder", strings.ToLower(symbol))
+}
+
+// ReadCandlesCSV reads candles from a csv file in the layout written by the download command
+func ReadCandlesCSV(file string) ([]model.Candle, error) {
+	csvFile, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer csvFile.Close()
+
+	lines, err := csv.NewReader(csvFile).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var candles = make([]model.Candle, 0, len(lines))
+	for _, line := range lines {
+		candle, err := model.CandleFromSlice(line)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+func (s *Server) push(stream string, event interface{}) int {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return 0
+	}
+	combinedData, err := json.Marshal(struct {
+		Stream string          `json:"stream"`
+		Data   json.RawMessage `json:"data"`
+	}{
+		Stream: stream,
+		Data:   data,
+	})
+	if err != nil {
+		return 0
+	}
+
+	s.RLock()
+	var conns = make([]*wsConn, 0, len(s.subscribers[stream]))
+	for conn := range s.subscribers[stream] {
+		conns = append(conns, conn)
+	}
+	s.RUnlock()
+
+	var sent int
+	for _, conn := range conns {
+		message := data
+		if conn.combined {
+			message = combinedData
+		}
+		conn.Lock()
+		err := conn.conn.WriteMessage(websocket.TextMessage, message)
+		conn.Unlock()
+		if err == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// weighted counts the request weight in the response headers, and answers HTTP 429 to throttled requests
+func (s *Server) weighted(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		weight, ok := requestWeights[r.URL.Path]
+		if !ok {
+			weight = 1
+		}
+
+		s.Lock()
+		if window := time.Now().Truncate(time.Minute); window.After(s.weightWindow) {
+			s.weightWindow = window
+			s.usedWeight = 0
+		}
+		s.usedWeight += weight
+		usedWeight := s.usedWeight
+		throttled := s.throttleRequests > 0
+		if throttled {
+			s.throttleRequests--
+		}
+		retryAfter := s.retryAfter
+		s.Unlock()
+
+		w.Header().Set("X-MBX-USED-WEIGHT", strconv.Itoa(usedWeight))
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", strconv.Itoa(usedWeight))
+		if throttled {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+			writeError(w, http.StatusTooManyRequests, -1003, "too many requests")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]int64{"serverTime": toMilliseconds(time.Now())})
+}
+
+func (s *Server) handleExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var symbols = make([]binance.Symbol, 0, len(s.symbols))
+	for _, info := range s.symbols {
+		var orderTypes = make([]string, 0, len(info.OrderTypes))
+		for _, orderType := range info.OrderTypes {
+			orderTypes = append(orderTypes, string(orderType))
+		}
+		symbols = append(symbols, binance.Symbol{
+			Symbol:             info.Symbol,
+			Status:             info.Status,
+			BaseAsset:          info.BaseAsset,
+			BaseAssetPrecision: info.QuantityPrecision,
+			QuoteAsset:         info.QuoteAsset,
+			QuotePrecision:     info.PricePrecision,
+			OrderTypes:         orderTypes,
+			Permissions:        info.Permissions,
+			Filters: []map[string]interface{}{
+				{
+					"filterType": string(binance.SymbolFilterTypePriceFilter),
+					"minPrice":   formatFloat(info.MinPrice),
+					"maxPrice":   formatFloat(info.MaxPrice),
+					"tickSize":   formatFloat(info.TickSize),
+				},
+				{
+					"filterType": string(binance.SymbolFilterTypeLotSize),
+					"minQty":     formatFloat(info.MinQuantity),
+					"maxQty":     formatFloat(info.MaxQuantity),
+					"stepSize":   formatFloat(info.StepSize),
+				},
+				{
+					"filterType":       "NOTIONAL",
+					"minNotional":      formatFloat(info.MinNotional),
+					"applyMinToMarket": true,
+					"maxNotional":      "9000000.00000000",
+					"applyMaxToMarket": false,
+					"avgPriceMins":     5,
+				},
+			},
+		})
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Symbol < symbols[j].Symbol
+	})
+
+	writeJSON(w, binance.ExchangeInfo{
+		Timezone:   "UTC",
+		ServerTime: toMilliseconds(time.Now()),
+		Symbols:    symbols,
+	})
+}
+
+// handleFuturesExchangeInfo serves every symbol as a futures contract, perpetual unless its contract type is set
+func (s *Server) handleFuturesExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var symbols = make([]futures.Symbol, 0, len(s.symbols))
+	for _, info := range s.symbols {
+		contractType := futures.ContractType(info.ContractType)
+		if contractType == "" {
+			contractType = futures.ContractType(model.ContractTypePerpetual)
+		}
+		marginAsset := info.MarginAsset
+		if marginAsset == "" {
+			marginAsset = info.QuoteAsset
+		}
+		var onboardDate int64
+		if !info.OnboardDate.IsZero() {
+			onboardDate = toMilliseconds(info.OnboardDate)
+		}
+		var orderTypes = make([]futures.OrderType, 0, len(info.OrderTypes))
+		for _, orderType := range info.OrderTypes {
+			if orderType == model.OrderTypeStopLossLimit {
+				orderTypes = append(orderTypes, futures.OrderTypeStop)
+				continue
+			}
+			orderTypes = append(orderTypes, futures.OrderType(orderType))
+		}
+		symbols = append(symbols, futures.Symbol{
+			Symbol:            info.Symbol,
+			Pair:              info.Symbol,
+			ContractType:      contractType,
+			OnboardDate:       onboardDate,
+			Status:            info.Status,
+			PricePrecision:    info.PricePrecision,
+			QuantityPrecision: info.QuantityPrecision,
+			OrderType:         orderTypes,
+			BaseAsset:         info.BaseAsset,
+			QuoteAsset:        info.QuoteAsset,
+			MarginAsset:       marginAsset,
+			Filters: []map[string]interface{}{
+				{
+					"filterType": string(futures.SymbolFilterTypePrice),
+					"minPrice":   formatFloat(info.MinPrice),
+					"maxPrice":   formatFloat(info.MaxPrice),
+					"tickSize":   formatFloat(info.TickSize),
+				},
+				{
+					"filterType": string(futures.SymbolFilterTypeLotSize),
+					"minQty":     formatFloat(info.MinQuantity),
+					"maxQty":     formatFloat(info.MaxQuantity),
+					"stepSize":   formatFloat(info.StepSize),
+				},
+				{
+					"filterType": string(futures.SymbolFilterTypeMinNotional),
+					"notional":   formatFloat(info.MinNotional),
+				},
+			},
+		})
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Symbol < symbols[j].Symbol
+	})
+
+	writeJSON(w, futures.ExchangeInfo{
+		Timezone:   "UTC",
+		ServerTime: toMilliseconds(time.Now()),
+		Symbols:    symbols,
+	})
+}
+
+func (s *Server) handleKlines(w http.ResponseWriter, r *http.Request) {
+	s.serveKlines(w, r, false)
+}
+
+func (s *Server) handleMarkPriceKlines(w http.ResponseWriter, r *http.Request) {
+	s.serveKlines(w, r, true)
+}
+
+func (s *Server) serveKlines(w http.ResponseWriter, r *http.Request, markPrice bool) {
+	query := r.URL.Query()
+	symbol, interval := query.Get("symbol"), query.Get("interval")
+	if symbol == "" || interval == "" {
+		writeError(w, http.StatusBadRequest, -1102, "mandatory parameter 'symbol' or 'interval' was not sent")
+		return
+	}
+
+	limit := DefaultKlinesLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, -1100, "illegal characters found in parameter 'limit'")
+			return
+		}
+	}
+	if limit > MaxKlinesLimit {
+		limit = MaxKlinesLimit
+	}
+	startTime, _ := strconv.ParseInt(query.Get("startTime"), 10, 64)
+	_, hasStartTime := query["startTime"]
+	endTime, _ := strconv.ParseInt(query.Get("endTime"), 10, 64)
+
+	s.RLock()
+	klines := s.klines
+	if markPrice {
+		klines = s.markKlines
+	}
+	var candles = make([]model.Candle, 0)
+	for _, candle := range klines[generateKey(symbol, model.Timeframe(interval))] {
+		openTime := toMilliseconds(candle.Time)
+		if startTime > 0 && openTime < startTime {
+			continue
+		}
+		if endTime > 0 && openTime > endTime {
+			continue
+		}
+		candles = append(candles, candle)
+	}
+	s.RUnlock()
+
+	// binance returns the oldest candles when startTime is informed, the most recent ones otherwise
+	if len(candles) > limit {
+		if hasStartTime {
+			candles = candles[:limit]
+		} else {
+			candles = candles[len(candles)-limit:]
+		}
+	}
+
+	var result = make([][]interface{}, 0, len(candles))
+	for _, candle := range candles {
+		result = append(result, []interface{}{
+			toMilliseconds(candle.Time),
+			formatFloat(candle.Open),
+			formatFloat(candle.High),
+			formatFloat(candle.Low),
+			formatFloat(candle.Close),
+			formatFloat(candle.Volume),
+			closeTime(candle),
+			formatFloat(candle.Volume * candle.Close),
+			candle.Trades,
+			"0",
+			"0",
+			"0",
+		})
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleFundingRate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	startTime, _ := strconv.ParseInt(query.Get("startTime"), 10, 64)
+	endTime, _ := strconv.ParseInt(query.Get("endTime"), 10, 64)
+
+	s.RLock()
+	defer s.RUnlock()
+	var result = make([]futures.FundingRate, 0)
+	for _, rate := range s.fundings[symbol] {
+		fundingTime := toMilliseconds(rate.FundingTime)
+		if (startTime > 0 && fundingTime < startTime) || (endTime > 0 && fundingTime > endTime) {
+			continue
+		}
+		result = append(result, futures.FundingRate{
+			Symbol:      rate.Symbol,
+			FundingRate: formatFloat(rate.FundingRate),
+			FundingTime: fundingTime,
+		})
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) handleOpenInterest(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	s.RLock()
+	openInterest, ok := s.openInts[symbol]
+	s.RUnlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, -1121, "invalid symbol")
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"symbol":       openInterest.Symbol,
+		"openInterest": formatFloat(openInterest.OpenInterest),
+		"time":         toMilliseconds(openInterest.Time),
+	})
+}
+
+func (s *Server) handleMarketStats(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+	var result = make([]binance.PriceChangeStats, 0, len(s.stats))
+	for _, stat := range s.stats {
+		result = append(result, binance.PriceChangeStats{
+			Symbol:             stat.Symbol,
+			PriceChange:        stat.PriceChange,
+			PriceChangePercent: stat.PriceChangePercent,
+			LastPrice:          formatFloat(stat.LastPrice),
+			LastQty:            formatFloat(stat.LastQty),
+			Volume:             formatFloat(stat.BaseVolume),
+			QuoteVolume:        formatFloat(stat.QuoteVolume),
+			OpenTime:           toMilliseconds(stat.OpenTime),
+			CloseTime:          toMilliseconds(stat.CloseTime),
+			FristID:            stat.FirstTradeId,
+			LastID:             stat.LastTradeId,
+			Count:              stat.TotalTrades,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Symbol < result[j].Symbol
+	})
+	writeJSON(w, result)
+}
+
+func (s *Server) handleDepth(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	s.RLock()
+	book, ok := s.depths[symbol]
+	s.RUnlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, -1121, "invalid symbol")
+		return
+	}
+
+	writeJSON(w, struct {
+		LastUpdateID int64       `json:"lastUpdateId"`
+		Bids         [][2]string `json:"bids"`
+		Asks         [][2]string `json:"asks"`
+	}{
+		LastUpdateID: book.LastUpdateID(),
+		Bids:         formatLevels(book.Bids(limit)),
+		Asks:         formatLevels(book.Asks(limit)),
+	})
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	stream := strings.TrimPrefix(r.URL.Path, "/ws/")
+	s.serveWs(w, r, []string{stream}, false)
+}
+
+func (s *Server) handleCombinedStream(w http.ResponseWriter, r *http.Request) {
+	streams := strings.Split(r.URL.Query().Get("streams"), "/")
+	s.serveWs(w, r, streams, true)
+}
+
+func (s *Server) serveWs(w http.ResponseWriter, r *http.Request, streams []string, combined bool) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsConn{
+		conn:     conn,
+		combined: combined,
+	}
+
+	s.Lock()
+	for _, stream := range streams {
+		if _, ok := s.subscribers[stream]; !ok {
+			s.subscribers[stream] = make(map[*wsConn]struct{})
+		}
+		s.subscribers[stream][c] = struct{}{}
+	}
+	s.Unlock()
+
+	// the client never sends data, reading only detects that the connection is closed
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.Lock()
+	for _, stream := range streams {
+		delete(s.subscribers[stream], c)
+	}
+	s.Unlock()
+	conn.Close()
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, code int64, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": code,
+		"msg":  msg,
+	})
+}
+
+func generateKey(symbol string, timeframe model.Timeframe) string {
+	return fmt.Sprintf("%s--%s", symbol, timeframe)
+}
+
+func closeTime(candle model.Candle) int64 {
+	return toMilliseconds(candle.Timeframe.CloseTime(candle.Time)) - 1
+}
+
+func toMilliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func formatLevels(levels []model.PriceLevel) [][2]string {
+	var result = make([][2]string, 0, len(levels))
+	for _, level := range levels {
+		result = append(result, [2]string{formatFloat(level.Price), formatFloat(level.Quantity)})
+	}
+	return result
+}
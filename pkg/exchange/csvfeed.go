@@ -6,7 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strconv"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,7 +19,7 @@ var ErrInsufficientData = errors.New("insufficient data")
 type SymbolFeed struct {
 	SymbolInfo model.SymbolInfo
 	File       string
-	Timeframe  string
+	Timeframe  model.Timeframe
 }
 
 type CSVFeed struct {
@@ -27,6 +27,11 @@ type CSVFeed struct {
 	l       *zap.SugaredLogger
 	Feeds   map[string]SymbolFeed
 	Candles map[string][]model.Candle
+	Trades  map[string][]model.Trade
+
+	Fundings      map[string][]model.FundingRate
+	OpenInterests map[string][]model.OpenInterest
+	Liquidations  map[string][]model.Liquidation
 
 	exchangeInfo model.ExchangeInfo
 }
@@ -37,6 +42,11 @@ func NewCSVFeed(feeds ...SymbolFeed) (*CSVFeed, error) {
 		l:       l,
 		Feeds:   make(map[string]SymbolFeed),
 		Candles: make(map[string][]model.Candle),
+		Trades:  make(map[string][]model.Trade),
+
+		Fundings:      make(map[string][]model.FundingRate),
+		OpenInterests: make(map[string][]model.OpenInterest),
+		Liquidations:  make(map[string][]model.Liquidation),
 	}
 
 	for _, feed := range feeds {
@@ -54,6 +64,104 @@ func NewCSVFeed(feeds ...SymbolFeed) (*CSVFeed, error) {
 	return csvFeed, nil
 }
 
+// LoadCandles feeds the candles of a symbol and timeframe read from elsewhere than a csv file, e.g. a candle store
+func (c *CSVFeed) LoadCandles(info model.SymbolInfo, timeframe model.Timeframe, candles []model.Candle) {
+	c.Lock()
+	defer c.Unlock()
+	key := c.feedTimeframeKey(info.Symbol, timeframe)
+	c.Feeds[key] = SymbolFeed{SymbolInfo: info, Timeframe: timeframe}
+	c.Candles[key] = candles
+}
+
+// LoadTrades loads the aggregate trades of a symbol from a csv file in the layout of model.Trade.ToSlice
+func (c *CSVFeed) LoadTrades(symbol, file string) error {
+	lines, err := c.readCsv(file)
+	if err != nil {
+		return err
+	}
+
+	var trades = make([]model.Trade, 0, len(lines))
+	for _, line := range lines {
+		trade, err := model.TradeFromSlice(line)
+		if err != nil {
+			return err
+		}
+		trades = append(trades, trade)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.Trades[symbol] = trades
+	return nil
+}
+
+// LoadFundingRates loads the funding rates of a symbol from a csv file in the layout of model.FundingRate.ToSlice
+func (c *CSVFeed) LoadFundingRates(symbol, file string) error {
+	lines, err := c.readCsv(file)
+	if err != nil {
+		return err
+	}
+
+	var rates = make([]model.FundingRate, 0, len(lines))
+	for _, line := range lines {
+		rate, err := model.FundingRateFromSlice(line)
+		if err != nil {
+			return err
+		}
+		rates = append(rates, rate)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.Fundings[symbol] = rates
+	return nil
+}
+
+// LoadOpenInterest loads the open interest history of a symbol from a csv file in the layout of
+// model.OpenInterest.ToSlice
+func (c *CSVFeed) LoadOpenInterest(symbol, file string) error {
+	lines, err := c.readCsv(file)
+	if err != nil {
+		return err
+	}
+
+	var openInterests = make([]model.OpenInterest, 0, len(lines))
+	for _, line := range lines {
+		openInterest, err := model.OpenInterestFromSlice(line)
+		if err != nil {
+			return err
+		}
+		openInterests = append(openInterests, openInterest)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.OpenInterests[symbol] = openInterests
+	return nil
+}
+
+// LoadLiquidations loads the liquidations of a symbol from a csv file in the layout of model.Liquidation.ToSlice
+func (c *CSVFeed) LoadLiquidations(symbol, file string) error {
+	lines, err := c.readCsv(file)
+	if err != nil {
+		return err
+	}
+
+	var liquidations = make([]model.Liquidation, 0, len(lines))
+	for _, line := range lines {
+		liquidation, err := model.LiquidationFromSlice(line)
+		if err != nil {
+			return err
+		}
+		liquidations = append(liquidations, liquidation)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.Liquidations[symbol] = liquidations
+	return nil
+}
+
 func (c *CSVFeed) GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, error) {
 	c.RLock()
 	defer c.RUnlock()
@@ -67,9 +175,9 @@ func (c *CSVFeed) GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, erro
 	}, nil
 }
 
-func (c *CSVFeed) CandlesByLimit(ctx context.Context, symbol, timeframe string, limit int) ([]model.Candle, error) {
-	c.RLock()
-	defer c.RUnlock()
+func (c *CSVFeed) CandlesByLimit(ctx context.Context, symbol string, timeframe model.Timeframe, limit int) ([]model.Candle, error) {
+	c.Lock()
+	defer c.Unlock()
 	var result = make([]model.Candle, 0)
 	key := c.feedTimeframeKey(symbol, timeframe)
 	if len(c.Candles[key]) < limit {
@@ -81,7 +189,11 @@ func (c *CSVFeed) CandlesByLimit(ctx context.Context, symbol, timeframe string,
 	return result, nil
 }
 
-func (c *CSVFeed) CandlesByPeriod(ctx context.Context, symbol, timeframe string, start, end time.Time) ([]model.Candle, error) {
+func (c *CSVFeed) FirstCandle(ctx context.Context, symbol string, timeframe model.Timeframe) (model.Candle, error) {
+	return model.Candle{}, fmt.Errorf("%w: csv feed has no listing date", ErrNotSupported)
+}
+
+func (c *CSVFeed) CandlesByPeriod(ctx context.Context, symbol string, timeframe model.Timeframe, start, end time.Time) ([]model.Candle, error) {
 	c.RLock()
 	defer c.RUnlock()
 	var result = make([]model.Candle, 0)
@@ -95,10 +207,37 @@ func (c *CSVFeed) CandlesByPeriod(ctx context.Context, symbol, timeframe string,
 	return result, nil
 }
 
-func (c *CSVFeed) CandlesSubscription(ctx context.Context, symbol, timeframe string, candleCh chan<- model.Candle, errCh chan<- error) {
-	key := c.feedTimeframeKey(symbol, timeframe)
-	for _, candle := range c.Candles[key] {
-		candleCh <- candle
+func (c *CSVFeed) CandlesSubscription(ctx context.Context, symbol string, timeframe model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	c.RLock()
+	candles := c.Candles[c.feedTimeframeKey(symbol, timeframe)]
+	c.RUnlock()
+
+	c.emitCandles(ctx, candles, candleCh)
+}
+
+// CombinedCandlesSubscription emits the candles of every symbol ordered by time, as they would arrive from a
+// combined stream
+func (c *CSVFeed) CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	var candles = make([]model.Candle, 0)
+	c.RLock()
+	for symbol, timeframe := range mapSymbolTimeframe {
+		candles = append(candles, c.Candles[c.feedTimeframeKey(symbol, timeframe)]...)
+	}
+	c.RUnlock()
+
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].Time.Before(candles[j].Time)
+	})
+	c.emitCandles(ctx, candles, candleCh)
+}
+
+func (c *CSVFeed) emitCandles(ctx context.Context, candles []model.Candle, candleCh chan<- model.Candle) {
+	for _, candle := range candles {
+		select {
+		case candleCh <- candle:
+		case <-ctx.Done():
+			return
+		}
 	}
 	// after we emit all the candles, we close the candle channel to indicate that no more candle is emitted
 	close(candleCh)
@@ -108,70 +247,148 @@ func (c *CSVFeed) MarketStatsSubscription(ctx context.Context, symbol string, st
 	c.l.Errorw("MarketStatsSubscription not implemented")
 }
 
-func (c *CSVFeed) feedTimeframeKey(symbol, timeframe string) string {
-	return fmt.Sprintf("%s--%s", symbol, timeframe)
+// OrderBookSubscription is not supported as csv files have no depth, the books are never synced
+func (c *CSVFeed) OrderBookSubscription(ctx context.Context, books []*model.OrderBook, errCh chan<- error) {
+	c.l.Warnw("OrderBookSubscription not supported by csv feed")
+	<-ctx.Done()
 }
 
-func (c *CSVFeed) parseCandlesFromCsv(csvFilepath string) ([]model.Candle, error) {
-	csvFile, err := os.Open(csvFilepath)
-	if err != nil {
-		return nil, err
-	}
+func (c *CSVFeed) TradesSubscription(ctx context.Context, symbol string, tradeCh chan<- model.Trade, errCh chan<- error) {
+	c.RLock()
+	trades := c.Trades[symbol]
+	c.RUnlock()
 
-	csvLines, err := csv.NewReader(csvFile).ReadAll()
-	if err != nil {
-		return nil, err
+	c.emitTrades(ctx, trades, tradeCh)
+}
+
+// CombinedTradesSubscription emits the trades of every symbol ordered by time
+func (c *CSVFeed) CombinedTradesSubscription(ctx context.Context, symbols []string, tradeCh chan<- model.Trade, errCh chan<- error) {
+	var trades = make([]model.Trade, 0)
+	c.RLock()
+	for _, symbol := range symbols {
+		trades = append(trades, c.Trades[symbol]...)
 	}
+	c.RUnlock()
 
-	var candles = make([]model.Candle, 0)
-	for _, line := range csvLines {
-		if len(line) < model.CandleFieldLength() {
-			return nil, fmt.Errorf("invalid csv candle data")
-		}
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].Time.Before(trades[j].Time)
+	})
+	c.emitTrades(ctx, trades, tradeCh)
+}
 
-		candle := model.Candle{
-			Symbol:    line[0],
-			Timeframe: line[1],
-			Complete:  true,
+func (c *CSVFeed) emitTrades(ctx context.Context, trades []model.Trade, tradeCh chan<- model.Trade) {
+	for _, trade := range trades {
+		select {
+		case tradeCh <- trade:
+		case <-ctx.Done():
+			return
 		}
+	}
+	close(tradeCh)
+}
 
-		timestamp, err := strconv.ParseInt(line[2], 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		candle.Time = time.Unix(timestamp, 0)
-		candle.Open, err = strconv.ParseFloat(line[3], 64)
-		if err != nil {
-			return nil, err
+// FundingRates returns the loaded funding rates settled between start and end, zero times are not checked
+func (c *CSVFeed) FundingRates(ctx context.Context, symbol string, start, end time.Time) ([]model.FundingRate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	var result = make([]model.FundingRate, 0)
+	for _, rate := range c.Fundings[symbol] {
+		if (!start.IsZero() && rate.FundingTime.Before(start)) || (!end.IsZero() && rate.FundingTime.After(end)) {
+			continue
 		}
+		result = append(result, rate)
+	}
+	return result, nil
+}
 
-		candle.Close, err = strconv.ParseFloat(line[4], 64)
-		if err != nil {
-			return nil, err
-		}
+// OpenInterest returns the last loaded open interest of the symbol
+func (c *CSVFeed) OpenInterest(ctx context.Context, symbol string) (model.OpenInterest, error) {
+	c.RLock()
+	defer c.RUnlock()
+	openInterests := c.OpenInterests[symbol]
+	if len(openInterests) == 0 {
+		return model.OpenInterest{}, fmt.Errorf("%w: %s open interest", ErrInsufficientData, symbol)
+	}
+	return openInterests[len(openInterests)-1], nil
+}
 
-		candle.Low, err = strconv.ParseFloat(line[5], 64)
-		if err != nil {
-			return nil, err
-		}
+// OpenInterestSubscription emits the loaded open interest of every symbol ordered by time
+func (c *CSVFeed) OpenInterestSubscription(ctx context.Context, symbols []string, openInterestCh chan<- model.OpenInterest, errCh chan<- error) {
+	var openInterests = make([]model.OpenInterest, 0)
+	c.RLock()
+	for _, symbol := range symbols {
+		openInterests = append(openInterests, c.OpenInterests[symbol]...)
+	}
+	c.RUnlock()
 
-		candle.High, err = strconv.ParseFloat(line[6], 64)
-		if err != nil {
-			return nil, err
+	sort.SliceStable(openInterests, func(i, j int) bool {
+		return openInterests[i].Time.Before(openInterests[j].Time)
+	})
+	for _, openInterest := range openInterests {
+		select {
+		case openInterestCh <- openInterest:
+		case <-ctx.Done():
+			return
 		}
+	}
+	close(openInterestCh)
+}
 
-		candle.Volume, err = strconv.ParseFloat(line[7], 64)
-		if err != nil {
-			return nil, err
+// CombinedLiquidationsSubscription emits the loaded liquidations of every symbol ordered by time
+func (c *CSVFeed) CombinedLiquidationsSubscription(ctx context.Context, symbols []string, liquidationCh chan<- model.Liquidation, errCh chan<- error) {
+	var liquidations = make([]model.Liquidation, 0)
+	c.RLock()
+	for _, symbol := range symbols {
+		liquidations = append(liquidations, c.Liquidations[symbol]...)
+	}
+	c.RUnlock()
+
+	sort.SliceStable(liquidations, func(i, j int) bool {
+		return liquidations[i].Time.Before(liquidations[j].Time)
+	})
+	for _, liquidation := range liquidations {
+		select {
+		case liquidationCh <- liquidation:
+		case <-ctx.Done():
+			return
 		}
+	}
+	close(liquidationCh)
+}
+
+// MarketStats is not supported, csv files have no 24h statistics
+func (c *CSVFeed) MarketStats(ctx context.Context) ([]model.MarketStats24h, error) {
+	return nil, fmt.Errorf("%w: csv feed has no market stats", ErrNotSupported)
+}
+
+func (c *CSVFeed) feedTimeframeKey(symbol string, timeframe model.Timeframe) string {
+	return fmt.Sprintf("%s--%s", symbol, timeframe)
+}
+
+func (c *CSVFeed) parseCandlesFromCsv(csvFilepath string) ([]model.Candle, error) {
+	csvLines, err := c.readCsv(csvFilepath)
+	if err != nil {
+		return nil, err
+	}
 
-		candle.Trades, err = strconv.ParseInt(line[8], 10, 64)
+	var candles = make([]model.Candle, 0)
+	for _, line := range csvLines {
+		candle, err := model.CandleFromSlice(line)
 		if err != nil {
 			return nil, err
 		}
-
 		candles = append(candles, candle)
 	}
 
 	return candles, nil
 }
+
+func (c *CSVFeed) readCsv(csvFilepath string) ([][]string, error) {
+	csvFile, err := os.Open(csvFilepath)
+	if err != nil {
+		return nil, err
+	}
+	defer csvFile.Close()
+
+	return csv.NewReader(csvFile).ReadAll()
+}
@@ -2,23 +2,64 @@ package exchange
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/quangkeu95/binancebot/pkg/model"
 )
 
+// MaxStreamsPerConnection is the maximum number of streams a single combined websocket connection can subscribe to
+const MaxStreamsPerConnection = 1024
+
+var (
+	ErrTooManyStreams = errors.New("too many streams")
+	ErrUnknownSymbol  = errors.New("unknown symbol")
+	ErrNotSupported   = errors.New("not supported")
+)
+
 // Feeder feeder implementations help fetching market data
 type Feeder interface {
 	GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, error)
-	CandlesByLimit(ctx context.Context, symbol, timeframe string, limit int) ([]model.Candle, error)
-	CandlesByPeriod(ctx context.Context, symbol, timeframe string, start, end time.Time) ([]model.Candle, error)
+	CandlesByLimit(ctx context.Context, symbol string, timeframe model.Timeframe, limit int) ([]model.Candle, error)
+	CandlesByPeriod(ctx context.Context, symbol string, timeframe model.Timeframe, start, end time.Time) ([]model.Candle, error)
+	// FirstCandle returns the oldest candle of the symbol, e.g. to date the listing of a spot symbol
+	FirstCandle(ctx context.Context, symbol string, timeframe model.Timeframe) (model.Candle, error)
 
-	CandlesSubscription(ctx context.Context, symbol, timeframe string, candleCh chan<- model.Candle, errCh chan<- error)
-	// CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]string, candleCh chan<- model.Candle, errCh chan<- error)
+	CandlesSubscription(ctx context.Context, symbol string, timeframe model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error)
+	CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error)
 	MarketStatsSubscription(ctx context.Context, symbol string, statCh chan<- model.MarketStats24h, errCh chan<- error)
+	TradesSubscription(ctx context.Context, symbol string, tradeCh chan<- model.Trade, errCh chan<- error)
+	CombinedTradesSubscription(ctx context.Context, symbols []string, tradeCh chan<- model.Trade, errCh chan<- error)
+	OrderBookSubscription(ctx context.Context, books []*model.OrderBook, errCh chan<- error)
+
+	// FundingRates returns the funding rates of a perpetual contract settled between start and end, zero times are
+	// not sent
+	FundingRates(ctx context.Context, symbol string, start, end time.Time) ([]model.FundingRate, error)
+	OpenInterest(ctx context.Context, symbol string) (model.OpenInterest, error)
+	OpenInterestSubscription(ctx context.Context, symbols []string, openInterestCh chan<- model.OpenInterest, errCh chan<- error)
+	// CombinedLiquidationsSubscription subscribe the liquidations of multiple futures symbols through a single
+	// connection
+	CombinedLiquidationsSubscription(ctx context.Context, symbols []string, liquidationCh chan<- model.Liquidation, errCh chan<- error)
+	// MarketStats returns the 24h statistics of every symbol of the exchange
+	MarketStats(ctx context.Context) ([]model.MarketStats24h, error)
 	// CombinedMarketStatsSubscription(ctx context.Context, symbols []string, statCh chan<- model.MarketStats24h, errCh chan<- error)
 }
 
+// Broker broker implementations place and manage orders
+type Broker interface {
+	Balances(ctx context.Context) (map[string]model.Balance, error)
+	OrderMarket(ctx context.Context, side model.SideType, symbol string, quantity float64) (model.Order, error)
+	// OrderMarketQuote spends or receives quoteQuantity of the quote asset, e.g. buys 100 USDT of BTC
+	OrderMarketQuote(ctx context.Context, side model.SideType, symbol string, quoteQuantity float64) (model.Order, error)
+	OrderLimit(ctx context.Context, side model.SideType, symbol string, quantity, price float64) (model.Order, error)
+	// OrderStop places a limit order at price once the market reaches stopPrice
+	OrderStop(ctx context.Context, side model.SideType, symbol string, quantity, stopPrice, price float64) (model.Order, error)
+	Cancel(ctx context.Context, symbol string, id int64) (model.Order, error)
+	OpenOrders(ctx context.Context, symbol string) ([]model.Order, error)
+	Order(ctx context.Context, symbol string, id int64) (model.Order, error)
+}
+
 type Exchange interface {
 	Feeder
+	Broker
 }
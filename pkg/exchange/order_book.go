@@ -0,0 +1,209 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+const (
+	// DepthSnapshotLimit is the number of levels of each side fetched in the order book snapshots
+	DepthSnapshotLimit = 1000
+	// DepthSnapshotWeightShare is the part of the weight limit the order book snapshots may use, a reconnection of
+	// many books fetches their snapshots over several minutes instead of starving the other requests
+	DepthSnapshotWeightShare = 0.5
+
+	DepthSyncMinDelay = 200 * time.Millisecond
+	DepthSyncMaxDelay = 30 * time.Second
+)
+
+// depthSyncer sequences the depth updates of a symbol on top of a snapshot, following
+// https://binance-docs.github.io/apidocs/spot/en/#how-to-manage-a-local-order-book-correctly
+type depthSyncer struct {
+	sync.Mutex
+	book      *model.OrderBook
+	synced    bool
+	syncing   bool
+	buffer    []*wsDepthEvent // updates received while the snapshot is fetched
+	lastEvent int64           // last update id of the last event applied since the snapshot
+}
+
+// depthSnapshot requests the order book of a symbol
+type depthSnapshot func(ctx context.Context, symbol string) (lastUpdateID int64, bids, asks []model.PriceLevel, err error)
+
+// OrderBookSubscription keeps the order books in sync with the depth streams of their symbols until ctx is done.
+// Each book is rebuilt from a snapshot when the subscription starts and whenever an update is missed, it is not
+// synced in the meantime.
+func (b *Binance) OrderBookSubscription(ctx context.Context, books []*model.OrderBook, errCh chan<- error) {
+	b.orderBookSubscription(ctx, books, b.depthSnapshot, errCh)
+}
+
+func (b *Binance) depthSnapshot(ctx context.Context, symbol string) (int64, []model.PriceLevel, []model.PriceLevel, error) {
+	snapshot, err := b.client.NewDepthService().Symbol(symbol).Limit(DepthSnapshotLimit).Do(ctx)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return snapshot.LastUpdateID, levelsFromBinance(snapshot.Bids), levelsFromBinance(snapshot.Asks), nil
+}
+
+func (s *binanceStreams) orderBookSubscription(ctx context.Context, books []*model.OrderBook, snapshot depthSnapshot, errCh chan<- error) {
+	if len(books) > MaxStreamsPerConnection {
+		sendError(ctx, errCh, fmt.Errorf("%w: %d streams, maximum is %d", ErrTooManyStreams, len(books), MaxStreamsPerConnection))
+		return
+	}
+
+	// snapshots still fetched are abandoned with the connection
+	syncCtx, cancel := context.WithCancel(withWeightShare(ctx, DepthSnapshotWeightShare))
+	defer cancel()
+
+	var (
+		symbols = make([]string, 0, len(books))
+		syncers = make(map[string]*depthSyncer, len(books))
+	)
+	for _, book := range books {
+		book.Invalidate()
+		symbols = append(symbols, book.Symbol)
+		syncers[strings.ToUpper(book.Symbol)] = &depthSyncer{book: book, syncing: true}
+	}
+	handler := func(event *wsDepthEvent) {
+		syncer, ok := syncers[event.Symbol]
+		if !ok {
+			return
+		}
+		if syncer.onEvent(event) {
+			s.l.Warnw("order book out of sync", "symbol", event.Symbol, "first_update_id", event.FirstUpdateID,
+				"last_update_id", syncer.book.LastUpdateID())
+			go s.syncOrderBook(syncCtx, syncer, snapshot)
+		}
+	}
+
+	s.l.Debugw("binance order book subscription", "streams", len(books))
+	s.serveSubscription(ctx, "order book subscription", func(errHandler func(err error)) (chan struct{}, chan struct{}, error) {
+		doneC, stopC, err := wsCombinedDepthServe(s.wsEndpoint, symbols, handler, errHandler)
+		if err != nil {
+			return doneC, stopC, err
+		}
+		// the snapshots are fetched once the updates are buffered
+		for _, syncer := range syncers {
+			go s.syncOrderBook(syncCtx, syncer, snapshot)
+		}
+		return doneC, stopC, nil
+	}, errCh)
+}
+
+// syncOrderBook fetches snapshots until one is recent enough to be followed by the buffered updates
+func (s *binanceStreams) syncOrderBook(ctx context.Context, syncer *depthSyncer, snapshot depthSnapshot) {
+	backoff := app.NewBackoff(DepthSyncMinDelay, DepthSyncMaxDelay)
+	for {
+		lastUpdateID, bids, asks, err := snapshot(ctx, syncer.book.Symbol)
+		if err != nil {
+			s.l.Warnw("order book snapshot error", "error", err, "symbol", syncer.book.Symbol)
+		} else if syncer.resync(lastUpdateID, bids, asks) {
+			s.l.Debugw("order book synced", "symbol", syncer.book.Symbol, "last_update_id", lastUpdateID)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+// onEvent applies the update to a synced book and buffers it otherwise. It returns true when an update was missed
+// and a new snapshot has to be fetched.
+func (s *depthSyncer) onEvent(event *wsDepthEvent) bool {
+	s.Lock()
+	defer s.Unlock()
+	if !s.synced {
+		s.buffer = append(s.buffer, event)
+		return false
+	}
+	if s.apply(event) {
+		return false
+	}
+
+	s.synced = false
+	s.book.Invalidate()
+	s.buffer = []*wsDepthEvent{event}
+	if s.syncing {
+		return false
+	}
+	s.syncing = true
+	return true
+}
+
+// resync resets the book with the snapshot and replays the buffered updates, it returns false when an update
+// between the snapshot and the buffered ones is missing
+func (s *depthSyncer) resync(lastUpdateID int64, bids, asks []model.PriceLevel) bool {
+	s.Lock()
+	defer s.Unlock()
+	s.book.Reset(lastUpdateID, bids, asks)
+	s.lastEvent = 0
+	for i, event := range s.buffer {
+		if !s.apply(event) {
+			s.book.Invalidate()
+			s.buffer = s.buffer[i:]
+			return false
+		}
+	}
+	s.buffer = nil
+	s.synced = true
+	s.syncing = false
+	return true
+}
+
+// apply applies the update unless it is older than the book, it returns false when the update does not follow
+// the last one applied. Futures updates reference the previous one, their ids are not consecutive.
+func (s *depthSyncer) apply(event *wsDepthEvent) bool {
+	lastUpdateID := s.book.LastUpdateID()
+	if event.LastUpdateID <= lastUpdateID {
+		return true
+	}
+	if event.PrevUpdateID > 0 && s.lastEvent > 0 {
+		if event.PrevUpdateID != s.lastEvent {
+			return false
+		}
+	} else if event.FirstUpdateID > lastUpdateID+1 {
+		return false
+	}
+	s.book.Update(event.LastUpdateID, levelsFromWs(event.Bids), levelsFromWs(event.Asks))
+	s.lastEvent = event.LastUpdateID
+	return true
+}
+
+func levelsFromBinance(levels []binance.Bid) []model.PriceLevel {
+	var result = make([]model.PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		price, quantity, err := level.Parse()
+		if err != nil {
+			continue
+		}
+		result = append(result, model.PriceLevel{Price: price, Quantity: quantity})
+	}
+	return result
+}
+
+func levelsFromWs(levels [][2]string) []model.PriceLevel {
+	var result = make([]model.PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		price, err := strconv.ParseFloat(level[0], 64)
+		if err != nil {
+			continue
+		}
+		quantity, err := strconv.ParseFloat(level[1], 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, model.PriceLevel{Price: price, Quantity: quantity})
+	}
+	return result
+}
@@ -0,0 +1,468 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// PaperEnabledFlag places the orders of the strategies on a PaperExchange wrapping Binance
+	PaperEnabledFlag = "paper.enabled"
+	// PaperFeeFlag is the fee rate of the paper orders, paid in the received asset
+	PaperFeeFlag = "paper.fee"
+	// PaperSlippageFlag is the price ratio market orders lose, e.g. 0.001 buys 0.1% higher than the last price
+	PaperSlippageFlag = "paper.slippage"
+	// PaperVolumeRatioFlag is the part of the volume traded in a candle update a limit order can fill, zero fills
+	// the whole order at once
+	PaperVolumeRatioFlag = "paper.volume_ratio"
+	// PaperBalancesFlag is the initial free balance of each asset, e.g. {"USDT": 1000}
+	PaperBalancesFlag = "paper.balances"
+
+	DefaultPaperFee = 0.001
+
+	// PaperAccountKey is the storage key of the paper account
+	PaperAccountKey = "paper_account"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrNoPrice             = errors.New("no price")
+)
+
+// PaperExchange simulates the orders of a Broker against the candles streamed by the wrapped Feeder, so strategies
+// can trade virtual balances. Market orders fill at the last close price moved by the slippage, limit orders fill at
+// their price once a candle trades through it, up to the volume ratio of each candle update. Stop orders become limit
+// orders once a candle reaches the stop price. The account is saved to the storage after every change.
+//
+// Only the first timeframe subscribed for a symbol fills its orders, so the volume of a symbol is not counted twice.
+type PaperExchange struct {
+	Feeder
+	sync.Mutex
+	l           *zap.SugaredLogger
+	storage     storage.KeyValueStorage
+	fee         float64
+	slippage    float64
+	volumeRatio float64
+
+	symbols map[string]model.SymbolInfo
+	prices  map[string]float64
+	candles map[string]model.Candle // last candle used to fill the orders of each symbol
+	account paperAccount
+}
+
+// paperAccount is the persisted state of a PaperExchange
+type paperAccount struct {
+	Balances    map[string]model.Balance
+	Orders      []*paperOrder
+	NextOrderID int64
+}
+
+type paperOrder struct {
+	Order      model.Order
+	BaseAsset  string
+	QuoteAsset string
+	Triggered  bool // a stop order reached its stop price
+}
+
+// NewPaperExchange wraps the feeder, the account is loaded from the storage when one was saved and starts with the
+// configured balances otherwise. A nil storage keeps the account in memory.
+func NewPaperExchange(feeder Feeder, store storage.KeyValueStorage) (*PaperExchange, error) {
+	l := zap.S()
+	fee := DefaultPaperFee
+	if viper.IsSet(PaperFeeFlag) {
+		fee = viper.GetFloat64(PaperFeeFlag)
+	}
+
+	p := &PaperExchange{
+		Feeder:      feeder,
+		l:           l,
+		storage:     store,
+		fee:         fee,
+		slippage:    viper.GetFloat64(PaperSlippageFlag),
+		volumeRatio: viper.GetFloat64(PaperVolumeRatioFlag),
+		symbols:     make(map[string]model.SymbolInfo),
+		prices:      make(map[string]float64),
+		candles:     make(map[string]model.Candle),
+		account: paperAccount{
+			Balances:    make(map[string]model.Balance),
+			Orders:      make([]*paperOrder, 0),
+			NextOrderID: 1,
+		},
+	}
+
+	if store != nil {
+		var account paperAccount
+		err := store.Get(PaperAccountKey, &account)
+		if err == nil {
+			if account.Balances == nil {
+				account.Balances = make(map[string]model.Balance)
+			}
+			p.account = account
+			l.Infow("paper account loaded", "balances", len(account.Balances), "orders", len(account.Orders))
+			return p, nil
+		}
+		if !errors.Is(err, storage.ErrKeyNotFound) {
+			l.Errorw("load paper account error", "error", err)
+			return nil, err
+		}
+	}
+
+	// viper lowercases the keys of the configuration
+	for asset := range viper.GetStringMap(PaperBalancesFlag) {
+		free := viper.GetFloat64(PaperBalancesFlag + "." + asset)
+		asset = strings.ToUpper(asset)
+		p.account.Balances[asset] = model.Balance{Asset: asset, Free: free}
+	}
+	return p, nil
+}
+
+func (p *PaperExchange) CandlesSubscription(ctx context.Context, symbol string, timeframe model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	innerCh := make(chan model.Candle)
+	go p.forwardCandles(ctx, innerCh, candleCh)
+	p.Feeder.CandlesSubscription(ctx, symbol, timeframe, innerCh, errCh)
+}
+
+func (p *PaperExchange) CombinedCandlesSubscription(ctx context.Context, mapSymbolTimeframe map[string]model.Timeframe, candleCh chan<- model.Candle, errCh chan<- error) {
+	innerCh := make(chan model.Candle)
+	go p.forwardCandles(ctx, innerCh, candleCh)
+	p.Feeder.CombinedCandlesSubscription(ctx, mapSymbolTimeframe, innerCh, errCh)
+}
+
+// forwardCandles fills the orders before forwarding each candle, so the strategies see the balances of the
+// candle. The output channel is closed when the feeder closes the input one.
+func (p *PaperExchange) forwardCandles(ctx context.Context, innerCh <-chan model.Candle, candleCh chan<- model.Candle) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case candle, ok := <-innerCh:
+			if !ok {
+				close(candleCh)
+				return
+			}
+			p.OnCandle(candle)
+			select {
+			case <-ctx.Done():
+				return
+			case candleCh <- candle:
+			}
+		}
+	}
+}
+
+// OnCandle updates the last price of the symbol and fills its open orders with the volume traded since the
+// previous update of the candle
+func (p *PaperExchange) OnCandle(candle model.Candle) {
+	p.Lock()
+	defer p.Unlock()
+
+	previous, ok := p.candles[candle.Symbol]
+	if ok && previous.Timeframe != candle.Timeframe {
+		return
+	}
+	volume := candle.Volume
+	if ok && previous.Time.Equal(candle.Time) {
+		volume -= previous.Volume
+	}
+	p.candles[candle.Symbol] = candle
+	p.prices[candle.Symbol] = candle.Close
+
+	var changed bool
+	for _, order := range p.account.Orders {
+		if order.Order.Symbol != candle.Symbol || !order.Order.IsOpen() {
+			continue
+		}
+		if order.Order.Type == model.OrderTypeStopLossLimit && !order.Triggered {
+			if (order.Order.Side == model.SideTypeBuy && candle.High >= order.Order.StopPrice) ||
+				(order.Order.Side == model.SideTypeSell && candle.Low <= order.Order.StopPrice) {
+				order.Triggered = true
+				changed = true
+			}
+			continue
+		}
+		if (order.Order.Side == model.SideTypeBuy && candle.Low > order.Order.Price) ||
+			(order.Order.Side == model.SideTypeSell && candle.High < order.Order.Price) {
+			continue
+		}
+
+		quantity := order.Order.Quantity - order.Order.ExecutedQuantity
+		if p.volumeRatio > 0 {
+			quantity = math.Min(quantity, p.volumeRatio*volume)
+			volume -= quantity / p.volumeRatio
+		}
+		if quantity <= 0 {
+			continue
+		}
+		p.fill(order, quantity, order.Order.Price, order.Order.Price, candle.Time)
+		changed = true
+	}
+	if changed {
+		p.save()
+	}
+}
+
+func (p *PaperExchange) Balances(ctx context.Context) (map[string]model.Balance, error) {
+	p.Lock()
+	defer p.Unlock()
+	var balances = make(map[string]model.Balance, len(p.account.Balances))
+	for asset, balance := range p.account.Balances {
+		if balance.Total() > 0 {
+			balances[asset] = balance
+		}
+	}
+	return balances, nil
+}
+
+func (p *PaperExchange) OrderMarket(ctx context.Context, side model.SideType, symbol string, quantity float64) (model.Order, error) {
+	return p.orderMarket(ctx, side, symbol, quantity, 0)
+}
+
+func (p *PaperExchange) OrderMarketQuote(ctx context.Context, side model.SideType, symbol string, quoteQuantity float64) (model.Order, error) {
+	return p.orderMarket(ctx, side, symbol, 0, quoteQuantity)
+}
+
+func (p *PaperExchange) OrderLimit(ctx context.Context, side model.SideType, symbol string, quantity, price float64) (model.Order, error) {
+	return p.orderLimit(ctx, model.OrderTypeLimit, side, symbol, quantity, 0, price)
+}
+
+func (p *PaperExchange) OrderStop(ctx context.Context, side model.SideType, symbol string, quantity, stopPrice, price float64) (model.Order, error) {
+	return p.orderLimit(ctx, model.OrderTypeStopLossLimit, side, symbol, quantity, stopPrice, price)
+}
+
+// Cancel cancels an open order and unlocks the balance of its remaining quantity
+func (p *PaperExchange) Cancel(ctx context.Context, symbol string, id int64) (model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+	order := p.findOrder(symbol, id)
+	if order == nil || !order.Order.IsOpen() {
+		return model.Order{}, fmt.Errorf("%w: %s %d", ErrOrderNotFound, symbol, id)
+	}
+
+	remaining := order.Order.Quantity - order.Order.ExecutedQuantity
+	asset, amount := order.QuoteAsset, remaining*order.Order.Price
+	if order.Order.Side == model.SideTypeSell {
+		asset, amount = order.BaseAsset, remaining
+	}
+	p.updateBalance(asset, amount, -amount)
+
+	order.Order.Status = model.OrderStatusCanceled
+	order.Order.UpdatedAt = time.Now()
+	p.save()
+	return order.Order, nil
+}
+
+func (p *PaperExchange) OpenOrders(ctx context.Context, symbol string) ([]model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+	var orders = make([]model.Order, 0)
+	for _, order := range p.account.Orders {
+		if order.Order.Symbol == symbol && order.Order.IsOpen() {
+			orders = append(orders, order.Order)
+		}
+	}
+	return orders, nil
+}
+
+func (p *PaperExchange) Order(ctx context.Context, symbol string, id int64) (model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+	order := p.findOrder(symbol, id)
+	if order == nil {
+		return model.Order{}, fmt.Errorf("%w: %s %d", ErrOrderNotFound, symbol, id)
+	}
+	return order.Order, nil
+}
+
+// orderMarket fills the whole order at the last price moved by the slippage, the quantity is computed from the
+// quote quantity when it is set
+func (p *PaperExchange) orderMarket(ctx context.Context, side model.SideType, symbol string, quantity, quoteQuantity float64) (model.Order, error) {
+	info, err := p.symbolInfo(ctx, symbol)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if err := info.ValidateOrderType(model.OrderTypeMarket); err != nil {
+		return model.Order{}, fmt.Errorf("%s market order: %w", symbol, err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	lastPrice, ok := p.prices[symbol]
+	if !ok {
+		return model.Order{}, fmt.Errorf("%s market order: %w", symbol, ErrNoPrice)
+	}
+	price := lastPrice * (1 + p.slippage)
+	if side == model.SideTypeSell {
+		price = lastPrice * (1 - p.slippage)
+	}
+	if quoteQuantity > 0 {
+		quantity = quoteQuantity / price
+	}
+	quantity = info.FloorQuantity(quantity)
+	if err := info.ValidateOrder(quantity, 0, lastPrice); err != nil {
+		return model.Order{}, fmt.Errorf("%s market order: %w", symbol, err)
+	}
+
+	asset, amount := info.QuoteAsset, quantity*price
+	if side == model.SideTypeSell {
+		asset, amount = info.BaseAsset, quantity
+	}
+	if p.account.Balances[asset].Free < amount {
+		return model.Order{}, fmt.Errorf("%s market order: %w: %v %s needed", symbol, ErrInsufficientBalance, amount, asset)
+	}
+	// the spent amount is locked then released by the fill, like the balance of limit orders
+	p.updateBalance(asset, -amount, amount)
+
+	order := p.newOrder(info, side, model.OrderTypeMarket, quantity, 0, 0)
+	p.fill(order, quantity, price, price, order.Order.CreatedAt)
+	p.save()
+	p.l.Infow("paper order filled", "symbol", symbol, "id", order.Order.ID, "side", side, "quantity", quantity,
+		"price", price)
+	return order.Order, nil
+}
+
+// orderLimit locks the balance spent by a limit or stop order until it is filled or canceled
+func (p *PaperExchange) orderLimit(ctx context.Context, orderType model.OrderType, side model.SideType, symbol string, quantity, stopPrice, price float64) (model.Order, error) {
+	info, err := p.symbolInfo(ctx, symbol)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if err := info.ValidateOrderType(orderType); err != nil {
+		return model.Order{}, fmt.Errorf("%s %s order: %w", symbol, orderType, err)
+	}
+	quantity, stopPrice, price = info.FloorQuantity(quantity), info.RoundPrice(stopPrice), info.RoundPrice(price)
+	if err := info.ValidateOrder(quantity, price, 0); err != nil {
+		return model.Order{}, fmt.Errorf("%s %s order: %w", symbol, orderType, err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	asset, amount := info.QuoteAsset, quantity*price
+	if side == model.SideTypeSell {
+		asset, amount = info.BaseAsset, quantity
+	}
+	if p.account.Balances[asset].Free < amount {
+		return model.Order{}, fmt.Errorf("%s %s order: %w: %v %s needed", symbol, orderType, ErrInsufficientBalance,
+			amount, asset)
+	}
+	p.updateBalance(asset, -amount, amount)
+
+	order := p.newOrder(info, side, orderType, quantity, stopPrice, price)
+	p.save()
+	p.l.Infow("paper order created", "symbol", symbol, "id", order.Order.ID, "side", side, "type", orderType,
+		"quantity", quantity, "price", price, "stop_price", stopPrice)
+	return order.Order, nil
+}
+
+func (p *PaperExchange) newOrder(info model.SymbolInfo, side model.SideType, orderType model.OrderType, quantity, stopPrice, price float64) *paperOrder {
+	now := time.Now()
+	order := &paperOrder{
+		BaseAsset:  info.BaseAsset,
+		QuoteAsset: info.QuoteAsset,
+		Order: model.Order{
+			ID:        p.account.NextOrderID,
+			Symbol:    info.Symbol,
+			Side:      side,
+			Type:      orderType,
+			Status:    model.OrderStatusNew,
+			Price:     price,
+			StopPrice: stopPrice,
+			Quantity:  quantity,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+	p.account.NextOrderID++
+	p.account.Orders = append(p.account.Orders, order)
+	return order
+}
+
+// fill executes quantity of the order at price, lockedPrice being the price its balance was locked at. The fee is
+// paid in the received asset.
+func (p *PaperExchange) fill(paper *paperOrder, quantity, price, lockedPrice float64, at time.Time) {
+	order := &paper.Order
+	quote := quantity * price
+	if order.Side == model.SideTypeBuy {
+		fee := quantity * p.fee
+		p.updateBalance(paper.QuoteAsset, quantity*lockedPrice-quote, -quantity*lockedPrice)
+		p.updateBalance(paper.BaseAsset, quantity-fee, 0)
+		order.Fee += fee
+		order.FeeAsset = paper.BaseAsset
+	} else {
+		fee := quote * p.fee
+		p.updateBalance(paper.BaseAsset, 0, -quantity)
+		p.updateBalance(paper.QuoteAsset, quote-fee, 0)
+		order.Fee += fee
+		order.FeeAsset = paper.QuoteAsset
+	}
+
+	order.ExecutedQuantity += quantity
+	order.QuoteQuantity += quote
+	order.Status = model.OrderStatusPartiallyFilled
+	if order.ExecutedQuantity >= order.Quantity {
+		order.Status = model.OrderStatusFilled
+	}
+	order.UpdatedAt = at
+}
+
+func (p *PaperExchange) updateBalance(asset string, free, locked float64) {
+	balance := p.account.Balances[asset]
+	balance.Asset = asset
+	balance.Free += free
+	balance.Locked += locked
+	p.account.Balances[asset] = balance
+}
+
+func (p *PaperExchange) findOrder(symbol string, id int64) *paperOrder {
+	for _, order := range p.account.Orders {
+		if order.Order.ID == id && order.Order.Symbol == symbol {
+			return order
+		}
+	}
+	return nil
+}
+
+// symbolInfo returns the filters of the symbol, the exchange info of the feeder is fetched when the symbol is not
+// known yet
+func (p *PaperExchange) symbolInfo(ctx context.Context, symbol string) (model.SymbolInfo, error) {
+	p.Lock()
+	info, ok := p.symbols[symbol]
+	p.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	exchangeInfo, err := p.Feeder.GetExchangeInfo(ctx)
+	if err != nil {
+		return model.SymbolInfo{}, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, item := range exchangeInfo.Symbols {
+		p.symbols[item.Symbol] = item
+	}
+	if info, ok = p.symbols[symbol]; !ok {
+		return model.SymbolInfo{}, fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+	return info, nil
+}
+
+// save stores the account, the caller holds the lock
+func (p *PaperExchange) save() {
+	if p.storage == nil {
+		return
+	}
+	if err := p.storage.Set(PaperAccountKey, p.account); err != nil {
+		p.l.Errorw("save paper account error", "error", err)
+	}
+}
@@ -0,0 +1,163 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubFeeder struct {
+	Feeder
+	info model.ExchangeInfo
+}
+
+func (f *stubFeeder) GetExchangeInfo(ctx context.Context) (model.ExchangeInfo, error) {
+	return f.info, nil
+}
+
+// memoryStorage encodes the values like BadgerDB does
+type memoryStorage map[string][]byte
+
+func (m memoryStorage) Set(key string, value interface{}) error {
+	data, err := storage.Encode(value)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+func (m memoryStorage) Get(key string, value interface{}) error {
+	data, ok := m[key]
+	if !ok {
+		return storage.ErrKeyNotFound
+	}
+	return storage.Decode(data, value)
+}
+
+func newTestPaperExchange(t *testing.T, store storage.KeyValueStorage) *PaperExchange {
+	viper.Set(PaperFeeFlag, 0.001)
+	viper.Set(PaperSlippageFlag, 0.01)
+	viper.Set(PaperVolumeRatioFlag, 0.1)
+	viper.Set(PaperBalancesFlag, map[string]interface{}{"USDT": 1000})
+	t.Cleanup(func() {
+		for _, flag := range []string{PaperFeeFlag, PaperSlippageFlag, PaperVolumeRatioFlag, PaperBalancesFlag} {
+			viper.Set(flag, nil)
+		}
+	})
+
+	feeder := &stubFeeder{info: model.ExchangeInfo{Symbols: []model.SymbolInfo{{
+		Symbol:      "SXPUSDT",
+		BaseAsset:   "SXP",
+		QuoteAsset:  "USDT",
+		TickSize:    0.001,
+		StepSize:    0.1,
+		MinNotional: 10,
+	}}}}
+	paper, err := NewPaperExchange(feeder, store)
+	require.NoError(t, err)
+	return paper
+}
+
+func TestPaperExchangeMarketOrders(t *testing.T) {
+	ctx := context.Background()
+	paper := newTestPaperExchange(t, nil)
+
+	_, err := paper.OrderMarketQuote(ctx, model.SideTypeBuy, "SXPUSDT", 100)
+	assert.ErrorIs(t, err, ErrNoPrice)
+
+	paper.OnCandle(model.Candle{Symbol: "SXPUSDT", Timeframe: model.Timeframe1m, Time: time.Unix(0, 0), Close: 2})
+
+	// 100 USDT at 2 with 1% slippage buys 49.5 SXP, the fee is paid in SXP
+	order, err := paper.OrderMarketQuote(ctx, model.SideTypeBuy, "SXPUSDT", 100)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusFilled, order.Status)
+	assert.Equal(t, 49.5, order.ExecutedQuantity)
+	assert.InDelta(t, 2.02, order.AveragePrice(), 1e-9)
+	assert.InDelta(t, 0.0495, order.Fee, 1e-9)
+	assert.Equal(t, "SXP", order.FeeAsset)
+
+	balances, err := paper.Balances(ctx)
+	require.NoError(t, err)
+	assert.InDelta(t, 1000-49.5*2.02, balances["USDT"].Free, 1e-9)
+	assert.InDelta(t, 49.5-0.0495, balances["SXP"].Free, 1e-9)
+
+	_, err = paper.OrderMarket(ctx, model.SideTypeSell, "SXPUSDT", 100)
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+	_, err = paper.OrderMarket(ctx, model.SideTypeSell, "SXPUSDT", 1)
+	assert.ErrorIs(t, err, model.ErrMinNotional)
+
+	// 49.4 SXP at 2 with 1% slippage, the fee is paid in USDT
+	order, err = paper.OrderMarket(ctx, model.SideTypeSell, "SXPUSDT", 49.45)
+	require.NoError(t, err)
+	assert.Equal(t, 49.4, order.ExecutedQuantity)
+	assert.InDelta(t, 49.4*1.98*0.001, order.Fee, 1e-9)
+	assert.Equal(t, "USDT", order.FeeAsset)
+}
+
+func TestPaperExchangeLimitOrders(t *testing.T) {
+	ctx := context.Background()
+	store := make(memoryStorage)
+	paper := newTestPaperExchange(t, store)
+	openTime := time.Unix(0, 0)
+
+	order, err := paper.OrderLimit(ctx, model.SideTypeBuy, "SXPUSDT", 100, 1.5)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusNew, order.Status)
+	balances, _ := paper.Balances(ctx)
+	assert.Equal(t, model.Balance{Asset: "USDT", Free: 850, Locked: 150}, balances["USDT"])
+
+	// the price does not reach the order
+	paper.OnCandle(model.Candle{Symbol: "SXPUSDT", Timeframe: model.Timeframe1m, Time: openTime,
+		Close: 1.6, Low: 1.55, High: 1.7, Volume: 5000})
+	// candles of another timeframe of the symbol do not fill the orders
+	paper.OnCandle(model.Candle{Symbol: "SXPUSDT", Timeframe: model.Timeframe1h, Time: openTime,
+		Close: 1.4, Low: 1.4, High: 1.7, Volume: 5000})
+	order, err = paper.Order(ctx, "SXPUSDT", order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, order.ExecutedQuantity)
+
+	// only 10% of the 300 traded since the previous update of the candle are filled
+	paper.OnCandle(model.Candle{Symbol: "SXPUSDT", Timeframe: model.Timeframe1m, Time: openTime,
+		Close: 1.5, Low: 1.5, High: 1.7, Volume: 5300})
+	order, err = paper.Order(ctx, "SXPUSDT", order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusPartiallyFilled, order.Status)
+	assert.InDelta(t, 30, order.ExecutedQuantity, 1e-9)
+
+	// the account is restored after a restart
+	paper = newTestPaperExchange(t, store)
+	openOrders, err := paper.OpenOrders(ctx, "SXPUSDT")
+	require.NoError(t, err)
+	require.Len(t, openOrders, 1)
+	assert.InDelta(t, 30, openOrders[0].ExecutedQuantity, 1e-9)
+
+	canceled, err := paper.Cancel(ctx, "SXPUSDT", order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusCanceled, canceled.Status)
+	balances, _ = paper.Balances(ctx)
+	assert.InDelta(t, 955, balances["USDT"].Free, 1e-9)
+	assert.InDelta(t, 0, balances["USDT"].Locked, 1e-9)
+	assert.InDelta(t, 30*0.999, balances["SXP"].Free, 1e-9)
+	_, err = paper.Cancel(ctx, "SXPUSDT", order.ID)
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+
+	// the stop order waits for the stop price before filling as a limit order
+	stop, err := paper.OrderStop(ctx, model.SideTypeSell, "SXPUSDT", 20, 1.4, 1.39)
+	require.NoError(t, err)
+	paper.OnCandle(model.Candle{Symbol: "SXPUSDT", Timeframe: model.Timeframe1m, Time: openTime.Add(time.Minute),
+		Close: 1.38, Low: 1.38, High: 1.45, Volume: 100})
+	stop, _ = paper.Order(ctx, "SXPUSDT", stop.ID)
+	assert.Equal(t, model.OrderStatusNew, stop.Status)
+	paper.OnCandle(model.Candle{Symbol: "SXPUSDT", Timeframe: model.Timeframe1m, Time: openTime.Add(time.Minute),
+		Close: 1.39, Low: 1.38, High: 1.45, Volume: 1000})
+	stop, _ = paper.Order(ctx, "SXPUSDT", stop.ID)
+	assert.Equal(t, model.OrderStatusFilled, stop.Status)
+	assert.InDelta(t, 20*1.39, stop.QuoteQuantity, 1e-9)
+}
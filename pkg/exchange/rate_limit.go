@@ -0,0 +1,298 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultWeightLimit is the request weight allowed per minute, it is updated from the exchange info rate limits
+	DefaultWeightLimit = 1200
+	// WeightWarningRatio is the used weight ratio above which the usage is logged as a warning
+	WeightWarningRatio = 0.8
+	// MaxBanWait is the longest ban we wait for before retrying, longer bans fail fast
+	MaxBanWait = time.Minute
+	// MaxThrottledRetries is the number of retries of a request answered with HTTP 429
+	MaxThrottledRetries = 3
+
+	HeaderUsedWeight   = "X-MBX-USED-WEIGHT"
+	HeaderUsedWeight1M = "X-MBX-USED-WEIGHT-1M"
+	HeaderRetryAfter   = "Retry-After"
+
+	// LimiterWeight and LimiterRequests name the waits for the request weight and the requests per second in the
+	// metrics
+	LimiterWeight   = "binance_weight"
+	LimiterRequests = "binance_requests"
+)
+
+var ErrIPBanned = errors.New("ip banned by exchange")
+
+// weightShareKey is the context key of the part of the weight limit a request may use
+type weightShareKey struct{}
+
+// withWeightShare limits the requests of ctx to share of the weight limit, the rest of the weight is kept for the
+// other requests
+func withWeightShare(ctx context.Context, share float64) context.Context {
+	return context.WithValue(ctx, weightShareKey{}, share)
+}
+
+// endpointWeight is the weight of requests with a limit parameter lower or equal than maxLimit, a zero maxLimit
+// matches any limit
+type endpointWeight struct {
+	maxLimit int
+	weight   int
+}
+
+// requestWeights are the weights of the REST endpoints, https://binance-docs.github.io/apidocs/spot/en/#limits
+var requestWeights = map[string][]endpointWeight{
+	"/api/v3/ping":         {{0, 1}},
+	"/api/v3/time":         {{0, 1}},
+	"/api/v3/exchangeInfo": {{0, 10}},
+	"/api/v3/klines":       {{0, 1}},
+	"/api/v3/depth":        {{100, 1}, {500, 5}, {1000, 10}, {0, 50}},
+	"/api/v3/ticker/price": {{0, 1}},
+	"/api/v3/ticker/24hr":  {{0, 40}},
+	"/api/v3/order":        {{0, 2}},
+	"/api/v3/openOrders":   {{0, 3}},
+	"/api/v3/account":      {{0, 10}},
+
+	"/fapi/v1/ping":            {{0, 1}},
+	"/fapi/v1/time":            {{0, 1}},
+	"/fapi/v1/exchangeInfo":    {{0, 1}},
+	"/fapi/v1/klines":          {{99, 1}, {499, 2}, {1000, 5}, {0, 10}},
+	"/fapi/v1/markPriceKlines": {{99, 1}, {499, 2}, {1000, 5}, {0, 10}},
+	"/fapi/v1/depth":           {{50, 2}, {100, 5}, {500, 10}, {0, 20}},
+	"/fapi/v1/ticker/price":    {{0, 1}},
+	"/fapi/v1/ticker/24hr":     {{0, 40}},
+	"/fapi/v1/order":           {{0, 1}},
+	"/fapi/v1/openOrders":      {{0, 1}},
+	"/fapi/v2/balance":         {{0, 5}},
+	"/fapi/v1/fundingRate":     {{0, 1}},
+	"/fapi/v1/openInterest":    {{0, 1}},
+}
+
+// defaultLimits are the limits applied by the endpoints to the requests without limit parameter
+var defaultLimits = map[string]int{
+	"/api/v3/klines":           500,
+	"/api/v3/depth":            100,
+	"/fapi/v1/klines":          500,
+	"/fapi/v1/markPriceKlines": 500,
+	"/fapi/v1/depth":           500,
+}
+
+// allSymbolsWeights are the weights of the requests without symbol parameter, which cover every symbol
+var allSymbolsWeights = map[string]int{
+	"/api/v3/openOrders":  40,
+	"/fapi/v1/openOrders": 40,
+}
+
+// RequestWeight returns the weight of a request to the endpoint with the given query parameters
+func RequestWeight(endpoint string, query url.Values) int {
+	if weight, ok := allSymbolsWeights[endpoint]; ok && query.Get("symbol") == "" {
+		return weight
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = defaultLimits[endpoint]
+	}
+	for _, w := range requestWeights[endpoint] {
+		if w.maxLimit == 0 || limit <= w.maxLimit {
+			return w.weight
+		}
+	}
+	return 1
+}
+
+// RateLimitStats is a snapshot of the rate limiter state
+type RateLimitStats struct {
+	WeightLimit int
+	UsedWeight  int
+	Requests    int64
+	Throttled   int64 // number of HTTP 429 responses
+	Banned      int64 // number of HTTP 418 responses
+	WaitTime    time.Duration
+	BannedUntil time.Time
+}
+
+// WeightLimiter is a http.RoundTripper that keeps the request weight used in the current minute under the
+// exchange limit. The used weight is synced with the X-MBX-USED-WEIGHT response headers, and requests are held
+// back after HTTP 429 or 418 responses until the Retry-After delay has passed.
+type WeightLimiter struct {
+	sync.Mutex
+	l           *zap.SugaredLogger
+	transport   http.RoundTripper
+	rateLimiter *app.RateLimiter
+
+	weightLimit int
+	usedWeight  int
+	window      time.Time
+	warned      bool // the high usage is logged once per window
+	bannedUntil time.Time
+
+	requests    int64
+	throttled   int64
+	banned      int64
+	waitTime    time.Duration
+	observeWait func(wait time.Duration)
+}
+
+func NewWeightLimiter(transport http.RoundTripper) *WeightLimiter {
+	rateLimiter := app.NewRateLimiter(RequestPerSecond, RequestPerSecond)
+	rateLimiter.OnWait(metrics.ObserveWait(LimiterRequests))
+	return &WeightLimiter{
+		l:           zap.S(),
+		transport:   transport,
+		rateLimiter: rateLimiter,
+		weightLimit: DefaultWeightLimit,
+		observeWait: metrics.ObserveWait(LimiterWeight),
+	}
+}
+
+// SetWeightLimit updates the weight allowed per minute
+func (w *WeightLimiter) SetWeightLimit(limit int) {
+	w.Lock()
+	defer w.Unlock()
+	w.weightLimit = limit
+}
+
+func (w *WeightLimiter) Stats() RateLimitStats {
+	w.Lock()
+	defer w.Unlock()
+	w.resetWindow(time.Now())
+	return RateLimitStats{
+		WeightLimit: w.weightLimit,
+		UsedWeight:  w.usedWeight,
+		Requests:    w.requests,
+		Throttled:   w.throttled,
+		Banned:      w.banned,
+		WaitTime:    w.waitTime,
+		BannedUntil: w.bannedUntil,
+	}
+}
+
+func (w *WeightLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	weight := RequestWeight(req.URL.Path, req.URL.Query())
+
+	for retry := 0; ; retry++ {
+		if err := w.reserve(req.Context(), weight); err != nil {
+			return nil, err
+		}
+		if err := w.rateLimiter.WaitN(RequestTimeout, 1); err != nil {
+			return nil, err
+		}
+
+		resp, err := w.transport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		w.syncResponse(resp)
+
+		// only idempotent requests are sent again
+		if resp.StatusCode != http.StatusTooManyRequests || req.Method != http.MethodGet || retry >= MaxThrottledRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
+
+// reserve blocks until the weight can be used without exceeding the limit of the current minute, or the share of the
+// limit given to ctx
+func (w *WeightLimiter) reserve(ctx context.Context, weight int) error {
+	share, ok := ctx.Value(weightShareKey{}).(float64)
+	if !ok {
+		share = 1
+	}
+	var waited time.Duration
+	for {
+		w.Lock()
+		now := time.Now()
+		w.resetWindow(now)
+		weightLimit := int(share * float64(w.weightLimit))
+
+		var wait time.Duration
+		switch {
+		case now.Before(w.bannedUntil):
+			wait = w.bannedUntil.Sub(now)
+			if wait > MaxBanWait {
+				w.Unlock()
+				return fmt.Errorf("%w until %v", ErrIPBanned, w.bannedUntil)
+			}
+		case w.usedWeight+weight > weightLimit && w.usedWeight > 0:
+			wait = w.window.Add(time.Minute).Sub(now)
+		default:
+			w.usedWeight += weight
+			w.requests++
+			w.Unlock()
+			w.observeWait(waited)
+			return nil
+		}
+		w.waitTime += wait
+		waited += wait
+		w.Unlock()
+
+		w.l.Debugw("rate limit wait", "weight", weight, "wait", wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (w *WeightLimiter) syncResponse(resp *http.Response) {
+	w.Lock()
+	defer w.Unlock()
+	now := time.Now()
+	w.resetWindow(now)
+
+	usedHeader := resp.Header.Get(HeaderUsedWeight1M)
+	if usedHeader == "" {
+		usedHeader = resp.Header.Get(HeaderUsedWeight)
+	}
+	// the exchange counts the weight of every client sharing our IP, but not our requests still in flight
+	if used, err := strconv.Atoi(usedHeader); err == nil && used > w.usedWeight {
+		w.usedWeight = used
+	}
+	if !w.warned && float64(w.usedWeight) >= WeightWarningRatio*float64(w.weightLimit) {
+		w.warned = true
+		w.l.Warnw("rate limit weight usage is high", "used_weight", w.usedWeight, "weight_limit", w.weightLimit)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		w.throttled++
+	case http.StatusTeapot:
+		w.banned++
+	default:
+		return
+	}
+
+	retryAfter := time.Minute
+	if seconds, err := strconv.Atoi(resp.Header.Get(HeaderRetryAfter)); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	if until := now.Add(retryAfter); until.After(w.bannedUntil) {
+		w.bannedUntil = until
+	}
+	w.l.Warnw("rate limit exceeded", "status", resp.StatusCode, "retry_after", retryAfter,
+		"used_weight", w.usedWeight, "throttled", w.throttled, "banned", w.banned)
+}
+
+// resetWindow starts a new weight window at each minute, as the exchange does
+func (w *WeightLimiter) resetWindow(now time.Time) {
+	if window := now.Truncate(time.Minute); window.After(w.window) {
+		w.window = window
+		w.usedWeight = 0
+		w.warned = false
+	}
+}
@@ -1,13 +1,99 @@
 package exchange
 
-func ParseTimeframeToSeconds(timeframe string) int64 {
-	switch timeframe {
-	case "1h":
-		return 3600
-	case "4h":
-		return 14400
-	case "1d":
-		return 86400
-	}
-	return 0
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+// sendError sends err to errCh unless ctx is done first
+func sendError(ctx context.Context, errCh chan<- error, err error) {
+	select {
+	case errCh <- err:
+	case <-ctx.Done():
+	}
+}
+
+// appendCandles appends the candles opened after the last candle of the list, so pages overlapping on their
+// boundaries are merged without duplicates
+func appendCandles(candles []model.Candle, page []model.Candle) []model.Candle {
+	for _, candle := range page {
+		if len(candles) > 0 && !candle.Time.After(candles[len(candles)-1].Time) {
+			continue
+		}
+		candles = append(candles, candle)
+	}
+	return candles
+}
+
+// klinesPage requests a single page of candles, zero start or end times are not sent
+type klinesPage func(start, end time.Time, limit int) ([]model.Candle, error)
+
+// candlesByLimit requests pages of MaxKlinesLimit candles backward until the limit is reached or there is no older
+// candle
+func candlesByLimit(limit int, fetch klinesPage) ([]model.Candle, error) {
+	var (
+		pages   = make([][]model.Candle, 0)
+		total   int
+		endTime time.Time
+	)
+	for total < limit {
+		pageLimit := limit - total
+		if pageLimit > MaxKlinesLimit {
+			pageLimit = MaxKlinesLimit
+		}
+
+		page, err := fetch(time.Time{}, endTime, pageLimit)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+		total += len(page)
+
+		if len(page) < pageLimit {
+			break
+		}
+		endTime = page[0].Time.Add(-time.Millisecond)
+	}
+
+	var candles = make([]model.Candle, 0, total)
+	for i := len(pages) - 1; i >= 0; i-- {
+		candles = appendCandles(candles, pages[i])
+	}
+	return candles, nil
+}
+
+// firstCandle requests the single candle following the unix epoch, i.e. the oldest one
+func firstCandle(symbol string, timeframe model.Timeframe, fetch klinesPage) (model.Candle, error) {
+	page, err := fetch(time.Unix(0, 0), time.Time{}, 1)
+	if err != nil {
+		return model.Candle{}, err
+	}
+	if len(page) == 0 {
+		return model.Candle{}, fmt.Errorf("%w: %s -- %s has no candle", ErrInsufficientData, symbol, timeframe)
+	}
+	return page[0], nil
+}
+
+// candlesByPeriod requests pages of MaxKlinesLimit candles forward until the end of the period
+func candlesByPeriod(start, end time.Time, fetch klinesPage) ([]model.Candle, error) {
+	var candles = make([]model.Candle, 0)
+	for pageStart := start; !pageStart.After(end); {
+		page, err := fetch(pageStart, end, MaxKlinesLimit)
+		if err != nil {
+			return nil, err
+		}
+		candles = appendCandles(candles, page)
+
+		if len(page) < MaxKlinesLimit {
+			break
+		}
+		pageStart = page[len(page)-1].Time.Add(time.Millisecond)
+	}
+	return candles, nil
 }
@@ -0,0 +1,262 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/gorilla/websocket"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+// wsServe dials the websocket endpoint and forwards every message to handler. doneC is closed when the
+// connection is terminated, closing stopC terminates the connection.
+func wsServe(endpoint string, handler func(message []byte), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	doneC = make(chan struct{})
+	stopC = make(chan struct{})
+	go func() {
+		defer close(doneC)
+		// ReadMessage is blocking, so we wait for stopC in a separate goroutine and close the connection to
+		// unblock the reader
+		silent := make(chan struct{})
+		go func() {
+			select {
+			case <-stopC:
+				close(silent)
+			case <-doneC:
+			}
+			conn.Close()
+		}()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-silent:
+				default:
+					errHandler(err)
+				}
+				return
+			}
+			handler(message)
+		}
+	}()
+	return doneC, stopC, nil
+}
+
+// combinedStreamEvent is the envelope of every message received from a combined stream
+type combinedStreamEvent struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// wsDepthEvent is a diff depth update, levels are [price, quantity] pairs
+type wsDepthEvent struct {
+	Event         string      `json:"e"`
+	Time          int64       `json:"E"`
+	Symbol        string      `json:"s"`
+	FirstUpdateID int64       `json:"U"`
+	LastUpdateID  int64       `json:"u"`
+	PrevUpdateID  int64       `json:"pu"` // futures only
+	Bids          [][2]string `json:"b"`
+	Asks          [][2]string `json:"a"`
+}
+
+func wsStreamEndpoint(wsEndpoint, stream string) string {
+	return fmt.Sprintf("%s/ws/%s", strings.TrimSuffix(wsEndpoint, "/"), stream)
+}
+
+func wsCombinedStreamEndpoint(wsEndpoint string, streams []string) string {
+	return fmt.Sprintf("%s/stream?streams=%s", strings.TrimSuffix(wsEndpoint, "/"), strings.Join(streams, "/"))
+}
+
+func klineStreamName(symbol string, timeframe model.Timeframe) string {
+	return fmt.Sprintf("%s@kline_%s", strings.ToLower(symbol), timeframe)
+}
+
+func marketStatStreamName(symbol string) string {
+	return fmt.Sprintf("%s@ticker", strings.ToLower(symbol))
+}
+
+func aggTradeStreamName(symbol string) string {
+	return fmt.Sprintf("%s@aggTrade", strings.ToLower(symbol))
+}
+
+func depthStreamName(symbol string) string {
+	return fmt.Sprintf("%s@depth@100ms", strings.ToLower(symbol))
+}
+
+func markPriceStreamName(symbol string) string {
+	return fmt.Sprintf("%s@markPrice@1s", strings.ToLower(symbol))
+}
+
+func forceOrderStreamName(symbol string) string {
+	return fmt.Sprintf("%s@forceOrder", strings.ToLower(symbol))
+}
+
+func wsKlineServe(wsEndpoint, symbol string, timeframe model.Timeframe, handler func(event *binance.WsKlineEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	wsHandler := func(message []byte) {
+		event := new(binance.WsKlineEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsStreamEndpoint(wsEndpoint, klineStreamName(symbol, timeframe)), wsHandler, errHandler)
+}
+
+func wsCombinedKlineServe(wsEndpoint string, mapSymbolTimeframe map[string]model.Timeframe, handler func(event *binance.WsKlineEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	var streams = make([]string, 0, len(mapSymbolTimeframe))
+	for symbol, timeframe := range mapSymbolTimeframe {
+		streams = append(streams, klineStreamName(symbol, timeframe))
+	}
+	wsHandler := func(message []byte) {
+		var combined combinedStreamEvent
+		if err := json.Unmarshal(message, &combined); err != nil {
+			errHandler(err)
+			return
+		}
+		event := new(binance.WsKlineEvent)
+		if err := json.Unmarshal(combined.Data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsCombinedStreamEndpoint(wsEndpoint, streams), wsHandler, errHandler)
+}
+
+func wsAggTradeServe(wsEndpoint, symbol string, handler func(event *binance.WsAggTradeEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	wsHandler := func(message []byte) {
+		event := new(binance.WsAggTradeEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsStreamEndpoint(wsEndpoint, aggTradeStreamName(symbol)), wsHandler, errHandler)
+}
+
+func wsCombinedAggTradeServe(wsEndpoint string, symbols []string, handler func(event *binance.WsAggTradeEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	var streams = make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		streams = append(streams, aggTradeStreamName(symbol))
+	}
+	wsHandler := func(message []byte) {
+		var combined combinedStreamEvent
+		if err := json.Unmarshal(message, &combined); err != nil {
+			errHandler(err)
+			return
+		}
+		event := new(binance.WsAggTradeEvent)
+		if err := json.Unmarshal(combined.Data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsCombinedStreamEndpoint(wsEndpoint, streams), wsHandler, errHandler)
+}
+
+func wsCombinedDepthServe(wsEndpoint string, symbols []string, handler func(event *wsDepthEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	var streams = make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		streams = append(streams, depthStreamName(symbol))
+	}
+	wsHandler := func(message []byte) {
+		var combined combinedStreamEvent
+		if err := json.Unmarshal(message, &combined); err != nil {
+			errHandler(err)
+			return
+		}
+		event := new(wsDepthEvent)
+		if err := json.Unmarshal(combined.Data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsCombinedStreamEndpoint(wsEndpoint, streams), wsHandler, errHandler)
+}
+
+func wsMarketStatServe(wsEndpoint, symbol string, handler func(event *binance.WsMarketStatEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	wsHandler := func(message []byte) {
+		event := new(binance.WsMarketStatEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsStreamEndpoint(wsEndpoint, marketStatStreamName(symbol)), wsHandler, errHandler)
+}
+
+func wsCombinedMarketStatServe(wsEndpoint string, symbols []string, handler func(event *binance.WsMarketStatEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	var streams = make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		streams = append(streams, marketStatStreamName(symbol))
+	}
+	wsHandler := func(message []byte) {
+		var combined combinedStreamEvent
+		if err := json.Unmarshal(message, &combined); err != nil {
+			errHandler(err)
+			return
+		}
+		event := new(binance.WsMarketStatEvent)
+		if err := json.Unmarshal(combined.Data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsCombinedStreamEndpoint(wsEndpoint, streams), wsHandler, errHandler)
+}
+
+func wsCombinedMarkPriceServe(wsEndpoint string, symbols []string, handler func(event *futures.WsMarkPriceEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	var streams = make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		streams = append(streams, markPriceStreamName(symbol))
+	}
+	wsHandler := func(message []byte) {
+		var combined combinedStreamEvent
+		if err := json.Unmarshal(message, &combined); err != nil {
+			errHandler(err)
+			return
+		}
+		event := new(futures.WsMarkPriceEvent)
+		if err := json.Unmarshal(combined.Data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsCombinedStreamEndpoint(wsEndpoint, streams), wsHandler, errHandler)
+}
+
+func wsCombinedForceOrderServe(wsEndpoint string, symbols []string, handler func(event *futures.WsLiquidationOrderEvent), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	var streams = make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		streams = append(streams, forceOrderStreamName(symbol))
+	}
+	wsHandler := func(message []byte) {
+		var combined combinedStreamEvent
+		if err := json.Unmarshal(message, &combined); err != nil {
+			errHandler(err)
+			return
+		}
+		event := new(futures.WsLiquidationOrderEvent)
+		if err := json.Unmarshal(combined.Data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}
+	return wsServe(wsCombinedStreamEndpoint(wsEndpoint, streams), wsHandler, errHandler)
+}
@@ -0,0 +1,134 @@
+// Package metrics holds the Prometheus metrics of the bot, served by Handler
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "binancebot"
+
+// Reasons of the websocket reconnections
+const (
+	ReconnectError = "error" // the connection failed
+	ReconnectQuiet = "quiet" // the connection stayed quiet without error
+)
+
+// Kinds of the updates dropped by the candle dispatch
+const (
+	DropPartial = "partial" // a partial candle dropped from a full queue
+	DropCandle  = "candle"  // a complete candle dropped from a queue at its hard limit
+	DropTrade   = "trade"   // a trade dropped from a queue at its hard limit
+)
+
+// Statuses of the notifications
+const (
+	NotificationSent   = "sent"
+	NotificationFailed = "failed"
+)
+
+var (
+	// CandlesReceived counts the candle updates received from the exchange per feed
+	CandlesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "candles_received_total",
+		Help:      "Candle updates received from the exchange per feed.",
+	}, []string{"symbol", "timeframe"})
+
+	// CandleLatency is the delay from the exchange event of a candle update to its strategy
+	CandleLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "candle_latency_seconds",
+		Help:      "Delay from the kline event time to the strategy OnCandle.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"timeframe"})
+
+	// Reconnects counts the websocket subscriptions started again per stream
+	Reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_reconnects_total",
+		Help:      "Websocket subscriptions started again, per stream and reason.",
+	}, []string{"stream", "reason"})
+
+	// RateLimiterWait is the time spent waiting on the rate limiters
+	RateLimiterWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rate_limiter_wait_seconds",
+		Help:      "Time waited for a rate limiter before a request.",
+		Buckets:   []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"limiter"})
+
+	// DispatchDropped counts the updates dropped from the candle dispatch queues
+	DispatchDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dispatch_dropped_total",
+		Help:      "Candle updates and trades dropped from the dispatch queues, per kind.",
+	}, []string{"kind"})
+
+	// Notifications counts the messages sent and failed per notifier
+	Notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications sent or failed per notifier.",
+	}, []string{"notifier", "status"})
+
+	// Alerts counts the alerts fired per strategy and timeframe
+	Alerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_total",
+		Help:      "Alerts fired per strategy and timeframe.",
+	}, []string{"strategy", "timeframe"})
+
+	// AlertsDropped counts the notifications of the alerts dropped from the full queue of the alert controller
+	AlertsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_dropped_total",
+		Help:      "Alert notifications dropped from the full alert queue, per strategy.",
+	}, []string{"strategy"})
+
+	// DataframeCandles is the number of candles of each dataframe read by the strategies
+	DataframeCandles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dataframe_candles",
+		Help:      "Candles held by the dataframe of each feed.",
+	}, []string{"symbol", "timeframe"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		CandlesReceived,
+		CandleLatency,
+		Reconnects,
+		RateLimiterWait,
+		DispatchDropped,
+		Notifications,
+		Alerts,
+		AlertsDropped,
+		DataframeCandles,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveWait returns an observer of the waits of the rate limiter name, see app.RateLimiter.OnWait
+func ObserveWait(name string) func(wait time.Duration) {
+	observer := RateLimiterWait.WithLabelValues(name)
+	return func(wait time.Duration) {
+		observer.Observe(wait.Seconds())
+	}
+}
+
+// ObserveNotification counts a notification of notifier, failed when err is not nil
+func ObserveNotification(notifier string, err error) {
+	status := NotificationSent
+	if err != nil {
+		status = NotificationFailed
+	}
+	Notifications.WithLabelValues(notifier, status).Inc()
+}
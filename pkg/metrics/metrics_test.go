@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	CandlesReceived.WithLabelValues("BTCUSDT", "4h").Inc()
+	ObserveWait("telegram")(2 * time.Second)
+	ObserveNotification("telegram", nil)
+	ObserveNotification("telegram", errors.New("timeout"))
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), `binancebot_candles_received_total{symbol="BTCUSDT",timeframe="4h"} 1`)
+	assert.Contains(t, string(body), `binancebot_rate_limiter_wait_seconds_sum{limiter="telegram"} 2`)
+	assert.Contains(t, string(body), `binancebot_notifications_total{notifier="telegram",status="sent"} 1`)
+	assert.Contains(t, string(body), `binancebot_notifications_total{notifier="telegram",status="failed"} 1`)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -18,7 +19,7 @@ const (
 
 type Candle struct {
 	Symbol    string
-	Timeframe string
+	Timeframe Timeframe
 	Time      time.Time
 	Open      float64
 	Close     float64
@@ -27,6 +28,7 @@ type Candle struct {
 	Volume    float64
 	Trades    int64
 	Complete  bool
+	EventTime time.Time // time of the exchange event carrying the update, zero for historical candles
 }
 
 func CandleFieldLength() int {
@@ -36,7 +38,7 @@ func CandleFieldLength() int {
 func (c Candle) ToSlice() []string {
 	return []string{
 		c.Symbol,
-		c.Timeframe,
+		c.Timeframe.String(),
 		fmt.Sprintf("%d", c.Time.Unix()),
 		fmt.Sprintf("%f", c.Open),
 		fmt.Sprintf("%f", c.Close),
@@ -47,6 +49,45 @@ func (c Candle) ToSlice() []string {
 	}
 }
 
+// CandleFromSlice parses a candle from the csv record layout produced by ToSlice
+func CandleFromSlice(line []string) (Candle, error) {
+	if len(line) < CandleFieldLength() {
+		return Candle{}, fmt.Errorf("invalid csv candle data")
+	}
+
+	candle := Candle{
+		Symbol:    line[0],
+		Timeframe: Timeframe(line[1]),
+		Complete:  true,
+	}
+
+	timestamp, err := strconv.ParseInt(line[2], 10, 64)
+	if err != nil {
+		return Candle{}, err
+	}
+	candle.Time = time.Unix(timestamp, 0)
+
+	if candle.Open, err = strconv.ParseFloat(line[3], 64); err != nil {
+		return Candle{}, err
+	}
+	if candle.Close, err = strconv.ParseFloat(line[4], 64); err != nil {
+		return Candle{}, err
+	}
+	if candle.Low, err = strconv.ParseFloat(line[5], 64); err != nil {
+		return Candle{}, err
+	}
+	if candle.High, err = strconv.ParseFloat(line[6], 64); err != nil {
+		return Candle{}, err
+	}
+	if candle.Volume, err = strconv.ParseFloat(line[7], 64); err != nil {
+		return Candle{}, err
+	}
+	if candle.Trades, err = strconv.ParseInt(line[8], 10, 64); err != nil {
+		return Candle{}, err
+	}
+	return candle, nil
+}
+
 type CandleAttribute int
 
 const (
@@ -60,7 +101,7 @@ const (
 type Dataframe struct {
 	sync.RWMutex
 	Symbol    string
-	Timeframe string
+	Timeframe Timeframe
 
 	Close  series.Series
 	Open   series.Series
@@ -71,6 +112,9 @@ type Dataframe struct {
 	Time       []time.Time
 	LastUpdate time.Time
 
+	// OrderBook is the local order book of the symbol, nil when the depth is not subscribed
+	OrderBook *OrderBook
+
 	// Custom user metadata
 	Metadata map[string]series.Series
 }
@@ -177,13 +221,258 @@ type MarketStats24h struct {
 	TotalTrades        int64
 }
 
+// MarkPrice is the mark price update of a perpetual contract, the price the unrealized profits and liquidations are
+// computed with
+type MarkPrice struct {
+	Symbol          string
+	MarkPrice       float64
+	IndexPrice      float64
+	FundingRate     float64
+	NextFundingTime time.Time
+	Time            time.Time
+}
+
+// FundingRate is the funding rate settled between the long and short positions of a perpetual contract, paid by the
+// longs when positive
+type FundingRate struct {
+	Symbol      string
+	FundingRate float64
+	FundingTime time.Time
+}
+
+func FundingRateFieldLength() int {
+	return 3
+}
+
+func (f FundingRate) ToSlice() []string {
+	return []string{
+		f.Symbol,
+		strconv.FormatFloat(f.FundingRate, 'f', -1, 64),
+		fmt.Sprintf("%d", f.FundingTime.UnixNano()/int64(time.Millisecond)),
+	}
+}
+
+// FundingRateFromSlice parses a funding rate from the csv record layout produced by ToSlice
+func FundingRateFromSlice(line []string) (FundingRate, error) {
+	if len(line) < FundingRateFieldLength() {
+		return FundingRate{}, fmt.Errorf("invalid csv funding rate data")
+	}
+
+	fundingRate := FundingRate{
+		Symbol: line[0],
+	}
+
+	var err error
+	if fundingRate.FundingRate, err = strconv.ParseFloat(line[1], 64); err != nil {
+		return FundingRate{}, err
+	}
+	timestamp, err := strconv.ParseInt(line[2], 10, 64)
+	if err != nil {
+		return FundingRate{}, err
+	}
+	fundingRate.FundingTime = time.Unix(0, timestamp*int64(time.Millisecond))
+	return fundingRate, nil
+}
+
+// OpenInterest is the number of open contracts of a futures symbol, in base asset
+type OpenInterest struct {
+	Symbol       string
+	OpenInterest float64
+	Time         time.Time
+}
+
+func OpenInterestFieldLength() int {
+	return 3
+}
+
+func (o OpenInterest) ToSlice() []string {
+	return []string{
+		o.Symbol,
+		strconv.FormatFloat(o.OpenInterest, 'f', -1, 64),
+		fmt.Sprintf("%d", o.Time.UnixNano()/int64(time.Millisecond)),
+	}
+}
+
+// OpenInterestFromSlice parses an open interest from the csv record layout produced by ToSlice
+func OpenInterestFromSlice(line []string) (OpenInterest, error) {
+	if len(line) < OpenInterestFieldLength() {
+		return OpenInterest{}, fmt.Errorf("invalid csv open interest data")
+	}
+
+	openInterest := OpenInterest{
+		Symbol: line[0],
+	}
+
+	var err error
+	if openInterest.OpenInterest, err = strconv.ParseFloat(line[1], 64); err != nil {
+		return OpenInterest{}, err
+	}
+	timestamp, err := strconv.ParseInt(line[2], 10, 64)
+	if err != nil {
+		return OpenInterest{}, err
+	}
+	openInterest.Time = time.Unix(0, timestamp*int64(time.Millisecond))
+	return openInterest, nil
+}
+
+// Trade is an aggregate trade, the trades filled by a single taker order at the same price
+type Trade struct {
+	Symbol       string
+	ID           int64
+	Price        float64
+	Quantity     float64
+	Time         time.Time
+	IsBuyerMaker bool
+}
+
+func TradeFieldLength() int {
+	return 6
+}
+
+func (t Trade) ToSlice() []string {
+	return []string{
+		t.Symbol,
+		fmt.Sprintf("%d", t.ID),
+		strconv.FormatFloat(t.Price, 'f', -1, 64),
+		strconv.FormatFloat(t.Quantity, 'f', -1, 64),
+		fmt.Sprintf("%d", t.Time.UnixNano()/int64(time.Millisecond)),
+		strconv.FormatBool(t.IsBuyerMaker),
+	}
+}
+
+// QuoteQuantity returns the traded amount in quote asset
+func (t Trade) QuoteQuantity() float64 {
+	return t.Price * t.Quantity
+}
+
+// TradeFromSlice parses a trade from the csv record layout produced by ToSlice
+func TradeFromSlice(line []string) (Trade, error) {
+	if len(line) < TradeFieldLength() {
+		return Trade{}, fmt.Errorf("invalid csv trade data")
+	}
+
+	trade := Trade{
+		Symbol: line[0],
+	}
+
+	var err error
+	if trade.ID, err = strconv.ParseInt(line[1], 10, 64); err != nil {
+		return Trade{}, err
+	}
+	if trade.Price, err = strconv.ParseFloat(line[2], 64); err != nil {
+		return Trade{}, err
+	}
+	if trade.Quantity, err = strconv.ParseFloat(line[3], 64); err != nil {
+		return Trade{}, err
+	}
+	timestamp, err := strconv.ParseInt(line[4], 10, 64)
+	if err != nil {
+		return Trade{}, err
+	}
+	trade.Time = time.Unix(0, timestamp*int64(time.Millisecond))
+	if trade.IsBuyerMaker, err = strconv.ParseBool(line[5]); err != nil {
+		return Trade{}, err
+	}
+	return trade, nil
+}
+
+// Liquidation is a forced order of the exchange closing the position of a futures trader, a SELL side closes a long
+// position and a BUY side a short one
+type Liquidation struct {
+	Symbol   string
+	Side     SideType
+	Price    float64 // average fill price
+	Quantity float64 // filled quantity
+	Time     time.Time
+}
+
+func LiquidationFieldLength() int {
+	return 5
+}
+
+func (l Liquidation) ToSlice() []string {
+	return []string{
+		l.Symbol,
+		string(l.Side),
+		strconv.FormatFloat(l.Price, 'f', -1, 64),
+		strconv.FormatFloat(l.Quantity, 'f', -1, 64),
+		fmt.Sprintf("%d", l.Time.UnixNano()/int64(time.Millisecond)),
+	}
+}
+
+// QuoteQuantity returns the liquidated amount in quote asset
+func (l Liquidation) QuoteQuantity() float64 {
+	return l.Price * l.Quantity
+}
+
+// LiquidationFromSlice parses a liquidation from the csv record layout produced by ToSlice
+func LiquidationFromSlice(line []string) (Liquidation, error) {
+	if len(line) < LiquidationFieldLength() {
+		return Liquidation{}, fmt.Errorf("invalid csv liquidation data")
+	}
+
+	liquidation := Liquidation{
+		Symbol: line[0],
+		Side:   SideType(line[1]),
+	}
+
+	var err error
+	if liquidation.Price, err = strconv.ParseFloat(line[2], 64); err != nil {
+		return Liquidation{}, err
+	}
+	if liquidation.Quantity, err = strconv.ParseFloat(line[3], 64); err != nil {
+		return Liquidation{}, err
+	}
+	timestamp, err := strconv.ParseInt(line[4], 10, 64)
+	if err != nil {
+		return Liquidation{}, err
+	}
+	liquidation.Time = time.Unix(0, timestamp*int64(time.Millisecond))
+	return liquidation, nil
+}
+
 type ExchangeInfo struct {
 	Symbols []SymbolInfo
 }
 
+// ContractTypePerpetual is the contract type of the futures symbols without delivery date
+const ContractTypePerpetual = "PERPETUAL"
+
 type SymbolInfo struct {
 	Symbol     string
 	Status     string
 	BaseAsset  string
 	QuoteAsset string
+
+	// futures contract metadata, empty for spot symbols
+	ContractType string
+	MarginAsset  string
+	OnboardDate  time.Time
+
+	// order filters, a zero value is not checked
+	TickSize    float64
+	MinPrice    float64
+	MaxPrice    float64
+	StepSize    float64
+	MinQuantity float64
+	MaxQuantity float64
+	MinNotional float64
+
+	// decimals of the prices and quantities, used when the tick and step sizes are unknown
+	PricePrecision    int
+	QuantityPrecision int
+
+	// trading permissions, e.g. SPOT or MARGIN, and order types accepted by the exchange, not checked when empty
+	Permissions []string
+	OrderTypes  []OrderType
+}
+
+const (
+	PermissionSpot   = "SPOT"
+	PermissionMargin = "MARGIN"
+)
+
+// IsPerpetual reports whether the symbol is a perpetual futures contract
+func (s SymbolInfo) IsPerpetual() bool {
+	return s.ContractType == ContractTypePerpetual
 }
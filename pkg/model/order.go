@@ -0,0 +1,224 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SideType string
+
+const (
+	SideTypeBuy  SideType = "BUY"
+	SideTypeSell SideType = "SELL"
+)
+
+type OrderType string
+
+const (
+	OrderTypeMarket        OrderType = "MARKET"
+	OrderTypeLimit         OrderType = "LIMIT"
+	OrderTypeStopLossLimit OrderType = "STOP_LOSS_LIMIT"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusNew             OrderStatus = "NEW"
+	OrderStatusPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
+	OrderStatusFilled          OrderStatus = "FILLED"
+	OrderStatusCanceled        OrderStatus = "CANCELED"
+	OrderStatusRejected        OrderStatus = "REJECTED"
+	OrderStatusExpired         OrderStatus = "EXPIRED"
+)
+
+type Order struct {
+	ID               int64
+	ClientOrderID    string
+	Symbol           string
+	Side             SideType
+	Type             OrderType
+	Status           OrderStatus
+	Price            float64
+	StopPrice        float64
+	Quantity         float64
+	ExecutedQuantity float64
+	QuoteQuantity    float64 // executed quote amount
+	Fee              float64 // paid in FeeAsset, only known for simulated orders
+	FeeAsset         string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// IsOpen reports whether the order can still be filled
+func (o Order) IsOpen() bool {
+	return o.Status == OrderStatusNew || o.Status == OrderStatusPartiallyFilled
+}
+
+// AveragePrice returns the average price of the executed quantity
+func (o Order) AveragePrice() float64 {
+	if o.ExecutedQuantity == 0 {
+		return 0
+	}
+	return o.QuoteQuantity / o.ExecutedQuantity
+}
+
+type Balance struct {
+	Asset  string
+	Free   float64
+	Locked float64
+}
+
+func (b Balance) Total() float64 {
+	return b.Free + b.Locked
+}
+
+var (
+	ErrInvalidPrice    = errors.New("invalid price")
+	ErrInvalidQuantity = errors.New("invalid quantity")
+	ErrMinNotional     = errors.New("order value below min notional")
+	ErrOrderNotAllowed = errors.New("order not allowed")
+)
+
+// RoundPrice rounds the price to the nearest multiple of the tick size
+func (s SymbolInfo) RoundPrice(price float64) float64 {
+	return roundStep(price, s.TickSize, math.Round)
+}
+
+// FloorQuantity rounds the quantity down to a multiple of the lot step size
+func (s SymbolInfo) FloorQuantity(quantity float64) float64 {
+	return roundStep(quantity, s.StepSize, math.Floor)
+}
+
+// FormatPrice formats the price with the decimals of the tick size, or of the price precision when the tick size is
+// unknown
+func (s SymbolInfo) FormatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', precision(s.TickSize, s.PricePrecision), 64)
+}
+
+// FormatQuantity formats the quantity with the decimals of the lot step size, or of the quantity precision when
+// the step size is unknown
+func (s SymbolInfo) FormatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', precision(s.StepSize, s.QuantityPrecision), 64)
+}
+
+// HasPermission reports whether the symbol can be traded with permission, e.g. PermissionSpot. Symbols without
+// known permissions are allowed.
+func (s SymbolInfo) HasPermission(permission string) bool {
+	if len(s.Permissions) == 0 {
+		return true
+	}
+	for _, item := range s.Permissions {
+		if item == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrderType reports whether orders of orderType are accepted. Symbols without known order types accept all
+// of them.
+func (s SymbolInfo) AllowsOrderType(orderType OrderType) bool {
+	if len(s.OrderTypes) == 0 {
+		return true
+	}
+	for _, item := range s.OrderTypes {
+		if item == orderType {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePermission checks that the symbol can be traded with permission
+func (s SymbolInfo) ValidatePermission(permission string) error {
+	if !s.HasPermission(permission) {
+		return fmt.Errorf("%w: %s trading is not allowed", ErrOrderNotAllowed, strings.ToLower(permission))
+	}
+	return nil
+}
+
+// ValidateOrderType checks that orders of orderType are accepted
+func (s SymbolInfo) ValidateOrderType(orderType OrderType) error {
+	if !s.AllowsOrderType(orderType) {
+		return fmt.Errorf("%w: %s orders are not accepted", ErrOrderNotAllowed, orderType)
+	}
+	return nil
+}
+
+// ValidateOrder checks an order against the price, lot size and min notional filters. Market orders have no price,
+// their value is estimated with the last price.
+func (s SymbolInfo) ValidateOrder(quantity, price, lastPrice float64) error {
+	if price > 0 {
+		if s.MinPrice > 0 && price < s.MinPrice {
+			return fmt.Errorf("%w: %v is lower than %v", ErrInvalidPrice, price, s.MinPrice)
+		}
+		if s.MaxPrice > 0 && price > s.MaxPrice {
+			return fmt.Errorf("%w: %v is higher than %v", ErrInvalidPrice, price, s.MaxPrice)
+		}
+		if !isMultiple(price, s.TickSize) {
+			return fmt.Errorf("%w: %v is not a multiple of tick size %v", ErrInvalidPrice, price, s.TickSize)
+		}
+	}
+
+	if quantity <= 0 || (s.MinQuantity > 0 && quantity < s.MinQuantity) {
+		return fmt.Errorf("%w: %v is lower than %v", ErrInvalidQuantity, quantity, s.MinQuantity)
+	}
+	if s.MaxQuantity > 0 && quantity > s.MaxQuantity {
+		return fmt.Errorf("%w: %v is higher than %v", ErrInvalidQuantity, quantity, s.MaxQuantity)
+	}
+	if !isMultiple(quantity, s.StepSize) {
+		return fmt.Errorf("%w: %v is not a multiple of step size %v", ErrInvalidQuantity, quantity, s.StepSize)
+	}
+
+	if price == 0 {
+		price = lastPrice
+	}
+	if s.MinNotional > 0 && price > 0 && quantity*price < s.MinNotional {
+		return fmt.Errorf("%w: %v is lower than %v", ErrMinNotional, quantity*price, s.MinNotional)
+	}
+	return nil
+}
+
+// roundStep rounds value to a multiple of step, a zero step leaves the value unchanged
+func roundStep(value, step float64, round func(float64) float64) float64 {
+	if step <= 0 {
+		return value
+	}
+	// the epsilon absorbs the float error of values already on a step, e.g. 0.3 / 0.1
+	rounded := round(value/step+1e-9) * step
+	precision := math.Pow10(stepDecimals(step))
+	return math.Round(rounded*precision) / precision
+}
+
+func isMultiple(value, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	ratio := value / step
+	return math.Abs(ratio-math.Round(ratio)) < 1e-6
+}
+
+// precision returns the decimals of step, or decimals when step is unknown. Without both, the shortest
+// representation is used.
+func precision(step float64, decimals int) int {
+	if step <= 0 && decimals > 0 {
+		return decimals
+	}
+	return stepDecimals(step)
+}
+
+// stepDecimals returns the number of decimals of a step, e.g. 3 for 0.001
+func stepDecimals(step float64) int {
+	if step <= 0 {
+		return -1
+	}
+	value := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.Index(value, "."); i >= 0 {
+		return len(value) - i - 1
+	}
+	return 0
+}
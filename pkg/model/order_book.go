@@ -0,0 +1,238 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type PriceLevel struct {
+	Price    float64
+	Quantity float64
+}
+
+// OrderBook is the local copy of the order book of a symbol, rebuilt from a snapshot then kept in sync with the
+// depth updates
+type OrderBook struct {
+	sync.RWMutex
+	Symbol       string
+	lastUpdateID int64
+	updatedAt    time.Time
+	synced       bool
+	bids         map[float64]float64
+	asks         map[float64]float64
+}
+
+func NewOrderBook(symbol string) *OrderBook {
+	return &OrderBook{
+		Symbol: symbol,
+		bids:   make(map[float64]float64),
+		asks:   make(map[float64]float64),
+	}
+}
+
+// Reset replaces every level with the snapshot
+func (b *OrderBook) Reset(lastUpdateID int64, bids, asks []PriceLevel) {
+	b.Lock()
+	defer b.Unlock()
+	b.bids = make(map[float64]float64, len(bids))
+	b.asks = make(map[float64]float64, len(asks))
+	b.apply(lastUpdateID, bids, asks)
+	b.synced = true
+}
+
+// Update applies a depth update, a zero quantity removes the level
+func (b *OrderBook) Update(lastUpdateID int64, bids, asks []PriceLevel) {
+	b.Lock()
+	defer b.Unlock()
+	b.apply(lastUpdateID, bids, asks)
+}
+
+// Invalidate marks the book out of sync until the next snapshot
+func (b *OrderBook) Invalidate() {
+	b.Lock()
+	defer b.Unlock()
+	b.synced = false
+}
+
+func (b *OrderBook) apply(lastUpdateID int64, bids, asks []PriceLevel) {
+	for _, level := range bids {
+		setLevel(b.bids, level)
+	}
+	for _, level := range asks {
+		setLevel(b.asks, level)
+	}
+	b.lastUpdateID = lastUpdateID
+	b.updatedAt = time.Now()
+}
+
+func setLevel(levels map[float64]float64, level PriceLevel) {
+	if level.Quantity == 0 {
+		delete(levels, level.Price)
+		return
+	}
+	levels[level.Price] = level.Quantity
+}
+
+// IsSynced reports whether the book reflects the exchange, it is not while a snapshot is fetched after a gap in
+// the updates
+func (b *OrderBook) IsSynced() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.synced
+}
+
+func (b *OrderBook) LastUpdateID() int64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.lastUpdateID
+}
+
+func (b *OrderBook) UpdatedAt() time.Time {
+	b.RLock()
+	defer b.RUnlock()
+	return b.updatedAt
+}
+
+// Bids returns the best limit bid levels, highest price first. A zero limit returns every level.
+func (b *OrderBook) Bids(limit int) []PriceLevel {
+	b.RLock()
+	defer b.RUnlock()
+	return sortedLevels(b.bids, limit, func(a, c float64) bool { return a > c })
+}
+
+// Asks returns the best limit ask levels, lowest price first. A zero limit returns every level.
+func (b *OrderBook) Asks(limit int) []PriceLevel {
+	b.RLock()
+	defer b.RUnlock()
+	return sortedLevels(b.asks, limit, func(a, c float64) bool { return a < c })
+}
+
+func (b *OrderBook) BestBid() (PriceLevel, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	return bestLevel(b.bids, func(a, c float64) bool { return a > c })
+}
+
+func (b *OrderBook) BestAsk() (PriceLevel, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	return bestLevel(b.asks, func(a, c float64) bool { return a < c })
+}
+
+// MidPrice returns the average of the best bid and ask prices, zero when a side is empty
+func (b *OrderBook) MidPrice() float64 {
+	bid, okBid := b.BestBid()
+	ask, okAsk := b.BestAsk()
+	if !okBid || !okAsk {
+		return 0
+	}
+	return (bid.Price + ask.Price) / 2
+}
+
+// Spread returns the difference between the best ask and bid prices, zero when a side is empty
+func (b *OrderBook) Spread() float64 {
+	bid, okBid := b.BestBid()
+	ask, okAsk := b.BestAsk()
+	if !okBid || !okAsk {
+		return 0
+	}
+	return ask.Price - bid.Price
+}
+
+// Depth returns the quote amount of the bids and asks with a price within percent of price
+func (b *OrderBook) Depth(price, percent float64) (bidAmount, askAmount float64) {
+	low, high := price*(1-percent/100), price*(1+percent/100)
+	b.RLock()
+	defer b.RUnlock()
+	for levelPrice, quantity := range b.bids {
+		if levelPrice >= low && levelPrice <= high {
+			bidAmount += levelPrice * quantity
+		}
+	}
+	for levelPrice, quantity := range b.asks {
+		if levelPrice >= low && levelPrice <= high {
+			askAmount += levelPrice * quantity
+		}
+	}
+	return bidAmount, askAmount
+}
+
+// Imbalance compares the bid and ask amounts within percent of the mid price, from -1 when there are only asks to
+// 1 when there are only bids
+func (b *OrderBook) Imbalance(percent float64) float64 {
+	bidAmount, askAmount := b.Depth(b.MidPrice(), percent)
+	if bidAmount+askAmount == 0 {
+		return 0
+	}
+	return (bidAmount - askAmount) / (bidAmount + askAmount)
+}
+
+// Walls returns the levels within percent of price whose quantity is at least multiplier times the average level
+// quantity of the same side in that range
+func (b *OrderBook) Walls(price, percent, multiplier float64) (bids, asks []PriceLevel) {
+	low, high := price*(1-percent/100), price*(1+percent/100)
+	b.RLock()
+	defer b.RUnlock()
+	bids = walls(b.bids, low, high, multiplier, func(a, c float64) bool { return a > c })
+	asks = walls(b.asks, low, high, multiplier, func(a, c float64) bool { return a < c })
+	return bids, asks
+}
+
+func walls(levels map[float64]float64, low, high, multiplier float64, less func(a, b float64) bool) []PriceLevel {
+	var (
+		inRange = make([]PriceLevel, 0)
+		total   float64
+	)
+	for price, quantity := range levels {
+		if price >= low && price <= high {
+			inRange = append(inRange, PriceLevel{Price: price, Quantity: quantity})
+			total += quantity
+		}
+	}
+	if len(inRange) == 0 {
+		return nil
+	}
+
+	var (
+		threshold = multiplier * total / float64(len(inRange))
+		result    = make([]PriceLevel, 0)
+	)
+	for _, level := range inRange {
+		if level.Quantity >= threshold {
+			result = append(result, level)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return less(result[i].Price, result[j].Price)
+	})
+	return result
+}
+
+func bestLevel(levels map[float64]float64, less func(a, b float64) bool) (PriceLevel, bool) {
+	var (
+		best  PriceLevel
+		found bool
+	)
+	for price, quantity := range levels {
+		if !found || less(price, best.Price) {
+			best = PriceLevel{Price: price, Quantity: quantity}
+			found = true
+		}
+	}
+	return best, found
+}
+
+func sortedLevels(levels map[float64]float64, limit int, less func(a, b float64) bool) []PriceLevel {
+	var result = make([]PriceLevel, 0, len(levels))
+	for price, quantity := range levels {
+		result = append(result, PriceLevel{Price: price, Quantity: quantity})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return less(result[i].Price, result[j].Price)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBook(t *testing.T) {
+	book := NewOrderBook("BTCUSDT")
+	assert.False(t, book.IsSynced())
+
+	book.Reset(100, []PriceLevel{
+		{Price: 99, Quantity: 1},
+		{Price: 98, Quantity: 10},
+		{Price: 90, Quantity: 5},
+	}, []PriceLevel{
+		{Price: 101, Quantity: 2},
+		{Price: 102, Quantity: 1},
+	})
+	assert.True(t, book.IsSynced())
+
+	// a zero quantity removes the level
+	book.Update(101, []PriceLevel{{Price: 99, Quantity: 0}, {Price: 99.5, Quantity: 1}}, []PriceLevel{{Price: 101, Quantity: 3}})
+	assert.Equal(t, int64(101), book.LastUpdateID())
+	assert.Equal(t, []PriceLevel{{99.5, 1}, {98, 10}}, book.Bids(2))
+	assert.Equal(t, []PriceLevel{{101, 3}, {102, 1}}, book.Asks(0))
+
+	bid, _ := book.BestBid()
+	ask, _ := book.BestAsk()
+	assert.Equal(t, 99.5, bid.Price)
+	assert.Equal(t, 101.0, ask.Price)
+	assert.Equal(t, 1.5, book.Spread())
+	assert.Equal(t, 100.25, book.MidPrice())
+
+	bidAmount, askAmount := book.Depth(100, 2)
+	assert.Equal(t, 99.5+980, bidAmount)
+	assert.Equal(t, 303.0+102, askAmount)
+	// the range around the mid price excludes the bid at 98
+	assert.InDelta(t, (99.5-405)/(99.5+405), book.Imbalance(2), 1e-9)
+
+	bids, asks := book.Walls(100, 2, 1.5)
+	assert.Equal(t, []PriceLevel{{98, 10}}, bids)
+	assert.Equal(t, []PriceLevel{{101, 3}}, asks)
+
+	book.Invalidate()
+	assert.False(t, book.IsSynced())
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSymbolInfoFilters(t *testing.T) {
+	info := SymbolInfo{
+		TickSize:    0.01,
+		MinPrice:    0.01,
+		MaxPrice:    100000,
+		StepSize:    0.001,
+		MinQuantity: 0.001,
+		MaxQuantity: 1000,
+		MinNotional: 10,
+	}
+
+	assert.Equal(t, 123.46, info.RoundPrice(123.456))
+	assert.Equal(t, 0.3, info.RoundPrice(0.1+0.2))
+	assert.Equal(t, 1.234, info.FloorQuantity(1.2349))
+	assert.Equal(t, 0.3, info.FloorQuantity(0.1+0.2))
+	assert.Equal(t, "123.40", info.FormatPrice(123.4))
+	assert.Equal(t, "0.500", info.FormatQuantity(0.5))
+	assert.Equal(t, 1.2349, SymbolInfo{}.FloorQuantity(1.2349))
+
+	assert.NoError(t, info.ValidateOrder(0.5, 100, 0))
+	assert.NoError(t, info.ValidateOrder(0.5, 0, 100))
+	assert.ErrorIs(t, info.ValidateOrder(0.5, 100.005, 0), ErrInvalidPrice)
+	assert.ErrorIs(t, info.ValidateOrder(0.5, 200000, 0), ErrInvalidPrice)
+	assert.ErrorIs(t, info.ValidateOrder(0.0005, 100, 0), ErrInvalidQuantity)
+	assert.ErrorIs(t, info.ValidateOrder(0.5005, 100, 0), ErrInvalidQuantity)
+	assert.ErrorIs(t, info.ValidateOrder(0.05, 100, 0), ErrMinNotional)
+	assert.ErrorIs(t, info.ValidateOrder(0.05, 0, 100), ErrMinNotional)
+}
+
+func TestSymbolInfoPermissions(t *testing.T) {
+	info := SymbolInfo{
+		PricePrecision:    2,
+		QuantityPrecision: 4,
+		Permissions:       []string{PermissionMargin},
+		OrderTypes:        []OrderType{OrderTypeMarket, OrderTypeLimit},
+	}
+
+	// the precisions format the values without tick and step sizes
+	assert.Equal(t, "0.10", info.FormatPrice(0.1))
+	assert.Equal(t, "1.2000", info.FormatQuantity(1.2))
+	assert.Equal(t, "0.1", SymbolInfo{}.FormatPrice(0.1))
+
+	assert.ErrorIs(t, info.ValidatePermission(PermissionSpot), ErrOrderNotAllowed)
+	assert.NoError(t, info.ValidatePermission(PermissionMargin))
+	assert.NoError(t, info.ValidateOrderType(OrderTypeLimit))
+	assert.ErrorIs(t, info.ValidateOrderType(OrderTypeStopLossLimit), ErrOrderNotAllowed)
+
+	// unknown permissions and order types are not checked
+	assert.NoError(t, SymbolInfo{}.ValidatePermission(PermissionSpot))
+	assert.NoError(t, SymbolInfo{}.ValidateOrderType(OrderTypeStopLossLimit))
+}
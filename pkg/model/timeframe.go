@@ -0,0 +1,245 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeframe is the interval of a candle, e.g. 15m, 4h or 1M. Intervals the exchange does not stream, e.g. 2d or
+// 2w, are built by resampling a smaller one, and may shift the candle boundaries with an offset suffix, e.g. 6h@17h
+// opens 6h candles at 17:00 UTC, which is midnight in UTC+7. Candles built from trades are labeled with their bar
+// type and threshold instead, e.g. volume:5000.
+type Timeframe string
+
+const (
+	Timeframe1m  Timeframe = "1m"
+	Timeframe3m  Timeframe = "3m"
+	Timeframe5m  Timeframe = "5m"
+	Timeframe15m Timeframe = "15m"
+	Timeframe30m Timeframe = "30m"
+	Timeframe1h  Timeframe = "1h"
+	Timeframe2h  Timeframe = "2h"
+	Timeframe4h  Timeframe = "4h"
+	Timeframe6h  Timeframe = "6h"
+	Timeframe8h  Timeframe = "8h"
+	Timeframe12h Timeframe = "12h"
+	Timeframe1d  Timeframe = "1d"
+	Timeframe3d  Timeframe = "3d"
+	Timeframe1w  Timeframe = "1w"
+	Timeframe1M  Timeframe = "1M"
+)
+
+// Timeframes lists every interval supported by the exchange
+var Timeframes = []Timeframe{
+	Timeframe1m, Timeframe3m, Timeframe5m, Timeframe15m, Timeframe30m,
+	Timeframe1h, Timeframe2h, Timeframe4h, Timeframe6h, Timeframe8h, Timeframe12h,
+	Timeframe1d, Timeframe3d, Timeframe1w, Timeframe1M,
+}
+
+// BarType is the measure closing the candles built from trades
+type BarType string
+
+const (
+	BarTypeTick   BarType = "tick"   // number of trades
+	BarTypeVolume BarType = "volume" // traded base asset quantity
+	BarTypeDollar BarType = "dollar" // traded quote asset amount
+)
+
+var ErrInvalidTimeframe = errors.New("invalid timeframe")
+
+// the first weekly candle opens on Monday 1970-01-05
+var weekAnchor = time.Unix(4*24*3600, 0).UTC()
+
+// BarTimeframe labels the candles built from trades closing at threshold, e.g. tick:100
+func BarTimeframe(barType BarType, threshold float64) Timeframe {
+	return Timeframe(fmt.Sprintf("%s:%s", barType, strconv.FormatFloat(threshold, 'f', -1, 64)))
+}
+
+// ParseTimeframe parses an interval or a bar label, IsValid reports whether the exchange streams it
+func ParseTimeframe(value string) (Timeframe, error) {
+	timeframe := Timeframe(value)
+	_, _, isBar := timeframe.Bar()
+	if _, _, _, ok := timeframe.parse(); !ok && !isBar {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTimeframe, value)
+	}
+	return timeframe, nil
+}
+
+// ParseTimeframes parses a list of intervals, e.g. the `timeframes` configuration
+func ParseTimeframes(values []string) ([]Timeframe, error) {
+	var timeframes = make([]Timeframe, 0, len(values))
+	for _, value := range values {
+		timeframe, err := ParseTimeframe(value)
+		if err != nil {
+			return nil, err
+		}
+		timeframes = append(timeframes, timeframe)
+	}
+	return timeframes, nil
+}
+
+func (t Timeframe) String() string {
+	return string(t)
+}
+
+// IsValid reports whether the interval is streamed by the exchange
+func (t Timeframe) IsValid() bool {
+	for _, timeframe := range Timeframes {
+		if t == timeframe {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCustom reports whether the interval is well-formed but not streamed by the exchange, so it has to be resampled
+func (t Timeframe) IsCustom() bool {
+	_, _, _, ok := t.parse()
+	return ok && !t.IsValid()
+}
+
+// Bar returns the bar type and threshold of the candles built from trades
+func (t Timeframe) Bar() (BarType, float64, bool) {
+	i := strings.Index(string(t), ":")
+	if i < 0 {
+		return "", 0, false
+	}
+	barType := BarType(t[:i])
+	switch barType {
+	case BarTypeTick, BarTypeVolume, BarTypeDollar:
+	default:
+		return "", 0, false
+	}
+	threshold, err := strconv.ParseFloat(string(t[i+1:]), 64)
+	if err != nil || threshold <= 0 {
+		return "", 0, false
+	}
+	return barType, threshold, true
+}
+
+// Offset returns the shift of the candle boundaries from the exchange alignment
+func (t Timeframe) Offset() time.Duration {
+	_, _, offset, _ := t.parse()
+	return offset
+}
+
+// IsMonthly reports whether candles span calendar months, which have different lengths
+func (t Timeframe) IsMonthly() bool {
+	_, unit, _, ok := t.parse()
+	return ok && unit == 'M'
+}
+
+// Duration returns the nominal length of a candle, a month counts 30 days
+func (t Timeframe) Duration() time.Duration {
+	n, unit, _, ok := t.parse()
+	if !ok {
+		return 0
+	}
+	switch unit {
+	case 'm':
+		return time.Duration(n) * time.Minute
+	case 'h':
+		return time.Duration(n) * time.Hour
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour
+	case 'M':
+		return time.Duration(n) * 30 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Seconds returns the nominal length of a candle in seconds
+func (t Timeframe) Seconds() int64 {
+	return int64(t.Duration() / time.Second)
+}
+
+// OpenTime returns the open time of the candle containing tm. Candles are aligned on the unix epoch in UTC, weeks
+// open on Monday and months on their first day, then shifted by the offset.
+func (t Timeframe) OpenTime(tm time.Time) time.Time {
+	n, unit, offset, ok := t.parse()
+	if !ok {
+		return tm
+	}
+	shifted := tm.Add(-offset)
+	var openTime time.Time
+	switch unit {
+	case 'w':
+		period := time.Duration(n) * 7 * 24 * time.Hour
+		openTime = weekAnchor.Add(floorDuration(shifted.Sub(weekAnchor), period))
+	case 'M':
+		utc := shifted.UTC()
+		months := (utc.Year()-1970)*12 + int(utc.Month()) - 1
+		months -= mod(months, n)
+		openTime = time.Date(1970, time.Month(months+1), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		period := t.Duration()
+		openTime = time.Unix(0, 0).Add(floorDuration(shifted.Sub(time.Unix(0, 0)), period))
+	}
+	return openTime.Add(offset).In(tm.Location())
+}
+
+// CloseTime returns the close time of the candle containing tm, which is the open time of the next candle
+func (t Timeframe) CloseTime(tm time.Time) time.Time {
+	return t.Add(tm, 1)
+}
+
+// Add returns the open time of the n-th candle after the candle containing tm
+func (t Timeframe) Add(tm time.Time, n int) time.Time {
+	count, unit, offset, ok := t.parse()
+	if !ok {
+		return tm
+	}
+	openTime := t.OpenTime(tm)
+	if unit == 'M' {
+		return openTime.Add(-offset).UTC().AddDate(0, count*n, 0).Add(offset).In(tm.Location())
+	}
+	return openTime.Add(time.Duration(n) * t.Duration())
+}
+
+// parse splits the timeframe into its count, unit and offset, e.g. 6h@17h is (6, 'h', 17h)
+func (t Timeframe) parse() (int, byte, time.Duration, bool) {
+	var (
+		interval = string(t)
+		offset   time.Duration
+	)
+	if i := strings.Index(interval, "@"); i >= 0 {
+		var err error
+		if offset, err = time.ParseDuration(interval[i+1:]); err != nil || offset == 0 {
+			return 0, 0, 0, false
+		}
+		interval = interval[:i]
+	}
+
+	if len(interval) < 2 {
+		return 0, 0, 0, false
+	}
+	unit := interval[len(interval)-1]
+	switch unit {
+	case 'm', 'h', 'd', 'w', 'M':
+	default:
+		return 0, 0, 0, false
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, 0, 0, false
+	}
+	return n, unit, offset, true
+}
+
+// floorDuration rounds d down to a multiple of period, also for negative durations
+func floorDuration(d, period time.Duration) time.Duration {
+	return d - time.Duration(mod(int(d), int(period)))
+}
+
+func mod(a, b int) int {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeframe(t *testing.T) {
+	for _, timeframe := range Timeframes {
+		parsed, err := ParseTimeframe(timeframe.String())
+		require.NoError(t, err)
+		assert.Equal(t, timeframe, parsed)
+	}
+
+	for _, value := range []string{"2d", "2w", "6h@17h"} {
+		timeframe, err := ParseTimeframe(value)
+		require.NoError(t, err)
+		assert.True(t, timeframe.IsCustom(), value)
+	}
+
+	for _, value := range []string{"", "4", "h", "0h", "1y", "4H", "4h@", "4h@1x"} {
+		_, err := ParseTimeframe(value)
+		assert.ErrorIs(t, err, ErrInvalidTimeframe, value)
+	}
+}
+
+func TestTimeframeOpenCloseTime(t *testing.T) {
+	tm := time.Date(2021, 8, 19, 13, 45, 12, 0, time.UTC) // Thursday
+
+	tests := []struct {
+		timeframe Timeframe
+		open      time.Time
+		close     time.Time
+	}{
+		{Timeframe1m, time.Date(2021, 8, 19, 13, 45, 0, 0, time.UTC), time.Date(2021, 8, 19, 13, 46, 0, 0, time.UTC)},
+		{Timeframe15m, time.Date(2021, 8, 19, 13, 45, 0, 0, time.UTC), time.Date(2021, 8, 19, 14, 0, 0, 0, time.UTC)},
+		{Timeframe4h, time.Date(2021, 8, 19, 12, 0, 0, 0, time.UTC), time.Date(2021, 8, 19, 16, 0, 0, 0, time.UTC)},
+		{Timeframe12h, time.Date(2021, 8, 19, 12, 0, 0, 0, time.UTC), time.Date(2021, 8, 20, 0, 0, 0, 0, time.UTC)},
+		{Timeframe1d, time.Date(2021, 8, 19, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 20, 0, 0, 0, 0, time.UTC)},
+		{Timeframe3d, time.Date(2021, 8, 19, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 22, 0, 0, 0, 0, time.UTC)},
+		{Timeframe1w, time.Date(2021, 8, 16, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 23, 0, 0, 0, 0, time.UTC)},
+		{Timeframe1M, time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{"2d", time.Date(2021, 8, 19, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 21, 0, 0, 0, 0, time.UTC)},
+		{"6h@17h", time.Date(2021, 8, 19, 11, 0, 0, 0, time.UTC), time.Date(2021, 8, 19, 17, 0, 0, 0, time.UTC)},
+		{"1d@-7h", time.Date(2021, 8, 18, 17, 0, 0, 0, time.UTC), time.Date(2021, 8, 19, 17, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.open, test.timeframe.OpenTime(tm), test.timeframe)
+		assert.Equal(t, test.close, test.timeframe.CloseTime(tm), test.timeframe)
+	}
+
+	assert.Equal(t, time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), Timeframe1M.Add(tm, 6))
+	assert.Equal(t, 4*time.Hour, Timeframe4h.Duration())
+	assert.Equal(t, int64(900), Timeframe15m.Seconds())
+}
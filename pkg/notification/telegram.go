@@ -6,6 +6,7 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/quangkeu95/binancebot/lib/app"
+	"github.com/quangkeu95/binancebot/pkg/metrics"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -13,6 +14,8 @@ import (
 const (
 	EmojiArrowDown = "\U00002B07"
 	EmojiArrowUp   = "\U00002B06"
+	EmojiCollision = "\U0001F4A5"
+	EmojiWhale     = "\U0001F40B"
 )
 
 const (
@@ -21,6 +24,9 @@ const (
 	DefaultTelegramRateLimit = 30
 	DefaultTelegramRateBurst = 1
 	DefaultTelegramTimeout   = 5 * time.Second
+
+	// TelegramNotifier names the telegram notifier in the metrics
+	TelegramNotifier = "telegram"
 )
 
 type Telegram struct {
@@ -55,11 +61,14 @@ func NewTelegram() (*Telegram, error) {
 		return nil, err
 	}
 
+	rateLimiter := app.NewRateLimiter(DefaultTelegramRateLimit, DefaultTelegramRateBurst)
+	rateLimiter.OnWait(metrics.ObserveWait(TelegramNotifier))
+
 	return &Telegram{
 		l:           l,
 		api:         api,
 		chatId:      chatId,
-		rateLimiter: app.NewRateLimiter(DefaultTelegramRateLimit, DefaultTelegramRateBurst),
+		rateLimiter: rateLimiter,
 	}, nil
 }
 
@@ -70,10 +79,12 @@ func (t Telegram) SendMessage(msg string) error {
 
 	if err := t.rateLimiter.WaitN(DefaultTelegramTimeout, 1); err != nil {
 		t.l.Errorw("telegram bot send message error rate limit", "error", err)
+		metrics.ObserveNotification(TelegramNotifier, err)
 		return err
 	}
 
 	_, err := t.api.Send(sendMsg)
+	metrics.ObserveNotification(TelegramNotifier, err)
 	if err != nil {
 		t.l.Errorw("telegram bot send message error", "error", err)
 		return err
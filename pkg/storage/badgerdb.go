@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"log"
+	"os"
 
 	badger "github.com/dgraph-io/badger/v3"
 	validation "github.com/go-ozzo/ozzo-validation"
@@ -11,6 +12,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// StoragePathFlag is the directory of the database, dedicated to it as badger fills it with its own files
+const StoragePathFlag = "storage_path"
+
 type BadgerDB struct {
 	l  *zap.SugaredLogger
 	db *badger.DB
@@ -18,11 +22,15 @@ type BadgerDB struct {
 
 func NewBadgerDB() (*BadgerDB, error) {
 	l := zap.S()
-	storagePath := viper.GetString("storage_path")
+	storagePath := viper.GetString(StoragePathFlag)
 	if err := validation.Validate(storagePath, validation.Required); err != nil {
 		l.Errorw("storage_path is required", "error", err)
 		return nil, err
 	}
+	if err := os.MkdirAll(storagePath, 0755); err != nil {
+		l.Errorw("create storage_path error", "error", err, "path", storagePath)
+		return nil, err
+	}
 	db, err := badger.Open(badger.DefaultOptions(storagePath))
 	if err != nil {
 		l.Errorw("init badger db error", "error", err)
@@ -34,6 +42,11 @@ func NewBadgerDB() (*BadgerDB, error) {
 	}, nil
 }
 
+// Close flushes the pending writes and releases the database directory
+func (b *BadgerDB) Close() error {
+	return b.db.Close()
+}
+
 func (b *BadgerDB) Set(key string, value interface{}) error {
 	err := b.db.Update(func(txn *badger.Txn) error {
 		dataB, err := Encode(value)
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/quangkeu95/binancebot/pkg/model"
+)
+
+const (
+	// CandleStoreEnabledFlag keeps the closed candles in `storage_path`, off by default as every candle of every
+	// followed symbol and timeframe is kept
+	CandleStoreEnabledFlag = "candle_store.enabled"
+
+	// CandleKeyPrefix prefixes the storage keys of the candles, followed by the symbol, the timeframe and the open time
+	CandleKeyPrefix = "candle--"
+	// SymbolInfoKeyPrefix prefixes the storage keys of the exchange info of the symbols saved with their candles
+	SymbolInfoKeyPrefix = "symbol_info--"
+
+	candleValueLength = 6 * 8
+)
+
+// CandleStore keeps the closed candles of each symbol and timeframe by open time
+type CandleStore interface {
+	// SaveCandles saves the complete candles, the others are skipped. A candle already saved is replaced.
+	SaveCandles(candles []model.Candle) error
+	// Candles returns the saved candles opened between start and end included, ordered by open time
+	Candles(symbol string, timeframe model.Timeframe, start, end time.Time) ([]model.Candle, error)
+}
+
+// SymbolInfoKey is the storage key of the exchange info of symbol
+func SymbolInfoKey(symbol string) string {
+	return SymbolInfoKeyPrefix + symbol
+}
+
+func (b *BadgerDB) SaveCandles(candles []model.Candle) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		for _, candle := range candles {
+			if !candle.Complete {
+				continue
+			}
+			if err := txn.Set(candleKey(candle.Symbol, candle.Timeframe, candle.Time), encodeCandle(candle)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BadgerDB) Candles(symbol string, timeframe model.Timeframe, start, end time.Time) ([]model.Candle, error) {
+	var (
+		candles = make([]model.Candle, 0)
+		prefix  = candlePrefix(symbol, timeframe)
+		last    = candleKey(symbol, timeframe, end)
+	)
+	err := b.db.View(func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.Prefix = prefix
+		it := txn.NewIterator(options)
+		defer it.Close()
+
+		for it.Seek(candleKey(symbol, timeframe, start)); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			if bytes.Compare(key, last) > 0 {
+				break
+			}
+			err := item.Value(func(val []byte) error {
+				candle, err := decodeCandle(val)
+				if err != nil {
+					return err
+				}
+				candle.Symbol, candle.Timeframe = symbol, timeframe
+				candle.Time = time.Unix(0, int64(binary.BigEndian.Uint64(key[len(prefix):]))*int64(time.Millisecond))
+				candles = append(candles, candle)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return candles, err
+}
+
+func candlePrefix(symbol string, timeframe model.Timeframe) []byte {
+	return []byte(fmt.Sprintf("%s%s--%s--", CandleKeyPrefix, symbol, timeframe))
+}
+
+// candleKey orders the candles of a feed by open time in milliseconds, big endian so the keys sort like the times.
+// Times before the unix epoch are stored at the epoch.
+func candleKey(symbol string, timeframe model.Timeframe, openTime time.Time) []byte {
+	millis := openTime.Unix()*1000 + int64(openTime.Nanosecond())/int64(time.Millisecond)
+	if millis < 0 {
+		millis = 0
+	}
+	var suffix [8]byte
+	binary.BigEndian.PutUint64(suffix[:], uint64(millis))
+	return append(candlePrefix(symbol, timeframe), suffix[:]...)
+}
+
+// encodeCandle packs the prices, volume and trades of the candle, the other fields are in its key
+func encodeCandle(candle model.Candle) []byte {
+	var value = make([]byte, candleValueLength)
+	for i, field := range []float64{candle.Open, candle.Close, candle.Low, candle.High, candle.Volume} {
+		binary.BigEndian.PutUint64(value[i*8:], math.Float64bits(field))
+	}
+	binary.BigEndian.PutUint64(value[5*8:], uint64(candle.Trades))
+	return value
+}
+
+func decodeCandle(value []byte) (model.Candle, error) {
+	if len(value) != candleValueLength {
+		return model.Candle{}, fmt.Errorf("invalid stored candle of %d bytes", len(value))
+	}
+	field := func(i int) float64 {
+		return math.Float64frombits(binary.BigEndian.Uint64(value[i*8:]))
+	}
+	return model.Candle{
+		Open:     field(0),
+		Close:    field(1),
+		Low:      field(2),
+		High:     field(3),
+		Volume:   field(4),
+		Trades:   int64(binary.BigEndian.Uint64(value[5*8:])),
+		Complete: true,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBadgerDBCandles(t *testing.T) {
+	viper.Set(StoragePathFlag, t.TempDir())
+	defer viper.Set(StoragePathFlag, nil)
+	db, err := NewBadgerDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	candle := func(symbol string, timeframe model.Timeframe, i int, complete bool) model.Candle {
+		return model.Candle{
+			Symbol:    symbol,
+			Timeframe: timeframe,
+			Time:      timeframe.Add(start, i),
+			Open:      float64(i),
+			Close:     float64(i) + 0.5,
+			Low:       float64(i) - 1,
+			High:      float64(i) + 1,
+			Volume:    1000.25,
+			Trades:    int64(i * 10),
+			Complete:  complete,
+		}
+	}
+
+	// saved out of order, with another feed and an incomplete candle
+	require.NoError(t, db.SaveCandles([]model.Candle{
+		candle("BTCUSDT", model.Timeframe4h, 2, true),
+		candle("BTCUSDT", model.Timeframe4h, 0, true),
+		candle("BTCUSDT", model.Timeframe4h, 1, true),
+		candle("BTCUSDT", model.Timeframe4h, 3, false),
+		candle("BTCUSDT", model.Timeframe1h, 1, true),
+		candle("ETHUSDT", model.Timeframe4h, 1, true),
+	}))
+
+	candles, err := db.Candles("BTCUSDT", model.Timeframe4h, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, candles, 3)
+	for i, c := range candles {
+		expected := candle("BTCUSDT", model.Timeframe4h, i, true)
+		assert.True(t, expected.Time.Equal(c.Time))
+		c.Time = expected.Time
+		assert.Equal(t, expected, c)
+	}
+
+	// the bounds are included
+	candles, err = db.Candles("BTCUSDT", model.Timeframe4h, start.Add(4*time.Hour), start.Add(8*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, candles, 2)
+	assert.Equal(t, 1.0, candles[0].Open)
+	assert.Equal(t, 2.0, candles[1].Open)
+
+	// a saved candle is replaced
+	updated := candle("BTCUSDT", model.Timeframe4h, 1, true)
+	updated.Close = 42
+	require.NoError(t, db.SaveCandles([]model.Candle{updated}))
+	candles, err = db.Candles("BTCUSDT", model.Timeframe4h, start.Add(4*time.Hour), start.Add(4*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, candles, 1)
+	assert.Equal(t, 42.0, candles[0].Close)
+
+	candles, err = db.Candles("BNBUSDT", model.Timeframe4h, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, candles)
+}
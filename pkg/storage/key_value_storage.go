@@ -1,5 +1,12 @@
 package storage
 
+import (
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// ErrKeyNotFound is returned by Get when nothing is stored under the key
+var ErrKeyNotFound = badger.ErrKeyNotFound
+
 type KeyValueStorage interface {
 	Set(key string, value interface{}) error
 	Get(key string, value interface{}) error
@@ -3,67 +3,118 @@ package strategy
 import (
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/quangkeu95/binancebot/pkg/metrics"
 	"github.com/quangkeu95/binancebot/pkg/model"
 	"github.com/quangkeu95/binancebot/pkg/series"
 	"go.uber.org/zap"
 )
 
+// Controller feeds the candles to the strategy. The map of dataframes is guarded by the controller lock, the candles
+// of a dataframe by its own lock so the feeds are consumed in parallel.
 type Controller struct {
 	sync.RWMutex
 	l          *zap.SugaredLogger
-	dataframes map[string]*model.Dataframe
+	dataframes map[string]*dataframeEntry
 	strategy   Strategy
 	started    bool
 }
 
-func NewStategyController(mapSymbolTimeframe map[string]string, strategy Strategy) *Controller {
+type dataframeEntry struct {
+	sync.Mutex
+	dataframe *model.Dataframe
+}
+
+func NewStategyController(mapSymbolTimeframe map[string]model.Timeframe, strategy Strategy) *Controller {
 	c := &Controller{
 		l:          zap.S(),
-		dataframes: make(map[string]*model.Dataframe),
+		dataframes: make(map[string]*dataframeEntry),
 		strategy:   strategy,
 	}
 
 	for symbol, timeframe := range mapSymbolTimeframe {
 		key := c.generateKey(symbol, timeframe)
-		c.dataframes[key] = &model.Dataframe{
-			Symbol:    symbol,
-			Timeframe: timeframe,
-			Metadata:  make(map[string]series.Series),
+		c.dataframes[key] = &dataframeEntry{
+			dataframe: &model.Dataframe{
+				Symbol:    symbol,
+				Timeframe: timeframe,
+				Metadata:  make(map[string]series.Series),
+			},
 		}
 	}
 
 	return c
 }
 
+// SetOrderBook makes the order book readable by the strategy next to the dataframes of its symbol
+func (c *Controller) SetOrderBook(book *model.OrderBook) {
+	c.RLock()
+	defer c.RUnlock()
+	for _, entry := range c.dataframes {
+		entry.Lock()
+		if entry.dataframe.Symbol == book.Symbol {
+			entry.dataframe.OrderBook = book
+		}
+		entry.Unlock()
+	}
+}
+
+// RemoveSymbol frees the dataframes of symbol, its candles are not followed anymore
+func (c *Controller) RemoveSymbol(symbol string) {
+	c.Lock()
+	defer c.Unlock()
+	for key, entry := range c.dataframes {
+		if entry.dataframe.Symbol == symbol {
+			metrics.DataframeCandles.DeleteLabelValues(symbol, entry.dataframe.Timeframe.String())
+			delete(c.dataframes, key)
+		}
+	}
+}
+
 func (s *Controller) Start() {
+	s.Lock()
+	defer s.Unlock()
 	s.started = true
 }
 
+// OnCandle only locks the dataframe of the candle, the strategy must be safe for concurrent calls on different
+// dataframes
 func (c *Controller) OnCandle(candle model.Candle) {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
 	key := c.generateKey(candle.Symbol, candle.Timeframe)
-	dataframe, ok := c.dataframes[key]
+	entry, ok := c.dataframes[key]
+	started := c.started
+	c.RUnlock()
 	if !ok {
 		c.l.Warnw("cannot found dataframe for entry", "symbol", candle.Symbol, "timeframe", candle.Timeframe)
 		return
 	}
 
-	if dataframe.IsLastCandle(candle) && c.started {
+	entry.Lock()
+	defer entry.Unlock()
+	dataframe := entry.dataframe
+
+	// partial updates replace the last candle, resampled candles are updated while preloading too
+	if dataframe.IsLastCandle(candle) {
 		lastIndex := dataframe.Length() - 1
 		dataframe.UpdateWithIndex(lastIndex, candle)
 	} else {
 		dataframe.AddNewCandle(candle)
 	}
 
+	metrics.DataframeCandles.WithLabelValues(dataframe.Symbol, dataframe.Timeframe.String()).Set(float64(dataframe.Length()))
+
 	if dataframe.Length() >= c.strategy.WarmupPeriod() {
-		if c.started {
+		if started {
+			if !candle.EventTime.IsZero() {
+				metrics.CandleLatency.WithLabelValues(candle.Timeframe.String()).Observe(time.Since(candle.EventTime).Seconds())
+			}
 			c.strategy.OnCandle(dataframe)
 		}
 	}
 }
 
-func (c *Controller) generateKey(symbol, timeframe string) string {
+func (c *Controller) generateKey(symbol string, timeframe model.Timeframe) string {
 	return fmt.Sprintf("%s--%s", symbol, timeframe)
 }
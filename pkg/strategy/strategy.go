@@ -1,9 +1,35 @@
 package strategy
 
-import "github.com/quangkeu95/binancebot/pkg/model"
+import (
+	"github.com/quangkeu95/binancebot/pkg/model"
+	"github.com/quangkeu95/binancebot/pkg/storage"
+)
 
 type Strategy interface {
 	Init()
 	WarmupPeriod() int
 	OnCandle(dataframe *model.Dataframe)
 }
+
+// OpenInterestStrategy is a Strategy also following the open interest of its symbols
+type OpenInterestStrategy interface {
+	Strategy
+	OnOpenInterest(openInterest model.OpenInterest)
+}
+
+// SymbolInfoGetter returns the exchange info of a symbol, e.g. its tick size
+type SymbolInfoGetter interface {
+	SymbolInfo(symbol string) (model.SymbolInfo, bool)
+}
+
+// StorageStrategy is a Strategy saving its state to a storage, so it is restored after a restart
+type StorageStrategy interface {
+	Strategy
+	SetStorage(store storage.KeyValueStorage)
+}
+
+// SymbolsStrategy is a Strategy reading the exchange info of its symbols, e.g. to format its prices
+type SymbolsStrategy interface {
+	Strategy
+	SetSymbols(symbols SymbolInfoGetter)
+}